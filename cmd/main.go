@@ -72,14 +72,16 @@ func action(cliCtx *cli.Context) error {
 		cancel() // Notify each goroutine and wait them stopped
 	}()
 
-	cfg, err := factory.ReadConfig(cliCtx.String("config"))
+	factory.SmfConfigPath = cliCtx.String("config")
+	cfg, err := factory.ReadConfig(factory.SmfConfigPath)
 	if err != nil {
 		sigCh <- nil
 		return err
 	}
 	factory.SmfConfig = cfg
 
-	ueRoutingCfg, err := factory.ReadUERoutingConfig(cliCtx.String("uerouting"))
+	factory.UERoutingConfigPath = cliCtx.String("uerouting")
+	ueRoutingCfg, err := factory.ReadUERoutingConfig(factory.UERoutingConfigPath)
 	if err != nil {
 		sigCh <- nil
 		return err
@@ -94,6 +96,16 @@ func action(cliCtx *cli.Context) error {
 	}
 	SMF = smf
 
+	// The configuration files are reloaded on SIGHUP
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	go func() {
+		for range hupCh {
+			smf.ReloadConfig()
+		}
+	}()
+
 	smf.Start()
 
 	return nil
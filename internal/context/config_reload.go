@@ -0,0 +1,253 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// ErrConfigReloadRejected is returned if some changes of the reloaded configuration can't be applied
+// to the running SMF, none of the changes is applied then
+var ErrConfigReloadRejected = errors.New("changes of the reloaded configuration are rejected")
+
+// configReloadMu serializes the configuration reloads
+var configReloadMu sync.Mutex
+
+// ConfigReload is the result of a configuration reload, the configuration items are named by their YAML keys
+type ConfigReload struct {
+	// Applied are the changed items applied to the running SMF
+	Applied []string `json:"applied,omitempty"`
+	// Rejected are the changed items which can't be applied to the running SMF, with the reasons
+	Rejected map[string]string `json:"rejected,omitempty"`
+
+	// The changes below are applied by the caller of ReloadConfig
+	NfProfileChanged bool     `json:"-"`
+	LoggerChanged    bool     `json:"-"`
+	PfdDataChanged   bool     `json:"-"`
+	RemovedPfdAppIDs []string `json:"-"`
+}
+
+func (r *ConfigReload) apply(item string) {
+	r.Applied = append(r.Applied, item)
+}
+
+func (r *ConfigReload) reject(item, reason string) {
+	r.Rejected[item] = reason
+}
+
+// ReloadConfig compares the reloaded configurations with the running ones and applies the changes
+// if they are all safe for the existing PDU sessions. The changes of the items which are only read
+// when the SMF starts, or which would invalidate the existing PDU sessions, are rejected.
+func ReloadConfig(cfg *factory.Config, routingCfg *factory.RoutingConfig) (*ConfigReload, error) {
+	configReloadMu.Lock()
+	defer configReloadMu.Unlock()
+
+	running := factory.SmfConfig
+	if running == nil || running.Configuration == nil {
+		return nil, errors.New("no running configuration to reload")
+	}
+	runningRouting := factory.UERoutingConfig
+	if runningRouting == nil {
+		runningRouting = &factory.RoutingConfig{}
+	}
+
+	reload := &ConfigReload{
+		Rejected: make(map[string]string),
+	}
+	current, next := running.Configuration, cfg.Configuration
+
+	// The items only read when the SMF starts
+	restartItems := []struct {
+		name          string
+		current, next interface{}
+	}{
+		{"smfName", current.SmfName, next.SmfName},
+		{"sbi", current.Sbi, next.Sbi},
+		{"pfcp", current.PFCP, next.PFCP},
+		{"nrfUri", current.NrfUri, next.NrfUri},
+		{"nrfCertPem", current.NrfCertPem, next.NrfCertPem},
+		{"serviceNameList", current.ServiceNameList, next.ServiceNameList},
+		{"ulcl", current.ULCL, next.ULCL},
+		{"sessionStore", current.SessionStore, next.SessionStore},
+		{"tracing", current.Tracing, next.Tracing},
+	}
+	for _, item := range restartItems {
+		if !reflect.DeepEqual(item.current, item.next) {
+			reload.reject(item.name, "only read when the SMF starts")
+		}
+	}
+	if !reflect.DeepEqual(current.UserPlaneInformation, next.UserPlaneInformation) {
+		reload.reject("userplaneInformation", "the user plane is changed by the UPI API instead")
+	}
+
+	var snssaiInfos []*SnssaiSmfInfo
+	if !reflect.DeepEqual(current.SNssaiInfo, next.SNssaiInfo) {
+		snssaiInfos = newSnssaiInfos(next.SNssaiInfo)
+		if inUse := snssaiDnnsInUse(snssaiInfos); len(inUse) != 0 {
+			reload.reject("snssaiInfos", fmt.Sprintf("S-NSSAI and DNN %v removed with PDU sessions", inUse))
+		} else {
+			reload.apply("snssaiInfos")
+			reload.NfProfileChanged = reload.NfProfileChanged ||
+				!reflect.DeepEqual(snssaiDnns(smfContext.GetSnssaiInfos()), snssaiDnns(snssaiInfos))
+		}
+	}
+
+	liveItems := []struct {
+		name          string
+		current, next interface{}
+		nfProfile     bool
+	}{
+		{"plmnList", current.PLMNList, next.PLMNList, true},
+		{"locality", current.Locality, next.Locality, true},
+		{"urrPeriod", current.UrrPeriod, next.UrrPeriod, false},
+		{"urrThreshold", current.UrrThreshold, next.UrrThreshold, false},
+		{"requestedUnit", current.RequestedUnit, next.RequestedUnit, false},
+		{"t3591", current.T3591, next.T3591, false},
+		{"t3592", current.T3592, next.T3592, false},
+		{"nwInstFqdnEncoding", current.NwInstFqdnEncoding, next.NwInstFqdnEncoding, false},
+		{"ddnBackoffTime", current.DdnBackoffTime, next.DdnBackoffTime, false},
+	}
+	for _, item := range liveItems {
+		if !reflect.DeepEqual(item.current, item.next) {
+			reload.apply(item.name)
+			reload.NfProfileChanged = reload.NfProfileChanged || item.nfProfile
+		}
+	}
+	if !reflect.DeepEqual(running.Logger, cfg.Logger) {
+		reload.apply("logger")
+		reload.LoggerChanged = true
+	}
+
+	// The ULCL groups and their paths are used when the PDU sessions are established,
+	// the existing PDU sessions keep their data paths
+	var ulclGroups map[string][]string
+	var uePreConfigPathPool map[string]*UEPreConfigPaths
+	var ueDefaultPathPool map[string]*UEDefaultPaths
+	ueRoutingChanged := !reflect.DeepEqual(runningRouting.UERoutingInfo, routingCfg.UERoutingInfo)
+	if ueRoutingChanged {
+		var errs []error
+		ulclGroups, uePreConfigPathPool, ueDefaultPathPool, errs = newUERouting(routingCfg.UERoutingInfo)
+		if len(errs) != 0 {
+			reload.reject("ueRoutingInfo", errors.Join(errs...).Error())
+		} else {
+			reload.apply("ueRoutingInfo")
+		}
+	}
+	if !reflect.DeepEqual(runningRouting.RouteProf, routingCfg.RouteProf) {
+		reload.apply("routeProfile")
+	}
+	if !reflect.DeepEqual(runningRouting.PfdDatas, routingCfg.PfdDatas) {
+		reload.apply("pfdDataForApp")
+		reload.PfdDataChanged = true
+		reload.RemovedPfdAppIDs = removedPfdAppIDs(runningRouting.PfdDatas, routingCfg.PfdDatas)
+	}
+
+	if len(reload.Rejected) != 0 {
+		return reload, ErrConfigReloadRejected
+	}
+
+	// The running configurations are updated in place since they are also referred by the SMF app
+	running.Lock()
+	running.Info = cfg.Info
+	running.Configuration = next
+	running.Unlock()
+
+	if factory.UERoutingConfig == nil {
+		factory.UERoutingConfig = routingCfg
+	} else {
+		factory.UERoutingConfig.Lock()
+		factory.UERoutingConfig.Info = routingCfg.Info
+		factory.UERoutingConfig.UERoutingInfo = routingCfg.UERoutingInfo
+		factory.UERoutingConfig.RouteProf = routingCfg.RouteProf
+		factory.UERoutingConfig.PfdDatas = routingCfg.PfdDatas
+		factory.UERoutingConfig.Unlock()
+	}
+
+	smfContext.reloadMu.Lock()
+	if snssaiInfos != nil {
+		smfContext.SnssaiInfos = snssaiInfos
+	}
+	if ueRoutingChanged && smfContext.ULCLSupport {
+		smfContext.ULCLGroups = ulclGroups
+		smfContext.UEPreConfigPathPool = uePreConfigPathPool
+		smfContext.UEDefaultPathPool = ueDefaultPathPool
+	}
+	smfContext.Locality = next.Locality
+	smfContext.reloadMu.Unlock()
+	if reload.NfProfileChanged {
+		smfContext.SetupNFProfile(running)
+	}
+	if reload.PfdDataChanged {
+		notifyPfdDataReloaded()
+	}
+
+	logger.CfgLog.Infof("Configuration reloaded, applied changes: %v", reload.Applied)
+	return reload, nil
+}
+
+// snssaiDnns returns the S-NSSAI and DNN pairs served by the SMF
+func snssaiDnns(snssaiInfos []*SnssaiSmfInfo) []string {
+	pairs := make([]string, 0)
+	for _, snssaiInfo := range snssaiInfos {
+		for dnn := range snssaiInfo.DnnInfos {
+			pairs = append(pairs, snssaiDnnKey(snssaiInfo.Snssai.Sst, snssaiInfo.Snssai.Sd, dnn))
+		}
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// snssaiDnnsInUse returns the S-NSSAI and DNN pairs of the existing PDU sessions which are not served
+// with snssaiInfos
+func snssaiDnnsInUse(snssaiInfos []*SnssaiSmfInfo) []string {
+	inUse := make(map[string]bool)
+	ProcEachSMContext(func(smContext *SMContext) {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		if smContext.SmfPduSessionSmContextCreateData == nil || smContext.SNssai == nil {
+			return
+		}
+		for _, snssaiInfo := range snssaiInfos {
+			if snssaiInfo.Snssai.EqualModelsSnssai(smContext.SNssai) {
+				if _, ok := snssaiInfo.DnnInfos[smContext.Dnn]; ok {
+					return
+				}
+				break
+			}
+		}
+		inUse[snssaiDnnKey(smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)] = true
+	})
+
+	pairs := make([]string, 0, len(inUse))
+	for pair := range inUse {
+		pairs = append(pairs, pair)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func snssaiDnnKey(sst int32, sd, dnn string) string {
+	return openapi.SnssaiModelsToHex(models.Snssai{Sst: sst, Sd: strings.ToLower(sd)}) + "/" + dnn
+}
+
+func removedPfdAppIDs(current, next []*factory.PfdDataForApp) []string {
+	nextAppIDs := make(map[string]bool, len(next))
+	for _, pfdData := range next {
+		nextAppIDs[pfdData.AppID] = true
+	}
+	var appIDs []string
+	for _, pfdData := range current {
+		if !nextAppIDs[pfdData.AppID] {
+			appIDs = append(appIDs, pfdData.AppID)
+		}
+	}
+	return appIDs
+}
@@ -0,0 +1,173 @@
+package context_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func newReloadConfig(dnsIPv4 string, sbiPort int, locality string, dnns ...string) *factory.Config {
+	dnnInfos := make([]*factory.SnssaiDnnInfoItem, 0, len(dnns))
+	for _, dnn := range dnns {
+		dnnInfos = append(dnnInfos, &factory.SnssaiDnnInfoItem{
+			Dnn: dnn,
+			DNS: &factory.DNS{IPv4Addr: dnsIPv4},
+		})
+	}
+	return &factory.Config{
+		Info: &factory.Info{Version: "1.0.7"},
+		Configuration: &factory.Configuration{
+			SmfName: "SMF",
+			Sbi: &factory.Sbi{
+				Scheme:       "http",
+				RegisterIPv4: "127.0.0.2",
+				BindingIPv4:  "127.0.0.2",
+				Port:         sbiPort,
+			},
+			ServiceNameList: []string{"nsmf-pdusession"},
+			SNssaiInfo: []*factory.SnssaiInfoItem{
+				{
+					SNssai:   &models.Snssai{Sst: 1, Sd: "010203"},
+					DnnInfos: dnnInfos,
+				},
+			},
+			Locality: locality,
+			T3591:    &factory.TimerValue{Enable: true, ExpireTime: 16 * time.Second, MaxRetryTimes: 3},
+			T3592:    &factory.TimerValue{Enable: true, ExpireTime: 16 * time.Second, MaxRetryTimes: 3},
+		},
+		Logger: &factory.Logger{Enable: true, Level: "info"},
+	}
+}
+
+func newReloadRoutingConfig(appIDs ...string) *factory.RoutingConfig {
+	routingCfg := &factory.RoutingConfig{Info: &factory.Info{Version: "1.0.7"}}
+	for _, appID := range appIDs {
+		routingCfg.PfdDatas = append(routingCfg.PfdDatas, &factory.PfdDataForApp{
+			AppID: appID,
+			Pfds:  []factory.PfdContent{{PfdID: "pfd1", DomainNames: []string{"example.com"}}},
+		})
+	}
+	return routingCfg
+}
+
+func TestReloadConfig(t *testing.T) {
+	initConfig()
+	smfConfig, ueRoutingConfig := factory.SmfConfig, factory.UERoutingConfig
+	snssaiInfos := smf_context.GetSelf().SnssaiInfos
+	t.Cleanup(func() {
+		factory.SmfConfig, factory.UERoutingConfig = smfConfig, ueRoutingConfig
+		smf_context.GetSelf().SnssaiInfos = snssaiInfos
+	})
+
+	factory.SmfConfig = newReloadConfig("8.8.8.8", 8000, "", "internet", "ims")
+	factory.UERoutingConfig = newReloadRoutingConfig("app1", "app2")
+	smf_context.GetSelf().SnssaiInfos = nil
+	_, err := smf_context.ReloadConfig(newReloadConfig("8.8.8.8", 8000, "", "internet", "ims"),
+		newReloadRoutingConfig("app1", "app2"))
+	require.NoError(t, err)
+
+	smContext := smf_context.NewSMContext("imsi-208930000000601", 1)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000601",
+		PduSessionId: 1,
+		SNssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+		Dnn:          "ims",
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	// The changes which are safe for the PDU sessions are applied
+	reload, err := smf_context.ReloadConfig(newReloadConfig("1.1.1.1", 8000, "area1", "ims"),
+		newReloadRoutingConfig("app1"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"snssaiInfos", "locality", "pfdDataForApp"}, reload.Applied)
+	require.True(t, reload.NfProfileChanged)
+	require.True(t, reload.PfdDataChanged)
+	require.Equal(t, []string{"app2"}, reload.RemovedPfdAppIDs)
+	require.Equal(t, "area1", factory.SmfConfig.Configuration.Locality)
+	require.Equal(t, "area1", smf_context.GetSelf().Locality)
+	dnnInfo := smf_context.RetrieveDnnInformation(smContext.SNssai, "ims")
+	require.NotNil(t, dnnInfo)
+	require.True(t, dnnInfo.DNS.IPv4Addr.Equal(net.ParseIP("1.1.1.1")))
+	require.Nil(t, smf_context.RetrieveDnnInformation(smContext.SNssai, "internet"))
+	require.Nil(t, smf_context.GetPfdDataForApp("app2"))
+
+	// Nothing is applied if any change is rejected
+	reload, err = smf_context.ReloadConfig(newReloadConfig("9.9.9.9", 8080, "area1", "internet"),
+		newReloadRoutingConfig("app1"))
+	require.ErrorIs(t, err, smf_context.ErrConfigReloadRejected)
+	require.Contains(t, reload.Rejected, "sbi")
+	require.Contains(t, reload.Rejected["snssaiInfos"], "01010203/ims")
+	require.Equal(t, 8000, factory.SmfConfig.Configuration.Sbi.Port)
+	dnnInfo = smf_context.RetrieveDnnInformation(smContext.SNssai, "ims")
+	require.NotNil(t, dnnInfo)
+	require.True(t, dnnInfo.DNS.IPv4Addr.Equal(net.ParseIP("1.1.1.1")))
+}
+
+// TestReloadConfigDuringPDUSessionCreation is to be run with the race detector
+func TestReloadConfigDuringPDUSessionCreation(t *testing.T) {
+	initConfig()
+	for _, n := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		n.UPF.AssociationContext = context.Background()
+	}
+	smfConfig, ueRoutingConfig := factory.SmfConfig, factory.UERoutingConfig
+	snssaiInfos := smf_context.GetSelf().GetSnssaiInfos()
+	t.Cleanup(func() {
+		factory.SmfConfig, factory.UERoutingConfig = smfConfig, ueRoutingConfig
+		smf_context.GetSelf().SnssaiInfos = snssaiInfos
+	})
+
+	factory.SmfConfig = newReloadConfig("8.8.8.8", 8000, "")
+	factory.UERoutingConfig = newReloadRoutingConfig()
+	_, err := smf_context.ReloadConfig(newReloadConfig("8.8.8.8", 8000, "area1", "internet"),
+		newReloadRoutingConfig())
+	require.NoError(t, err)
+
+	// The reloads are stopped before the configurations are restored
+	done := make(chan struct{})
+	reloaded := make(chan error, 1)
+	t.Cleanup(func() {
+		close(done)
+		<-reloaded
+	})
+	go func() {
+		defer close(reloaded)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			cfg := newReloadConfig("8.8.8.8", 8000, "area1", "internet")
+			if i%2 == 1 {
+				cfg = newReloadConfig("1.1.1.1", 8000, "area2", "internet")
+				cfg.Configuration.T3591.ExpireTime = 8 * time.Second
+			}
+			if _, err := smf_context.ReloadConfig(cfg, newReloadRoutingConfig()); err != nil {
+				reloaded <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		smContext := newActiveSMContext(t, fmt.Sprintf("imsi-20893000000%04d", 700+i), 1)
+		require.NotNil(t, smf_context.RetrieveDnnInformation(smContext.SNssai, smContext.Dnn))
+		require.NotNil(t, factory.SmfConfig.GetT3591())
+		require.NotEmpty(t, smf_context.GetSelf().GetLocality())
+		require.NotNil(t, smf_context.GetSelf().GetNfProfile().SMFInfo)
+		smf_context.RemoveSMContext(smContext.Ref)
+	}
+	select {
+	case err = <-reloaded:
+		require.NoError(t, err)
+	default:
+	}
+}
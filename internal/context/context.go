@@ -6,6 +6,7 @@ import (
 	"math"
 	"net"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,9 +15,9 @@ import (
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/openapi/oauth"
 	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/context/pool"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/pkg/factory"
-	"github.com/free5gc/util/idgenerator"
 )
 
 func Init() {
@@ -65,9 +66,14 @@ type SMFContext struct {
 	PfcpContext           context.Context
 	PfcpCancelFunc        context.CancelFunc
 	PfcpHeartbeatInterval time.Duration
-
-	// Now only "IPv4" supported
-	// TODO: support "IPv6", "IPv4v6", "Ethernet"
+	// The size of the receive buffer of the PFCP messages
+	PfcpMaxMessageSize int
+	// The retransmission timers of the PFCP requests to the UPFs without their own timers
+	PfcpRequestTimers *factory.PfcpRequestTimers
+	// The directory of the capture files of the PFCP messages, the capture is disabled if empty
+	PfcpCaptureDir string
+
+	// "IPv4", "IPv6", "IPv4v6" and "Ethernet" supported
 	SupportedPDUSessionType string
 
 	// *** For ULCL *** //
@@ -78,14 +84,39 @@ type SMFContext struct {
 	LocalSEIDCount      uint64
 
 	// Each pdu session should have a unique charging id
-	ChargingIDGenerator *idgenerator.IDGenerator
+	ChargingIDGenerator *pool.LazyReusePool
 
 	Ues *Ues
+
+	// reloadMu guards NfProfile, SnssaiInfos, Locality and the ULCL groups and paths,
+	// which are replaced when the configuration is reloaded
+	reloadMu sync.RWMutex
+}
+
+// GetNfProfile returns the NF profile of the SMF
+func (c *SMFContext) GetNfProfile() NFProfile {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	return c.NfProfile
+}
+
+// GetSnssaiInfos returns the S-NSSAIs and DNNs served by the SMF
+func (c *SMFContext) GetSnssaiInfos() []*SnssaiSmfInfo {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	return c.SnssaiInfos
+}
+
+// GetLocality returns the locality of the SMF
+func (c *SMFContext) GetLocality() string {
+	c.reloadMu.RLock()
+	defer c.reloadMu.RUnlock()
+	return c.Locality
 }
 
 func GenerateChargingID() int32 {
 	if smfContext.ChargingIDGenerator != nil {
-		if id, err := smfContext.ChargingIDGenerator.Allocate(); err == nil {
+		if id, ok := smfContext.ChargingIDGenerator.Allocate(); ok {
 			return int32(id)
 		}
 	}
@@ -110,7 +141,7 @@ func (s *SMFContext) ListenIP() net.IP {
 
 // RetrieveDnnInformation gets the corresponding dnn info from S-NSSAI and DNN
 func RetrieveDnnInformation(snssai *models.Snssai, dnn string) *SnssaiSmfDnnInfo {
-	for _, snssaiInfo := range GetSelf().SnssaiInfos {
+	for _, snssaiInfo := range GetSelf().GetSnssaiInfos() {
 		if snssaiInfo.Snssai.EqualModelsSnssai(snssai) {
 			return snssaiInfo.DnnInfos[dnn]
 		}
@@ -199,6 +230,9 @@ func InitSmfContext(config *factory.Config) {
 		}
 
 		smfContext.PfcpHeartbeatInterval = pfcp.HeartbeatInterval
+		smfContext.PfcpMaxMessageSize = pfcp.GetMaxMessageSize()
+		smfContext.PfcpRequestTimers = pfcp.RequestTimers
+		smfContext.PfcpCaptureDir = pfcp.CaptureDir
 		var multipleOfInterval time.Duration = 5
 		if pfcp.AssocFailAlertInterval == 0 {
 			smfContext.AssocFailAlertInterval = multipleOfInterval * time.Minute
@@ -212,9 +246,32 @@ func InitSmfContext(config *factory.Config) {
 		}
 	}
 
-	smfContext.SnssaiInfos = make([]*SnssaiSmfInfo, 0, len(configuration.SNssaiInfo))
+	smfContext.SnssaiInfos = newSnssaiInfos(configuration.SNssaiInfo)
+
+	smfContext.ULCLSupport = configuration.ULCL
+
+	smfContext.SupportedPDUSessionType = "IPv4v6"
 
-	for _, snssaiInfoConfig := range configuration.SNssaiInfo {
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(&configuration.UserPlaneInformation)
+
+	smfContext.ChargingIDGenerator, _ = pool.NewLazyReusePool(1, math.MaxUint32)
+
+	// The NF instance ID is recovered from the session store before the NF profile is set up
+	InitSessionStore(configuration.SessionStore)
+
+	smfContext.SetupNFProfile(config)
+
+	smfContext.Locality = configuration.Locality
+
+	TeidGenerator, _ = pool.NewLazyReusePool(1, math.MaxUint32)
+
+	smfContext.Ues = InitSmfUeData()
+}
+
+func newSnssaiInfos(snssaiInfoConfigs []*factory.SnssaiInfoItem) []*SnssaiSmfInfo {
+	snssaiInfos := make([]*SnssaiSmfInfo, 0, len(snssaiInfoConfigs))
+
+	for _, snssaiInfoConfig := range snssaiInfoConfigs {
 		snssaiInfo := SnssaiSmfInfo{}
 		snssaiInfo.Snssai = SNssai{
 			Sst: snssaiInfoConfig.SNssai.Sst,
@@ -232,26 +289,12 @@ func InitSmfContext(config *factory.Config) {
 			if dnnInfoConfig.PCSCF != nil {
 				dnnInfo.PCSCF.IPv4Addr = net.ParseIP(dnnInfoConfig.PCSCF.IPv4Addr).To4()
 			}
+			dnnInfo.DlBuffering = dnnInfoConfig.DlBuffering
 			snssaiInfo.DnnInfos[dnnInfoConfig.Dnn] = &dnnInfo
 		}
-		smfContext.SnssaiInfos = append(smfContext.SnssaiInfos, &snssaiInfo)
+		snssaiInfos = append(snssaiInfos, &snssaiInfo)
 	}
-
-	smfContext.ULCLSupport = configuration.ULCL
-
-	smfContext.SupportedPDUSessionType = "IPv4"
-
-	smfContext.UserPlaneInformation = NewUserPlaneInformation(&configuration.UserPlaneInformation)
-
-	smfContext.ChargingIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
-
-	smfContext.SetupNFProfile(config)
-
-	smfContext.Locality = configuration.Locality
-
-	TeidGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
-
-	smfContext.Ues = InitSmfUeData()
+	return snssaiInfos
 }
 
 func InitSMFUERouting(routingConfig *factory.RoutingConfig) {
@@ -267,27 +310,41 @@ func InitSMFUERouting(routingConfig *factory.RoutingConfig) {
 	logger.CtxLog.Infof("ue routing config Info: Version[%s] Description[%s]",
 		routingConfig.Info.Version, routingConfig.Info.Description)
 
-	UERoutingInfo := routingConfig.UERoutingInfo
-	smfContext.UEPreConfigPathPool = make(map[string]*UEPreConfigPaths)
-	smfContext.UEDefaultPathPool = make(map[string]*UEDefaultPaths)
-	smfContext.ULCLGroups = make(map[string][]string)
+	ulclGroups, uePreConfigPathPool, ueDefaultPathPool, errs := newUERouting(routingConfig.UERoutingInfo)
+	for _, err := range errs {
+		logger.CtxLog.Warnln(err)
+	}
+	smfContext.ULCLGroups = ulclGroups
+	smfContext.UEPreConfigPathPool = uePreConfigPathPool
+	smfContext.UEDefaultPathPool = ueDefaultPathPool
+}
+
+// newUERouting makes the ULCL groups and their paths, the groups whose paths fail to be made are without paths
+func newUERouting(ueRoutingInfo map[string]factory.UERoutingInfo) (
+	map[string][]string, map[string]*UEPreConfigPaths, map[string]*UEDefaultPaths, []error,
+) {
+	ulclGroups := make(map[string][]string)
+	uePreConfigPathPool := make(map[string]*UEPreConfigPaths)
+	ueDefaultPathPool := make(map[string]*UEDefaultPaths)
+	var errs []error
 
-	for groupName, routingInfo := range UERoutingInfo {
+	for groupName, routingInfo := range ueRoutingInfo {
 		logger.CtxLog.Debugln("Set context for ULCL group: ", groupName)
-		smfContext.ULCLGroups[groupName] = routingInfo.Members
+		ulclGroups[groupName] = routingInfo.Members
 		uePreConfigPaths, err := NewUEPreConfigPaths(routingInfo.SpecificPaths)
 		if err != nil {
-			logger.CtxLog.Warnln(err)
+			errs = append(errs, fmt.Errorf("ULCL group %s: %w", groupName, err))
 		} else {
-			smfContext.UEPreConfigPathPool[groupName] = uePreConfigPaths
+			uePreConfigPathPool[groupName] = uePreConfigPaths
 		}
 		ueDefaultPaths, err := NewUEDefaultPaths(smfContext.UserPlaneInformation, routingInfo.Topology)
 		if err != nil {
-			logger.CtxLog.Warnln(err)
+			errs = append(errs, fmt.Errorf("ULCL group %s: %w", groupName, err))
 		} else {
-			smfContext.UEDefaultPathPool[groupName] = ueDefaultPaths
+			ueDefaultPathPool[groupName] = ueDefaultPaths
 		}
 	}
+	return ulclGroups, uePreConfigPathPool, ueDefaultPathPool, errs
 }
 
 func GetSelf() *SMFContext {
@@ -299,6 +356,8 @@ func GetUserPlaneInformation() *UserPlaneInformation {
 }
 
 func GetUEDefaultPathPool(groupName string) *UEDefaultPaths {
+	smfContext.reloadMu.RLock()
+	defer smfContext.reloadMu.RUnlock()
 	return smfContext.UEDefaultPathPool[groupName]
 }
 
@@ -2,11 +2,13 @@ package context
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
 
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/smf/internal/logger"
@@ -47,6 +49,9 @@ type DataPathNode struct {
 
 	UpLinkTunnel   *GTPTunnel
 	DownLinkTunnel *GTPTunnel
+	// FramedRoutePDRs detect the DL traffic to the framed routes in the PSA,
+	// they share the FAR, QERs and URRs of the PDR of DownLinkTunnel
+	FramedRoutePDRs []*PDR
 	// for UE Routing Topology
 	// for special case:
 	// branching & leafnode
@@ -187,14 +192,7 @@ func (node *DataPathNode) DeactivateUpLinkTunnel(smContext *SMContext) {
 			if err != nil {
 				logger.CtxLog.Warnln("Deactivated UpLinkTunnel", err)
 			}
-
-			bar := far.BAR
-			if bar != nil {
-				err = node.UPF.RemoveBAR(bar)
-				if err != nil {
-					logger.CtxLog.Warnln("Deactivated UpLinkTunnel", err)
-				}
-			}
+			// The BAR is shared by the FARs of the PFCP session and removed with the session
 		}
 		if qerList := pdr.QER; qerList != nil {
 			for _, qer := range qerList {
@@ -209,7 +207,57 @@ func (node *DataPathNode) DeactivateUpLinkTunnel(smContext *SMContext) {
 	}
 }
 
+// activateFramedRoutePDRs creates a DL PDR for each framed route of the PDU session (TS 23.501 5.6.14),
+// the UPF without the Framed Routing feature detects the traffic to the route with an SDF filter instead
+func (node *DataPathNode) activateFramedRoutePDRs(smContext *SMContext, dlPDR *PDR) error {
+	frrt := node.UPF.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesFrrt)
+	for _, route := range smContext.FramedRoutes() {
+		pdr, err := node.UPF.AddPDR()
+		if err != nil {
+			return fmt.Errorf("Add PDR failed: %s", err)
+		}
+		// The PDR shares the downlink FAR
+		if err = node.UPF.RemoveFAR(pdr.FAR); err != nil {
+			return err
+		}
+
+		pdr.Precedence = dlPDR.Precedence
+		pdr.PDI = PDI{
+			SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCore},
+			NetworkInstance: dlPDR.PDI.NetworkInstance,
+		}
+		if frrt {
+			pdr.PDI.FramedRoute = route
+		} else {
+			flowDesc := fmt.Sprintf("permit out ip from any to %s", route)
+			pdr.PDI.SDFFilter = &pfcpType.SDFFilter{
+				Fd:                      true,
+				LengthOfFlowDescription: uint16(len(flowDesc)),
+				FlowDescription:         []byte(flowDesc),
+			}
+		}
+		pdr.FAR = dlPDR.FAR
+		pdr.QER = dlPDR.QER
+		pdr.URR = dlPDR.URR
+		pdr.State = RULE_INITIAL
+		if err = smContext.PutPDRtoPFCPSession(node.UPF.NodeID, pdr); err != nil {
+			return err
+		}
+		node.FramedRoutePDRs = append(node.FramedRoutePDRs, pdr)
+	}
+	return nil
+}
+
 func (node *DataPathNode) DeactivateDownLinkTunnel(smContext *SMContext) {
+	// The FAR, QERs and URRs of the framed route PDRs are removed with the downlink PDR
+	for _, pdr := range node.FramedRoutePDRs {
+		smContext.RemovePDRfromPFCPSession(node.UPF.NodeID, pdr)
+		if err := node.UPF.RemovePDR(pdr); err != nil {
+			logger.CtxLog.Warnln("Deactivated DownLinkTunnel", err)
+		}
+	}
+	node.FramedRoutePDRs = nil
+
 	if pdr := node.DownLinkTunnel.PDR; pdr != nil {
 		smContext.RemovePDRfromPFCPSession(node.UPF.NodeID, pdr)
 		err := node.UPF.RemovePDR(pdr)
@@ -222,14 +270,7 @@ func (node *DataPathNode) DeactivateDownLinkTunnel(smContext *SMContext) {
 			if err != nil {
 				logger.CtxLog.Warnln("Deactivated DownLinkTunnel", err)
 			}
-
-			bar := far.BAR
-			if bar != nil {
-				err = node.UPF.RemoveBAR(bar)
-				if err != nil {
-					logger.CtxLog.Warnln("Deactivated DownLinkTunnel", err)
-				}
-			}
+			// The BAR is shared by the FARs of the PFCP session and removed with the session
 		}
 		if qerList := pdr.QER; qerList != nil {
 			for _, qer := range qerList {
@@ -294,6 +335,31 @@ func (dataPathPool DataPathPool) GetDefaultPath() *DataPath {
 	return nil
 }
 
+// HasUPF reports whether any activated data path of the pool goes through the UPF
+func (dataPathPool DataPathPool) HasUPF(upf *UPF) bool {
+	for _, path := range dataPathPool {
+		if !path.Activated {
+			continue
+		}
+		for node := path.FirstDPNode; node != nil; node = node.Next() {
+			if node.UPF == upf {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsPathFailed reports whether any activated data path of the pool goes through a failed user plane path
+func (dataPathPool DataPathPool) IsPathFailed(anIP net.IP) bool {
+	for _, path := range dataPathPool {
+		if path.Activated && path.IsPathFailed(anIP) {
+			return true
+		}
+	}
+	return false
+}
+
 func (dataPathPool DataPathPool) ResetDefaultPath() error {
 	for _, path := range dataPathPool {
 		path.IsDefaultPath = false
@@ -356,10 +422,15 @@ func (node DataPathNode) addUrrToNode(smContext *SMContext, urrId uint32, isMeas
 	id := getUrrIdKey(currentUUID, urrId)
 
 	if urr, ok = smContext.UrrUpfMap[id]; !ok {
-		if urr, err = node.UPF.AddURR(urrId,
+		opts := []UrrOpt{
 			NewMeasureInformation(isMeasurePkt, isMeasureBeforeQos),
 			NewMeasurementPeriod(smContext.UrrReportTime),
-			NewVolumeThreshold(smContext.UrrReportThreshold)); err != nil {
+			NewVolumeThreshold(smContext.UrrReportThreshold),
+		}
+		if smContext.SelectedPDUSessionType == nasMessage.PDUSessionTypeEthernet {
+			opts = append(opts, SetMACAddressReportingTrigger())
+		}
+		if urr, err = node.UPF.AddURR(urrId, opts...); err != nil {
 			logger.PduSessLog.Errorln("new URR failed")
 			return
 		}
@@ -512,7 +583,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 
 			var iface *UPFInterfaceInfo
 			if curDataPathNode.IsANUPF() {
-				iface = ULDestUPF.GetInterface(models.UpInterfaceType_N3, smContext.Dnn)
+				iface = ULDestUPF.GetInterface(smContext.AccessInterfaceType(), smContext.Dnn)
 			} else {
 				iface = ULDestUPF.GetInterface(models.UpInterfaceType_N9, smContext.Dnn)
 			}
@@ -526,26 +597,20 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
 				return
 			} else {
+				localFTEID := NewLocalFTEID(upIP, curULTunnel.TEID)
+				if curDataPathNode.IsANUPF() {
+					localFTEID = smContext.ULN3LocalFTEID(curDataPathNode.UPF, upIP)
+				}
 				ULPDR.PDI = PDI{
 					SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceAccess},
-					LocalFTeid: &pfcpType.FTEID{
-						V4:          true,
-						Ipv4Address: upIP,
-						Teid:        curULTunnel.TEID,
-					},
+					LocalFTeid:      localFTEID,
 					NetworkInstance: &pfcpType.NetworkInstance{
 						NetworkInstance: smContext.Dnn,
-						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
-					},
-					UEIPAddress: &pfcpType.UEIPAddress{
-						V4:          true,
-						Ipv4Address: smContext.PDUAddress.To4(),
+						FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
 					},
+					UEIPAddress: smContext.UEIPAddress(false),
 				}
-			}
-
-			ULPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
-				OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+				ULPDR.OuterHeaderRemoval = NewOuterHeaderRemoval(upIP)
 			}
 
 			ULFAR := ULPDR.FAR
@@ -565,7 +630,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				},
 				NetworkInstance: &pfcpType.NetworkInstance{
 					NetworkInstance: smContext.Dnn,
-					FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
+					FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
 				},
 			}
 
@@ -577,12 +642,12 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 					logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
 					return
 				} else {
-					ULFAR.ForwardingParameters.OuterHeaderCreation = &pfcpType.OuterHeaderCreation{
-						OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
-						Ipv4Address:                    upIP,
-						Teid:                           nextULTunnel.TEID,
-					}
+					ULFAR.ForwardingParameters.OuterHeaderCreation = NewOuterHeaderCreation(upIP, nextULTunnel.TEID)
 				}
+			} else if smContext.ServedByPeerSmf() {
+				// The V-UPF or the I-UPF forwards the UL traffic to the H-UPF or the PSA over N9
+				ULFAR.ForwardingParameters.OuterHeaderCreation = NewOuterHeaderCreation(
+					smContext.AnchorULTunnel.IPAddress, smContext.AnchorULTunnel.TEID)
 			}
 		}
 
@@ -600,26 +665,28 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 
 			DLPDR.Precedence = precedence
 
-			if curDataPathNode.IsAnchorUPF() {
+			// The V-UPF or the I-UPF receives the DL traffic from the H-UPF or the PSA over N9
+			if curDataPathNode.IsAnchorUPF() && !smContext.ServedByPeerSmf() {
 				DLPDR.PDI = PDI{
 					SourceInterface: pfcpType.SourceInterface{
 						InterfaceValue: pfcpType.SourceInterfaceCore,
 					},
 					NetworkInstance: &pfcpType.NetworkInstance{
 						NetworkInstance: smContext.Dnn,
-						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
-					},
-					UEIPAddress: &pfcpType.UEIPAddress{
-						V4:          true,
-						Sd:          true,
-						Ipv4Address: smContext.PDUAddress.To4(),
+						FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
 					},
+					UEIPAddress: smContext.UEIPAddress(true),
+					// Detect all DL Ethernet traffic of the PDU session (TS 29.244 5.13.1)
+					EthernetPDUSessionInformation: smContext.SelectedPDUSessionType ==
+						nasMessage.PDUSessionTypeEthernet,
 				}
-			} else {
-				DLPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
-					OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+				if dataPath.IsDefaultPath {
+					if err := curDataPathNode.activateFramedRoutePDRs(smContext, DLPDR); err != nil {
+						logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
+						return
+					}
 				}
-
+			} else {
 				iface = DLDestUPF.GetInterface(models.UpInterfaceType_N9, smContext.Dnn)
 				if upIP, err := iface.IP(smContext.SelectedPDUSessionType); err != nil {
 					logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
@@ -627,21 +694,14 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				} else {
 					DLPDR.PDI = PDI{
 						SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCore},
-						LocalFTeid: &pfcpType.FTEID{
-							V4:          true,
-							Ipv4Address: upIP,
-							Teid:        curDLTunnel.TEID,
-						},
+						LocalFTeid:      NewLocalFTEID(upIP, curDLTunnel.TEID),
 						NetworkInstance: &pfcpType.NetworkInstance{
 							NetworkInstance: smContext.Dnn,
-							FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
-						},
-						UEIPAddress: &pfcpType.UEIPAddress{
-							V4:          true,
-							Sd:          true,
-							Ipv4Address: smContext.PDUAddress.To4(),
+							FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
 						},
+						UEIPAddress: smContext.UEIPAddress(true),
 					}
+					DLPDR.OuterHeaderRemoval = NewOuterHeaderRemoval(upIP)
 				}
 			}
 
@@ -670,11 +730,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				} else {
 					DLFAR.ForwardingParameters = &ForwardingParameters{
 						DestinationInterface: pfcpType.DestinationInterface{InterfaceValue: pfcpType.DestinationInterfaceAccess},
-						OuterHeaderCreation: &pfcpType.OuterHeaderCreation{
-							OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
-							Ipv4Address:                    upIP,
-							Teid:                           nextDLTunnel.TEID,
-						},
+						OuterHeaderCreation:  NewOuterHeaderCreation(upIP, nextDLTunnel.TEID),
 					}
 				}
 			} else {
@@ -687,14 +743,20 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				if anIP := smContext.Tunnel.ANInformation.IPAddress; anIP != nil {
 					DLFAR.ForwardingParameters.NetworkInstance = &pfcpType.NetworkInstance{
 						NetworkInstance: smContext.Dnn,
-						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
+						FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
+					}
+					DLFAR.ForwardingParameters.OuterHeaderCreation = NewOuterHeaderCreation(
+						anIP, smContext.Tunnel.ANInformation.TEID)
+					// The DL tunnel of the V-UPF or the I-UPF is known at the PDU session establishment
+					if smContext.ServesPeerSmf() {
+						DLFAR.ApplyAction = pfcpType.ApplyAction{
+							Buff: false,
+							Drop: false,
+							Dupl: false,
+							Forw: true,
+							Nocp: false,
+						}
 					}
-					DLFAR.ForwardingParameters.OuterHeaderCreation = new(pfcpType.OuterHeaderCreation)
-
-					dlOuterHeaderCreation := DLFAR.ForwardingParameters.OuterHeaderCreation
-					dlOuterHeaderCreation.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
-					dlOuterHeaderCreation.Teid = smContext.Tunnel.ANInformation.TEID
-					dlOuterHeaderCreation.Ipv4Address = smContext.Tunnel.ANInformation.IPAddress.To4()
 				}
 			}
 		}
@@ -719,6 +781,24 @@ func (dataPath *DataPath) DeactivateTunnelAndPDR(smContext *SMContext) {
 	dataPath.Activated = false
 }
 
+// IsPathFailed reports whether a user plane path failure has been reported on any link of the data path,
+// anIP is the address of the AN tunnel endpoint
+func (p *DataPath) IsPathFailed(anIP net.IP) bool {
+	if p.FirstDPNode == nil {
+		return false
+	}
+	if p.FirstDPNode.UPF.IsPathFailed(anIP) {
+		return true
+	}
+	for node := p.FirstDPNode; node.Next() != nil; node = node.Next() {
+		next := node.Next()
+		if node.UPF.IsPathFailedToUPF(next.UPF) || next.UPF.IsPathFailedToUPF(node.UPF) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *DataPath) RemovePDR() {
 	for curDPNode := p.FirstDPNode; curDPNode != nil; curDPNode = curDPNode.Next() {
 		if curDPNode.DownLinkTunnel != nil && curDPNode.DownLinkTunnel.PDR != nil {
@@ -966,6 +1046,47 @@ func (p *DataPath) UpdateFlowDescription(ulFlowDesc, dlFlowDesc string) {
 	}
 }
 
+// UpdateApplicationID applies the application identifier to the PDRs of the data path on the UPFs which have
+// accepted the PFDs of the application. The flow description is applied instead on the other UPFs, which don't
+// support PFDM or haven't confirmed the provisioning of the PFDs.
+func (p *DataPath) UpdateApplicationID(appID, flowDesc string) error {
+	for curDPNode := p.FirstDPNode; curDPNode != nil; curDPNode = curDPNode.Next() {
+		if curDPNode.UPF.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesPfdm) &&
+			curDPNode.UPF.IsPfdProvisioned(appID) {
+			for _, pdr := range []*PDR{curDPNode.DownLinkTunnel.PDR, curDPNode.UpLinkTunnel.PDR} {
+				pdr.PDI.ApplicationID = appID
+				pdr.PDI.SDFFilter = nil
+			}
+			continue
+		}
+		if flowDesc == "" {
+			return fmt.Errorf("PFDs of AppID [%s] aren't provisioned to UPF[%s] and no flow description for them",
+				appID, curDPNode.GetNodeIP())
+		}
+		for _, pdr := range []*PDR{curDPNode.DownLinkTunnel.PDR, curDPNode.UpLinkTunnel.PDR} {
+			pdr.PDI.ApplicationID = ""
+			pdr.PDI.SDFFilter = &pfcpType.SDFFilter{
+				Fd:                      true,
+				LengthOfFlowDescription: uint16(len(flowDesc)),
+				FlowDescription:         []byte(flowDesc),
+			}
+		}
+	}
+	return nil
+}
+
+// UpdateEthernetPacketFilter applies the Ethernet packet filter to the PDRs of the data path
+func (p *DataPath) UpdateEthernetPacketFilter(epf *EthernetPacketFilter) {
+	for curDPNode := p.FirstDPNode; curDPNode != nil; curDPNode = curDPNode.Next() {
+		for _, pdr := range []*PDR{curDPNode.DownLinkTunnel.PDR, curDPNode.UpLinkTunnel.PDR} {
+			pdrEPF := *epf
+			// Ethernet Filter ID is mandatory for the bidirectional filter
+			pdrEPF.EthernetFilterID = uint32(pdr.PDRID)
+			pdr.PDI.EthernetPacketFilter = &pdrEPF
+		}
+	}
+}
+
 func (p *DataPath) AddForwardingParameters(fwdPolicyID string, teid uint32) {
 	for curDPNode := p.FirstDPNode; curDPNode != nil; curDPNode = curDPNode.Next() {
 		if curDPNode.IsAnchorUPF() {
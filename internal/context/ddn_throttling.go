@@ -0,0 +1,42 @@
+package context
+
+import (
+	"sync"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+)
+
+// ddnThrottling is the throttling of the Downlink Data Notifications requested by an AMF (TS 23.502 4.2.3.3),
+// the notifications of the downlink data whose ARP priority level is higher than highestPrioArp are not throttled
+type ddnThrottling struct {
+	until          time.Time
+	highestPrioArp *models.Arp
+}
+
+// ddnThrottlings maps the API prefix of the AMF to its DDN throttling
+var ddnThrottlings sync.Map
+
+// StartDDNThrottling throttles the Downlink Data Notifications to the AMF for the delay
+func StartDDNThrottling(amfApiPrefix string, delay time.Duration, highestPrioArp *models.Arp) {
+	ddnThrottlings.Store(amfApiPrefix, &ddnThrottling{
+		until:          time.Now().Add(delay),
+		highestPrioArp: highestPrioArp,
+	})
+}
+
+// IsDDNThrottled returns true if the Downlink Data Notification with the ARP to the AMF is throttled
+func IsDDNThrottled(amfApiPrefix string, arp *models.Arp) bool {
+	value, ok := ddnThrottlings.Load(amfApiPrefix)
+	if !ok {
+		return false
+	}
+	throttling := value.(*ddnThrottling)
+	if time.Now().After(throttling.until) {
+		ddnThrottlings.CompareAndDelete(amfApiPrefix, throttling)
+		return false
+	}
+	// The lower priority level is the higher priority (TS 23.501 5.7.2.2)
+	return throttling.highestPrioArp == nil || arp == nil ||
+		arp.PriorityLevel >= throttling.highestPrioArp.PriorityLevel
+}
@@ -0,0 +1,331 @@
+package context
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+)
+
+// Nsmf_EventExposure subscriptions (TS 29.508)
+
+var eeSubscriptionPool sync.Map // Key: subId
+
+// EventExposureSubscription - a subscription created by a NF service consumer (e.g. NEF/AF)
+// through the Nsmf_EventExposure service
+type EventExposureSubscription struct {
+	*models.NsmfEventExposure
+
+	mu         sync.Mutex
+	numReports int32
+}
+
+// AddEventExposureSubscription allocates a subscription ID and stores the subscription.
+func AddEventExposureSubscription(sub *models.NsmfEventExposure) *EventExposureSubscription {
+	sub.SubId = uuid.New().String()
+	eeSub := &EventExposureSubscription{
+		NsmfEventExposure: sub,
+	}
+	eeSubscriptionPool.Store(sub.SubId, eeSub)
+	return eeSub
+}
+
+// GetEventExposureSubscription returns the subscription with subId,
+// or nil if it does not exist or has expired.
+func GetEventExposureSubscription(subId string) *EventExposureSubscription {
+	value, ok := eeSubscriptionPool.Load(subId)
+	if !ok {
+		return nil
+	}
+	eeSub := value.(*EventExposureSubscription)
+	if eeSub.IsExpired(time.Now()) {
+		eeSubscriptionPool.Delete(subId)
+		return nil
+	}
+	return eeSub
+}
+
+// ReplaceEventExposureSubscription replaces the subscription with subId,
+// the report counter starts over. It returns nil if the subscription does not exist.
+func ReplaceEventExposureSubscription(subId string, sub *models.NsmfEventExposure) *EventExposureSubscription {
+	if GetEventExposureSubscription(subId) == nil {
+		return nil
+	}
+	sub.SubId = subId
+	eeSub := &EventExposureSubscription{
+		NsmfEventExposure: sub,
+	}
+	eeSubscriptionPool.Store(subId, eeSub)
+	return eeSub
+}
+
+// RemoveEventExposureSubscription deletes the subscription with subId
+// and reports whether it existed.
+func RemoveEventExposureSubscription(subId string) bool {
+	_, ok := eeSubscriptionPool.LoadAndDelete(subId)
+	return ok
+}
+
+// ProcEachEventExposureSubscription calls procFunc for every subscription that has not expired.
+func ProcEachEventExposureSubscription(procFunc func(*EventExposureSubscription)) {
+	now := time.Now()
+	eeSubscriptionPool.Range(func(key, value interface{}) bool {
+		eeSub := value.(*EventExposureSubscription)
+		if eeSub.IsExpired(now) {
+			eeSubscriptionPool.Delete(key)
+			return true
+		}
+		procFunc(eeSub)
+		return true
+	})
+}
+
+func (s *EventExposureSubscription) IsExpired(now time.Time) bool {
+	return s.Expiry != nil && !now.Before(*s.Expiry)
+}
+
+// EventSubscription returns the event subscription of the given event, or nil if not subscribed
+func (s *EventExposureSubscription) EventSubscription(
+	event models.SmfEvent,
+) *models.SmfEventExposureEventSubscription {
+	for i := range s.EventSubs {
+		if s.EventSubs[i].Event == event {
+			return &s.EventSubs[i]
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the SM context is targeted by this subscription
+func (s *EventExposureSubscription) Matches(smContext *SMContext) bool {
+	if !s.AnyUeInd {
+		if s.Supi == "" || s.Supi != smContext.Supi {
+			return false
+		}
+		if s.PduSeId != 0 && s.PduSeId != smContext.PDUSessionID {
+			return false
+		}
+	}
+	if s.Dnn != "" && s.Dnn != smContext.Dnn {
+		return false
+	}
+	if s.Snssai != nil {
+		if smContext.SNssai == nil ||
+			s.Snssai.Sst != smContext.SNssai.Sst ||
+			s.Snssai.Sd != smContext.SNssai.Sd {
+			return false
+		}
+	}
+	return true
+}
+
+// maxReportNbr returns the maximum number of reports, 0 means no limit
+func (s *EventExposureSubscription) maxReportNbr() int32 {
+	if s.NotifMethod == models.SmfEventExposureNotificationMethod_ONE_TIME {
+		return 1
+	}
+	return s.MaxReportNbr
+}
+
+// CountReport increases the number of reports sent for this subscription
+// and removes the subscription once the maximum number of reports is reached.
+// It returns false if no more report is allowed.
+func (s *EventExposureSubscription) CountReport() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	maxReportNbr := s.maxReportNbr()
+	if maxReportNbr > 0 && s.numReports >= maxReportNbr {
+		return false
+	}
+	s.numReports++
+	if maxReportNbr > 0 && s.numReports >= maxReportNbr {
+		// Only remove the entry if it has not been replaced in the meantime
+		eeSubscriptionPool.CompareAndDelete(s.SubId, s)
+	}
+	return true
+}
+
+// countEventExposureReport returns false if the subscription is gone or no more report is allowed
+func countEventExposureReport(subId string) bool {
+	eeSub := GetEventExposureSubscription(subId)
+	if eeSub == nil {
+		return false
+	}
+	return eeSub.CountReport()
+}
+
+func (c *SMContext) pduSessionType() models.PduSessionType {
+	switch c.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv4:
+		return models.PduSessionType_IPV4
+	case nasMessage.PDUSessionTypeIPv6:
+		return models.PduSessionType_IPV6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return models.PduSessionType_IPV4_V6
+	case nasMessage.PDUSessionTypeUnstructured:
+		return models.PduSessionType_UNSTRUCTURED
+	case nasMessage.PDUSessionTypeEthernet:
+		return models.PduSessionType_ETHERNET
+	}
+	return ""
+}
+
+// IPv6PrefixString returns the IPv6 prefix of the PDU session in CIDR notation, or empty if none
+func (c *SMContext) IPv6PrefixString() string {
+	if c.PDUIPv6Prefix == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", c.PDUIPv6Prefix, IPv6PrefixLen)
+}
+
+// NewEventNotification returns an event notification filled with the PDU session information
+func (c *SMContext) NewEventNotification(event models.SmfEvent) models.SmfEventExposureEventNotification {
+	now := time.Now()
+	en := models.SmfEventExposureEventNotification{
+		Event:       event,
+		TimeStamp:   &now,
+		Supi:        c.Supi,
+		Gpsi:        c.Gpsi,
+		PduSeId:     c.PDUSessionID,
+		Dnn:         c.Dnn,
+		Snssai:      c.SNssai,
+		PduSessType: c.pduSessionType(),
+	}
+	if ip := c.PDUAddress.To4(); ip != nil {
+		en.Ipv4Addr = ip.String()
+	}
+	if prefix := c.IPv6PrefixString(); prefix != "" {
+		en.Ipv6Prefixes = []string{prefix}
+	}
+	return en
+}
+
+// SendEventExposureNotification notifies the event to every subscription targeting this SM context.
+// fillFunc may be used to set the event specific attributes of the notification.
+func (c *SMContext) SendEventExposureNotification(
+	event models.SmfEvent,
+	fillFunc func(*models.SmfEventExposureEventNotification),
+	notifCb NotifCallback,
+) {
+	ProcEachEventExposureSubscription(func(eeSub *EventExposureSubscription) {
+		if eeSub.EventSubscription(event) == nil || !eeSub.Matches(c) {
+			return
+		}
+		if !eeSub.CountReport() {
+			return
+		}
+
+		en := c.NewEventNotification(event)
+		if fillFunc != nil {
+			fillFunc(&en)
+		}
+		c.Log.Infof("Send %s Event Exposure Notification [%s] to NEF/AF", event, eeSub.NotifId)
+		go notifCb(eeSub.NotifUri, &models.NsmfEventExposureNotification{
+			NotifId:     eeSub.NotifId,
+			EventNotifs: []models.SmfEventExposureEventNotification{en},
+		})
+	})
+}
+
+// BuildEventExposureImmeReport returns the current status of the subscribed events of this SM context,
+// it is used when the NF service consumer requests immediate reporting.
+func (c *SMContext) BuildEventExposureImmeReport(
+	eeSub *EventExposureSubscription,
+) []models.SmfEventExposureEventNotification {
+	var reports []models.SmfEventExposureEventNotification
+	if !eeSub.Matches(c) || c.State() != Active {
+		return nil
+	}
+	for _, eventSub := range eeSub.EventSubs {
+		switch eventSub.Event {
+		case models.SmfEvent_PDU_SES_EST:
+			reports = append(reports, c.NewEventNotification(eventSub.Event))
+		case models.SmfEvent_UE_IP_CH:
+			if c.HasPDUAddress() {
+				en := c.NewEventNotification(eventSub.Event)
+				if ip := c.PDUAddress.To4(); ip != nil {
+					en.AdIpv4Addr = ip.String()
+				}
+				en.AdIpv6Prefix = c.IPv6PrefixString()
+				reports = append(reports, en)
+			}
+		case models.SmfEvent_QFI_ALLOC:
+			qosFlows := c.QosFlowEventInfos()
+			for _, qfi := range sortedQfis(qosFlows) {
+				en := c.NewEventNotification(eventSub.Event)
+				en.Qfi = int32(qfi)
+				en.FlowDescs = qosFlows[qfi].FlowDescs
+				en.AppId = qosFlows[qfi].AppID
+				reports = append(reports, en)
+			}
+		}
+	}
+	return reports
+}
+
+// QosFlowEventInfo is the QoS flow reported in the QFI_ALLOC event notifications
+type QosFlowEventInfo struct {
+	QosData models.QosData
+	// FlowDescs and AppID are the traffic of the PCC rules mapped to the QoS flow
+	FlowDescs []string
+	AppID     string
+}
+
+// QosFlowEventInfos returns the QoS flows of the PCC rules by their QFIs
+func (c *SMContext) QosFlowEventInfos() map[uint8]*QosFlowEventInfo {
+	qosFlows := make(map[uint8]*QosFlowEventInfo)
+	for qfi, qosFlow := range c.AdditonalQosFlows {
+		qosFlows[qfi] = &QosFlowEventInfo{QosData: *qosFlow.QoSProfile}
+	}
+	pccRuleIDs := make([]string, 0, len(c.PCCRules))
+	for id := range c.PCCRules {
+		pccRuleIDs = append(pccRuleIDs, id)
+	}
+	sort.Strings(pccRuleIDs)
+	for _, id := range pccRuleIDs {
+		pcc := c.PCCRules[id]
+		qosFlow, ok := qosFlows[pcc.QFI]
+		if !ok {
+			continue
+		}
+		if flowDesc := pcc.FlowDescription(); flowDesc != "" {
+			qosFlow.FlowDescs = append(qosFlow.FlowDescs, flowDesc)
+		}
+		if qosFlow.AppID == "" {
+			qosFlow.AppID = pcc.AppId
+		}
+	}
+	return qosFlows
+}
+
+// ChangedQosFlows compares the QoS flows before and after a change, it returns the QFIs of the QoS flows
+// allocated or modified, and the QFIs of the QoS flows released
+func ChangedQosFlows(origQosFlows, qosFlows map[uint8]*QosFlowEventInfo) (changed, released []uint8) {
+	for _, qfi := range sortedQfis(qosFlows) {
+		if orig, ok := origQosFlows[qfi]; !ok || !reflect.DeepEqual(orig, qosFlows[qfi]) {
+			changed = append(changed, qfi)
+		}
+	}
+	for _, qfi := range sortedQfis(origQosFlows) {
+		if _, ok := qosFlows[qfi]; !ok {
+			released = append(released, qfi)
+		}
+	}
+	return changed, released
+}
+
+func sortedQfis(qosFlows map[uint8]*QosFlowEventInfo) []uint8 {
+	qfis := make([]uint8, 0, len(qosFlows))
+	for qfi := range qosFlows {
+		qfis = append(qfis, qfi)
+	}
+	sort.Slice(qfis, func(i, j int) bool { return qfis[i] < qfis[j] })
+	return qfis
+}
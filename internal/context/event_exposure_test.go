@@ -0,0 +1,190 @@
+package context_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func newEventExposureTestSMContext(supi string, pduSessID int32) *smf_context.SMContext {
+	initConfig()
+
+	smctx := smf_context.NewSMContext(supi, pduSessID)
+	smctx.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         supi,
+		PduSessionId: pduSessID,
+		Dnn:          "internet",
+		SNssai: &models.Snssai{
+			Sst: 1,
+			Sd:  "010203",
+		},
+	}
+	smctx.SelectedPDUSessionType = 1
+	smctx.PDUAddress = net.ParseIP("10.60.0.1").To4()
+	return smctx
+}
+
+func TestEventExposureSubscriptionStore(t *testing.T) {
+	sub := smf_context.AddEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:     "imsi-208930000000101",
+		NotifId:  "notif-1",
+		NotifUri: "http://127.0.0.1:8000/notify",
+		EventSubs: []models.SmfEventExposureEventSubscription{
+			{Event: models.SmfEvent_PDU_SES_EST},
+		},
+	})
+	require.NotEmpty(t, sub.SubId)
+	require.Equal(t, sub, smf_context.GetEventExposureSubscription(sub.SubId))
+
+	replaced := smf_context.ReplaceEventExposureSubscription(sub.SubId, &models.NsmfEventExposure{
+		Supi:     "imsi-208930000000101",
+		NotifId:  "notif-2",
+		NotifUri: "http://127.0.0.1:8000/notify",
+		EventSubs: []models.SmfEventExposureEventSubscription{
+			{Event: models.SmfEvent_PDU_SES_REL},
+		},
+	})
+	require.NotNil(t, replaced)
+	require.Equal(t, sub.SubId, replaced.SubId)
+	require.Equal(t, "notif-2", smf_context.GetEventExposureSubscription(sub.SubId).NotifId)
+	require.Nil(t, replaced.EventSubscription(models.SmfEvent_PDU_SES_EST))
+	require.NotNil(t, replaced.EventSubscription(models.SmfEvent_PDU_SES_REL))
+
+	require.True(t, smf_context.RemoveEventExposureSubscription(sub.SubId))
+	require.False(t, smf_context.RemoveEventExposureSubscription(sub.SubId))
+	require.Nil(t, smf_context.GetEventExposureSubscription(sub.SubId))
+	require.Nil(t, smf_context.ReplaceEventExposureSubscription(sub.SubId, &models.NsmfEventExposure{}))
+
+	expiry := time.Now().Add(-time.Second)
+	expired := smf_context.AddEventExposureSubscription(&models.NsmfEventExposure{
+		AnyUeInd: true,
+		Expiry:   &expiry,
+	})
+	require.Nil(t, smf_context.GetEventExposureSubscription(expired.SubId))
+}
+
+func TestEventExposureSubscriptionMatches(t *testing.T) {
+	smctx := newEventExposureTestSMContext("imsi-208930000000102", 10)
+	defer smf_context.RemoveSMContext(smctx.Ref)
+
+	testCases := []struct {
+		name    string
+		sub     *models.NsmfEventExposure
+		matched bool
+	}{
+		{
+			name:    "Supi",
+			sub:     &models.NsmfEventExposure{Supi: "imsi-208930000000102"},
+			matched: true,
+		},
+		{
+			name:    "Other Supi",
+			sub:     &models.NsmfEventExposure{Supi: "imsi-208930000000103"},
+			matched: false,
+		},
+		{
+			name:    "Other PDU Session",
+			sub:     &models.NsmfEventExposure{Supi: "imsi-208930000000102", PduSeId: 11},
+			matched: false,
+		},
+		{
+			name:    "Any UE with DNN",
+			sub:     &models.NsmfEventExposure{AnyUeInd: true, Dnn: "internet"},
+			matched: true,
+		},
+		{
+			name: "Any UE with other S-NSSAI",
+			sub: &models.NsmfEventExposure{
+				AnyUeInd: true,
+				Snssai:   &models.Snssai{Sst: 1, Sd: "112233"},
+			},
+			matched: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sub := &smf_context.EventExposureSubscription{NsmfEventExposure: tc.sub}
+			require.Equal(t, tc.matched, sub.Matches(smctx))
+		})
+	}
+}
+
+func TestSendEventExposureNotification(t *testing.T) {
+	smctx := newEventExposureTestSMContext("imsi-208930000000104", 10)
+	defer smf_context.RemoveSMContext(smctx.Ref)
+
+	sub := smf_context.AddEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:         "imsi-208930000000104",
+		NotifId:      "notif-3",
+		NotifUri:     "http://127.0.0.1:8000/notify",
+		MaxReportNbr: 2,
+		EventSubs: []models.SmfEventExposureEventSubscription{
+			{Event: models.SmfEvent_UE_IP_CH},
+		},
+	})
+	defer smf_context.RemoveEventExposureSubscription(sub.SubId)
+
+	notifCh := make(chan *models.NsmfEventExposureNotification, 3)
+	notifCb := func(uri string, notification *models.NsmfEventExposureNotification) {
+		notifCh <- notification
+	}
+
+	// Not subscribed
+	smctx.SendEventExposureNotification(models.SmfEvent_PDU_SES_EST, nil, notifCb)
+
+	for i := 0; i < 3; i++ {
+		smctx.SendEventExposureNotification(models.SmfEvent_UE_IP_CH,
+			func(en *models.SmfEventExposureEventNotification) {
+				en.AdIpv4Addr = "10.60.0.1"
+			}, notifCb)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-notifCh:
+			require.Equal(t, "notif-3", n.NotifId)
+			require.Len(t, n.EventNotifs, 1)
+			require.Equal(t, models.SmfEvent_UE_IP_CH, n.EventNotifs[0].Event)
+			require.Equal(t, "imsi-208930000000104", n.EventNotifs[0].Supi)
+			require.Equal(t, "10.60.0.1", n.EventNotifs[0].AdIpv4Addr)
+		case <-time.After(time.Second):
+			t.Fatal("notification not sent")
+		}
+	}
+
+	// The subscription is removed once MaxReportNbr is reached
+	require.Nil(t, smf_context.GetEventExposureSubscription(sub.SubId))
+	select {
+	case <-notifCh:
+		t.Fatal("unexpected notification")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestChangedQosFlows(t *testing.T) {
+	origQosFlows := map[uint8]*smf_context.QosFlowEventInfo{
+		1: {QosData: models.QosData{QosId: "1", Var5qi: 5}, FlowDescs: []string{"permit out ip from any to assigned"}},
+		2: {QosData: models.QosData{QosId: "2", Var5qi: 7}, AppID: "app1"},
+		3: {QosData: models.QosData{QosId: "3", Var5qi: 9}},
+	}
+	qosFlows := map[uint8]*smf_context.QosFlowEventInfo{
+		1: {QosData: models.QosData{QosId: "1", Var5qi: 5}, FlowDescs: []string{"permit out ip from any to assigned"}},
+		2: {QosData: models.QosData{QosId: "2", Var5qi: 8}, AppID: "app1"},
+		4: {QosData: models.QosData{QosId: "4", Var5qi: 9}},
+	}
+
+	changed, released := smf_context.ChangedQosFlows(origQosFlows, qosFlows)
+	require.Equal(t, []uint8{2, 4}, changed)
+	require.Equal(t, []uint8{3}, released)
+
+	// All QoS flows are released with the PDU session
+	changed, released = smf_context.ChangedQosFlows(origQosFlows, nil)
+	require.Empty(t, changed)
+	require.Equal(t, []uint8{1, 2, 3}, released)
+}
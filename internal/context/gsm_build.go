@@ -2,6 +2,7 @@ package context
 
 import (
 	"encoding/hex"
+	"fmt"
 
 	"github.com/free5gc/nas"
 	"github.com/free5gc/nas/nasConvert"
@@ -78,7 +79,7 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 	pDUSessionEstablishmentAccept.AuthorizedQosRules.SetLen(uint16(len(qosRulesBytes)))
 	pDUSessionEstablishmentAccept.AuthorizedQosRules.SetQosRule(qosRulesBytes)
 
-	if smContext.PDUAddress != nil {
+	if smContext.HasPDUAddress() {
 		addr, addrLen := smContext.PDUAddressToNAS()
 		pDUSessionEstablishmentAccept.PDUAddress = nasType.
 			NewPDUAddress(nasMessage.PDUSessionEstablishmentAcceptPDUAddressType)
@@ -137,7 +138,7 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 		protocolConfigurationOptions := nasConvert.NewProtocolConfigurationOptions()
 
 		// IPv4 DNS
-		if smContext.ProtocolConfigurationOptions.DNSIPv4Request {
+		if smContext.ProtocolConfigurationOptions.DNSIPv4Request && smContext.PDUAddress != nil {
 			errAddDNSServerIPv4Address := protocolConfigurationOptions.AddDNSServerIPv4Address(smContext.DNNInfo.DNS.IPv4Addr)
 			if errAddDNSServerIPv4Address != nil {
 				logger.GsmLog.Warnln("Error while adding DNS IPv4 Addr: ", errAddDNSServerIPv4Address)
@@ -145,7 +146,10 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 		}
 
 		// IPv6 DNS
-		if smContext.ProtocolConfigurationOptions.DNSIPv6Request {
+		if smContext.ProtocolConfigurationOptions.DNSIPv6Request && smContext.PDUIPv6Prefix != nil {
+			if smContext.DNNInfo.DNS.IPv6Addr == nil {
+				logger.GsmLog.Warnf("No IPv6 DNS configured for DNN[%s]", smContext.Dnn)
+			}
 			errAddDNSServerIPv6Address := protocolConfigurationOptions.AddDNSServerIPv6Address(smContext.DNNInfo.DNS.IPv6Addr)
 			if errAddDNSServerIPv6Address != nil {
 				logger.GsmLog.Warnln("Error while adding DNS IPv6 Addr: ", errAddDNSServerIPv6Address)
@@ -224,7 +228,14 @@ func BuildGSMPDUSessionReleaseCommand(smContext *SMContext, cause uint8, isTrigg
 	return m.PlainNasEncode()
 }
 
+// BuildGSMPDUSessionModificationCommand builds the network-requested PDU Session Modification Command
+// which carries the authorized session AMBR and default QoS flow description (TS 24.501 6.3.2.2)
 func BuildGSMPDUSessionModificationCommand(smContext *SMContext) ([]byte, error) {
+	sessRule := smContext.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil || sessRule.AuthDefQos == nil {
+		return nil, fmt.Errorf("no authorized session rule")
+	}
+
 	m := nas.NewMessage()
 	m.GsmMessage = nas.NewGsmMessage()
 	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionModificationCommand)
@@ -234,15 +245,30 @@ func BuildGSMPDUSessionModificationCommand(smContext *SMContext) ([]byte, error)
 
 	pDUSessionModificationCommand.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
 	pDUSessionModificationCommand.SetPDUSessionID(uint8(smContext.PDUSessionID))
-	pDUSessionModificationCommand.SetPTI(smContext.Pti)
+	// No procedure transaction identity is assigned to the network-requested procedure
+	pDUSessionModificationCommand.SetPTI(0x00)
 	pDUSessionModificationCommand.SetMessageType(nas.MsgTypePDUSessionModificationCommand)
-	// pDUSessionModificationCommand.SetQosRule()
-	// pDUSessionModificationCommand.AuthorizedQosRules.SetLen()
-	// pDUSessionModificationCommand.SessionAMBR.SetSessionAMBRForDownlink([2]uint8{0x11, 0x11})
-	// pDUSessionModificationCommand.SessionAMBR.SetSessionAMBRForUplink([2]uint8{0x11, 0x11})
-	// pDUSessionModificationCommand.SessionAMBR.SetUnitForSessionAMBRForDownlink(10)
-	// pDUSessionModificationCommand.SessionAMBR.SetUnitForSessionAMBRForUplink(10)
-	// pDUSessionModificationCommand.SessionAMBR.SetLen(uint8(len(pDUSessionModificationCommand.SessionAMBR.Octet)))
+
+	sessionAMBR := nasConvert.ModelsToSessionAMBR(sessRule.AuthSessAmbr)
+	sessionAMBR.SetIei(nasMessage.PDUSessionModificationCommandSessionAMBRType)
+	sessionAMBR.SetLen(uint8(len(sessionAMBR.Octet)))
+	pDUSessionModificationCommand.SessionAMBR = &sessionAMBR
+
+	defaultAuthDesc := nasType.QoSFlowDesc{}
+	defaultAuthDesc.QFI = sessRule.DefQosQFI
+	defaultAuthDesc.OperationCode = nasType.OperationCodeModifyExistingQoSFlowDescription
+	parameter := new(nasType.QoSFlow5QI)
+	parameter.FiveQI = uint8(sessRule.AuthDefQos.Var5qi)
+	defaultAuthDesc.Parameters = append(defaultAuthDesc.Parameters, parameter)
+	authDescs := nasType.QoSFlowDescs{defaultAuthDesc}
+	qosDescBytes, err := authDescs.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	pDUSessionModificationCommand.AuthorizedQosFlowDescriptions = nasType.
+		NewAuthorizedQosFlowDescriptions(nasMessage.PDUSessionModificationCommandAuthorizedQosFlowDescriptionsType)
+	pDUSessionModificationCommand.AuthorizedQosFlowDescriptions.SetLen(uint16(len(qosDescBytes)))
+	pDUSessionModificationCommand.AuthorizedQosFlowDescriptions.SetQoSFlowDescriptions(qosDescBytes)
 
 	return m.PlainNasEncode()
 }
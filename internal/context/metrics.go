@@ -0,0 +1,91 @@
+package context
+
+import (
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/smf/internal/metrics"
+)
+
+const (
+	ueIPPoolTypeDynamic = "dynamic"
+	ueIPPoolTypeStatic  = "static"
+)
+
+func init() {
+	metrics.NewGaugeFunc("smf_pdu_sessions",
+		"Number of the established PDU sessions by the S-NSSAI and the DNN.",
+		collectPDUSessions, "snssai", "dnn")
+	metrics.NewGaugeFunc("smf_ue_ip_pool_size",
+		"Number of the UE IP addresses of a UE IP pool.",
+		func(emit func(v float64, labelValues ...string)) {
+			procEachUeIPPool(func(upfName, poolType string, ueIPPool *UeIPPool) {
+				emit(float64(ueIPPool.pool.Total()), upfName, ueIPPool.ueSubNet.String(), poolType)
+			})
+		}, "upf", "pool", "type")
+	metrics.NewGaugeFunc("smf_ue_ip_pool_available",
+		"Number of the UE IP addresses of a UE IP pool which are not allocated.",
+		func(emit func(v float64, labelValues ...string)) {
+			procEachUeIPPool(func(upfName, poolType string, ueIPPool *UeIPPool) {
+				emit(float64(ueIPPool.pool.Remain()), upfName, ueIPPool.ueSubNet.String(), poolType)
+			})
+		}, "upf", "pool", "type")
+	metrics.NewCounterFunc("smf_upf_restarts_total",
+		"Number of the restarts of a UPF detected by the change of its Recovery Time Stamp.",
+		collectUPFRestarts, "upf")
+}
+
+func collectUPFRestarts(emit func(v float64, labelValues ...string)) {
+	upi := GetUserPlaneInformation()
+	if upi == nil {
+		return
+	}
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+	for upfName, upNode := range upi.UPFs {
+		if upNode.UPF != nil {
+			emit(float64(upNode.UPF.RestartCount()), upfName)
+		}
+	}
+}
+
+func collectPDUSessions(emit func(v float64, labelValues ...string)) {
+	ProcEachSMContext(func(smContext *SMContext) {
+		switch smContext.State() {
+		case Active, ModificationPending, PFCPModification:
+		default:
+			return
+		}
+		if smContext.SmfPduSessionSmContextCreateData == nil {
+			return
+		}
+		var snssai string
+		if smContext.SNssai != nil {
+			snssai = openapi.SnssaiModelsToHex(*smContext.SNssai)
+		}
+		emit(1, snssai, smContext.Dnn)
+	})
+}
+
+// procEachUeIPPool calls procFunc for every UE IP pool of the UPFs
+func procEachUeIPPool(procFunc func(upfName, poolType string, ueIPPool *UeIPPool)) {
+	upi := GetUserPlaneInformation()
+	if upi == nil {
+		return
+	}
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+	for upfName, upNode := range upi.UPFs {
+		if upNode.UPF == nil {
+			continue
+		}
+		for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
+			for _, dnnInfo := range snssaiInfo.DnnList {
+				for _, ueIPPool := range dnnInfo.UeIPPools {
+					procFunc(upfName, ueIPPoolTypeDynamic, ueIPPool)
+				}
+				for _, ueIPPool := range dnnInfo.StaticIPPools {
+					procFunc(upfName, ueIPPoolTypeStatic, ueIPPool)
+				}
+			}
+		}
+	}
+}
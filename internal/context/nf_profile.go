@@ -16,11 +16,14 @@ type NFProfile struct {
 }
 
 func (c *SMFContext) SetupNFProfile(nfProfileconfig *factory.Config) {
+	// The profile is replaced as a whole, it may be read while it is set up again by a configuration reload
+	profile := c.GetNfProfile()
+
 	// Set time
 	nfSetupTime := time.Now()
 
 	// set NfServiceVersion
-	c.NfProfile.NFServiceVersion = &[]models.NfServiceVersion{
+	profile.NFServiceVersion = &[]models.NfServiceVersion{
 		{
 			ApiVersionInUri: "v1",
 			ApiFullVersion: fmt.
@@ -30,12 +33,12 @@ func (c *SMFContext) SetupNFProfile(nfProfileconfig *factory.Config) {
 	}
 
 	// set NFServices
-	c.NfProfile.NFServices = new([]models.NrfNfManagementNfService)
-	for _, serviceName := range nfProfileconfig.Configuration.ServiceNameList {
-		*c.NfProfile.NFServices = append(*c.NfProfile.NFServices, models.NrfNfManagementNfService{
+	profile.NFServices = new([]models.NrfNfManagementNfService)
+	for _, serviceName := range nfProfileconfig.GetServiceNameList() {
+		*profile.NFServices = append(*profile.NFServices, models.NrfNfManagementNfService{
 			ServiceInstanceId: GetSelf().NfInstanceID + serviceName,
 			ServiceName:       models.ServiceName(serviceName),
-			Versions:          *c.NfProfile.NFServiceVersion,
+			Versions:          *profile.NFServiceVersion,
 			Scheme:            models.UriScheme_HTTPS,
 			NfServiceStatus:   models.NfServiceStatus_REGISTERED,
 			ApiPrefix:         fmt.Sprintf("%s://%s:%d", GetSelf().URIScheme, GetSelf().RegisterIPv4, GetSelf().SBIPort),
@@ -49,25 +52,29 @@ func (c *SMFContext) SetupNFProfile(nfProfileconfig *factory.Config) {
 	}
 
 	// set smfInfo
-	c.NfProfile.SMFInfo = &models.SmfInfo{
+	profile.SMFInfo = &models.SmfInfo{
 		SNssaiSmfInfoList: SNssaiSmfInfo(),
 	}
 
 	// set PlmnList if exists
-	if plmnList := nfProfileconfig.Configuration.PLMNList; plmnList != nil {
-		c.NfProfile.PLMNList = new([]models.PlmnId)
+	if plmnList := nfProfileconfig.GetPLMNList(); plmnList != nil {
+		profile.PLMNList = new([]models.PlmnId)
 		for _, plmn := range plmnList {
-			*c.NfProfile.PLMNList = append(*c.NfProfile.PLMNList, models.PlmnId{
+			*profile.PLMNList = append(*profile.PLMNList, models.PlmnId{
 				Mcc: plmn.Mcc,
 				Mnc: plmn.Mnc,
 			})
 		}
 	}
+
+	c.reloadMu.Lock()
+	c.NfProfile = profile
+	c.reloadMu.Unlock()
 }
 
 func SNssaiSmfInfo() []models.SnssaiSmfInfoItem {
 	snssaiInfo := make([]models.SnssaiSmfInfoItem, 0)
-	for _, snssai := range smfContext.SnssaiInfos {
+	for _, snssai := range smfContext.GetSnssaiInfos() {
 		var snssaiInfoModel models.SnssaiSmfInfoItem
 		snssaiInfoModel.SNssai = &models.ExtSnssai{
 			Sst: snssai.Snssai.Sst,
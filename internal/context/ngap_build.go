@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/free5gc/aper"
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/ngap/ngapConvert"
 	"github.com/free5gc/ngap/ngapType"
 	"github.com/free5gc/openapi/models"
@@ -12,11 +13,31 @@ import (
 
 const DefaultNonGBR5QI = 9
 
+// ngapPDUSessionType converts the NAS PDU session type to the NGAP PDU Session Type IE value
+func ngapPDUSessionType(pduSessType uint8) aper.Enumerated {
+	switch pduSessType {
+	case nasMessage.PDUSessionTypeIPv6:
+		return ngapType.PDUSessionTypePresentIpv6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return ngapType.PDUSessionTypePresentIpv4v6
+	case nasMessage.PDUSessionTypeEthernet:
+		return ngapType.PDUSessionTypePresentEthernet
+	case nasMessage.PDUSessionTypeUnstructured:
+		return ngapType.PDUSessionTypePresentUnstructured
+	default:
+		return ngapType.PDUSessionTypePresentIpv4
+	}
+}
+
 func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error) {
 	ANUPF := ctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
 	UpNode := ANUPF.UPF
+	n3IP, teid, err := ctx.ULN3TunnelEndpoint(UpNode, ctx.LocalULTeid)
+	if err != nil {
+		return nil, err
+	}
 	teidOct := make([]byte, 4)
-	binary.BigEndian.PutUint32(teidOct, ctx.LocalULTeid)
+	binary.BigEndian.PutUint32(teidOct, teid)
 
 	resourceSetupRequestTransfer := ngapType.PDUSessionResourceSetupRequestTransfer{}
 
@@ -47,24 +68,20 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 	ie = ngapType.PDUSessionResourceSetupRequestTransferIEs{}
 	ie.Id.Value = ngapType.ProtocolIEIDULNGUUPTNLInformation
 	ie.Criticality.Value = ngapType.CriticalityPresentReject
-	if n3IP, err := UpNode.N3Interfaces[0].IP(ctx.SelectedPDUSessionType); err != nil {
-		return nil, err
-	} else {
-		ie.Value = ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
-			Present: ngapType.PDUSessionResourceSetupRequestTransferIEsPresentULNGUUPTNLInformation,
-			ULNGUUPTNLInformation: &ngapType.UPTransportLayerInformation{
-				Present: ngapType.UPTransportLayerInformationPresentGTPTunnel,
-				GTPTunnel: &ngapType.GTPTunnel{
-					TransportLayerAddress: ngapType.TransportLayerAddress{
-						Value: aper.BitString{
-							Bytes:     n3IP,
-							BitLength: uint64(len(n3IP) * 8),
-						},
+	ie.Value = ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
+		Present: ngapType.PDUSessionResourceSetupRequestTransferIEsPresentULNGUUPTNLInformation,
+		ULNGUUPTNLInformation: &ngapType.UPTransportLayerInformation{
+			Present: ngapType.UPTransportLayerInformationPresentGTPTunnel,
+			GTPTunnel: &ngapType.GTPTunnel{
+				TransportLayerAddress: ngapType.TransportLayerAddress{
+					Value: aper.BitString{
+						Bytes:     n3IP,
+						BitLength: uint64(len(n3IP) * 8),
 					},
-					GTPTEID: ngapType.GTPTEID{Value: teidOct},
 				},
+				GTPTEID: ngapType.GTPTEID{Value: teidOct},
 			},
-		}
+		},
 	}
 
 	resourceSetupRequestTransfer.ProtocolIEs.List = append(resourceSetupRequestTransfer.ProtocolIEs.List, ie)
@@ -76,7 +93,7 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 	ie.Value = ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
 		Present: ngapType.PDUSessionResourceSetupRequestTransferIEsPresentPDUSessionType,
 		PDUSessionType: &ngapType.PDUSessionType{
-			Value: ngapType.PDUSessionTypePresentIpv4,
+			Value: ngapPDUSessionType(ctx.SelectedPDUSessionType),
 		},
 	}
 	resourceSetupRequestTransfer.ProtocolIEs.List = append(resourceSetupRequestTransfer.ProtocolIEs.List, ie)
@@ -198,6 +215,27 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 
 func BuildPDUSessionResourceModifyRequestTransfer(ctx *SMContext) ([]byte, error) {
 	resourceModifyRequestTransfer := ngapType.PDUSessionResourceModifyRequestTransfer{}
+
+	// PDU Session Aggregate Maximum Bit Rate changed by the PCF
+	if sessRule := ctx.SelectedSessionRule(); ctx.SessionAMBRModifiedForAN() && sessRule != nil &&
+		sessRule.AuthSessAmbr != nil {
+		ie := ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+		ie.Id.Value = ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate
+		ie.Criticality.Value = ngapType.CriticalityPresentReject
+		ie.Value = ngapType.PDUSessionResourceModifyRequestTransferIEsValue{
+			Present: ngapType.PDUSessionResourceModifyRequestTransferIEsPresentPDUSessionAggregateMaximumBitRate,
+			PDUSessionAggregateMaximumBitRate: &ngapType.PDUSessionAggregateMaximumBitRate{
+				PDUSessionAggregateMaximumBitRateDL: ngapType.BitRate{
+					Value: ngapConvert.UEAmbrToInt64(sessRule.AuthSessAmbr.Downlink),
+				},
+				PDUSessionAggregateMaximumBitRateUL: ngapType.BitRate{
+					Value: ngapConvert.UEAmbrToInt64(sessRule.AuthSessAmbr.Uplink),
+				},
+			},
+		}
+		resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+	}
+
 	ie := ngapType.PDUSessionResourceModifyRequestTransferIEs{}
 
 	ie.Id.Value = ngapType.ProtocolIEIDQosFlowAddOrModifyRequestList
@@ -216,6 +254,82 @@ func BuildPDUSessionResourceModifyRequestTransfer(ctx *SMContext) ([]byte, error
 		}
 	}
 
+	// The list has at least one item, it's omitted if only the session AMBR is modified
+	if len(qosFlowAddOrModifyRequestList.List) != 0 || len(resourceModifyRequestTransfer.ProtocolIEs.List) == 0 {
+		resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+	}
+
+	if buf, err := aper.MarshalWithParams(resourceModifyRequestTransfer, "valueExt"); err != nil {
+		return nil, fmt.Errorf("encode resourceModifyRequestTransfer failed: %s", err)
+	} else {
+		return buf, nil
+	}
+}
+
+// BuildPDUSessionResourceModifyRequestTransferForSessionRule modifies the PDU session AMBR and
+// the default QoS flow to the authorized session rule (TS 38.413 9.3.4.3)
+func BuildPDUSessionResourceModifyRequestTransferForSessionRule(ctx *SMContext) ([]byte, error) {
+	sessRule := ctx.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil || sessRule.AuthDefQos == nil {
+		return nil, fmt.Errorf("No authorized session rule")
+	}
+	resourceModifyRequestTransfer := ngapType.PDUSessionResourceModifyRequestTransfer{}
+
+	// PDU Session Aggregate Maximum Bit Rate
+	ie := ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value = ngapType.PDUSessionResourceModifyRequestTransferIEsValue{
+		Present: ngapType.PDUSessionResourceModifyRequestTransferIEsPresentPDUSessionAggregateMaximumBitRate,
+		PDUSessionAggregateMaximumBitRate: &ngapType.PDUSessionAggregateMaximumBitRate{
+			PDUSessionAggregateMaximumBitRateDL: ngapType.BitRate{
+				Value: ngapConvert.UEAmbrToInt64(sessRule.AuthSessAmbr.Downlink),
+			},
+			PDUSessionAggregateMaximumBitRateUL: ngapType.BitRate{
+				Value: ngapConvert.UEAmbrToInt64(sessRule.AuthSessAmbr.Uplink),
+			},
+		},
+	}
+	resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+
+	// QoS Flow Add or Modify Request List with the default QoS flow
+	authDefQos := sessRule.AuthDefQos
+	ie = ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDQosFlowAddOrModifyRequestList
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value = ngapType.PDUSessionResourceModifyRequestTransferIEsValue{
+		Present: ngapType.PDUSessionResourceModifyRequestTransferIEsPresentQosFlowAddOrModifyRequestList,
+		QosFlowAddOrModifyRequestList: &ngapType.QosFlowAddOrModifyRequestList{
+			List: []ngapType.QosFlowAddOrModifyRequestItem{
+				{
+					QosFlowIdentifier: ngapType.QosFlowIdentifier{
+						Value: int64(sessRule.DefQosQFI),
+					},
+					QosFlowLevelQosParameters: &ngapType.QosFlowLevelQosParameters{
+						QosCharacteristics: ngapType.QosCharacteristics{
+							Present: ngapType.QosCharacteristicsPresentNonDynamic5QI,
+							NonDynamic5QI: &ngapType.NonDynamic5QIDescriptor{
+								FiveQI: ngapType.FiveQI{
+									Value: int64(authDefQos.Var5qi),
+								},
+							},
+						},
+						AllocationAndRetentionPriority: ngapType.AllocationAndRetentionPriority{
+							PriorityLevelARP: ngapType.PriorityLevelARP{
+								Value: int64(authDefQos.Arp.PriorityLevel),
+							},
+							PreEmptionCapability: ngapType.PreEmptionCapability{
+								Value: ngapType.PreEmptionCapabilityPresentShallNotTriggerPreEmption,
+							},
+							PreEmptionVulnerability: ngapType.PreEmptionVulnerability{
+								Value: ngapType.PreEmptionVulnerabilityPresentNotPreEmptable,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
 	resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
 
 	if buf, err := aper.MarshalWithParams(resourceModifyRequestTransfer, "valueExt"); err != nil {
@@ -229,8 +343,12 @@ func BuildPDUSessionResourceModifyRequestTransfer(ctx *SMContext) ([]byte, error
 func BuildPathSwitchRequestAcknowledgeTransfer(ctx *SMContext) ([]byte, error) {
 	ANUPF := ctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
 	UpNode := ANUPF.UPF
+	n3IP, teid, err := ctx.ULN3TunnelEndpoint(UpNode, ANUPF.UpLinkTunnel.TEID)
+	if err != nil {
+		return nil, err
+	}
 	teidOct := make([]byte, 4)
-	binary.BigEndian.PutUint32(teidOct, ANUPF.UpLinkTunnel.TEID)
+	binary.BigEndian.PutUint32(teidOct, teid)
 
 	pathSwitchRequestAcknowledgeTransfer := ngapType.PathSwitchRequestAcknowledgeTransfer{}
 
@@ -242,15 +360,11 @@ func BuildPathSwitchRequestAcknowledgeTransfer(ctx *SMContext) ([]byte, error) {
 	ULNGUUPTNLInformation.Present = ngapType.UPTransportLayerInformationPresentGTPTunnel
 	ULNGUUPTNLInformation.GTPTunnel = new(ngapType.GTPTunnel)
 
-	if n3IP, err := UpNode.N3Interfaces[0].IP(ctx.SelectedPDUSessionType); err != nil {
-		return nil, err
-	} else {
-		gtpTunnel := ULNGUUPTNLInformation.GTPTunnel
-		gtpTunnel.GTPTEID.Value = teidOct
-		gtpTunnel.TransportLayerAddress.Value = aper.BitString{
-			Bytes:     n3IP,
-			BitLength: uint64(len(n3IP) * 8),
-		}
+	gtpTunnel := ULNGUUPTNLInformation.GTPTunnel
+	gtpTunnel.GTPTEID.Value = teidOct
+	gtpTunnel.TransportLayerAddress.Value = aper.BitString{
+		Bytes:     n3IP,
+		BitLength: uint64(len(n3IP) * 8),
 	}
 
 	// Received UP security policy mismatch from SMF locally stored TS 33.501 6.6.1
@@ -65,6 +65,8 @@ func HandlePDUSessionResourceSetupResponseTransfer(b []byte, ctx *SMContext) err
 	for _, qos := range ctx.AdditonalQosFlows {
 		qos.State = QoSFlowSet
 	}
+	// The session AMBR is set up with the PDU session resource
+	ctx.sessionAMBRModifiedForAN = false
 	return nil
 }
 
@@ -84,6 +86,8 @@ func HandlePDUSessionResourceModifyResponseTransfer(b []byte, ctx *SMContext) er
 			binary.BigEndian.Uint32(GTPTunnel.GTPTEID.Value))
 	}
 
+	ctx.sessionAMBRModifiedForAN = false
+
 	if qosInfoList := resourceModifyResponseTransfer.QosFlowAddOrModifyResponseList; qosInfoList != nil {
 		for _, item := range qosInfoList.List {
 			qfi := uint8(item.QosFlowIdentifier.Value)
@@ -271,12 +275,12 @@ func HandleHandoverRequestAcknowledgeTransfer(b []byte, ctx *SMContext) error {
 			ctx.IndirectForwardingTunnel.FirstDPNode.UpLinkTunnel.PDR = indirectFowardingPDR
 			indirectFowardingPDR.PDI.LocalFTeid = &pfcpType.FTEID{
 				V4:          originPDR.PDI.LocalFTeid.V4,
+				V6:          originPDR.PDI.LocalFTeid.V6,
 				Teid:        ctx.IndirectForwardingTunnel.FirstDPNode.UpLinkTunnel.TEID,
 				Ipv4Address: originPDR.PDI.LocalFTeid.Ipv4Address,
+				Ipv6Address: originPDR.PDI.LocalFTeid.Ipv6Address,
 			}
-			indirectFowardingPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
-				OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
-			}
+			indirectFowardingPDR.OuterHeaderRemoval = originPDR.OuterHeaderRemoval
 
 			indirectFowardingPDR.FAR.ApplyAction = pfcpType.ApplyAction{
 				Forw: true,
@@ -285,11 +289,9 @@ func HandleHandoverRequestAcknowledgeTransfer(b []byte, ctx *SMContext) error {
 				DestinationInterface: pfcpType.DestinationInterface{
 					InterfaceValue: pfcpType.DestinationInterfaceAccess,
 				},
-				OuterHeaderCreation: &pfcpType.OuterHeaderCreation{
-					OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
-					Teid:                           binary.BigEndian.Uint32(DLForwardingGTPTunnel.GTPTEID.Value),
-					Ipv4Address:                    DLForwardingGTPTunnel.TransportLayerAddress.Value.Bytes,
-				},
+				OuterHeaderCreation: NewOuterHeaderCreation(
+					DLForwardingGTPTunnel.TransportLayerAddress.Value.Bytes,
+					binary.BigEndian.Uint32(DLForwardingGTPTunnel.GTPTEID.Value)),
 			}
 		}
 	} else if ctx.DLForwardingType == DirectForwarding {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/free5gc/nas/nasType"
 	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/pkg/factory"
 	"github.com/free5gc/util/flowdesc"
@@ -41,6 +42,14 @@ func (r *PCCRule) FlowDescription() string {
 	return ""
 }
 
+func (r *PCCRule) EthFlowDescription() *models.EthFlowDescription {
+	if len(r.FlowInfos) > 0 {
+		// now 1 pcc rule only maps to 1 FlowInfo
+		return r.FlowInfos[0].EthFlowDescription
+	}
+	return nil
+}
+
 func (r *PCCRule) RefChgDataID() string {
 	if len(r.RefChgData) > 0 {
 		// now 1 pcc rule only maps to 1 Charging data
@@ -70,6 +79,9 @@ func (r *PCCRule) RefTcDataID() string {
 }
 
 func (r *PCCRule) IdentifyChargingLevel() (ChargingLevel, error) {
+	if r.EthFlowDescription() != nil {
+		return FlowCharging, nil
+	}
 	dlIPFilterRule, err := flowdesc.Decode(r.FlowDescription())
 	if err != nil {
 		return 0, err
@@ -99,6 +111,30 @@ func (r *PCCRule) UpdateDataPathFlowDescription(dlFlowDesc string) error {
 	return nil
 }
 
+func (r *PCCRule) UpdateDataPathApplicationID(appID, flowDesc string) error {
+	if r.Datapath == nil {
+		return fmt.Errorf("pcc[%s]: no data path", r.PccRuleId)
+	}
+
+	if err := r.Datapath.UpdateApplicationID(appID, flowDesc); err != nil {
+		return fmt.Errorf("pcc[%s]: %s", r.PccRuleId, err)
+	}
+	return nil
+}
+
+func (r *PCCRule) UpdateDataPathEthernetFlowDescription(ethFlowDesc *models.EthFlowDescription) error {
+	if r.Datapath == nil {
+		return fmt.Errorf("pcc[%s]: no data path", r.PccRuleId)
+	}
+
+	epf, err := NewEthernetPacketFilter(ethFlowDesc)
+	if err != nil {
+		return fmt.Errorf("pcc[%s]: %s", r.PccRuleId, err)
+	}
+	r.Datapath.UpdateEthernetPacketFilter(epf)
+	return nil
+}
+
 func (r *PCCRule) AddDataPathForwardingParameters(c *SMContext,
 	tgtRoute *models.RouteToLocation,
 ) {
@@ -267,11 +303,134 @@ func createNasPacketFilter(
 	return pf, nil
 }
 
+// NewEthernetPacketFilter converts the Ethernet flow description of PCC rule
+// to the Ethernet packet filter of PDI
+func NewEthernetPacketFilter(ethFlowDesc *models.EthFlowDescription) (*EthernetPacketFilter, error) {
+	if ethFlowDesc == nil {
+		return nil, fmt.Errorf("no ethernet flow description")
+	}
+
+	epf := &EthernetPacketFilter{
+		Bidirectional: ethFlowDesc.FDir == models.FlowDirection_BIDIRECTIONAL,
+	}
+	var err error
+	if ethFlowDesc.SourceMacAddr != "" {
+		if epf.SourceMACAddress, err = net.ParseMAC(ethFlowDesc.SourceMacAddr); err != nil {
+			return nil, fmt.Errorf("parse source MAC address fail: %s", err)
+		}
+	}
+	if ethFlowDesc.DestMacAddr != "" {
+		if epf.DestinationMACAddress, err = net.ParseMAC(ethFlowDesc.DestMacAddr); err != nil {
+			return nil, fmt.Errorf("parse destination MAC address fail: %s", err)
+		}
+	}
+	if ethFlowDesc.EthType != "" {
+		ethType, parseErr := strconv.ParseUint(ethFlowDesc.EthType, 16, 16)
+		if parseErr != nil {
+			return nil, fmt.Errorf("parse ethertype fail: %s", parseErr)
+		}
+		epf.Ethertype = uint16(ethType)
+	}
+	// The first VLAN tag is C-TAG and the second one is S-TAG
+	if len(ethFlowDesc.VlanTags) > 0 {
+		if epf.CTag, err = NewVLANTag(ethFlowDesc.VlanTags[0]); err != nil {
+			return nil, err
+		}
+	}
+	if len(ethFlowDesc.VlanTags) > 1 {
+		if epf.STag, err = NewVLANTag(ethFlowDesc.VlanTags[1]); err != nil {
+			return nil, err
+		}
+	}
+	if ethFlowDesc.FDesc != "" {
+		epf.SDFFilter = &pfcpType.SDFFilter{
+			Fd:                      true,
+			LengthOfFlowDescription: uint16(len(ethFlowDesc.FDesc)),
+			FlowDescription:         []byte(ethFlowDesc.FDesc),
+		}
+	}
+	return epf, nil
+}
+
+// createNasEthernetPacketFilter builds the packet filter of Ethernet PDU session (TS 24.501 9.11.4.13)
+func createNasEthernetPacketFilter(
+	pfInfo *models.FlowInformation,
+	smCtx *SMContext,
+) (*nasType.PacketFilter, error) {
+	epf, err := NewEthernetPacketFilter(pfInfo.EthFlowDescription)
+	if err != nil {
+		return nil, err
+	}
+
+	pf := new(nasType.PacketFilter)
+	pfId, errAllocate := smCtx.PacketFilterIDGenerator.Allocate()
+	if errAllocate != nil {
+		return nil, errAllocate
+	}
+	pf.Identifier = uint8(pfId)
+	smCtx.PacketFilterIDToNASPFID[pfInfo.PackFiltId] = uint8(pfId)
+
+	flowDirection := pfInfo.FlowDirection
+	if flowDirection == "" {
+		flowDirection = pfInfo.EthFlowDescription.FDir
+	}
+	switch flowDirection {
+	case models.FlowDirection_DOWNLINK:
+		pf.Direction = nasType.PacketFilterDirectionDownlink
+	case models.FlowDirection_UPLINK:
+		pf.Direction = nasType.PacketFilterDirectionUplink
+	default:
+		pf.Direction = nasType.PacketFilterDirectionBidirectional
+	}
+
+	pfComponents := make(nasType.PacketFilterComponentList, 0)
+	if epf.DestinationMACAddress != nil {
+		pfComponents = append(pfComponents, &nasType.PacketFilterDestinationMACAddress{
+			MAC: epf.DestinationMACAddress,
+		})
+	}
+	if epf.SourceMACAddress != nil {
+		pfComponents = append(pfComponents, &nasType.PacketFilterSourceMACAddress{
+			MAC: epf.SourceMACAddress,
+		})
+	}
+	if epf.CTag != nil {
+		pfComponents = append(pfComponents,
+			&nasType.PacketFilterCTagVID{VID: epf.CTag.VID},
+			&nasType.PacketFilterCTagPCPDEI{Value: epf.CTag.PCPDEI()})
+	}
+	if epf.STag != nil {
+		pfComponents = append(pfComponents,
+			&nasType.PacketFilterSTagVID{VID: epf.STag.VID},
+			&nasType.PacketFilterSTagPCPDEI{Value: epf.STag.PCPDEI()})
+	}
+	if epf.Ethertype != 0 {
+		pfComponents = append(pfComponents, &nasType.PacketFilterEtherType{
+			EtherType: epf.Ethertype,
+		})
+	}
+
+	if len(pfComponents) == 0 {
+		pfComponents = append(pfComponents, &nasType.PacketFilterMatchAll{})
+	}
+
+	pf.Components = pfComponents
+	return pf, nil
+}
+
 func BuildNASPacketFiltersFromFlowInformation(pfInfo *models.FlowInformation,
 	smCtx *SMContext,
 ) ([]nasType.PacketFilter, error) {
 	var pfList []nasType.PacketFilter
 
+	if pfInfo.EthFlowDescription != nil {
+		pf, err := createNasEthernetPacketFilter(pfInfo, smCtx)
+		if err != nil {
+			return nil, errors.Wrap(err, "create packet filter fail")
+		}
+		return append(pfList, *pf), nil
+	}
+
 	ipFilterRule := flowdesc.NewIPFilterRule()
 	if pfInfo.FlowDescription != "" {
 		var err error
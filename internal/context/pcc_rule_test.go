@@ -0,0 +1,51 @@
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestBuildNASPacketFiltersFromEthFlowDescription(t *testing.T) {
+	initConfig()
+
+	smContext := smf_context.NewSMContext("imsi-208930000000105", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000105",
+		PduSessionId: 10,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	pfs, err := smf_context.BuildNASPacketFiltersFromFlowInformation(&models.FlowInformation{
+		PackFiltId:    "PackFiltId-1",
+		FlowDirection: models.FlowDirection_UPLINK,
+		EthFlowDescription: &models.EthFlowDescription{
+			DestMacAddr: "00-00-5e-00-53-01",
+			EthType:     "0800",
+			VlanTags:    []string{"a00a"},
+		},
+	}, smContext)
+	require.NoError(t, err)
+	require.Len(t, pfs, 1)
+	require.Equal(t, nasType.PacketFilterDirectionUplink, pfs[0].Direction)
+
+	mac, _ := net.ParseMAC("00-00-5e-00-53-01")
+	require.Equal(t, nasType.PacketFilterComponentList{
+		&nasType.PacketFilterDestinationMACAddress{MAC: mac},
+		&nasType.PacketFilterCTagVID{VID: 10},
+		&nasType.PacketFilterCTagPCPDEI{Value: 0x0a},
+		&nasType.PacketFilterEtherType{EtherType: 0x0800},
+	}, pfs[0].Components)
+
+	_, err = smf_context.BuildNASPacketFiltersFromFlowInformation(&models.FlowInformation{
+		EthFlowDescription: &models.EthFlowDescription{
+			SourceMacAddr: "invalid",
+		},
+	}, smContext)
+	require.Error(t, err)
+}
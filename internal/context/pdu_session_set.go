@@ -0,0 +1,239 @@
+package context
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/util/idgenerator"
+)
+
+// The SMF groups the PDU sessions served by the same set of UPFs into a PDU session set identified
+// by a Connection Set Identifier (CSID, TS 23.007), so the PDU sessions affected by the failure
+// or restart of a UPF are found through the sets involving that UPF.
+// NOTE: the CSIDs of the SMF are local only, they are not sent to the UPFs since free5gc/pfcp can't
+// encode the FQ-CSID IE. The PFCP Session Set Deletion Request of a UPF identifies the PDU sessions
+// by the FQ-CSIDs the UPF has allocated in the PFCP Session Establishment Response.
+
+type pduSessionSet struct {
+	csid       uint16
+	upfNodeIPs []string
+	smContexts map[string]*SMContext // Key: SM context ref
+}
+
+var pduSessionSets = struct {
+	sync.Mutex
+	csidGenerator *idgenerator.IDGenerator
+	byUPFs        map[string]*pduSessionSet // Key: node IPs of the UPFs
+	byCSID        map[uint16]*pduSessionSet
+}{
+	csidGenerator: idgenerator.NewGenerator(1, math.MaxUint16),
+	byUPFs:        make(map[string]*pduSessionSet),
+	byCSID:        make(map[uint16]*pduSessionSet),
+}
+
+// UpdatePDUSessionSet moves the SM context to the PDU session set of the UPFs it has PFCP sessions with
+func (c *SMContext) UpdatePDUSessionSet() {
+	upfNodeIPs := make([]string, 0, len(c.PFCPContext))
+	for nodeIP := range c.PFCPContext {
+		upfNodeIPs = append(upfNodeIPs, nodeIP)
+	}
+	sort.Strings(upfNodeIPs)
+	key := strings.Join(upfNodeIPs, ",")
+
+	pduSessionSets.Lock()
+	defer pduSessionSets.Unlock()
+
+	if set := pduSessionSets.byCSID[c.LocalCSID]; set != nil {
+		if strings.Join(set.upfNodeIPs, ",") == key {
+			return
+		}
+		leavePDUSessionSet(c, set)
+	}
+
+	set := pduSessionSets.byUPFs[key]
+	if set == nil {
+		csid, err := pduSessionSets.csidGenerator.Allocate()
+		if err != nil {
+			logger.CtxLog.Errorf("Allocate CSID failed: %+v", err)
+			c.LocalCSID = 0
+			return
+		}
+		set = &pduSessionSet{
+			csid:       uint16(csid),
+			upfNodeIPs: upfNodeIPs,
+			smContexts: make(map[string]*SMContext),
+		}
+		pduSessionSets.byUPFs[key] = set
+		pduSessionSets.byCSID[set.csid] = set
+	}
+	set.smContexts[c.Ref] = c
+	c.LocalCSID = set.csid
+}
+
+// LeavePDUSessionSet removes the SM context from its PDU session set
+func (c *SMContext) LeavePDUSessionSet() {
+	pduSessionSets.Lock()
+	defer pduSessionSets.Unlock()
+
+	if set := pduSessionSets.byCSID[c.LocalCSID]; set != nil {
+		leavePDUSessionSet(c, set)
+	}
+	c.LocalCSID = 0
+}
+
+func leavePDUSessionSet(c *SMContext, set *pduSessionSet) {
+	delete(set.smContexts, c.Ref)
+	if len(set.smContexts) == 0 {
+		delete(pduSessionSets.byUPFs, strings.Join(set.upfNodeIPs, ","))
+		delete(pduSessionSets.byCSID, set.csid)
+		pduSessionSets.csidGenerator.FreeID(int64(set.csid))
+	}
+}
+
+// CSIDsOfUPF returns the CSIDs of the PDU session sets served by the UPF
+func CSIDsOfUPF(upf *UPF) []uint16 {
+	nodeIP := upf.GetUPFIP()
+
+	pduSessionSets.Lock()
+	defer pduSessionSets.Unlock()
+
+	var csids []uint16
+	for csid, set := range pduSessionSets.byCSID {
+		for _, upfNodeIP := range set.upfNodeIPs {
+			if upfNodeIP == nodeIP {
+				csids = append(csids, csid)
+				break
+			}
+		}
+	}
+	return csids
+}
+
+// UPFNodeIPsOfPDUSessionSets returns the node IPs of the UPFs serving the PDU session sets
+func UPFNodeIPsOfPDUSessionSets(csids []uint16) []string {
+	pduSessionSets.Lock()
+	defer pduSessionSets.Unlock()
+
+	nodeIPs := make(map[string]struct{})
+	for _, csid := range csids {
+		if set := pduSessionSets.byCSID[csid]; set != nil {
+			for _, nodeIP := range set.upfNodeIPs {
+				nodeIPs[nodeIP] = struct{}{}
+			}
+		}
+	}
+	upfNodeIPs := make([]string, 0, len(nodeIPs))
+	for nodeIP := range nodeIPs {
+		upfNodeIPs = append(upfNodeIPs, nodeIP)
+	}
+	sort.Strings(upfNodeIPs)
+	return upfNodeIPs
+}
+
+// ProcEachSMContextInPDUSessionSets calls procFunc for every SM context of the PDU session sets
+func ProcEachSMContextInPDUSessionSets(csids []uint16, procFunc func(*SMContext)) {
+	var smContexts []*SMContext
+	pduSessionSets.Lock()
+	for _, csid := range csids {
+		if set := pduSessionSets.byCSID[csid]; set != nil {
+			for _, smContext := range set.smContexts {
+				smContexts = append(smContexts, smContext)
+			}
+		}
+	}
+	pduSessionSets.Unlock()
+
+	// procFunc may update the PDU session sets
+	for _, smContext := range smContexts {
+		procFunc(smContext)
+	}
+}
+
+// FQCSID is the FQ-CSID IE (TS 29.244 8.2.46) identifying the PDU session sets of a node
+type FQCSID struct {
+	// NodeAddress is the IPv4 or IPv6 address of the node, nil if the node is identified by the MCC, MNC and ID
+	NodeAddress net.IP
+	CSIDs       []uint16
+}
+
+// ParseFQCSID decodes the value of the FQ-CSID IE
+func ParseFQCSID(data []byte) (*FQCSID, error) {
+	if len(data) < 1 {
+		return nil, errors.New("FQ-CSID is empty")
+	}
+	fqCSID := &FQCSID{}
+	nodeIDType, numberOfCSIDs := data[0]>>4, int(data[0]&0x0f)
+	data = data[1:]
+	switch nodeIDType {
+	case 0:
+		if len(data) < net.IPv4len {
+			return nil, errors.New("FQ-CSID IPv4 node address is truncated")
+		}
+		fqCSID.NodeAddress = net.IP(append([]byte(nil), data[:net.IPv4len]...))
+		data = data[net.IPv4len:]
+	case 1:
+		if len(data) < net.IPv6len {
+			return nil, errors.New("FQ-CSID IPv6 node address is truncated")
+		}
+		fqCSID.NodeAddress = net.IP(append([]byte(nil), data[:net.IPv6len]...))
+		data = data[net.IPv6len:]
+	case 2:
+		// MCC, MNC and the 12-bit node ID
+		if len(data) < 4 {
+			return nil, errors.New("FQ-CSID node ID is truncated")
+		}
+		data = data[4:]
+	default:
+		return nil, errors.New("unknown FQ-CSID node ID type")
+	}
+	if len(data) < 2*numberOfCSIDs {
+		return nil, errors.New("FQ-CSID CSIDs are truncated")
+	}
+	for i := 0; i < numberOfCSIDs; i++ {
+		fqCSID.CSIDs = append(fqCSID.CSIDs, binary.BigEndian.Uint16(data[2*i:]))
+	}
+	return fqCSID, nil
+}
+
+// Contains reports whether the FQ-CSID shares a PDU session set with the other FQ-CSID of the same node
+func (f *FQCSID) Contains(other *FQCSID) bool {
+	if f == nil || other == nil || f.NodeAddress == nil || !f.NodeAddress.Equal(other.NodeAddress) {
+		return false
+	}
+	for _, csid := range other.CSIDs {
+		for _, ownCSID := range f.CSIDs {
+			if csid == ownCSID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SMContextsOfUPFFQCSIDs returns the SM contexts whose PFCP sessions on the UPF belong to the PDU session
+// sets identified by the FQ-CSIDs the UPF has allocated
+func SMContextsOfUPFFQCSIDs(upf *UPF, fqCSIDs []*FQCSID) []*SMContext {
+	upfIP := upf.GetUPFIP()
+	var smContexts []*SMContext
+	ProcEachSMContextInPDUSessionSets(CSIDsOfUPF(upf), func(smContext *SMContext) {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		pfcpSessionCtx := smContext.PFCPContext[upfIP]
+		if pfcpSessionCtx == nil {
+			return
+		}
+		for _, fqCSID := range fqCSIDs {
+			if pfcpSessionCtx.UPFFQCSID.Contains(fqCSID) {
+				smContexts = append(smContexts, smContext)
+				return
+			}
+		}
+	})
+	return smContexts
+}
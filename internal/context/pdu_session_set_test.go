@@ -0,0 +1,172 @@
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestPDUSessionSet(t *testing.T) {
+	initConfig()
+
+	newUPF := func(ip string) *smf_context.UPF {
+		return smf_context.NewUPF(&pfcpType.NodeID{
+			NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+			IP:         net.ParseIP(ip).To4(),
+		}, mockIfaces)
+	}
+	iUPF := newUPF("10.200.0.1")
+	psaUPF1 := newUPF("10.200.0.2")
+	psaUPF2 := newUPF("10.200.0.3")
+
+	newSMContext := func(supi string, upfs ...*smf_context.UPF) *smf_context.SMContext {
+		smContext := smf_context.NewSMContext(supi, 10)
+		smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+			Supi:         supi,
+			PduSessionId: 10,
+		}
+		for _, upf := range upfs {
+			smContext.PFCPContext[upf.GetUPFIP()] = &smf_context.PFCPSessionContext{}
+		}
+		smContext.UpdatePDUSessionSet()
+		return smContext
+	}
+	smContext1 := newSMContext("imsi-208930000000201", psaUPF1, iUPF)
+	smContext2 := newSMContext("imsi-208930000000202", iUPF, psaUPF1)
+	smContext3 := newSMContext("imsi-208930000000203", iUPF, psaUPF2)
+	defer func() {
+		smf_context.RemoveSMContext(smContext1.Ref)
+		smf_context.RemoveSMContext(smContext2.Ref)
+		smf_context.RemoveSMContext(smContext3.Ref)
+	}()
+
+	// The PDU sessions served by the same UPFs share the PDU session set
+	require.NotZero(t, smContext1.LocalCSID)
+	require.Equal(t, smContext1.LocalCSID, smContext2.LocalCSID)
+	require.NotEqual(t, smContext1.LocalCSID, smContext3.LocalCSID)
+
+	require.ElementsMatch(t, []uint16{smContext1.LocalCSID, smContext3.LocalCSID}, smf_context.CSIDsOfUPF(iUPF))
+	require.ElementsMatch(t, []uint16{smContext1.LocalCSID}, smf_context.CSIDsOfUPF(psaUPF1))
+	require.Equal(t, []string{"10.200.0.1", "10.200.0.2"},
+		smf_context.UPFNodeIPsOfPDUSessionSets([]uint16{smContext1.LocalCSID}))
+
+	var smContexts []*smf_context.SMContext
+	smf_context.ProcEachSMContextInPDUSessionSets(smf_context.CSIDsOfUPF(psaUPF1),
+		func(smContext *smf_context.SMContext) {
+			smContexts = append(smContexts, smContext)
+		})
+	require.ElementsMatch(t, []*smf_context.SMContext{smContext1, smContext2}, smContexts)
+
+	// The PDU session set is released with its last PDU session
+	csid := smContext3.LocalCSID
+	smContext3.LeavePDUSessionSet()
+	require.Zero(t, smContext3.LocalCSID)
+	require.Empty(t, smf_context.CSIDsOfUPF(psaUPF2))
+	require.NotContains(t, smf_context.CSIDsOfUPF(iUPF), csid)
+
+	// The PDU session moves to another set when its UPFs change
+	delete(smContext2.PFCPContext, psaUPF1.GetUPFIP())
+	smContext2.PFCPContext[psaUPF2.GetUPFIP()] = &smf_context.PFCPSessionContext{}
+	smContext2.UpdatePDUSessionSet()
+	require.NotEqual(t, smContext1.LocalCSID, smContext2.LocalCSID)
+	require.ElementsMatch(t, []uint16{smContext2.LocalCSID}, smf_context.CSIDsOfUPF(psaUPF2))
+}
+
+func TestParseFQCSID(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected *smf_context.FQCSID
+	}{
+		{
+			name: "IPv4",
+			data: []byte{0x02, 10, 0, 0, 1, 0, 5, 0x01, 0x02},
+			expected: &smf_context.FQCSID{
+				NodeAddress: net.IP{10, 0, 0, 1},
+				CSIDs:       []uint16{5, 0x0102},
+			},
+		},
+		{
+			name: "IPv6",
+			data: append(append([]byte{0x11}, net.ParseIP("2001:db8::1")...), 0, 7),
+			expected: &smf_context.FQCSID{
+				NodeAddress: net.ParseIP("2001:db8::1"),
+				CSIDs:       []uint16{7},
+			},
+		},
+		{
+			name:     "MCC MNC",
+			data:     []byte{0x21, 0x02, 0xf8, 0x39, 0x01, 0, 9},
+			expected: &smf_context.FQCSID{CSIDs: []uint16{9}},
+		},
+		{
+			name: "truncated CSIDs",
+			data: []byte{0x02, 10, 0, 0, 1, 0, 5},
+		},
+		{
+			name: "unknown node ID type",
+			data: []byte{0x31, 0, 5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fqCSID, err := smf_context.ParseFQCSID(tc.data)
+			if tc.expected == nil {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, fqCSID)
+		})
+	}
+}
+
+func TestSMContextsOfUPFFQCSIDs(t *testing.T) {
+	initConfig()
+
+	upf := smf_context.NewUPF(&pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.200.0.4").To4(),
+	}, mockIfaces)
+	upfAddress := net.ParseIP("10.200.0.4").To4()
+
+	newSMContext := func(supi string, upfFQCSID *smf_context.FQCSID) *smf_context.SMContext {
+		smContext := smf_context.NewSMContext(supi, 10)
+		smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+			Supi:         supi,
+			PduSessionId: 10,
+		}
+		smContext.PFCPContext[upf.GetUPFIP()] = &smf_context.PFCPSessionContext{UPFFQCSID: upfFQCSID}
+		smContext.UpdatePDUSessionSet()
+		return smContext
+	}
+	smContext1 := newSMContext("imsi-208930000000211",
+		&smf_context.FQCSID{NodeAddress: upfAddress, CSIDs: []uint16{1}})
+	smContext2 := newSMContext("imsi-208930000000212",
+		&smf_context.FQCSID{NodeAddress: upfAddress, CSIDs: []uint16{2}})
+	smContext3 := newSMContext("imsi-208930000000213", nil)
+	defer func() {
+		smf_context.RemoveSMContext(smContext1.Ref)
+		smf_context.RemoveSMContext(smContext2.Ref)
+		smf_context.RemoveSMContext(smContext3.Ref)
+	}()
+
+	// Only the PDU sessions of the PDU session sets named by the UPF are returned
+	require.ElementsMatch(t, []*smf_context.SMContext{smContext1}, smf_context.SMContextsOfUPFFQCSIDs(upf,
+		[]*smf_context.FQCSID{{NodeAddress: upfAddress, CSIDs: []uint16{1, 3}}}))
+	require.ElementsMatch(t, []*smf_context.SMContext{smContext1, smContext2}, smf_context.SMContextsOfUPFFQCSIDs(upf,
+		[]*smf_context.FQCSID{
+			{NodeAddress: upfAddress, CSIDs: []uint16{1}},
+			{NodeAddress: upfAddress, CSIDs: []uint16{2}},
+		}))
+
+	// The CSIDs of another node don't match
+	require.Empty(t, smf_context.SMContextsOfUPFFQCSIDs(upf,
+		[]*smf_context.FQCSID{{NodeAddress: net.ParseIP("10.200.0.5").To4(), CSIDs: []uint16{1}}}))
+}
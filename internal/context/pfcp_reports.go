@@ -1,6 +1,8 @@
 package context
 
 import (
+	"net"
+
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
@@ -18,6 +20,11 @@ func (smContext *SMContext) HandleReports(
 	upfId := upf.UUID()
 
 	for _, report := range usageReportRequest {
+		smContext.handleEthernetTrafficInformation(report.EthernetTrafficInformation)
+		if report.VolumeMeasurement == nil {
+			// e.g. the report of MAC addresses only
+			continue
+		}
 		usageReport.UrrId = report.URRID.UrrIdValue
 		usageReport.UpfId = upfId
 		usageReport.TotalVolume = report.VolumeMeasurement.TotalVolume
@@ -35,6 +42,11 @@ func (smContext *SMContext) HandleReports(
 		smContext.UrrReports = append(smContext.UrrReports, usageReport)
 	}
 	for _, report := range usageReportModification {
+		smContext.handleEthernetTrafficInformation(report.EthernetTrafficInformation)
+		if report.VolumeMeasurement == nil {
+			// e.g. the report of MAC addresses only
+			continue
+		}
 		usageReport.UrrId = report.URRID.UrrIdValue
 		usageReport.UpfId = upfId
 		usageReport.TotalVolume = report.VolumeMeasurement.TotalVolume
@@ -52,6 +64,11 @@ func (smContext *SMContext) HandleReports(
 		smContext.UrrReports = append(smContext.UrrReports, usageReport)
 	}
 	for _, report := range usageReportDeletion {
+		smContext.handleEthernetTrafficInformation(report.EthernetTrafficInformation)
+		if report.VolumeMeasurement == nil {
+			// e.g. the report of MAC addresses only
+			continue
+		}
 		usageReport.UrrId = report.URRID.UrrIdValue
 		usageReport.UpfId = upfId
 		usageReport.TotalVolume = report.VolumeMeasurement.TotalVolume
@@ -70,6 +87,38 @@ func (smContext *SMContext) HandleReports(
 	}
 }
 
+func (smContext *SMContext) handleEthernetTrafficInformation(eti *pfcp.EthernetTrafficInformation) {
+	if eti == nil {
+		return
+	}
+
+	var detected, removed []net.HardwareAddr
+	if eti.MACAddressesDetected != nil {
+		detected = parseMACAddresses(eti.MACAddressesDetected.MACAddressesDetecteddata)
+	}
+	if eti.MACAddressesRemoved != nil {
+		removed = parseMACAddresses(eti.MACAddressesRemoved.MACAddressesRemoveddata)
+	}
+	smContext.Log.Infof("Ethernet traffic information: MAC addresses detected %v, removed %v", detected, removed)
+	smContext.UpdateLearnedMACAddresses(detected, removed)
+}
+
+// parseMACAddresses decodes the MAC Addresses Detected/Removed IE value (TS 29.244 8.2.103, 8.2.104),
+// the number of MAC addresses is followed by the MAC address values
+func parseMACAddresses(data []byte) []net.HardwareAddr {
+	if len(data) == 0 {
+		return nil
+	}
+	num := int(data[0])
+	data = data[1:]
+	macs := make([]net.HardwareAddr, 0, num)
+	for i := 0; i < num && len(data) >= 6; i++ {
+		macs = append(macs, net.HardwareAddr(append([]byte(nil), data[:6]...)))
+		data = data[6:]
+	}
+	return macs
+}
+
 func identityTriggerType(usarTrigger *pfcpType.UsageReportTrigger) models.ChfConvergedChargingTriggerType {
 	var trigger models.ChfConvergedChargingTriggerType
 
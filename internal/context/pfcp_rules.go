@@ -1,6 +1,9 @@
 package context
 
 import (
+	"fmt"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/free5gc/pfcp/pfcpType"
@@ -88,6 +91,14 @@ func SetStartOfSDFTrigger() UrrOpt {
 	}
 }
 
+// SetMACAddressReportingTrigger requests the UPF to report the MAC addresses detected
+// or removed in the Ethernet PDU session (TS 29.244 5.13.4)
+func SetMACAddressReportingTrigger() UrrOpt {
+	return func(urr *URR) {
+		urr.ReportingTrigger.Macar = true
+	}
+}
+
 func MeasureInformation(isMeasurePkt, isMeasureBeforeQos bool) pfcpType.MeasurementInformation {
 	var measureInformation pfcpType.MeasurementInformation
 	measureInformation.Mnop = isMeasurePkt
@@ -120,6 +131,54 @@ type PDI struct {
 	UEIPAddress     *pfcpType.UEIPAddress
 	SDFFilter       *pfcpType.SDFFilter
 	ApplicationID   string
+	// FramedRoute is the network behind the UE, encoded as the Framed-Route or Framed-IPv6-Route IE
+	FramedRoute *net.IPNet
+
+	// Ethernet PDU session
+	EthernetPDUSessionInformation bool
+	EthernetPacketFilter          *EthernetPacketFilter
+}
+
+// Ethernet Packet Filter. 7.5.2.2-3
+type EthernetPacketFilter struct {
+	EthernetFilterID      uint32
+	Bidirectional         bool
+	SourceMACAddress      net.HardwareAddr
+	DestinationMACAddress net.HardwareAddr
+	Ethertype             uint16 // 0 if absent
+	CTag                  *VLANTag
+	STag                  *VLANTag
+	SDFFilter             *pfcpType.SDFFilter
+}
+
+// VLANTag - the 802.1Q tag control information (PCP, DEI and VID)
+type VLANTag struct {
+	PCP uint8
+	DEI bool
+	VID uint16
+}
+
+// NewVLANTag parses the tag control information encoded as a two-octet string
+// in hexadecimal representation (TS 29.514 EthFlowDescription vlanTags)
+func NewVLANTag(tci string) (*VLANTag, error) {
+	value, err := strconv.ParseUint(tci, 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("parse VLAN tag[%s] fail: %s", tci, err)
+	}
+	return &VLANTag{
+		PCP: uint8(value >> 13),
+		DEI: value&0x1000 != 0,
+		VID: uint16(value & 0x0fff),
+	}, nil
+}
+
+// PCPDEI returns the PCP and DEI fields in the lower 4 bits
+func (t *VLANTag) PCPDEI() uint8 {
+	pcpdei := t.PCP << 1
+	if t.DEI {
+		pcpdei |= 1
+	}
+	return pcpdei
 }
 
 // Forwarding Action Rule. 7.5.2.3-1
@@ -146,8 +205,9 @@ type ForwardingParameters struct {
 type BAR struct {
 	BARID uint8
 
-	DownlinkDataNotificationDelay  pfcpType.DownlinkDataNotificationDelay
-	SuggestedBufferingPacketsCount pfcpType.SuggestedBufferingPacketsCount
+	DownlinkDataNotificationDelay pfcpType.DownlinkDataNotificationDelay
+	// SuggestedBufferingPacketsCount is omitted if nil
+	SuggestedBufferingPacketsCount *pfcpType.SuggestedBufferingPacketsCount
 
 	State RuleState
 }
@@ -164,3 +224,51 @@ type QER struct {
 
 	State RuleState
 }
+
+// NewLocalFTEID returns the F-TEID of the UP tunnel endpoint, the address family follows the given IP
+func NewLocalFTEID(ip net.IP, teid uint32) *pfcpType.FTEID {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &pfcpType.FTEID{
+			V4:          true,
+			Ipv4Address: ipv4,
+			Teid:        teid,
+		}
+	}
+	return &pfcpType.FTEID{
+		V6:          true,
+		Ipv6Address: ip.To16(),
+		Teid:        teid,
+	}
+}
+
+// NewOuterHeaderCreation returns the GTP-U outer header toward the given peer IP
+func NewOuterHeaderCreation(ip net.IP, teid uint32) *pfcpType.OuterHeaderCreation {
+	if len(ip) == net.IPv4len+net.IPv6len {
+		// Transport Layer Address carrying both IPv4 and IPv6 (TS 38.414), prefer IPv4
+		ip = ip[:net.IPv4len]
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return &pfcpType.OuterHeaderCreation{
+			OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
+			Ipv4Address:                    ipv4,
+			Teid:                           teid,
+		}
+	}
+	return &pfcpType.OuterHeaderCreation{
+		OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv6,
+		Ipv6Address:                    ip.To16(),
+		Teid:                           teid,
+	}
+}
+
+// NewOuterHeaderRemoval returns the GTP-U outer header removal of the tunnel terminated at the given local IP
+func NewOuterHeaderRemoval(ip net.IP) *pfcpType.OuterHeaderRemoval {
+	if ip.To4() == nil && ip.To16() != nil {
+		return &pfcpType.OuterHeaderRemoval{
+			OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv6,
+		}
+	}
+	return &pfcpType.OuterHeaderRemoval{
+		OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+	}
+}
@@ -28,6 +28,10 @@ type PFCPSessionContext struct {
 	NodeID     pfcpType.NodeID
 	LocalSEID  uint64
 	RemoteSEID uint64
+	// BAR is shared by the downlink FARs of the session
+	BAR *BAR
+	// UPFFQCSID is the FQ-CSID allocated by the UPF to the session, nil if not provided
+	UPFFQCSID *FQCSID
 }
 
 func (pfcpSessionContext *PFCPSessionContext) String() string {
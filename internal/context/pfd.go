@@ -0,0 +1,84 @@
+package context
+
+import (
+	"time"
+
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// The PFDs of the applications (TS 23.503 6.1.2.3) are provisioned to the UPFs supporting PFDM
+// with the PFCP PFD Management procedure (TS 29.244 6.2.5), so the UPFs detect the application traffic
+// by the application identifier of the PDRs. The PFDs are removed once their caching time expires.
+// TODO: Get PFD from NEF (not from config)
+
+// pfdDataReloaded is signaled when the PFDs are changed by a configuration reload
+var pfdDataReloaded = make(chan struct{}, 1)
+
+// PfdDataReloaded returns the channel signaled when the PFDs are changed by a configuration reload
+func PfdDataReloaded() <-chan struct{} {
+	return pfdDataReloaded
+}
+
+func notifyPfdDataReloaded() {
+	select {
+	case pfdDataReloaded <- struct{}{}:
+	default:
+	}
+}
+
+// IsPfdDataExpired returns true if the caching time of the PFDs has expired
+func IsPfdDataExpired(pfdData *factory.PfdDataForApp, now time.Time) bool {
+	return pfdData.CachingTime != nil && !now.Before(*pfdData.CachingTime)
+}
+
+// GetPfdDataForApp returns the PFDs of the application if they have not expired
+func GetPfdDataForApp(appID string) *factory.PfdDataForApp {
+	if factory.UERoutingConfig == nil {
+		return nil
+	}
+	now := time.Now()
+	for _, pfdData := range factory.UERoutingConfig.PfdDatas {
+		if pfdData.AppID == appID && !IsPfdDataExpired(pfdData, now) {
+			return pfdData
+		}
+	}
+	return nil
+}
+
+// ValidPfdDatas returns the PFDs of the applications which have not expired
+func ValidPfdDatas(now time.Time) []*factory.PfdDataForApp {
+	if factory.UERoutingConfig == nil {
+		return nil
+	}
+	var pfdDatas []*factory.PfdDataForApp
+	for _, pfdData := range factory.UERoutingConfig.PfdDatas {
+		if !IsPfdDataExpired(pfdData, now) {
+			pfdDatas = append(pfdDatas, pfdData)
+		}
+	}
+	return pfdDatas
+}
+
+// NextPfdExpiry returns the first caching time after now and the applications whose PFDs expire then.
+// No application is returned if there is no caching time after now.
+func NextPfdExpiry(now time.Time) (time.Time, []string) {
+	var expiry time.Time
+	var appIDs []string
+	if factory.UERoutingConfig == nil {
+		return expiry, nil
+	}
+	for _, pfdData := range factory.UERoutingConfig.PfdDatas {
+		cachingTime := pfdData.CachingTime
+		if cachingTime == nil || !cachingTime.After(now) {
+			continue
+		}
+		switch {
+		case appIDs == nil || cachingTime.Before(expiry):
+			expiry = *cachingTime
+			appIDs = []string{pfdData.AppID}
+		case cachingTime.Equal(expiry):
+			appIDs = append(appIDs, pfdData.AppID)
+		}
+	}
+	return expiry, appIDs
+}
@@ -0,0 +1,79 @@
+package context_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+func TestPfdCachingTime(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	expiry := now.Add(time.Minute)
+	later := now.Add(time.Hour)
+
+	origin := factory.UERoutingConfig
+	defer func() {
+		factory.UERoutingConfig = origin
+	}()
+	factory.UERoutingConfig = &factory.RoutingConfig{
+		PfdDatas: []*factory.PfdDataForApp{
+			{AppID: "app1"},
+			{AppID: "app2", CachingTime: &expired},
+			{AppID: "app3", CachingTime: &later},
+			{AppID: "app4", CachingTime: &expiry},
+			{AppID: "app5", CachingTime: &expiry},
+		},
+	}
+
+	require.NotNil(t, smf_context.GetPfdDataForApp("app1"))
+	require.Nil(t, smf_context.GetPfdDataForApp("app2"))
+	require.Len(t, smf_context.ValidPfdDatas(now), 4)
+
+	nextExpiry, appIDs := smf_context.NextPfdExpiry(now)
+	require.Equal(t, expiry, nextExpiry)
+	require.Equal(t, []string{"app4", "app5"}, appIDs)
+
+	nextExpiry, appIDs = smf_context.NextPfdExpiry(expiry)
+	require.Equal(t, later, nextExpiry)
+	require.Equal(t, []string{"app3"}, appIDs)
+	require.Len(t, smf_context.ValidPfdDatas(expiry), 2)
+
+	_, appIDs = smf_context.NextPfdExpiry(later)
+	require.Empty(t, appIDs)
+}
+
+func TestUpdateApplicationID(t *testing.T) {
+	upf := smf_context.NewUPF(mockIPv4NodeID, mockIfaces)
+	upf.UPFunctionFeatures = &pfcpType.UPFunctionFeatures{SupportedFeatures: pfcpType.UpFunctionFeaturesPfdm}
+	node := &smf_context.DataPathNode{
+		UPF:            upf,
+		UpLinkTunnel:   &smf_context.GTPTunnel{PDR: &smf_context.PDR{}},
+		DownLinkTunnel: &smf_context.GTPTunnel{PDR: &smf_context.PDR{}},
+	}
+	dataPath := &smf_context.DataPath{FirstDPNode: node}
+	flowDesc := "permit out ip from 10.100.0.1 to assigned"
+
+	// The flow description is kept until the UPF accepts the PFDs
+	require.NoError(t, dataPath.UpdateApplicationID("app1", flowDesc))
+	require.Empty(t, node.UpLinkTunnel.PDR.PDI.ApplicationID)
+	require.Equal(t, []byte(flowDesc), node.UpLinkTunnel.PDR.PDI.SDFFilter.FlowDescription)
+	require.Error(t, dataPath.UpdateApplicationID("app1", ""))
+
+	upf.UpdateProvisionedPfds([]string{"app1"}, nil)
+	require.NoError(t, dataPath.UpdateApplicationID("app1", ""))
+	for _, pdr := range []*smf_context.PDR{node.UpLinkTunnel.PDR, node.DownLinkTunnel.PDR} {
+		require.Equal(t, "app1", pdr.PDI.ApplicationID)
+		require.Nil(t, pdr.PDI.SDFFilter)
+	}
+
+	upf.ResetProvisionedPfds()
+	require.NoError(t, dataPath.UpdateApplicationID("app1", flowDesc))
+	require.Empty(t, node.DownLinkTunnel.PDR.PDI.ApplicationID)
+	require.NotNil(t, node.DownLinkTunnel.PDR.PDI.SDFFilter)
+}
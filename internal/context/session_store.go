@@ -0,0 +1,529 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/context/store"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/pkg/factory"
+	"github.com/free5gc/util/idgenerator"
+)
+
+// sessionStore keeps the SM contexts over the SMF restart, nil if the SM contexts are not persisted
+var sessionStore store.Store
+
+// number of the SM contexts restored from the session store
+var restoredSMContextCount atomic.Int64
+
+// last local SEID count saved in the session store
+var savedLocalSEIDCount atomic.Uint64
+
+// checkpoints are written to the session store in the background
+var checkpoints = newCheckpointWriter()
+
+// SetSessionStore sets the store where the SM contexts are persisted, e.g. an external store.
+// It is to be called before InitSmfContext.
+func SetSessionStore(s store.Store) {
+	FlushCheckpoints()
+	checkpoints.mu.Lock()
+	defer checkpoints.mu.Unlock()
+	sessionStore = s
+}
+
+// FlushCheckpoints waits until the checkpoints taken are written to the session store,
+// it is called before the SMF terminates
+func FlushCheckpoints() {
+	checkpoints.flush()
+}
+
+// InitSessionStore opens the session store configured if no store is set, and recovers the state
+// of the SMF saved in the store
+func InitSessionStore(cfg *factory.SessionStore) {
+	if sessionStore == nil && cfg != nil {
+		fileStore, err := store.NewFileStore(cfg.Path)
+		if err != nil {
+			logger.CtxLog.Errorf("Open session store failed: %v", err)
+			return
+		}
+		sessionStore = fileStore
+	}
+	if sessionStore == nil {
+		return
+	}
+
+	state, err := sessionStore.LoadSmfState()
+	if err != nil {
+		logger.CtxLog.Errorf("Load SMF state from session store failed: %v", err)
+	} else if state != nil {
+		if state.NfInstanceID != "" {
+			smfContext.NfInstanceID = state.NfInstanceID
+		}
+		atomic.StoreUint64(&smfContext.LocalSEIDCount, state.LocalSEIDCount)
+		logger.CtxLog.Infof("SMF state restored: NfInstanceID[%s] LocalSEIDCount[%d]",
+			state.NfInstanceID, state.LocalSEIDCount)
+	}
+	saveSmfState(sessionStore)
+}
+
+// SMContextsRestored reports whether any SM context has been restored from the session store,
+// the PFCP sessions of the restored SM contexts are to be established on the UPFs again
+func SMContextsRestored() bool {
+	return restoredSMContextCount.Load() > 0
+}
+
+func saveSmfState(s store.Store) {
+	seidCount := atomic.LoadUint64(&smfContext.LocalSEIDCount)
+	if err := s.SaveSmfState(&store.SmfState{
+		NfInstanceID:   smfContext.NfInstanceID,
+		LocalSEIDCount: seidCount,
+	}); err != nil {
+		logger.CtxLog.Warnf("Save SMF state to session store failed: %v", err)
+		return
+	}
+	savedLocalSEIDCount.Store(seidCount)
+}
+
+// Checkpoint saves the SM context to the session store, it is called when a procedure of the SM context
+// is completed and the SM context is consistent. The SM context is written in the background.
+func (c *SMContext) Checkpoint() {
+	if sessionStore == nil || c.SmfPduSessionSmContextCreateData == nil {
+		return
+	}
+	// The record is copied, it refers to the data of the SM context which may change before it is written
+	record, err := cloneRecord(c.toRecord())
+	if err != nil {
+		c.Log.Warnf("Save SM context to session store failed: %v", err)
+		return
+	}
+	checkpoints.enqueue(c.Ref, record)
+}
+
+func deleteCheckpoint(ref string) {
+	if sessionStore == nil {
+		return
+	}
+	checkpoints.enqueue(ref, nil)
+}
+
+func cloneRecord(record *store.SMContextRecord) (*store.SMContextRecord, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	clone := new(store.SMContextRecord)
+	if err = json.Unmarshal(data, clone); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// checkpointWriter writes the checkpoints to the session store in a goroutine, so that the procedures
+// do not wait for the store. The checkpoints of an SM context not written yet are replaced by the latest one.
+type checkpointWriter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// record to be written by SM context reference, nil to delete the SM context from the store
+	pending map[string]*store.SMContextRecord
+	writing bool
+	start   sync.Once
+}
+
+func newCheckpointWriter() *checkpointWriter {
+	w := &checkpointWriter{
+		pending: make(map[string]*store.SMContextRecord),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+func (w *checkpointWriter) enqueue(ref string, record *store.SMContextRecord) {
+	w.start.Do(func() {
+		go w.run()
+	})
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[ref] = record
+	w.cond.Broadcast()
+}
+
+func (w *checkpointWriter) run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for len(w.pending) == 0 {
+			w.cond.Wait()
+		}
+		records, s := w.pending, sessionStore
+		w.pending = make(map[string]*store.SMContextRecord)
+		w.writing = true
+		w.mu.Unlock()
+
+		if s != nil {
+			writeCheckpoints(s, records)
+		}
+
+		w.mu.Lock()
+		w.writing = false
+		w.cond.Broadcast()
+	}
+}
+
+func (w *checkpointWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.pending) > 0 || w.writing {
+		w.cond.Wait()
+	}
+}
+
+func writeCheckpoints(s store.Store, records map[string]*store.SMContextRecord) {
+	for ref, record := range records {
+		if record == nil {
+			if err := s.DeleteSMContext(ref); err != nil {
+				logger.CtxLog.Warnf("Delete SM context[%s] from session store failed: %v", ref, err)
+			}
+			continue
+		}
+		if err := s.SaveSMContext(record); err != nil {
+			logger.CtxLog.Warnf("Save SM context[%s] to session store failed: %v", ref, err)
+		}
+	}
+	// The local SEIDs are allocated before the SM contexts using them are written
+	if atomic.LoadUint64(&smfContext.LocalSEIDCount) != savedLocalSEIDCount.Load() {
+		saveSmfState(s)
+	}
+}
+
+func (c *SMContext) toRecord() *store.SMContextRecord {
+	record := &store.SMContextRecord{
+		Ref:                          c.Ref,
+		CreateData:                   c.SmfPduSessionSmContextCreateData,
+		SmStatusNotifyUri:            c.SmStatusNotifyUri,
+		Pti:                          c.Pti,
+		SelectedPDUSessionType:       c.SelectedPDUSessionType,
+		UpCnxState:                   c.UpCnxState,
+		DnnConfiguration:             c.DnnConfiguration,
+		UpSecurity:                   c.UpSecurity,
+		UeCmRegistered:               c.UeCmRegistered,
+		PDUAddress:                   c.PDUAddress,
+		UseStaticIP:                  c.UseStaticIP,
+		PDUIPv6Prefix:                c.PDUIPv6Prefix,
+		UseStaticIPv6:                c.UseStaticIPv6,
+		IPv6InterfaceID:              c.IPv6InterfaceID,
+		LocalULTeid:                  c.LocalULTeid,
+		LocalDLTeid:                  c.LocalDLTeid,
+		ChargingID:                   c.ChargingID,
+		EpsBearerID:                  c.EpsBearerID,
+		SmfRole:                      uint8(c.SmfRole),
+		PeerPduSessionUri:            c.PeerPduSessionUri,
+		AMFProfile:                   c.AMFProfile,
+		CommunicationClientApiPrefix: c.CommunicationClientApiPrefix,
+		SelectedPCFProfile:           c.SelectedPCFProfile,
+		SMPolicyID:                   c.SMPolicyID,
+		SelectedSessionRuleID:        c.SelectedSessionRuleID,
+		QFIs:                         c.qosDataToQFI,
+		QoSRuleIDs:                   c.PCCRuleIDToQoSRuleID,
+		PacketFilterIDs:              c.PacketFilterIDToNASPFID,
+	}
+	if GetSelf().Ues != nil {
+		record.SdmSubscriptionId = GetSelf().Ues.GetSubscriptionId(c.Supi)
+	}
+	if c.SelectedUPF != nil {
+		record.SelectedUPF = c.SelectedUPF.Name
+	}
+	if c.Tunnel != nil {
+		record.ANTunnel = store.TunnelRecord{
+			IPAddress: c.Tunnel.ANInformation.IPAddress,
+			TEID:      c.Tunnel.ANInformation.TEID,
+		}
+	}
+	record.AnchorULTunnel = store.TunnelRecord{
+		IPAddress: c.AnchorULTunnel.IPAddress,
+		TEID:      c.AnchorULTunnel.TEID,
+	}
+	for nodeIP, pfcpSessionContext := range c.PFCPContext {
+		record.PFCPSessions = append(record.PFCPSessions, store.PFCPSessionRecord{
+			NodeIP:    nodeIP,
+			LocalSEID: pfcpSessionContext.LocalSEID,
+		})
+	}
+	c.recordRuleIDs(record.PFCPSessions)
+
+	if len(c.SessionRules) > 0 {
+		decision := &models.SmPolicyDecision{
+			SessRules:     make(map[string]*models.SessionRule),
+			PccRules:      make(map[string]*models.PccRule),
+			QosDecs:       c.QosDatas,
+			ChgDecs:       c.ChargingData,
+			TraffContDecs: make(map[string]*models.TrafficControlData),
+		}
+		for id, sessRule := range c.SessionRules {
+			decision.SessRules[id] = sessRule.SessionRule
+		}
+		for id, pccRule := range c.PCCRules {
+			decision.PccRules[id] = pccRule.PccRule
+		}
+		for id, tcData := range c.TrafficControlDatas {
+			if tcData != nil {
+				decision.TraffContDecs[id] = tcData.TrafficControlData
+			}
+		}
+		record.PolicyDecision = decision
+	}
+	return record
+}
+
+// RestoreSMContexts rebuilds the SM contexts saved in the session store, with the IDs allocated
+// before the SMF restart. The PFCP sessions of the restored SM contexts are not established on the UPFs
+// until the PFCP associations are set up, see SMContextsRestored.
+func RestoreSMContexts() {
+	if sessionStore == nil {
+		return
+	}
+
+	records, err := sessionStore.LoadSMContexts()
+	if err != nil {
+		logger.CtxLog.Errorf("Load SM contexts from session store: %v", err)
+	}
+
+	upi := GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	// The rules keep their IDs, which the UPFs use in the usage reports
+	reservedRuleIDs := reserveRuleIDs(records)
+	for _, record := range records {
+		if err = restoreSMContext(record); err != nil {
+			logger.CtxLog.Warnf("Restore SM context[%s] failed: %v", record.Ref, err)
+			if GetSMContextByRef(record.Ref) != nil {
+				RemoveSMContext(record.Ref)
+			} else {
+				deleteCheckpoint(record.Ref)
+			}
+			continue
+		}
+		restoredSMContextCount.Add(1)
+	}
+	releaseRuleIDs(reservedRuleIDs)
+	logger.CtxLog.Infof("%d SM contexts restored from session store", restoredSMContextCount.Load())
+	saveSmfState(sessionStore)
+}
+
+func restoreSMContext(record *store.SMContextRecord) error {
+	createData := record.CreateData
+	if createData == nil || createData.SNssai == nil {
+		return fmt.Errorf("no SM context create data")
+	}
+
+	smContext := newSMContext(record.Ref, createData.Supi, createData.PduSessionId)
+	smContext.SmfPduSessionSmContextCreateData = createData
+	smContext.SmStatusNotifyUri = record.SmStatusNotifyUri
+	smContext.Pti = record.Pti
+	smContext.SelectedPDUSessionType = record.SelectedPDUSessionType
+	smContext.UpCnxState = record.UpCnxState
+	smContext.DnnConfiguration = record.DnnConfiguration
+	smContext.UpSecurity = record.UpSecurity
+	smContext.UeCmRegistered = record.UeCmRegistered
+	smContext.IPv6InterfaceID = record.IPv6InterfaceID
+	smContext.EpsBearerID = record.EpsBearerID
+	smContext.SmfRole = SmfRole(record.SmfRole)
+	smContext.PeerPduSessionUri = record.PeerPduSessionUri
+	smContext.AnchorULTunnel.IPAddress = record.AnchorULTunnel.IPAddress
+	smContext.AnchorULTunnel.TEID = record.AnchorULTunnel.TEID
+	smContext.AMFProfile = record.AMFProfile
+	smContext.CommunicationClientApiPrefix = record.CommunicationClientApiPrefix
+	smContext.SelectedPCFProfile = record.SelectedPCFProfile
+	smContext.SMPolicyID = record.SMPolicyID
+	smContext.SelectedSessionRuleID = record.SelectedSessionRuleID
+	smContext.DNNInfo = RetrieveDnnInformation(smContext.SNssai, smContext.Dnn)
+
+	// The UL TEIDs are known by the AN, and the DL TEIDs by the peer UPF of the peer SMF
+	if !TeidGenerator.Use(int(record.LocalULTeid)) {
+		return fmt.Errorf("UL TEID %d is in use", record.LocalULTeid)
+	}
+	smContext.LocalULTeid = record.LocalULTeid
+	if !TeidGenerator.Use(int(record.LocalDLTeid)) {
+		return fmt.Errorf("DL TEID %d is in use", record.LocalDLTeid)
+	}
+	smContext.LocalDLTeid = record.LocalDLTeid
+
+	smContext.ChargingID = record.ChargingID
+	if !smfContext.ChargingIDGenerator.Use(int(record.ChargingID)) {
+		smContext.ChargingID = GenerateChargingID()
+		smContext.Log.Warnf("Charging ID %d is in use, charging ID %d is allocated",
+			record.ChargingID, smContext.ChargingID)
+	}
+
+	smContext.SelectionParam = &UPFSelectionParams{
+		Dnn: smContext.Dnn,
+		SNssai: &SNssai{
+			Sst: smContext.SNssai.Sst,
+			Sd:  smContext.SNssai.Sd,
+		},
+		PDUSessionType: smContext.SelectedPDUSessionType,
+	}
+	upi := GetUserPlaneInformation()
+	selectedUPF := upi.UPFs[record.SelectedUPF]
+	if selectedUPF == nil {
+		return fmt.Errorf("UPF[%s] not found", record.SelectedUPF)
+	}
+	smContext.SelectedUPF = selectedUPF
+	// The UE address is allocated by the peer SMF if the session management is performed by the peer SMF
+	if !smContext.ServedByPeerSmf() {
+		if record.PDUAddress != nil {
+			if err := upi.RestoreUEIP(selectedUPF, record.PDUAddress, record.UseStaticIP); err != nil {
+				return err
+			}
+			smContext.PDUAddress = record.PDUAddress
+			smContext.UseStaticIP = record.UseStaticIP
+		}
+		if record.PDUIPv6Prefix != nil {
+			if err := upi.RestoreUEIP(selectedUPF, record.PDUIPv6Prefix, record.UseStaticIPv6); err != nil {
+				return err
+			}
+			smContext.PDUIPv6Prefix = record.PDUIPv6Prefix
+			smContext.UseStaticIPv6 = record.UseStaticIPv6
+		}
+	} else {
+		smContext.PDUAddress = record.PDUAddress
+		smContext.PDUIPv6Prefix = record.PDUIPv6Prefix
+	}
+
+	// The IDs signaled to the UE are kept, so that the QoS rules and the packet filters can be modified
+	for qosID, qfi := range record.QFIs {
+		smContext.qosDataToQFI[qosID] = qfi
+	}
+	reserveIDs(smContext.QFIGenerator, record.QFIs)
+	for pccRuleID, qosRuleID := range record.QoSRuleIDs {
+		smContext.PCCRuleIDToQoSRuleID[pccRuleID] = qosRuleID
+	}
+	reserveIDs(smContext.QoSRuleIDGenerator, record.QoSRuleIDs)
+	for pfID, nasPfID := range record.PacketFilterIDs {
+		smContext.PacketFilterIDToNASPFID[pfID] = nasPfID
+	}
+	reserveIDs(smContext.PacketFilterIDGenerator, record.PacketFilterIDs)
+
+	// The local SEIDs are kept for the PFCP session reports and the session deletion on the UPFs
+	for _, pfcpSession := range record.PFCPSessions {
+		upNode := upi.GetUPFNodeByIP(pfcpSession.NodeIP)
+		if upNode == nil {
+			continue
+		}
+		smContext.PFCPContext[pfcpSession.NodeIP] = &PFCPSessionContext{
+			PDRs:      make(map[uint16]*PDR),
+			NodeID:    upNode.NodeID,
+			LocalSEID: pfcpSession.LocalSEID,
+		}
+		seidSMContextMap.Store(pfcpSession.LocalSEID, smContext)
+		for {
+			seidCount := atomic.LoadUint64(&smfContext.LocalSEIDCount)
+			if pfcpSession.LocalSEID <= seidCount ||
+				atomic.CompareAndSwapUint64(&smfContext.LocalSEIDCount, seidCount, pfcpSession.LocalSEID) {
+				break
+			}
+		}
+	}
+
+	if smContext.UpCnxState == models.UpCnxState_ACTIVATED {
+		smContext.Tunnel.ANInformation.IPAddress = record.ANTunnel.IPAddress
+		smContext.Tunnel.ANInformation.TEID = record.ANTunnel.TEID
+	}
+
+	reservedURRIDs := smContext.reserveURRIDs(record.PFCPSessions)
+	if err := smContext.restoreDataPaths(record.PolicyDecision); err != nil {
+		return err
+	}
+	smContext.restoreRuleIDs(record.PFCPSessions, reservedURRIDs)
+
+	if subscriptionID := record.SdmSubscriptionId; subscriptionID != "" && smfContext.Ues != nil {
+		smfContext.Ues.SetSubscriptionId(smContext.Supi, subscriptionID)
+		smfContext.Ues.IncrementPduSessionCount(smContext.Supi)
+	}
+
+	smContext.SetState(Active)
+	// The IDs allocated again, e.g. the charging ID, are saved
+	smContext.Checkpoint()
+	smContext.Log.Infof("SM context restored from session store")
+	return nil
+}
+
+// restoreDataPaths rebuilds the data paths of the SM context from the policy decision
+func (c *SMContext) restoreDataPaths(decision *models.SmPolicyDecision) error {
+	if !c.ServedByPeerSmf() {
+		if decision == nil {
+			return fmt.Errorf("no SM policy decision")
+		}
+		if err := c.ApplySessionRules(decision); err != nil {
+			return err
+		}
+		if err := c.ApplyPccRules(decision); err != nil {
+			return err
+		}
+	}
+	if err := c.SelectDefaultDataPath(); err != nil {
+		return err
+	}
+
+	// The DL traffic is forwarded to the AN if the user plane connection is activated
+	for _, dataPath := range c.Tunnel.DataPathPool {
+		if !dataPath.Activated || c.Tunnel.ANInformation.IPAddress == nil ||
+			dataPath.FirstDPNode.DownLinkTunnel == nil || dataPath.FirstDPNode.DownLinkTunnel.PDR == nil {
+			continue
+		}
+		dataPath.FirstDPNode.DownLinkTunnel.PDR.FAR.ApplyAction = pfcpType.ApplyAction{Forw: true}
+	}
+
+	// The PFCP sessions on the UPFs which are no longer in the data paths are not restored
+	for nodeIP, pfcpSessionContext := range c.PFCPContext {
+		if len(pfcpSessionContext.PDRs) == 0 {
+			seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
+			delete(c.PFCPContext, nodeIP)
+		}
+	}
+	c.UpdatePDUSessionSet()
+	return nil
+}
+
+// reserveIDs marks the IDs allocated before the SMF restart as used in the ID generator
+func reserveIDs(generator *idgenerator.IDGenerator, ids map[string]uint8) {
+	set := make(map[int64]bool)
+	for _, id := range ids {
+		set[int64(id)] = true
+	}
+	reserveIDSet(generator, set)
+}
+
+// reserveIDSet marks the IDs as used in the ID generator, which allocates the IDs in ascending order
+func reserveIDSet(generator *idgenerator.IDGenerator, ids map[int64]bool) {
+	if len(ids) == 0 {
+		return
+	}
+	var maxID int64
+	for id := range ids {
+		if id > maxID {
+			maxID = id
+		}
+	}
+
+	var unused []int64
+	for {
+		id, err := generator.Allocate()
+		if err != nil {
+			break
+		}
+		if !ids[id] {
+			unused = append(unused, id)
+		}
+		if id >= maxID {
+			break
+		}
+	}
+	for _, id := range unused {
+		generator.FreeID(id)
+	}
+}
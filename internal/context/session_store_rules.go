@@ -0,0 +1,330 @@
+package context
+
+import (
+	"strconv"
+
+	"github.com/free5gc/smf/internal/context/store"
+)
+
+// ruleIDSet is the IDs of the rules on a UPF by rule type
+type ruleIDSet struct {
+	pdr map[int64]bool
+	far map[int64]bool
+	qer map[int64]bool
+	bar map[int64]bool
+}
+
+// visitSessionRules calls visit for the rules of the SM context installed on the UPFs. The key identifies
+// the rule in the PFCP session and does not change when the data paths are rebuilt from the policy decision,
+// a rule shared by the data paths is visited with each key.
+func (c *SMContext) visitSessionRules(visit func(upf *UPF, key string, rule interface{})) {
+	pathKeys := make(map[*DataPath]string)
+	for id, pccRule := range c.PCCRules {
+		if pccRule.Datapath != nil {
+			pathKeys[pccRule.Datapath] = "pcc:" + id
+		}
+	}
+
+	for _, dataPath := range c.Tunnel.DataPathPool {
+		pathKey, ok := pathKeys[dataPath]
+		if !ok && dataPath.IsDefaultPath {
+			pathKey, ok = "default", true
+		}
+		if !ok || !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			c.visitPDRRules(node.UPF, pathKey+"/ul", node.UpLinkTunnel.PDR, visit)
+			c.visitPDRRules(node.UPF, pathKey+"/dl", node.DownLinkTunnel.PDR, visit)
+			// the framed route PDRs share the FAR, QERs and URRs of the downlink PDR
+			for i, pdr := range node.FramedRoutePDRs {
+				visit(node.UPF, pathKey+"/framed-route/"+strconv.Itoa(i), pdr)
+			}
+		}
+	}
+}
+
+func (c *SMContext) visitPDRRules(upf *UPF, key string, pdr *PDR,
+	visit func(upf *UPF, key string, rule interface{}),
+) {
+	if pdr == nil {
+		return
+	}
+	visit(upf, key, pdr)
+	if pdr.FAR != nil {
+		visit(upf, key, pdr.FAR)
+		// the downlink FARs share the BAR
+		if pdr.FAR.BAR != nil {
+			visit(upf, "downlink", pdr.FAR.BAR)
+		}
+	}
+	for _, qer := range pdr.QER {
+		if qer.QERID == c.AMBRQerMap[upf.uuid] {
+			visit(upf, "ambr", qer)
+		} else {
+			visit(upf, "qfi/"+strconv.Itoa(int(qer.QFI.QFI)), qer)
+		}
+	}
+	for i, urr := range pdr.URR {
+		visit(upf, key+"/urr/"+strconv.Itoa(i), urr)
+	}
+}
+
+// recordRuleIDs saves the IDs of the rules of the SM context in the PFCP session records
+func (c *SMContext) recordRuleIDs(pfcpSessions []store.PFCPSessionRecord) {
+	records := make(map[string]*store.PFCPSessionRecord)
+	for i := range pfcpSessions {
+		records[pfcpSessions[i].NodeIP] = &pfcpSessions[i]
+	}
+	c.visitSessionRules(func(upf *UPF, key string, rule interface{}) {
+		record := records[upf.GetUPFIP()]
+		if record == nil {
+			return
+		}
+		switch rule := rule.(type) {
+		case *PDR:
+			if record.PDRIDs == nil {
+				record.PDRIDs = make(map[string]uint16)
+			}
+			record.PDRIDs[key] = rule.PDRID
+		case *FAR:
+			if record.FARIDs == nil {
+				record.FARIDs = make(map[string]uint32)
+			}
+			record.FARIDs[key] = rule.FARID
+		case *QER:
+			if record.QERIDs == nil {
+				record.QERIDs = make(map[string]uint32)
+			}
+			record.QERIDs[key] = rule.QERID
+		case *URR:
+			if record.URRIDs == nil {
+				record.URRIDs = make(map[string]uint32)
+			}
+			record.URRIDs[key] = rule.URRID
+		case *BAR:
+			if record.BARIDs == nil {
+				record.BARIDs = make(map[string]uint8)
+			}
+			record.BARIDs[key] = rule.BARID
+		}
+	})
+}
+
+// reserveRuleIDs marks the IDs of the rules allocated before the SMF restart as used on the UPFs, so that
+// the IDs are not allocated to the rules of the other SM contexts before they are restored
+func reserveRuleIDs(records []*store.SMContextRecord) map[*UPF]*ruleIDSet {
+	upi := GetUserPlaneInformation()
+	reserved := make(map[*UPF]*ruleIDSet)
+	for _, record := range records {
+		for _, pfcpSession := range record.PFCPSessions {
+			upNode := upi.GetUPFNodeByIP(pfcpSession.NodeIP)
+			if upNode == nil || upNode.UPF == nil {
+				continue
+			}
+			ids := reserved[upNode.UPF]
+			if ids == nil {
+				ids = &ruleIDSet{
+					pdr: make(map[int64]bool),
+					far: make(map[int64]bool),
+					qer: make(map[int64]bool),
+					bar: make(map[int64]bool),
+				}
+				reserved[upNode.UPF] = ids
+			}
+			for _, id := range pfcpSession.PDRIDs {
+				ids.pdr[int64(id)] = true
+			}
+			for _, id := range pfcpSession.FARIDs {
+				ids.far[int64(id)] = true
+			}
+			for _, id := range pfcpSession.QERIDs {
+				ids.qer[int64(id)] = true
+			}
+			for _, id := range pfcpSession.BARIDs {
+				ids.bar[int64(id)] = true
+			}
+		}
+	}
+
+	for upf, ids := range reserved {
+		reserveIDSet(upf.pdrIDGenerator, ids.pdr)
+		reserveIDSet(upf.farIDGenerator, ids.far)
+		reserveIDSet(upf.qerIDGenerator, ids.qer)
+		reserveIDSet(upf.barIDGenerator, ids.bar)
+	}
+	return reserved
+}
+
+// releaseRuleIDs frees the IDs reserved by reserveRuleIDs which are not given to a restored rule
+func releaseRuleIDs(reserved map[*UPF]*ruleIDSet) {
+	for upf, ids := range reserved {
+		for id := range ids.pdr {
+			if _, ok := upf.pdrPool.Load(uint16(id)); !ok {
+				upf.pdrIDGenerator.FreeID(id)
+			}
+		}
+		for id := range ids.far {
+			if _, ok := upf.farPool.Load(uint32(id)); !ok {
+				upf.farIDGenerator.FreeID(id)
+			}
+		}
+		for id := range ids.qer {
+			if _, ok := upf.qerPool.Load(uint32(id)); !ok {
+				upf.qerIDGenerator.FreeID(id)
+			}
+		}
+		for id := range ids.bar {
+			if _, ok := upf.barPool.Load(uint8(id)); !ok {
+				upf.barIDGenerator.FreeID(id)
+			}
+		}
+	}
+}
+
+// reserveURRIDs marks the IDs of the URRs allocated before the SMF restart as used in the SM context
+func (c *SMContext) reserveURRIDs(pfcpSessions []store.PFCPSessionRecord) map[int64]bool {
+	ids := make(map[int64]bool)
+	for _, pfcpSession := range pfcpSessions {
+		for _, id := range pfcpSession.URRIDs {
+			ids[int64(id)] = true
+		}
+	}
+	reserveIDSet(c.UrrIDGenerator, ids)
+	return ids
+}
+
+// restoreRuleIDs gives the rules rebuilt from the policy decision the IDs allocated before the SMF restart,
+// which are reserved by reserveRuleIDs and reserveURRIDs. The rules not found in the records keep the IDs
+// allocated again.
+func (c *SMContext) restoreRuleIDs(pfcpSessions []store.PFCPSessionRecord, reservedURRIDs map[int64]bool) {
+	records := make(map[string]*store.PFCPSessionRecord)
+	for i := range pfcpSessions {
+		records[pfcpSessions[i].NodeIP] = &pfcpSessions[i]
+	}
+	restored := make(map[interface{}]bool)
+	c.visitSessionRules(func(upf *UPF, key string, rule interface{}) {
+		record := records[upf.GetUPFIP()]
+		if record == nil || restored[rule] {
+			return
+		}
+		switch rule := rule.(type) {
+		case *PDR:
+			if id, ok := record.PDRIDs[key]; ok {
+				c.changePDRID(upf, rule, id)
+				restored[rule] = true
+			}
+		case *FAR:
+			if id, ok := record.FARIDs[key]; ok {
+				changeFARID(upf, rule, id)
+				restored[rule] = true
+			}
+		case *QER:
+			if id, ok := record.QERIDs[key]; ok {
+				c.changeQERID(upf, rule, id)
+				restored[rule] = true
+			}
+		case *URR:
+			if id, ok := record.URRIDs[key]; ok {
+				c.changeURRID(upf, rule, id)
+				restored[rule] = true
+			}
+		case *BAR:
+			if id, ok := record.BARIDs[key]; ok {
+				changeBARID(upf, rule, id)
+				restored[rule] = true
+			}
+		}
+	})
+
+	// The URR IDs reserved but not given to a URR are freed
+	inUse := make(map[int64]bool)
+	for _, id := range c.UrrIdMap {
+		inUse[int64(id)] = true
+	}
+	c.visitSessionRules(func(_ *UPF, _ string, rule interface{}) {
+		if urr, ok := rule.(*URR); ok {
+			inUse[int64(urr.URRID)] = true
+		}
+	})
+	for id := range reservedURRIDs {
+		if !inUse[id] {
+			c.UrrIDGenerator.FreeID(id)
+		}
+	}
+}
+
+func (c *SMContext) changePDRID(upf *UPF, pdr *PDR, id uint16) {
+	if pdr.PDRID == id {
+		return
+	}
+	upf.pdrPool.Delete(pdr.PDRID)
+	upf.pdrIDGenerator.FreeID(int64(pdr.PDRID))
+	if pfcpSessionContext := c.PFCPContext[upf.GetUPFIP()]; pfcpSessionContext != nil {
+		delete(pfcpSessionContext.PDRs, pdr.PDRID)
+		pfcpSessionContext.PDRs[id] = pdr
+	}
+	pdr.PDRID = id
+	upf.pdrPool.Store(pdr.PDRID, pdr)
+}
+
+func changeFARID(upf *UPF, far *FAR, id uint32) {
+	if far.FARID == id {
+		return
+	}
+	upf.farPool.Delete(far.FARID)
+	upf.farIDGenerator.FreeID(int64(far.FARID))
+	far.FARID = id
+	upf.farPool.Store(far.FARID, far)
+}
+
+func changeBARID(upf *UPF, bar *BAR, id uint8) {
+	if bar.BARID == id {
+		return
+	}
+	upf.barPool.Delete(bar.BARID)
+	upf.barIDGenerator.FreeID(int64(bar.BARID))
+	bar.BARID = id
+	upf.barPool.Store(bar.BARID, bar)
+}
+
+func (c *SMContext) changeQERID(upf *UPF, qer *QER, id uint32) {
+	if qer.QERID == id {
+		return
+	}
+	if c.AMBRQerMap[upf.uuid] == qer.QERID {
+		c.AMBRQerMap[upf.uuid] = id
+	}
+	if qosIDKey := getQosIdKey(upf.uuid, qer.QFI.QFI); c.QerUpfMap[qosIDKey] == qer.QERID {
+		c.QerUpfMap[qosIDKey] = id
+	}
+	upf.qerPool.Delete(qer.QERID)
+	upf.qerIDGenerator.FreeID(int64(qer.QERID))
+	qer.QERID = id
+	upf.qerPool.Store(qer.QERID, qer)
+}
+
+// changeURRID changes the ID of the URR, the URR IDs are allocated by the SM context
+func (c *SMContext) changeURRID(upf *UPF, urr *URR, id uint32) {
+	oldID := urr.URRID
+	if oldID == id {
+		return
+	}
+	for urrType, urrID := range c.UrrIdMap {
+		if urrID == oldID {
+			c.UrrIdMap[urrType] = id
+		}
+	}
+	if urrIDKey := getUrrIdKey(upf.UUID(), oldID); c.UrrUpfMap[urrIDKey] == urr {
+		delete(c.UrrUpfMap, urrIDKey)
+		c.UrrUpfMap[getUrrIdKey(upf.UUID(), id)] = urr
+	}
+	if chgInfo, ok := c.ChargingInfo[oldID]; ok {
+		delete(c.ChargingInfo, oldID)
+		c.ChargingInfo[id] = chgInfo
+	}
+	upf.urrPool.Delete(oldID)
+	c.UrrIDGenerator.FreeID(int64(oldID))
+	urr.URRID = id
+	upf.urrPool.Store(urr.URRID, urr)
+}
@@ -0,0 +1,271 @@
+package context_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/context/store"
+)
+
+func TestRestoreSMContexts(t *testing.T) {
+	initConfig()
+	for _, n := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		n.UPF.AssociationContext = context.Background()
+	}
+
+	s, err := store.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	smf_context.SetSessionStore(s)
+	defer smf_context.SetSessionStore(nil)
+
+	supi := "imsi-208930000007487"
+	smContext := newActiveSMContext(t, supi, 10)
+	smContext.Checkpoint()
+	smf_context.FlushCheckpoints()
+
+	ref := smContext.Ref
+	ueIP := smContext.PDUAddress
+	ulTeid, dlTeid := smContext.LocalULTeid, smContext.LocalDLTeid
+	seids := make(map[string]uint64)
+	for nodeIP, pfcpSessionContext := range smContext.PFCPContext {
+		seids[nodeIP] = pfcpSessionContext.LocalSEID
+	}
+	require.NotEmpty(t, seids)
+
+	// The SMF restarts: the SM context is lost in memory but kept in the session store
+	smf_context.SetSessionStore(nil)
+	smf_context.RemoveSMContext(ref)
+	require.Nil(t, smf_context.GetSMContextByRef(ref))
+	smf_context.SetSessionStore(s)
+
+	smf_context.RestoreSMContexts()
+	require.True(t, smf_context.SMContextsRestored())
+
+	restored := smf_context.GetSMContextByRef(ref)
+	require.NotNil(t, restored)
+	require.Same(t, restored, smf_context.GetSMContextById(supi, 10))
+	require.Equal(t, smf_context.Active, restored.State())
+	require.True(t, ueIP.Equal(restored.PDUAddress))
+	require.Equal(t, ulTeid, restored.LocalULTeid)
+	require.Equal(t, dlTeid, restored.LocalDLTeid)
+	require.Equal(t, "SessRuleId-1", restored.SelectedSessionRuleID)
+	require.NotNil(t, restored.Tunnel.DataPathPool.GetDefaultPath())
+	require.Len(t, restored.PFCPContext, len(seids))
+	for nodeIP, seid := range seids {
+		require.Equal(t, seid, restored.PFCPContext[nodeIP].LocalSEID)
+		require.Zero(t, restored.PFCPContext[nodeIP].RemoteSEID)
+		require.Same(t, restored, smf_context.GetSMContextBySEID(seid))
+	}
+
+	// The UE IP address and the TEIDs are not allocated again
+	require.Error(t, smf_context.GetUserPlaneInformation().RestoreUEIP(restored.SelectedUPF, ueIP, false))
+	teid, err := smf_context.GenerateTEID()
+	require.NoError(t, err)
+	require.NotEqual(t, ulTeid, teid)
+	require.NotEqual(t, dlTeid, teid)
+	smf_context.ReleaseTEID(teid)
+
+	smf_context.RemoveSMContext(ref)
+	smf_context.FlushCheckpoints()
+	records, err := s.LoadSMContexts()
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
+
+func TestRestoreSMContextRuleIDs(t *testing.T) {
+	initConfig()
+	for _, n := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		n.UPF.AssociationContext = context.Background()
+	}
+
+	s, err := store.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	smf_context.SetSessionStore(s)
+	defer smf_context.SetSessionStore(nil)
+
+	// The rules of the SM context are not the first ones allocated on the UPFs
+	for _, n := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		_, err = n.UPF.AddPDR()
+		require.NoError(t, err)
+		_, err = n.UPF.AddQER()
+		require.NoError(t, err)
+	}
+
+	smContext := newActiveSMContext(t, "imsi-208930000007489", 12)
+	smContext.Checkpoint()
+	smf_context.FlushCheckpoints()
+	ref := smContext.Ref
+	pdrIDs := sessionPDRIDs(smContext)
+	require.NotEmpty(t, pdrIDs)
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	require.NotNil(t, defaultPath)
+	ulPDR := defaultPath.FirstDPNode.UpLinkTunnel.PDR
+	farID := ulPDR.FAR.FARID
+	qerIDs := make([]uint32, 0, len(ulPDR.QER))
+	for _, qer := range ulPDR.QER {
+		qerIDs = append(qerIDs, qer.QERID)
+	}
+	require.NotEmpty(t, qerIDs)
+
+	// The SMF restarts with the UPFs not associated before
+	smf_context.SetSessionStore(nil)
+	smf_context.RemoveSMContext(ref)
+	initConfig()
+	for _, n := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		n.UPF.AssociationContext = context.Background()
+	}
+	smf_context.SetSessionStore(s)
+	smf_context.RestoreSMContexts()
+
+	restored := smf_context.GetSMContextByRef(ref)
+	require.NotNil(t, restored)
+	defer smf_context.RemoveSMContext(ref)
+	require.Equal(t, pdrIDs, sessionPDRIDs(restored))
+	restoredPath := restored.Tunnel.DataPathPool.GetDefaultPath()
+	require.NotNil(t, restoredPath)
+	restoredULPDR := restoredPath.FirstDPNode.UpLinkTunnel.PDR
+	require.Equal(t, farID, restoredULPDR.FAR.FARID)
+	restoredQERIDs := make([]uint32, 0, len(restoredULPDR.QER))
+	for _, qer := range restoredULPDR.QER {
+		restoredQERIDs = append(restoredQERIDs, qer.QERID)
+		require.Same(t, qer, restoredPath.FirstDPNode.UPF.GetQERById(qer.QERID))
+	}
+	require.Equal(t, qerIDs, restoredQERIDs)
+
+	// The rules added by a modification after the restart do not reuse the IDs of the restored rules
+	require.NoError(t, restored.ApplyPccRules(&models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{
+			"PccRuleId-1": {
+				FlowInfos: []models.FlowInformation{
+					{
+						FlowDescription: "permit out ip from 192.168.0.21 to 10.60.0.0/16",
+					},
+				},
+				PccRuleId:  "PccRuleId-1",
+				Precedence: 23,
+				RefQosData: []string{"QosId-1"},
+			},
+		},
+		QosDecs: map[string]*models.QosData{
+			"QosId-1": {
+				QosId:  "QosId-1",
+				Var5qi: 9,
+			},
+		},
+	}))
+	modified := sessionPDRIDs(restored)
+	for nodeIP, ids := range pdrIDs {
+		require.Greater(t, len(modified[nodeIP]), len(ids))
+		for id := range ids {
+			require.True(t, modified[nodeIP][id])
+		}
+	}
+	// the QER of the QoS flow of the PCC rule is new, the session AMBR QER is shared by the data paths
+	for _, qer := range restored.PCCRules["PccRuleId-1"].Datapath.FirstDPNode.UpLinkTunnel.PDR.QER {
+		if qer.QFI.QFI != restoredULPDR.QER[0].QFI.QFI {
+			require.NotContains(t, qerIDs, qer.QERID)
+		}
+	}
+}
+
+// sessionPDRIDs returns the IDs of the PDRs in the PFCP sessions of the SM context by UPF
+func sessionPDRIDs(smContext *smf_context.SMContext) map[string]map[uint16]bool {
+	ids := make(map[string]map[uint16]bool)
+	for nodeIP, pfcpSessionContext := range smContext.PFCPContext {
+		ids[nodeIP] = make(map[uint16]bool)
+		for id, pdr := range pfcpSessionContext.PDRs {
+			if id == pdr.PDRID {
+				ids[nodeIP][id] = true
+			}
+		}
+	}
+	return ids
+}
+
+func TestCheckpointSmfState(t *testing.T) {
+	initConfig()
+	for _, n := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		n.UPF.AssociationContext = context.Background()
+	}
+
+	fileStore, err := store.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	s := &countingStore{FileStore: fileStore}
+	smf_context.SetSessionStore(s)
+	defer smf_context.SetSessionStore(nil)
+
+	// The SMF state is saved when a local SEID is allocated
+	smContext := newActiveSMContext(t, "imsi-208930000007488", 11)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.Checkpoint()
+	smf_context.FlushCheckpoints()
+	require.Equal(t, 1, s.smfStateSaved)
+	state, err := s.LoadSmfState()
+	require.NoError(t, err)
+	require.Equal(t, smf_context.GetSelf().LocalSEIDCount, state.LocalSEIDCount)
+
+	// but not when the SM context is saved again
+	smContext.UpCnxState = models.UpCnxState_ACTIVATED
+	smContext.Checkpoint()
+	smf_context.FlushCheckpoints()
+	require.Equal(t, 1, s.smfStateSaved)
+
+	records, err := s.LoadSMContexts()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, models.UpCnxState_ACTIVATED, records[0].UpCnxState)
+}
+
+type countingStore struct {
+	*store.FileStore
+	smfStateSaved int
+}
+
+func (s *countingStore) SaveSmfState(state *store.SmfState) error {
+	s.smfStateSaved++
+	return s.FileStore.SaveSmfState(state)
+}
+
+func newActiveSMContext(t *testing.T, supi string, pduSessionID int32) *smf_context.SMContext {
+	smContext := smf_context.NewSMContext(supi, pduSessionID)
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         supi,
+		PduSessionId: pduSessionID,
+		Dnn:          "internet",
+		SNssai: &models.Snssai{
+			Sst: 1,
+			Sd:  "010203",
+		},
+	}
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	require.NoError(t, smContext.ApplySessionRules(&models.SmPolicyDecision{
+		SessRules: map[string]*models.SessionRule{
+			"SessRuleId-1": {
+				AuthSessAmbr: &models.Ambr{
+					Uplink:   "1000 Kbps",
+					Downlink: "1000 Kbps",
+				},
+				AuthDefQos: &models.AuthorizedDefaultQos{
+					Var5qi: 9,
+					Arp: &models.Arp{
+						PriorityLevel: 8,
+					},
+					PriorityLevel: 8,
+				},
+				SessRuleId: "SessRuleId-1",
+			},
+		},
+	}))
+	require.NoError(t, smContext.AllocUeIP())
+	require.NoError(t, smContext.SelectDefaultDataPath())
+	smContext.SetState(smf_context.Active)
+	return smContext
+}
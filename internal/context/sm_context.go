@@ -1,6 +1,8 @@
 package context
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
 	"math"
 	"net"
@@ -17,6 +19,7 @@ import (
 	"github.com/free5gc/ngap/ngapType"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/context/pool"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/pkg/factory"
 	"github.com/free5gc/util/idgenerator"
@@ -89,7 +92,8 @@ func GetSMContextCount() uint64 {
 type EventExposureNotification struct {
 	*models.NsmfEventExposureNotification
 
-	Uri string
+	Uri   string
+	SubId string // Nsmf_EventExposure subscription ID, empty if requested by PCF
 }
 
 type UsageReport struct {
@@ -107,7 +111,7 @@ type UsageReport struct {
 	ReportTpye models.ChfConvergedChargingTriggerType
 }
 
-var TeidGenerator *idgenerator.IDGenerator
+var TeidGenerator *pool.LazyReusePool
 
 type SMContext struct {
 	*models.SmfPduSessionSmContextCreateData
@@ -131,10 +135,17 @@ type SMContext struct {
 	HoState models.HoState
 
 	SelectionParam         *UPFSelectionParams
-	PDUAddress             net.IP
+	PDUAddress             net.IP // IPv4 address of IPv4 or IPv4v6 PDU session
 	UseStaticIP            bool
+	PDUIPv6Prefix          net.IP // /64 prefix of IPv6 or IPv4v6 PDU session
+	UseStaticIPv6          bool
+	IPv6InterfaceID        [8]byte // interface identifier for the IPv6 link-local address of the UE
 	SelectedPDUSessionType uint8
 
+	// MAC addresses of Ethernet PDU session detected by the UPF
+	learnedMACAddresses []net.HardwareAddr
+	macLock             sync.Mutex
+
 	DnnConfiguration models.DnnConfiguration
 
 	SMPolicyID string
@@ -144,6 +155,10 @@ type SMContext struct {
 	DLDirectForwardingTunnel *ngapType.UPTransportLayerInformation
 	IndirectForwardingTunnel *DataPath
 
+	// EPS bearer ID of the default QoS flow assigned by the AMF for the interworking with EPS over N26,
+	// 0 if none is assigned (TS 23.502 4.11.1.4.1)
+	EpsBearerID uint8
+
 	// UP Security support TS 29.502 R16 6.1.6.2.39
 	UpSecurity                                                     *models.UpSecurity
 	MaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink   models.MaxIntegrityProtectedDataRate
@@ -165,9 +180,26 @@ type SMContext struct {
 	// NodeID(string form) to PFCP Session Context
 	PFCPContext                         map[string]*PFCPSessionContext
 	PDUSessionRelease_DUE_TO_DUP_PDU_ID bool
+	// CSID of the PDU session set, see UpdatePDUSessionSet
+	LocalCSID uint16
+	// N3 F-TEID allocated by the UPF supporting FTUP, see ULN3LocalFTEID
+	chosenN3FTEID    *pfcpType.FTEID
+	chosenN3FTEIDUPF *UPF
 
 	DNNInfo *SnssaiSmfDnnInfo
 
+	// Home-routed roaming and I-SMF related, see sm_context_peer_smf.go
+	SmfRole SmfRole
+	// URI of the PDU session resource in the peer SMF
+	PeerPduSessionUri string
+	// UL N9 tunnel endpoint of the H-UPF or the PSA, used by the V-SMF and the I-SMF
+	AnchorULTunnel struct {
+		IPAddress net.IP
+		TEID      uint32
+	}
+	// 5GSM message from the peer SMF to be relayed to the UE by the V-SMF or the I-SMF
+	PeerN1SmMsg []byte
+
 	// SM Policy related
 	PCCRules            map[string]*PCCRule
 	SessionRules        map[string]*SessionRule
@@ -235,23 +267,37 @@ type SMContext struct {
 	// T3592 is PDU SESSION RELEASE COMMAND timer
 	T3592 *Timer
 
+	// The session AMBR changed by the PCF is to be signalled to the UE and to the AN respectively
+	sessionAMBRModified      bool
+	sessionAMBRModifiedForAN bool
+
+	// Paging for the downlink data of the deactivated user plane
+	pagingInProgress bool
+	ddnBackoffTimer  *time.Timer
+
+	// Downlink data buffered at the SMF and the PDR receiving it when it's forwarded back to the UPF
+	dlDataBuffer        [][]byte
+	dlDataTEID          uint32
+	dlDataForwardingPDR *PDR
+	dlDataForwardingUPF *UPF
+
+	// span context of the SBI request being processed for the SM context
+	traceSpanContext atomic.Value
+
 	// lock
 	SMLock sync.Mutex
 }
 
 func GenerateTEID() (uint32, error) {
-	var id uint32
-	if tmpID, err := TeidGenerator.Allocate(); err != nil {
-		return 0, err
-	} else {
-		id = uint32(tmpID)
+	id, ok := TeidGenerator.Allocate()
+	if !ok {
+		return 0, fmt.Errorf("no TEID available")
 	}
-
-	return id, nil
+	return uint32(id), nil
 }
 
 func ReleaseTEID(teid uint32) {
-	TeidGenerator.FreeID(int64(teid))
+	TeidGenerator.Free(int(teid))
 }
 
 func canonicalName(id string, pduSessID int32) string {
@@ -268,9 +314,29 @@ func ResolveRef(id string, pduSessID int32) (string, error) {
 }
 
 func NewSMContext(id string, pduSessID int32) *SMContext {
-	smContext := new(SMContext)
 	// Create Ref and identifier
-	smContext.Ref = uuid.New().URN()
+	smContext := newSMContext(uuid.New().URN(), id, pduSessID)
+
+	smContext.ChargingID = GenerateChargingID()
+
+	var err error
+	smContext.LocalDLTeid, err = GenerateTEID()
+	if err != nil {
+		return nil
+	}
+
+	smContext.LocalULTeid, err = GenerateTEID()
+	if err != nil {
+		return nil
+	}
+
+	return smContext
+}
+
+// newSMContext makes the SM context of the reference, without the IDs allocated from the SMF context
+func newSMContext(ref string, id string, pduSessID int32) *SMContext {
+	smContext := new(SMContext)
+	smContext.Ref = ref
 	smContextPool.Store(smContext.Ref, smContext)
 	canonicalRef.Store(canonicalName(id, pduSessID), smContext.Ref)
 
@@ -320,32 +386,19 @@ func NewSMContext(id string, pduSessID int32) *SMContext {
 	smContext.UrrUpfMap = make(map[string]*URR)
 
 	smContext.ChargingInfo = make(map[uint32]*ChargingInfo)
-	smContext.ChargingID = GenerateChargingID()
 
-	if factory.SmfConfig != nil &&
-		factory.SmfConfig.Configuration != nil {
-		smContext.UrrReportTime = time.Duration(factory.SmfConfig.Configuration.UrrPeriod) * time.Second
-		smContext.UrrReportThreshold = factory.SmfConfig.Configuration.UrrThreshold
+	if factory.SmfConfig != nil {
+		smContext.UrrReportTime = time.Duration(factory.SmfConfig.GetUrrPeriod()) * time.Second
+		smContext.UrrReportThreshold = factory.SmfConfig.GetUrrThreshold()
 		logger.CtxLog.Infof("UrrPeriod: %v", smContext.UrrReportTime)
 		logger.CtxLog.Infof("UrrThreshold: %d", smContext.UrrReportThreshold)
-		if factory.SmfConfig.Configuration.RequestedUnit != 0 {
-			smContext.RequestedUnit = factory.SmfConfig.Configuration.RequestedUnit
+		if requestedUnit := factory.SmfConfig.GetRequestedUnit(); requestedUnit != 0 {
+			smContext.RequestedUnit = requestedUnit
 		} else {
 			smContext.RequestedUnit = 1000
 		}
 	}
 
-	var err error
-	smContext.LocalDLTeid, err = GenerateTEID()
-	if err != nil {
-		return nil
-	}
-
-	smContext.LocalULTeid, err = GenerateTEID()
-	if err != nil {
-		return nil
-	}
-
 	return smContext
 }
 
@@ -379,26 +432,46 @@ func RemoveSMContext(ref string) {
 		return
 	}
 
-	if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
-		logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] Release IP[%s]",
-			smContext.Supi, smContext.PDUSessionID, smContext.PDUAddress.String())
-		GetUserPlaneInformation().
-			ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
+	// The UE address is allocated by the peer SMF if the session management is performed by the peer SMF
+	if smContext.SelectedUPF != nil && !smContext.ServedByPeerSmf() {
+		if smContext.PDUAddress != nil {
+			logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] Release IP[%s]",
+				smContext.Supi, smContext.PDUSessionID, smContext.PDUAddress.String())
+			GetUserPlaneInformation().
+				ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
+		}
+		if smContext.PDUIPv6Prefix != nil {
+			logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] Release IPv6 prefix[%s/%d]",
+				smContext.Supi, smContext.PDUSessionID, smContext.PDUIPv6Prefix.String(), IPv6PrefixLen)
+			GetUserPlaneInformation().
+				ReleaseUEIP(smContext.SelectedUPF, smContext.PDUIPv6Prefix, smContext.UseStaticIPv6)
+		}
 		smContext.SelectedUPF = nil
 	}
 
 	for _, pfcpSessionContext := range smContext.PFCPContext {
 		seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
 	}
+	smContext.LeavePDUSessionSet()
+	smContext.releaseDlBuffering()
 
 	ReleaseTEID(smContext.LocalULTeid)
 	ReleaseTEID(smContext.LocalDLTeid)
 
+	deleteCheckpoint(ref)
 	smContextPool.Delete(ref)
 	canonicalRef.Delete(canonicalName(smContext.Supi, smContext.PDUSessionID))
 	smContext.Log.Infof("smContext[%s] is deleted from pool", ref)
 }
 
+// ProcEachSMContext calls procFunc for every SM context in the pool
+func ProcEachSMContext(procFunc func(*SMContext)) {
+	smContextPool.Range(func(key, value interface{}) bool {
+		procFunc(value.(*SMContext))
+		return true
+	})
+}
+
 // *** add unit test ***//
 func GetSMContextBySEID(seid uint64) *SMContext {
 	if value, ok := seidSMContextMap.Load(seid); ok {
@@ -455,22 +528,87 @@ func (smContext *SMContext) State() SMContextState {
 	return SMContextState(atomic.LoadUint32((*uint32)(&smContext.state)))
 }
 
+// PDUAddressToNAS returns the PDU address information and the length of the PDU address IE
+// (TS 24.501 9.11.4.10): the IPv4 address, the IPv6 interface identifier, or both.
 func (smContext *SMContext) PDUAddressToNAS() ([12]byte, uint8) {
 	var addr [12]byte
 	var addrLen uint8
-	copy(addr[:], smContext.PDUAddress)
 	switch smContext.SelectedPDUSessionType {
 	case nasMessage.PDUSessionTypeIPv4:
-		var addrLenBuf uint8 = 4 + 1
-		addrLen = addrLenBuf
+		copy(addr[:], smContext.PDUAddress.To4())
+		addrLen = 4 + 1
 	case nasMessage.PDUSessionTypeIPv6:
+		copy(addr[:], smContext.IPv6InterfaceID[:])
+		addrLen = 8 + 1
 	case nasMessage.PDUSessionTypeIPv4IPv6:
-		var addrLenBuf uint8 = 12 + 1
-		addrLen = addrLenBuf
+		copy(addr[:8], smContext.IPv6InterfaceID[:])
+		copy(addr[8:], smContext.PDUAddress.To4())
+		addrLen = 12 + 1
 	}
 	return addr, addrLen
 }
 
+// HasPDUAddress reports whether an IPv4 address or an IPv6 prefix is allocated to this PDU session
+func (smContext *SMContext) HasPDUAddress() bool {
+	return smContext.PDUAddress != nil || smContext.PDUIPv6Prefix != nil
+}
+
+// LearnedMACAddresses returns the MAC addresses detected in the Ethernet PDU session
+func (smContext *SMContext) LearnedMACAddresses() []net.HardwareAddr {
+	smContext.macLock.Lock()
+	defer smContext.macLock.Unlock()
+	return append([]net.HardwareAddr(nil), smContext.learnedMACAddresses...)
+}
+
+// UpdateLearnedMACAddresses updates the MAC addresses reported by the UPF (TS 29.244 5.13.4)
+func (smContext *SMContext) UpdateLearnedMACAddresses(detected, removed []net.HardwareAddr) {
+	smContext.macLock.Lock()
+	defer smContext.macLock.Unlock()
+
+	contains := func(macs []net.HardwareAddr, mac net.HardwareAddr) bool {
+		for _, m := range macs {
+			if bytes.Equal(m, mac) {
+				return true
+			}
+		}
+		return false
+	}
+
+	learned := smContext.learnedMACAddresses[:0]
+	for _, mac := range smContext.learnedMACAddresses {
+		if !contains(removed, mac) {
+			learned = append(learned, mac)
+		}
+	}
+	for _, mac := range detected {
+		if !contains(learned, mac) {
+			learned = append(learned, mac)
+		}
+	}
+	smContext.learnedMACAddresses = learned
+}
+
+// UEIPAddress returns the UE IP address IE of the PDI, sd is set for the PDR detecting downlink traffic
+func (smContext *SMContext) UEIPAddress(sd bool) *pfcpType.UEIPAddress {
+	if !smContext.HasPDUAddress() {
+		// e.g. Ethernet PDU session
+		return nil
+	}
+	ueIPAddress := &pfcpType.UEIPAddress{
+		Sd: sd,
+	}
+	if ipv4 := smContext.PDUAddress.To4(); ipv4 != nil {
+		ueIPAddress.V4 = true
+		ueIPAddress.Ipv4Address = ipv4
+	}
+	if smContext.PDUIPv6Prefix != nil {
+		// The prefix length is /64 when the IPv6 prefix delegation bits are absent (TS 29.244 8.2.62)
+		ueIPAddress.V6 = true
+		ueIPAddress.Ipv6Address = smContext.PDUIPv6Prefix.To16()
+	}
+	return ueIPAddress
+}
+
 func (smContext *SMContext) GetNodeIDByLocalSEID(seid uint64) pfcpType.NodeID {
 	for _, pfcpCtx := range smContext.PFCPContext {
 		if pfcpCtx.LocalSEID == seid {
@@ -496,6 +634,7 @@ func (smContext *SMContext) AllocateLocalSEIDForUPPath(path UPPath) {
 			seidSMContextMap.Store(allocatedSEID, smContext)
 		}
 	}
+	smContext.UpdatePDUSessionSet()
 }
 
 func (smContext *SMContext) AllocateLocalSEIDForDataPath(dataPath *DataPath) {
@@ -514,6 +653,7 @@ func (smContext *SMContext) AllocateLocalSEIDForDataPath(dataPath *DataPath) {
 			seidSMContextMap.Store(allocatedSEID, smContext)
 		}
 	}
+	smContext.UpdatePDUSessionSet()
 }
 
 func (smContext *SMContext) PutPDRtoPFCPSession(nodeID pfcpType.NodeID, pdr *PDR) error {
@@ -533,24 +673,57 @@ func (c *SMContext) findPSAandAllocUeIP(param *UPFSelectionParams) error {
 		return fmt.Errorf("UPFSelectionParams is nil")
 	}
 
+	var addr net.IP
+	var useStaticIP bool
 	upi := GetUserPlaneInformation()
+	if param.PDUSessionType == nasMessage.PDUSessionTypeEthernet {
+		// No UE IP address for Ethernet PDU session (TS 23.501 5.6.10.2)
+		c.SelectedUPF = upi.SelectUPF(param)
+		if c.SelectedUPF == nil {
+			return fmt.Errorf("fail to select UPF, Selection Parameter: %s", param.String())
+		}
+		return nil
+	}
 	if GetSelf().ULCLSupport && CheckUEHasPreConfig(c.Supi) {
 		groupName := GetULCLGroupNameFromSUPI(c.Supi)
 		preConfigPathPool := GetUEDefaultPathPool(groupName)
 		if preConfigPathPool != nil {
 			selectedUPFName := ""
-			selectedUPFName, c.PDUAddress, c.UseStaticIP = preConfigPathPool.SelectUPFAndAllocUEIPForULCL(
+			selectedUPFName, addr, useStaticIP = preConfigPathPool.SelectUPFAndAllocUEIPForULCL(
 				upi, param)
 			c.SelectedUPF = upi.UPFs[selectedUPFName]
 		}
 	} else {
-		c.SelectedUPF, c.PDUAddress, c.UseStaticIP = upi.SelectUPFAndAllocUEIP(param)
-		c.Log.Infof("Allocated PDUAdress[%s]", c.PDUAddress.String())
+		c.SelectedUPF, addr, useStaticIP = upi.SelectUPFAndAllocUEIP(param)
 	}
-	if c.PDUAddress == nil {
+	if addr == nil {
 		return fmt.Errorf("fail to allocate PDU address, Selection Parameter: %s",
 			param.String())
 	}
+
+	if param.PDUSessionType == nasMessage.PDUSessionTypeIPv6 {
+		c.PDUIPv6Prefix, c.UseStaticIPv6 = addr, useStaticIP
+	} else {
+		c.PDUAddress, c.UseStaticIP = addr, useStaticIP
+		c.Log.Infof("Allocated PDUAdress[%s]", c.PDUAddress.String())
+	}
+
+	if param.PDUSessionType == nasMessage.PDUSessionTypeIPv4IPv6 {
+		c.PDUIPv6Prefix, c.UseStaticIPv6 = upi.AllocUEIPv6Prefix(c.SelectedUPF, param)
+		if c.PDUIPv6Prefix == nil {
+			// TS 24.501 6.4.1.3: fall back to IPv4 if IPv6 can not be served
+			c.Log.Warnf("No IPv6 prefix for DNN[%s], fall back to IPv4 PDU session", c.Dnn)
+			c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+			c.EstAcceptCause5gSMValue = nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed
+		}
+	}
+
+	if c.PDUIPv6Prefix != nil {
+		c.Log.Infof("Allocated PDU IPv6 prefix[%s/%d]", c.PDUIPv6Prefix.String(), IPv6PrefixLen)
+		if _, err := rand.Read(c.IPv6InterfaceID[:]); err != nil {
+			return fmt.Errorf("generate IPv6 interface identifier failed: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -561,13 +734,24 @@ func (c *SMContext) AllocUeIP() error {
 			Sst: c.SNssai.Sst,
 			Sd:  c.SNssai.Sd,
 		},
+		PDUSessionType: c.SelectedPDUSessionType,
 	}
 
 	if len(c.DnnConfiguration.StaticIpAddress) > 0 {
 		staticIPConfig := c.DnnConfiguration.StaticIpAddress[0]
-		if staticIPConfig.Ipv4Addr != "" {
+		if staticIPConfig.Ipv4Addr != "" &&
+			c.SelectedPDUSessionType != nasMessage.PDUSessionTypeIPv6 {
 			c.SelectionParam.PDUAddress = net.ParseIP(staticIPConfig.Ipv4Addr).To4()
 		}
+		if staticIPConfig.Ipv6Prefix != "" {
+			if _, ipv6Prefix, err := net.ParseCIDR(staticIPConfig.Ipv6Prefix); err != nil {
+				c.Log.Warnf("Invalid static IPv6 prefix[%s]: %v", staticIPConfig.Ipv6Prefix, err)
+			} else if c.SelectedPDUSessionType == nasMessage.PDUSessionTypeIPv6 {
+				c.SelectionParam.PDUAddress = ipv6Prefix.IP
+			} else {
+				c.SelectionParam.PDUIPv6Prefix = ipv6Prefix.IP
+			}
+		}
 	}
 
 	if err := c.findPSAandAllocUeIP(c.SelectionParam); err != nil {
@@ -689,14 +873,6 @@ func (c *SMContext) BuildUpPathChgEventExposureNotification(
 	chgEvent *models.UpPathChgEvent,
 	srcRoute, tgtRoute *models.RouteToLocation,
 ) {
-	if chgEvent == nil {
-		return
-	}
-	if chgEvent.NotificationUri == "" {
-		c.Log.Warnf("No NotificationUri [%s]", chgEvent.NotificationUri)
-		return
-	}
-
 	en := models.SmfEventExposureEventNotification{
 		Event:            models.SmfEvent_UP_PATH_CH,
 		SourceTraRouting: srcRoute,
@@ -708,31 +884,59 @@ func (c *SMContext) BuildUpPathChgEventExposureNotification(
 	}
 	// TODO: sourceUeIpv4Addr, sourceUeIpv6Prefix, targetUeIpv4Addr, targetUeIpv6Prefix
 
-	k := chgEvent.NotificationUri + chgEvent.NotifCorreId
-	if strings.Contains(string(chgEvent.DnaiChgType), "EARLY") {
+	if chgEvent != nil {
+		if chgEvent.NotificationUri == "" {
+			c.Log.Warnf("No NotificationUri [%s]", chgEvent.NotificationUri)
+		} else {
+			c.addUpPathChgNotification(chgEvent.NotificationUri, chgEvent.NotifCorreId, "",
+				chgEvent.DnaiChgType, en)
+		}
+	}
+
+	// Subscriptions created through Nsmf_EventExposure
+	ProcEachEventExposureSubscription(func(eeSub *EventExposureSubscription) {
+		eventSub := eeSub.EventSubscription(models.SmfEvent_UP_PATH_CH)
+		if eventSub == nil || !eeSub.Matches(c) {
+			return
+		}
+		subEn := c.NewEventNotification(models.SmfEvent_UP_PATH_CH)
+		subEn.SourceTraRouting = en.SourceTraRouting
+		subEn.TargetTraRouting = en.TargetTraRouting
+		subEn.SourceDnai = en.SourceDnai
+		subEn.TargetDnai = en.TargetDnai
+		c.addUpPathChgNotification(eeSub.NotifUri, eeSub.NotifId, eeSub.SubId,
+			eventSub.DnaiChgType, subEn)
+	})
+}
+
+func (c *SMContext) addUpPathChgNotification(
+	uri, id, subId string,
+	dnaiChgType models.DnaiChangeType,
+	en models.SmfEventExposureEventNotification,
+) {
+	k := uri + id
+	if strings.Contains(string(dnaiChgType), "EARLY") {
 		en.DnaiChgType = models.DnaiChangeType("EARLY")
 		v, ok := c.UpPathChgEarlyNotification[k]
 		if ok {
 			v.EventNotifs = append(v.EventNotifs, en)
 		} else {
-			c.UpPathChgEarlyNotification[k] = newEventExposureNotification(
-				chgEvent.NotificationUri, chgEvent.NotifCorreId, &en)
+			c.UpPathChgEarlyNotification[k] = newEventExposureNotification(uri, id, subId, &en)
 		}
 	}
-	if strings.Contains(string(chgEvent.DnaiChgType), "LATE") {
+	if strings.Contains(string(dnaiChgType), "LATE") {
 		en.DnaiChgType = models.DnaiChangeType("LATE")
 		v, ok := c.UpPathChgLateNotification[k]
 		if ok {
 			v.EventNotifs = append(v.EventNotifs, en)
 		} else {
-			c.UpPathChgLateNotification[k] = newEventExposureNotification(
-				chgEvent.NotificationUri, chgEvent.NotifCorreId, &en)
+			c.UpPathChgLateNotification[k] = newEventExposureNotification(uri, id, subId, &en)
 		}
 	}
 }
 
 func newEventExposureNotification(
-	uri, id string,
+	uri, id, subId string,
 	en *models.SmfEventExposureEventNotification,
 ) *EventExposureNotification {
 	return &EventExposureNotification{
@@ -740,7 +944,8 @@ func newEventExposureNotification(
 			NotifId:     id,
 			EventNotifs: []models.SmfEventExposureEventNotification{*en},
 		},
-		Uri: uri,
+		Uri:   uri,
+		SubId: subId,
 	}
 }
 
@@ -757,6 +962,10 @@ func (c *SMContext) SendUpPathChgNotification(chgType string, notifCb NotifCallb
 		return
 	}
 	for k, n := range notifications {
+		if n.SubId != "" && !countEventExposureReport(n.SubId) {
+			delete(notifications, k)
+			continue
+		}
 		c.Log.Infof("Send UpPathChg Event Exposure Notification [%s][%s] to NEF/AF", chgType, n.NotifId)
 		go notifCb(n.Uri, n.NsmfEventExposureNotification)
 		delete(notifications, k)
@@ -769,16 +978,11 @@ func (smContext *SMContext) RemovePDRfromPFCPSession(nodeID pfcpType.NodeID, pdr
 	delete(pfcpSessCtx.PDRs, pdr.PDRID)
 }
 
-func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint8) error {
-	dnnPDUSessionType := smContext.DnnConfiguration.PduSessionTypes
-	if dnnPDUSessionType == nil {
-		return fmt.Errorf("this SMContext[%s] has no subscription pdu session type info", smContext.Ref)
+// allowedPDUSessionTypes returns the PDU session types allowed by the DNN configuration of the subscription
+func (smContext *SMContext) allowedPDUSessionTypes() (allowIPv4, allowIPv6, allowEthernet bool) {
+	if smContext.DnnConfiguration.PduSessionTypes == nil {
+		return false, false, false
 	}
-
-	allowIPv4 := false
-	allowIPv6 := false
-	allowEthernet := false
-
 	for _, allowedPDUSessionType := range smContext.DnnConfiguration.PduSessionTypes.AllowedSessionTypes {
 		switch allowedPDUSessionType {
 		case models.PduSessionType_IPV4:
@@ -792,42 +996,34 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 			allowEthernet = true
 		}
 	}
+	return allowIPv4, allowIPv6, allowEthernet
+}
 
-	supportedPDUSessionType := GetSelf().SupportedPDUSessionType
-	switch supportedPDUSessionType {
-	case "IPv4":
-		if !allowIPv4 {
-			return fmt.Errorf(
-				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
-				supportedPDUSessionType,
-				smContext.Dnn,
-			)
-		}
-	case "IPv6":
-		if !allowIPv6 {
-			return fmt.Errorf(
-				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
-				supportedPDUSessionType,
-				smContext.Dnn,
-			)
-		}
-	case "IPv4v6":
-		if !allowIPv4 && !allowIPv6 {
-			return fmt.Errorf(
-				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
-				supportedPDUSessionType,
-				smContext.Dnn,
-			)
+// PDUSessionTypeRejectCause returns the 5GSM cause of the rejection of the requested PDU session type
+// which is not allowed (TS 24.501 6.4.1.4.3)
+func (smContext *SMContext) PDUSessionTypeRejectCause(requestedPDUSessionType uint8) uint8 {
+	allowIPv4, allowIPv6, _ := smContext.allowedPDUSessionTypes()
+	switch requestedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv4:
+		if allowIPv6 {
+			return nasMessage.Cause5GSMPDUSessionTypeIPv6OnlyAllowed
 		}
-	case "Ethernet":
-		if !allowEthernet {
-			return fmt.Errorf(
-				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
-				supportedPDUSessionType,
-				smContext.Dnn,
-			)
+	case nasMessage.PDUSessionTypeIPv6:
+		if allowIPv4 {
+			return nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed
 		}
 	}
+	return nasMessage.Cause5GSMUnknownPDUSessionType
+}
+
+// IsAllowedPDUSessionType selects the PDU session type of the requested one allowed by the DNN configuration
+func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint8) error {
+	dnnPDUSessionType := smContext.DnnConfiguration.PduSessionTypes
+	if dnnPDUSessionType == nil {
+		return fmt.Errorf("this SMContext[%s] has no subscription pdu session type info", smContext.Ref)
+	}
+
+	allowIPv4, allowIPv6, allowEthernet := smContext.allowedPDUSessionTypes()
 
 	smContext.EstAcceptCause5gSMValue = 0
 	switch nasConvert.PDUSessionTypeToModels(requestedPDUSessionType) {
@@ -921,3 +1117,66 @@ func (smContext *SMContext) RemoveQFI(qosId string) {
 		smContext.RemoveQosFlow(qfi)
 	}
 }
+
+// chooseIDN3 is the CHOOSE ID of the N3 F-TEID allocated by the UPF (TS 29.244 5.5.3),
+// the UL PDRs on the N3 interface share the F-TEID
+const chooseIDN3 uint8 = 1
+
+// ULN3LocalFTEID returns the local F-TEID of the UL PDR on the N3 interface of the UPF.
+// The UPF supporting FTUP is requested to allocate the F-TEID, which is used for the later PDRs.
+func (smContext *SMContext) ULN3LocalFTEID(upf *UPF, upIP net.IP) *pfcpType.FTEID {
+	if smContext.chosenN3FTEID != nil && smContext.chosenN3FTEIDUPF == upf {
+		fteid := *smContext.chosenN3FTEID
+		return &fteid
+	}
+	fteid := NewLocalFTEID(upIP, smContext.LocalULTeid)
+	if !upf.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesFtup) {
+		return fteid
+	}
+	return &pfcpType.FTEID{
+		Ch:       true,
+		Chid:     true,
+		ChooseId: chooseIDN3,
+		V4:       fteid.V4,
+		V6:       fteid.V6,
+	}
+}
+
+// SetChosenN3FTEID sets the N3 F-TEID allocated by the UPF to the PDRs which have requested it
+func (smContext *SMContext) SetChosenN3FTEID(upf *UPF, fteid *pfcpType.FTEID) {
+	pfcpSessionCtx := smContext.PFCPContext[upf.GetUPFIP()]
+	if pfcpSessionCtx == nil {
+		return
+	}
+	for _, pdr := range pfcpSessionCtx.PDRs {
+		localFTEID := pdr.PDI.LocalFTeid
+		if localFTEID == nil || !localFTEID.Ch || localFTEID.ChooseId != chooseIDN3 {
+			continue
+		}
+		chosen := *fteid
+		pdr.PDI.LocalFTeid = &chosen
+		smContext.chosenN3FTEID = fteid
+		smContext.chosenN3FTEIDUPF = upf
+	}
+}
+
+// ULN3TunnelEndpoint returns the address and TEID of the UL N3 tunnel endpoint of the UPF
+// for the UL NG-U UP TNL Information
+func (smContext *SMContext) ULN3TunnelEndpoint(upf *UPF, teid uint32) (net.IP, uint32, error) {
+	if fteid := smContext.chosenN3FTEID; fteid != nil && smContext.chosenN3FTEIDUPF == upf {
+		if fteid.V4 {
+			return fteid.Ipv4Address.To4(), fteid.Teid, nil
+		}
+		return fteid.Ipv6Address.To16(), fteid.Teid, nil
+	}
+	if smContext.ServesPeerSmf() {
+		iface := upf.GetInterface(models.UpInterfaceType_N9, smContext.Dnn)
+		if iface == nil {
+			return nil, 0, fmt.Errorf("no N9 interface of UPF for DNN[%s]", smContext.Dnn)
+		}
+		n9IP, err := iface.IP(smContext.SelectedPDUSessionType)
+		return n9IP, teid, err
+	}
+	n3IP, err := upf.N3Interfaces[0].IP(smContext.SelectedPDUSessionType)
+	return n3IP, teid, err
+}
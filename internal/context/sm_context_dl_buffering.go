@@ -0,0 +1,217 @@
+package context
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// dlDataTEIDSMContextMap maps the TEID of the SMF N4-u endpoint to the SM context
+// whose downlink data is buffered at the SMF
+var dlDataTEIDSMContextMap sync.Map
+
+// DlBuffering returns the configured handling of the downlink data for the S-NSSAI and DNN of the PDU session,
+// nil if the data is buffered at the UPF by default
+func (c *SMContext) DlBuffering() *factory.DlBuffering {
+	if c.SmfPduSessionSmContextCreateData == nil {
+		return nil
+	}
+	if dnnInfo := RetrieveDnnInformation(c.SNssai, c.Dnn); dnnInfo != nil {
+		return dnnInfo.DlBuffering
+	}
+	return nil
+}
+
+// DlBufferingAtSmf returns true if the downlink data of any S-NSSAI and DNN is buffered at the SMF
+func DlBufferingAtSmf() bool {
+	for _, snssaiInfo := range GetSelf().GetSnssaiInfos() {
+		for _, dnnInfo := range snssaiInfo.DnnInfos {
+			if dnnInfo.DlBuffering.GetMode() == factory.DlBufferingModeSmf {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DownlinkBAR returns the BAR of the PFCP session in the UPF, which is created on the first call
+func (c *SMContext) DownlinkBAR(nodeID pfcpType.NodeID) (*BAR, error) {
+	nodeIDtoIP := nodeID.ResolveNodeIdToIp().String()
+	pfcpSessCtx, exist := c.PFCPContext[nodeIDtoIP]
+	if !exist {
+		return nil, fmt.Errorf("Can't find PFCPContext[%s] to put BAR", nodeIDtoIP)
+	}
+	if pfcpSessCtx.BAR == nil {
+		upf := RetrieveUPFNodeByNodeID(nodeID)
+		if upf == nil {
+			return nil, fmt.Errorf("Can't find UPF[%s] to allocate BAR", nodeIDtoIP)
+		}
+		bar, err := upf.AddBAR()
+		if err != nil {
+			return nil, fmt.Errorf("Allocate BAR in UPF[%s] failed: %w", nodeIDtoIP, err)
+		}
+		bar.State = RULE_INITIAL
+		pfcpSessCtx.BAR = bar
+	}
+	return pfcpSessCtx.BAR, nil
+}
+
+// releaseDownlinkBARs releases the BARs of the PFCP sessions, which are removed with the sessions
+func (c *SMContext) releaseDownlinkBARs() {
+	for _, pfcpSessCtx := range c.PFCPContext {
+		if pfcpSessCtx.BAR == nil {
+			continue
+		}
+		if upf := RetrieveUPFNodeByNodeID(pfcpSessCtx.NodeID); upf != nil {
+			if err := upf.RemoveBAR(pfcpSessCtx.BAR); err != nil {
+				c.Log.Warnf("Release BAR[%d] failed: %+v", pfcpSessCtx.BAR.BARID, err)
+			}
+		}
+		pfcpSessCtx.BAR = nil
+	}
+}
+
+// QoSOfFlow returns the ARP and the 5QI of the QoS flow, or of the default QoS flow
+// if the QFI is 0 or unknown
+func (c *SMContext) QoSOfFlow(qfi uint8) (*models.Arp, int32) {
+	if qosFlow, ok := c.AdditonalQosFlows[qfi]; ok && qfi != 0 && qosFlow.QoSProfile != nil {
+		return qosFlow.QoSProfile.Arp, qosFlow.QoSProfile.Var5qi
+	}
+	if sessRule := c.SelectedSessionRule(); sessRule != nil && sessRule.AuthDefQos != nil {
+		return sessRule.AuthDefQos.Arp, sessRule.AuthDefQos.Var5qi
+	}
+	return nil, 0
+}
+
+// BufferDownlinkData buffers the downlink data forwarded by the UPF, it returns false if the packet
+// is discarded since the buffer is full
+func (c *SMContext) BufferDownlinkData(packet []byte, limit int) bool {
+	if len(c.dlDataBuffer) >= limit {
+		return false
+	}
+	c.dlDataBuffer = append(c.dlDataBuffer, packet)
+	return true
+}
+
+// TakeBufferedDownlinkData returns the downlink data buffered at the SMF and empties the buffer
+func (c *SMContext) TakeBufferedDownlinkData() [][]byte {
+	packets := c.dlDataBuffer
+	c.dlDataBuffer = nil
+	return packets
+}
+
+// DlDataTEID returns the TEID of the SMF N4-u endpoint receiving the downlink data of the PDU session,
+// which is allocated on the first call
+func (c *SMContext) DlDataTEID() (uint32, error) {
+	if c.dlDataTEID == 0 {
+		teid, err := GenerateTEID()
+		if err != nil {
+			return 0, err
+		}
+		c.dlDataTEID = teid
+		dlDataTEIDSMContextMap.Store(teid, c)
+	}
+	return c.dlDataTEID, nil
+}
+
+func GetSMContextByDlDataTEID(teid uint32) *SMContext {
+	if value, ok := dlDataTEIDSMContextMap.Load(teid); ok {
+		return value.(*SMContext)
+	}
+	return nil
+}
+
+// DlDataForwardingPDR returns the PDR detecting the buffered downlink data forwarded by the SMF to the UPF,
+// the data is forwarded with the FAR of the downlink PDR (TS 29.244 5.2.3.1). The PDR is created on the first
+// call and it's kept until the SM context is removed.
+func (c *SMContext) DlDataForwardingPDR(upf *UPF, upIP net.IP, dlPDR *PDR) (*PDR, error) {
+	if c.dlDataForwardingPDR != nil && c.dlDataForwardingUPF == upf {
+		return c.dlDataForwardingPDR, nil
+	}
+	c.releaseDlDataForwardingPDR()
+
+	pdr, err := upf.AddPDR()
+	if err != nil {
+		return nil, err
+	}
+	// The PDR shares the downlink FAR
+	if err = upf.RemoveFAR(pdr.FAR); err != nil {
+		return nil, err
+	}
+	teid, err := GenerateTEID()
+	if err != nil {
+		return nil, err
+	}
+
+	pdr.Precedence = dlPDR.Precedence
+	pdr.PDI = PDI{
+		SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCpFunction},
+		LocalFTeid:      NewLocalFTEID(upIP, teid),
+	}
+	pdr.OuterHeaderRemoval = NewOuterHeaderRemoval(upIP)
+	pdr.FAR = dlPDR.FAR
+	pdr.QER = dlPDR.QER
+	pdr.State = RULE_INITIAL
+	if err = c.PutPDRtoPFCPSession(upf.NodeID, pdr); err != nil {
+		return nil, err
+	}
+
+	c.dlDataForwardingPDR = pdr
+	c.dlDataForwardingUPF = upf
+	return pdr, nil
+}
+
+// DlDataForwardingPDROf returns the PDR detecting the buffered downlink data forwarded to the UPF,
+// nil if the PDR isn't created in the UPF
+func (c *SMContext) DlDataForwardingPDROf(upf *UPF) *PDR {
+	if c.dlDataForwardingUPF != upf {
+		return nil
+	}
+	return c.dlDataForwardingPDR
+}
+
+// DlDataForwardingTunnel returns the UPF endpoint receiving the buffered downlink data forwarded by the SMF
+func (c *SMContext) DlDataForwardingTunnel() (net.IP, uint32, bool) {
+	if c.dlDataForwardingPDR == nil || c.dlDataForwardingPDR.PDI.LocalFTeid == nil {
+		return nil, 0, false
+	}
+	fteid := c.dlDataForwardingPDR.PDI.LocalFTeid
+	if fteid.V4 {
+		return fteid.Ipv4Address, fteid.Teid, true
+	}
+	return fteid.Ipv6Address, fteid.Teid, true
+}
+
+func (c *SMContext) releaseDlDataForwardingPDR() {
+	pdr, upf := c.dlDataForwardingPDR, c.dlDataForwardingUPF
+	if pdr == nil {
+		return
+	}
+	if _, exist := c.PFCPContext[upf.NodeID.ResolveNodeIdToIp().String()]; exist {
+		c.RemovePDRfromPFCPSession(upf.NodeID, pdr)
+	}
+	if err := upf.RemovePDR(pdr); err != nil {
+		c.Log.Warnf("Remove PDR of the forwarded downlink data failed: %+v", err)
+	}
+	if pdr.PDI.LocalFTeid != nil {
+		ReleaseTEID(pdr.PDI.LocalFTeid.Teid)
+	}
+	c.dlDataForwardingPDR = nil
+	c.dlDataForwardingUPF = nil
+}
+
+// releaseDlBuffering releases the resources of the downlink data buffering at the SMF
+func (c *SMContext) releaseDlBuffering() {
+	c.dlDataBuffer = nil
+	c.releaseDownlinkBARs()
+	c.releaseDlDataForwardingPDR()
+	if c.dlDataTEID != 0 {
+		dlDataTEIDSMContextMap.Delete(c.dlDataTEID)
+		ReleaseTEID(c.dlDataTEID)
+		c.dlDataTEID = 0
+	}
+}
@@ -0,0 +1,136 @@
+package context_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestSMContextDlBuffering(t *testing.T) {
+	initConfig()
+
+	smContext := smf_context.NewSMContext("imsi-208930000000702", 1)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000702",
+		PduSessionId: 1,
+	}
+
+	require.True(t, smContext.BufferDownlinkData([]byte{1}, 2))
+	require.True(t, smContext.BufferDownlinkData([]byte{2}, 2))
+	require.False(t, smContext.BufferDownlinkData([]byte{3}, 2))
+	require.Equal(t, [][]byte{{1}, {2}}, smContext.TakeBufferedDownlinkData())
+	require.Empty(t, smContext.TakeBufferedDownlinkData())
+
+	teid, err := smContext.DlDataTEID()
+	require.NoError(t, err)
+	again, err := smContext.DlDataTEID()
+	require.NoError(t, err)
+	require.Equal(t, teid, again)
+	require.Same(t, smContext, smf_context.GetSMContextByDlDataTEID(teid))
+
+	smf_context.RemoveSMContext(smContext.Ref)
+	require.Nil(t, smf_context.GetSMContextByDlDataTEID(teid))
+}
+
+func TestDownlinkBAR(t *testing.T) {
+	initConfig()
+
+	nodeID := pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.200.0.6").To4(),
+	}
+	upf := smf_context.NewUPF(&nodeID, mockIfaces)
+	upf.AssociationContext = context.Background()
+	defer smf_context.RemoveUPFNodeByNodeID(nodeID)
+
+	newSMContext := func(supi string) *smf_context.SMContext {
+		smContext := smf_context.NewSMContext(supi, 1)
+		smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+			Supi:         supi,
+			PduSessionId: 1,
+		}
+		smContext.PFCPContext[upf.GetUPFIP()] = &smf_context.PFCPSessionContext{NodeID: nodeID}
+		return smContext
+	}
+
+	// The BAR of a PFCP session is allocated by the UPF once
+	smContext1 := newSMContext("imsi-208930000000704")
+	bar1, err := smContext1.DownlinkBAR(nodeID)
+	require.NoError(t, err)
+	again, err := smContext1.DownlinkBAR(nodeID)
+	require.NoError(t, err)
+	require.Same(t, bar1, again)
+	require.Equal(t, smf_context.RULE_INITIAL, bar1.State)
+
+	smContext2 := newSMContext("imsi-208930000000705")
+	bar2, err := smContext2.DownlinkBAR(nodeID)
+	require.NoError(t, err)
+	require.NotEqual(t, bar1.BARID, bar2.BARID)
+
+	// The BAR is released with the SM context
+	smf_context.RemoveSMContext(smContext2.Ref)
+	require.Nil(t, smContext2.PFCPContext[upf.GetUPFIP()].BAR)
+	smf_context.RemoveSMContext(smContext1.Ref)
+	require.Nil(t, smContext1.PFCPContext[upf.GetUPFIP()].BAR)
+
+	// The BAR can't be allocated without the UPF
+	smContext3 := newSMContext("imsi-208930000000706")
+	defer smf_context.RemoveSMContext(smContext3.Ref)
+	unknownNodeID := pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.200.0.7").To4(),
+	}
+	smContext3.PFCPContext["10.200.0.7"] = &smf_context.PFCPSessionContext{NodeID: unknownNodeID}
+	_, err = smContext3.DownlinkBAR(unknownNodeID)
+	require.Error(t, err)
+}
+
+func TestQoSOfFlow(t *testing.T) {
+	smContext := smf_context.NewSMContext("imsi-208930000000703", 1)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000703",
+		PduSessionId: 1,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	defaultArp := &models.Arp{PriorityLevel: 8}
+	smContext.SessionRules["rule1"] = smf_context.NewSessionRule(&models.SessionRule{
+		AuthDefQos: &models.AuthorizedDefaultQos{Var5qi: 9, Arp: defaultArp},
+	})
+	smContext.SelectedSessionRuleID = "rule1"
+	flowArp := &models.Arp{PriorityLevel: 2}
+	smContext.AdditonalQosFlows[2] = smf_context.NewQoSFlow(2, &models.QosData{Var5qi: 5, Arp: flowArp})
+
+	arp, var5qi := smContext.QoSOfFlow(2)
+	require.Same(t, flowArp, arp)
+	require.Equal(t, int32(5), var5qi)
+	arp, var5qi = smContext.QoSOfFlow(0)
+	require.Same(t, defaultArp, arp)
+	require.Equal(t, int32(9), var5qi)
+}
+
+func TestDDNThrottling(t *testing.T) {
+	const amf = "http://127.0.0.18:8000"
+	low, high := &models.Arp{PriorityLevel: 10}, &models.Arp{PriorityLevel: 1}
+
+	require.False(t, smf_context.IsDDNThrottled(amf, low))
+
+	// The DDNs with the ARP priority higher than the indicated one are still sent
+	smf_context.StartDDNThrottling(amf, time.Minute, &models.Arp{PriorityLevel: 5})
+	require.True(t, smf_context.IsDDNThrottled(amf, low))
+	require.False(t, smf_context.IsDDNThrottled(amf, high))
+	require.True(t, smf_context.IsDDNThrottled(amf, nil))
+	require.False(t, smf_context.IsDDNThrottled("http://127.0.0.19:8000", low))
+
+	smf_context.StartDDNThrottling(amf, time.Millisecond, nil)
+	require.True(t, smf_context.IsDDNThrottled(amf, high))
+	require.Eventually(t, func() bool { return !smf_context.IsDDNThrottled(amf, high) },
+		time.Second, 10*time.Millisecond)
+}
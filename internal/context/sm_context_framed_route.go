@@ -0,0 +1,45 @@
+package context
+
+import (
+	"net"
+	"strings"
+)
+
+// FramedRoutes returns the subscribed framed routes of the PDU session, which are the networks
+// behind the UE (TS 23.501 5.6.14). The routes of the IP version without the UE address are ignored.
+func (c *SMContext) FramedRoutes() []*net.IPNet {
+	var routes []*net.IPNet
+	if c.PDUAddress != nil {
+		for _, route := range c.DnnConfiguration.Ipv4FrameRouteList {
+			routes = c.appendFramedRoute(routes, route.Ipv4Mask, false)
+		}
+	}
+	if c.PDUIPv6Prefix != nil {
+		for _, route := range c.DnnConfiguration.Ipv6FrameRouteList {
+			routes = c.appendFramedRoute(routes, route.Ipv6Prefix, true)
+		}
+	}
+	return routes
+}
+
+// appendFramedRoute appends the route in CIDR notation, a route without the prefix length is a host route
+func (c *SMContext) appendFramedRoute(routes []*net.IPNet, route string, ipv6 bool) []*net.IPNet {
+	if route == "" {
+		return routes
+	}
+	if !strings.Contains(route, "/") {
+		if ip := net.ParseIP(route); ip != nil {
+			if ip.To4() != nil {
+				route += "/32"
+			} else {
+				route += "/128"
+			}
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(route)
+	if err != nil || (ipNet.IP.To4() == nil) != ipv6 {
+		c.Log.Warnf("Invalid framed route[%s] is ignored", route)
+		return routes
+	}
+	return append(routes, ipNet)
+}
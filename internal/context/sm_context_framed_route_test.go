@@ -0,0 +1,70 @@
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+func TestFramedRoutes(t *testing.T) {
+	dnnConfig := models.DnnConfiguration{
+		Ipv4FrameRouteList: []models.FrameRouteInfo{
+			{Ipv4Mask: "10.1.0.0/16"},
+			{Ipv4Mask: "10.2.0.1"},
+			{Ipv4Mask: "10.3.0.0/33"},
+			{Ipv4Mask: "2001:db8:1::/48"},
+		},
+		Ipv6FrameRouteList: []models.FrameRouteInfo{
+			{Ipv6Prefix: "2001:db8:2::/48"},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		pduAddress     net.IP
+		pduIPv6Prefix  net.IP
+		expectedRoutes []string
+	}{
+		{
+			name:           "IPv4 PDU session",
+			pduAddress:     net.ParseIP("10.60.0.1").To4(),
+			expectedRoutes: []string{"10.1.0.0/16", "10.2.0.1/32"},
+		},
+		{
+			name:           "IPv6 PDU session",
+			pduIPv6Prefix:  net.ParseIP("2001:db8::"),
+			expectedRoutes: []string{"2001:db8:2::/48"},
+		},
+		{
+			name:           "IPv4v6 PDU session",
+			pduAddress:     net.ParseIP("10.60.0.1").To4(),
+			pduIPv6Prefix:  net.ParseIP("2001:db8::"),
+			expectedRoutes: []string{"10.1.0.0/16", "10.2.0.1/32", "2001:db8:2::/48"},
+		},
+		{
+			name: "Ethernet PDU session",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := &smf_context.SMContext{
+				PDUAddress:    tc.pduAddress,
+				PDUIPv6Prefix: tc.pduIPv6Prefix,
+				Log:           logger.CtxLog,
+			}
+			smContext.DnnConfiguration = dnnConfig
+
+			var routes []string
+			for _, route := range smContext.FramedRoutes() {
+				routes = append(routes, route.String())
+			}
+			require.Equal(t, tc.expectedRoutes, routes)
+		})
+	}
+}
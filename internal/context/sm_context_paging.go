@@ -0,0 +1,60 @@
+package context
+
+import (
+	"time"
+)
+
+// The UE is paged when the UPF reports the downlink data of the PDU session whose user plane is deactivated
+// (TS 23.502 4.2.3.3). The repeated Downlink Data Reports are not notified to the AMF while the UE is
+// being paged, or during the DDN back-off after the paging fails. These are protected by SMLock.
+
+// ShouldNotifyDownlinkData returns true if the AMF should be requested to page the UE
+// for the reported downlink data
+func (smContext *SMContext) ShouldNotifyDownlinkData() bool {
+	return !smContext.pagingInProgress && smContext.ddnBackoffTimer == nil
+}
+
+// StartPaging records that the AMF is paging the UE
+func (smContext *SMContext) StartPaging() {
+	smContext.pagingInProgress = true
+}
+
+// StopPaging records that the paging is completed or the user plane is activated, the DDN back-off is
+// stopped as well
+func (smContext *SMContext) StopPaging() {
+	smContext.pagingInProgress = false
+	smContext.StopDDNBackoff()
+}
+
+// StartDDNBackoff stops notifying the downlink data for the duration after the paging fails,
+// expired is called once the duration expires unless the back-off is stopped before
+func (smContext *SMContext) StartDDNBackoff(duration time.Duration, expired func()) {
+	smContext.pagingInProgress = false
+	smContext.StopDDNBackoff()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		// The back-off may be stopped or restarted while waiting for the lock
+		if smContext.ddnBackoffTimer != timer {
+			return
+		}
+		smContext.ddnBackoffTimer = nil
+		expired()
+	})
+	smContext.ddnBackoffTimer = timer
+}
+
+// StopDDNBackoff stops the DDN back-off
+func (smContext *SMContext) StopDDNBackoff() {
+	if smContext.ddnBackoffTimer != nil {
+		smContext.ddnBackoffTimer.Stop()
+		smContext.ddnBackoffTimer = nil
+	}
+}
+
+// InDDNBackoff returns true if the downlink data is not notified because of the DDN back-off
+func (smContext *SMContext) InDDNBackoff() bool {
+	return smContext.ddnBackoffTimer != nil
+}
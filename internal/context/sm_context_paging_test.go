@@ -0,0 +1,58 @@
+package context_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestSMContextPaging(t *testing.T) {
+	initConfig()
+
+	smContext := smf_context.NewSMContext("imsi-208930000000701", 1)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000701",
+		PduSessionId: 1,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	require.True(t, smContext.ShouldNotifyDownlinkData())
+
+	// The repeated Downlink Data Reports are not notified while the UE is being paged
+	smContext.StartPaging()
+	require.False(t, smContext.ShouldNotifyDownlinkData())
+	smContext.StopPaging()
+	require.True(t, smContext.ShouldNotifyDownlinkData())
+
+	// Nor during the DDN back-off after the paging fails
+	expired := make(chan struct{})
+	smContext.StartPaging()
+	smContext.SMLock.Lock()
+	smContext.StartDDNBackoff(10*time.Millisecond, func() {
+		close(expired)
+	})
+	require.True(t, smContext.InDDNBackoff())
+	require.False(t, smContext.ShouldNotifyDownlinkData())
+	smContext.SMLock.Unlock()
+
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("DDN back-off is not expired")
+	}
+	smContext.SMLock.Lock()
+	require.True(t, smContext.ShouldNotifyDownlinkData())
+	smContext.SMLock.Unlock()
+
+	// The stopped back-off doesn't expire
+	smContext.StartDDNBackoff(10*time.Millisecond, func() {
+		t.Error("Stopped DDN back-off is expired")
+	})
+	smContext.StopPaging()
+	require.True(t, smContext.ShouldNotifyDownlinkData())
+	time.Sleep(50 * time.Millisecond)
+}
@@ -0,0 +1,404 @@
+package context
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// SmfRole is the role of the SMF in the PDU session controlled by two SMFs.
+//   - Home-routed roaming (TS 23.502 4.3.2.2.2): the V-SMF relays the session management between
+//     the AMF and the H-SMF, and the V-UPF and H-UPF are connected with the N9 tunnel.
+//   - I-SMF (TS 23.502 4.23): the I-SMF controls the I-UPF in the service area of the AMF and
+//     relays the session management between the AMF and the anchor SMF, which controls the PSA.
+type SmfRole uint8
+
+const (
+	SmfRoleNone SmfRole = iota
+	SmfRoleVSmf
+	SmfRoleHSmf
+	SmfRoleISmf
+	SmfRoleAnchorSmf
+)
+
+const peerSmfSessionRuleID = "PeerSmfSessionRule"
+
+func (c *SMContext) IsVSmf() bool {
+	return c.SmfRole == SmfRoleVSmf
+}
+
+func (c *SMContext) IsHSmf() bool {
+	return c.SmfRole == SmfRoleHSmf
+}
+
+func (c *SMContext) IsISmf() bool {
+	return c.SmfRole == SmfRoleISmf
+}
+
+// IsAnchorSmf reports whether the SMF is the anchor SMF of the PDU session with an I-SMF inserted
+func (c *SMContext) IsAnchorSmf() bool {
+	return c.SmfRole == SmfRoleAnchorSmf
+}
+
+// ServedByPeerSmf reports whether the session management of the PDU session is performed by the peer SMF,
+// i.e. the SMF is the V-SMF or the I-SMF
+func (c *SMContext) ServedByPeerSmf() bool {
+	return c.IsVSmf() || c.IsISmf()
+}
+
+// ServesPeerSmf reports whether the SMF performs the session management of the PDU session for the peer SMF,
+// i.e. the SMF is the H-SMF or the anchor SMF
+func (c *SMContext) ServesPeerSmf() bool {
+	return c.IsHSmf() || c.IsAnchorSmf()
+}
+
+// AccessInterfaceType returns the interface of the AN UPF toward the access side of the PDU session,
+// the H-UPF and the PSA are reached from the V-UPF and the I-UPF over N9
+func (c *SMContext) AccessInterfaceType() models.UpInterfaceType {
+	if c.ServesPeerSmf() {
+		return models.UpInterfaceType_N9
+	}
+	return models.UpInterfaceType_N3
+}
+
+// PeerSmfUri returns the API root of the Nsmf_PDUSession service of the H-SMF or the anchor SMF
+// provided by the AMF to the V-SMF or the I-SMF
+func (c *SMContext) PeerSmfUri() string {
+	if c.IsISmf() {
+		return c.SmfUri
+	}
+	return c.HSmfUri
+}
+
+// SwitchAccessTunnel moves the UL tunnel endpoint of the AN UPF to the access interface of the SMF role,
+// which is N9 after the I-SMF insertion and N3 after the I-SMF removal (TS 23.502 4.23.4).
+// The UL PDRs to be updated in the AN UPF are returned.
+func (c *SMContext) SwitchAccessTunnel() ([]*PDR, error) {
+	var pdrList []*PDR
+	for _, dataPath := range c.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		anUPF := dataPath.FirstDPNode
+		if anUPF.UpLinkTunnel == nil || anUPF.UpLinkTunnel.PDR == nil {
+			continue
+		}
+		iface := anUPF.UPF.GetInterface(c.AccessInterfaceType(), c.Dnn)
+		if iface == nil {
+			return nil, fmt.Errorf("no %s interface of UPF for DNN[%s]", c.AccessInterfaceType(), c.Dnn)
+		}
+		upIP, err := iface.IP(c.SelectedPDUSessionType)
+		if err != nil {
+			return nil, err
+		}
+		pdr := anUPF.UpLinkTunnel.PDR
+		pdr.PDI.LocalFTeid = NewLocalFTEID(upIP, c.LocalULTeid)
+		pdr.OuterHeaderRemoval = NewOuterHeaderRemoval(upIP)
+		pdr.State = RULE_UPDATE
+		pdrList = append(pdrList, pdr)
+	}
+	// The F-TEID allocated by the UPF is on the previous access interface
+	c.chosenN3FTEID = nil
+	c.chosenN3FTEIDUPF = nil
+	return pdrList, nil
+}
+
+// PduSessionRef returns the reference of the PDU session resource of the SMF
+func (c *SMContext) PduSessionRef() string {
+	return strings.TrimPrefix(c.Ref, "urn:uuid:")
+}
+
+// PduSessionUri returns the URI of the PDU session resource of the SMF
+func (c *SMContext) PduSessionUri() string {
+	return fmt.Sprintf("%s://%s:%d%s/pdu-sessions/%s",
+		GetSelf().URIScheme, GetSelf().RegisterIPv4, GetSelf().SBIPort,
+		factory.SmfPdusessionResUriPrefix, c.PduSessionRef())
+}
+
+// GetSMContextByPduSessionRef returns the SM context of the PDU session resource
+func GetSMContextByPduSessionRef(pduSessionRef string) *SMContext {
+	return GetSMContextByRef("urn:uuid:" + strings.TrimPrefix(pduSessionRef, "urn:uuid:"))
+}
+
+// BuildPduSessionCreateData returns the PDU session to be created in the H-SMF or the anchor SMF by
+// the V-SMF or the I-SMF, the H-UPF or the PSA sends the DL traffic to the V-UPF or the I-UPF with tunnelInfo
+func (c *SMContext) BuildPduSessionCreateData(tunnelInfo *models.TunnelInfo) *models.PduSessionCreateData {
+	createData := &models.PduSessionCreateData{
+		Supi:                c.Supi,
+		UnauthenticatedSupi: c.SmfPduSessionSmContextCreateData.UnauthenticatedSupi,
+		Pei:                 c.SmfPduSessionSmContextCreateData.Pei,
+		Gpsi:                c.Gpsi,
+		PduSessionId:        c.PDUSessionID,
+		Dnn:                 c.Dnn,
+		SelectedDnn:         c.SelectedDnn,
+		SNssai:              c.SNssai,
+		ServingNetwork:      c.ServingNetwork,
+		RequestType:         c.RequestType,
+		AnType:              c.AnType,
+		RatType:             c.RatType,
+		UeLocation:          c.UeLocation,
+		UeTimeZone:          c.UeTimeZone,
+		N1SmInfoFromUe:      &models.RefToBinaryData{ContentId: "n1SmInfoFromUe"},
+		SelMode:             c.SelMode,
+		AmfNfId:             c.ServingNfId,
+		Guami:               c.Guami,
+	}
+	if c.IsISmf() {
+		createData.IsmfId = GetSelf().NfInstanceID
+		createData.IsmfPduSessionUri = c.PduSessionUri()
+		createData.IcnTunnelInfo = tunnelInfo
+	} else {
+		createData.HplmnSnssai = c.HplmnSnssai
+		createData.VsmfId = GetSelf().NfInstanceID
+		createData.VsmfPduSessionUri = c.PduSessionUri()
+		createData.VcnTunnelInfo = tunnelInfo
+	}
+	return createData
+}
+
+// NewPeerSMContextCreateData returns the SM context data of the PDU session created by the peer SMF
+func NewPeerSMContextCreateData(data *models.PduSessionCreateData) *models.SmfPduSessionSmContextCreateData {
+	createData := &models.SmfPduSessionSmContextCreateData{
+		Supi:                data.Supi,
+		UnauthenticatedSupi: data.UnauthenticatedSupi,
+		Pei:                 data.Pei,
+		Gpsi:                data.Gpsi,
+		PduSessionId:        data.PduSessionId,
+		Dnn:                 data.Dnn,
+		SelectedDnn:         data.SelectedDnn,
+		SNssai:              data.SNssai,
+		HplmnSnssai:         data.HplmnSnssai,
+		ServingNfId:         data.AmfNfId,
+		Guami:               data.Guami,
+		ServingNetwork:      data.ServingNetwork,
+		RequestType:         data.RequestType,
+		AnType:              data.AnType,
+		RatType:             data.RatType,
+		UeLocation:          data.UeLocation,
+		UeTimeZone:          data.UeTimeZone,
+		PcfId:               data.HPcfId,
+		SelMode:             data.SelMode,
+	}
+	// The subscription and the policies of the H-PLMN apply to the S-NSSAI of the H-PLMN
+	if data.HplmnSnssai != nil {
+		createData.SNssai = data.HplmnSnssai
+	}
+	return createData
+}
+
+// BuildPduSessionCreatedData returns the PDU session created in the H-SMF or the anchor SMF for the peer SMF
+func (c *SMContext) BuildPduSessionCreatedData() (*models.PduSessionCreatedData, error) {
+	sessRule := c.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil || sessRule.AuthDefQos == nil {
+		return nil, fmt.Errorf("no authorized session rule")
+	}
+
+	qosFlows, err := c.buildQosFlowSetupItems(sessRule)
+	if err != nil {
+		return nil, err
+	}
+
+	ulTunnelInfo, err := c.buildULTunnelInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	createdData := &models.PduSessionCreatedData{
+		PduSessionType:    c.pduSessionType(),
+		SscMode:           string(models.SscMode__1),
+		SessionAmbr:       sessRule.AuthSessAmbr,
+		QosFlowsSetupList: qosFlows,
+		PduSessionId:      c.PDUSessionID,
+		SNssai:            c.SNssai,
+		UeIpv6Prefix:      c.IPv6PrefixString(),
+		N1SmInfoToUe:      &models.RefToBinaryData{ContentId: "n1SmInfoToUe"},
+		UpSecurity:        c.UpSecurity,
+	}
+	if c.IsAnchorSmf() {
+		createdData.CnTunnelInfo = ulTunnelInfo
+		createdData.SmfInstanceId = GetSelf().NfInstanceID
+	} else {
+		createdData.HcnTunnelInfo = ulTunnelInfo
+		createdData.HSmfInstanceId = GetSelf().NfInstanceID
+	}
+	if ip := c.PDUAddress.To4(); ip != nil {
+		createdData.UeIpv4Address = ip.String()
+	}
+	return createdData, nil
+}
+
+// buildULTunnelInfo returns the UL tunnel endpoint of the AN UPF of the PDU session,
+// which is the local F-TEID of the UL PDR installed in the AN UPF
+func (c *SMContext) buildULTunnelInfo() (*models.TunnelInfo, error) {
+	defaultPath := c.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || defaultPath.FirstDPNode.UpLinkTunnel == nil ||
+		defaultPath.FirstDPNode.UpLinkTunnel.PDR == nil {
+		return nil, fmt.Errorf("no default data path")
+	}
+	fteid := defaultPath.FirstDPNode.UpLinkTunnel.PDR.PDI.LocalFTeid
+	if fteid == nil || fteid.Ch {
+		return nil, fmt.Errorf("no UL F-TEID of AN UPF")
+	}
+	if fteid.V4 {
+		return NewTunnelInfo(fteid.Ipv4Address, fteid.Teid), nil
+	}
+	return NewTunnelInfo(fteid.Ipv6Address, fteid.Teid), nil
+}
+
+// BuildHsmfUpdatedData returns the session AMBR and the QoS flows of the PDU session authorized
+// in the PDU session modification for the peer SMF
+func (c *SMContext) BuildHsmfUpdatedData() *models.HsmfUpdatedData {
+	updatedData := &models.HsmfUpdatedData{
+		Pti: int32(c.Pti),
+	}
+	if sessRule := c.SelectedSessionRule(); sessRule != nil && sessRule.AuthDefQos != nil {
+		updatedData.SessionAmbr = sessRule.AuthSessAmbr
+		if qosFlows, err := c.buildQosFlowSetupItems(sessRule); err != nil {
+			c.Log.Warnf("Build QoS flows of the PDU session failed: %v", err)
+		} else {
+			updatedData.QosFlowsSetupList = qosFlows
+		}
+	}
+	return updatedData
+}
+
+// BuildDLN9TunnelInfo returns the DL N9 tunnel endpoint of the V-UPF or the I-UPF selected for the PDU session
+func (c *SMContext) BuildDLN9TunnelInfo() (*models.TunnelInfo, error) {
+	if c.SelectedUPF == nil {
+		return nil, fmt.Errorf("no UPF selected")
+	}
+	iface := c.SelectedUPF.UPF.GetInterface(models.UpInterfaceType_N9, c.Dnn)
+	if iface == nil {
+		return nil, fmt.Errorf("no N9 interface of UPF[%s] for DNN[%s]", c.SelectedUPF.Name, c.Dnn)
+	}
+	ip, err := iface.IP(c.SelectedPDUSessionType)
+	if err != nil {
+		return nil, err
+	}
+	return NewTunnelInfo(ip, c.LocalDLTeid), nil
+}
+
+// ApplyPduSessionCreatedData applies the PDU session created in the H-SMF or the anchor SMF to the V-SMF
+// or the I-SMF. The authorized session AMBR and default QoS are installed as the session rule.
+func (c *SMContext) ApplyPduSessionCreatedData(data *models.PduSessionCreatedData) error {
+	cnTunnelInfo := data.HcnTunnelInfo
+	if c.IsISmf() {
+		cnTunnelInfo = data.CnTunnelInfo
+	}
+	cnIP, cnTEID, err := ParseTunnelInfo(cnTunnelInfo)
+	if err != nil {
+		return fmt.Errorf("invalid CN tunnel info: %v", err)
+	}
+	c.AnchorULTunnel.IPAddress = cnIP
+	c.AnchorULTunnel.TEID = cnTEID
+
+	switch data.PduSessionType {
+	case models.PduSessionType_IPV4:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	case models.PduSessionType_IPV6:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv6
+	case models.PduSessionType_IPV4_V6:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4IPv6
+	case models.PduSessionType_UNSTRUCTURED:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeUnstructured
+	case models.PduSessionType_ETHERNET:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+	}
+
+	if data.UeIpv4Address != "" {
+		c.PDUAddress = net.ParseIP(data.UeIpv4Address).To4()
+	}
+	if data.UeIpv6Prefix != "" {
+		if _, ipv6Prefix, errParse := net.ParseCIDR(data.UeIpv6Prefix); errParse != nil {
+			c.Log.Warnf("Invalid UE IPv6 prefix[%s]: %v", data.UeIpv6Prefix, errParse)
+		} else {
+			c.PDUIPv6Prefix = ipv6Prefix.IP
+		}
+	}
+	if data.UpSecurity != nil {
+		c.UpSecurity = data.UpSecurity
+	}
+
+	var defQosFlow *models.QosFlowSetupItem
+	for i := range data.QosFlowsSetupList {
+		qosFlow := &data.QosFlowsSetupList[i]
+		if qosFlow.DefaultQosRuleInd || defQosFlow == nil {
+			defQosFlow = qosFlow
+		}
+	}
+	if data.SessionAmbr == nil || defQosFlow == nil || defQosFlow.QosFlowProfile == nil {
+		return fmt.Errorf("no session AMBR or default QoS flow")
+	}
+
+	sessRule := NewSessionRule(&models.SessionRule{
+		SessRuleId:   peerSmfSessionRuleID,
+		AuthSessAmbr: data.SessionAmbr,
+		AuthDefQos: &models.AuthorizedDefaultQos{
+			Var5qi: defQosFlow.QosFlowProfile.Var5qi,
+			Arp:    defQosFlow.QosFlowProfile.Arp,
+		},
+	})
+	sessRule.DefQosQFI = uint8(defQosFlow.Qfi)
+	c.SessionRules[peerSmfSessionRuleID] = sessRule
+	c.SelectedSessionRuleID = peerSmfSessionRuleID
+	return nil
+}
+
+// ApplyHsmfUpdatedData applies the session AMBR and the default QoS authorized by the H-SMF or the anchor SMF
+// in the PDU session modification to the session rule of the V-SMF or the I-SMF
+func (c *SMContext) ApplyHsmfUpdatedData(data *models.HsmfUpdatedData) {
+	sessRule := c.SelectedSessionRule()
+	if sessRule == nil {
+		return
+	}
+	if data.SessionAmbr != nil {
+		sessRule.AuthSessAmbr = data.SessionAmbr
+	}
+	for _, qosFlow := range data.QosFlowsSetupList {
+		if uint8(qosFlow.Qfi) == sessRule.DefQosQFI && qosFlow.QosFlowProfile != nil {
+			sessRule.AuthDefQos = &models.AuthorizedDefaultQos{
+				Var5qi: qosFlow.QosFlowProfile.Var5qi,
+				Arp:    qosFlow.QosFlowProfile.Arp,
+			}
+		}
+	}
+}
+
+// NewTunnelInfo returns the tunnel information of the GTP-U tunnel endpoint (TS 29.502 6.1.6.2.16)
+func NewTunnelInfo(ip net.IP, teid uint32) *models.TunnelInfo {
+	tunnelInfo := &models.TunnelInfo{
+		GtpTeid: fmt.Sprintf("%08X", teid),
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		tunnelInfo.Ipv4Addr = ipv4.String()
+	} else {
+		tunnelInfo.Ipv6Addr = ip.String()
+	}
+	return tunnelInfo
+}
+
+// ParseTunnelInfo returns the address and TEID of the GTP-U tunnel endpoint
+func ParseTunnelInfo(tunnelInfo *models.TunnelInfo) (net.IP, uint32, error) {
+	if tunnelInfo == nil {
+		return nil, 0, fmt.Errorf("tunnel info is nil")
+	}
+	teid, err := strconv.ParseUint(tunnelInfo.GtpTeid, 16, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid GTP TEID[%s]: %v", tunnelInfo.GtpTeid, err)
+	}
+	var ip net.IP
+	if tunnelInfo.Ipv4Addr != "" {
+		ip = net.ParseIP(tunnelInfo.Ipv4Addr).To4()
+	} else {
+		ip = net.ParseIP(tunnelInfo.Ipv6Addr)
+	}
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid tunnel address")
+	}
+	return ip, uint32(teid), nil
+}
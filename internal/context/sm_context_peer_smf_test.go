@@ -0,0 +1,115 @@
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestTunnelInfo(t *testing.T) {
+	tunnelInfo := smf_context.NewTunnelInfo(net.ParseIP("10.200.200.102"), 0x1234)
+	require.Equal(t, "10.200.200.102", tunnelInfo.Ipv4Addr)
+	require.Equal(t, "00001234", tunnelInfo.GtpTeid)
+
+	ip, teid, err := smf_context.ParseTunnelInfo(tunnelInfo)
+	require.NoError(t, err)
+	require.True(t, ip.Equal(net.ParseIP("10.200.200.102")))
+	require.Equal(t, uint32(0x1234), teid)
+
+	_, _, err = smf_context.ParseTunnelInfo(&models.TunnelInfo{GtpTeid: "00001234"})
+	require.Error(t, err)
+	_, _, err = smf_context.ParseTunnelInfo(&models.TunnelInfo{Ipv4Addr: "10.200.200.102", GtpTeid: "teid"})
+	require.Error(t, err)
+}
+
+func TestApplyPduSessionCreatedData(t *testing.T) {
+	initConfig()
+
+	smContext := smf_context.NewSMContext("imsi-208930000000601", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000601",
+		PduSessionId: 10,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+	smContext.SmfRole = smf_context.SmfRoleVSmf
+
+	require.Equal(t, smContext, smf_context.GetSMContextByPduSessionRef(smContext.PduSessionRef()))
+
+	createdData := &models.PduSessionCreatedData{
+		PduSessionType: models.PduSessionType_IPV4,
+		HcnTunnelInfo:  smf_context.NewTunnelInfo(net.ParseIP("10.200.200.101"), 1),
+		SessionAmbr:    &models.Ambr{Uplink: "1 Gbps", Downlink: "2 Gbps"},
+		QosFlowsSetupList: []models.QosFlowSetupItem{
+			{
+				Qfi:               1,
+				DefaultQosRuleInd: true,
+				QosFlowProfile: &models.SmfPduSessionQosFlowProfile{
+					Var5qi: 9,
+					Arp:    &models.Arp{PriorityLevel: 8},
+				},
+			},
+		},
+		UeIpv4Address: "10.60.0.1",
+	}
+	require.NoError(t, smContext.ApplyPduSessionCreatedData(createdData))
+	require.True(t, smContext.AnchorULTunnel.IPAddress.Equal(net.ParseIP("10.200.200.101")))
+	require.Equal(t, "10.60.0.1", smContext.PDUAddress.String())
+
+	sessRule := smContext.SelectedSessionRule()
+	require.NotNil(t, sessRule)
+	require.Equal(t, "2 Gbps", sessRule.AuthSessAmbr.Downlink)
+	require.Equal(t, int32(9), sessRule.AuthDefQos.Var5qi)
+	require.Equal(t, uint8(1), sessRule.DefQosQFI)
+
+	// The H-SMF modified the session AMBR
+	smContext.ApplyHsmfUpdatedData(&models.HsmfUpdatedData{
+		SessionAmbr: &models.Ambr{Uplink: "1 Gbps", Downlink: "1 Gbps"},
+	})
+	require.Equal(t, "1 Gbps", smContext.SelectedSessionRule().AuthSessAmbr.Downlink)
+
+	createdData.QosFlowsSetupList = nil
+	require.Error(t, smContext.ApplyPduSessionCreatedData(createdData))
+}
+
+func TestBuildPduSessionCreateData_ISmf(t *testing.T) {
+	initConfig()
+
+	smContext := smf_context.NewSMContext("imsi-208930000000602", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000602",
+		PduSessionId: 10,
+		HSmfUri:      "https://h-smf.example.com/nsmf-pdusession/v1",
+		SmfUri:       "https://smf.example.com/nsmf-pdusession/v1",
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	tunnelInfo := smf_context.NewTunnelInfo(net.ParseIP("10.200.200.102"), 1)
+
+	smContext.SmfRole = smf_context.SmfRoleVSmf
+	require.True(t, smContext.ServedByPeerSmf())
+	require.Equal(t, "https://h-smf.example.com/nsmf-pdusession/v1", smContext.PeerSmfUri())
+	createData := smContext.BuildPduSessionCreateData(tunnelInfo)
+	require.Equal(t, tunnelInfo, createData.VcnTunnelInfo)
+	require.Nil(t, createData.IcnTunnelInfo)
+	require.Equal(t, smContext.PduSessionUri(), createData.VsmfPduSessionUri)
+
+	smContext.SmfRole = smf_context.SmfRoleISmf
+	require.True(t, smContext.ServedByPeerSmf())
+	require.Equal(t, "https://smf.example.com/nsmf-pdusession/v1", smContext.PeerSmfUri())
+	createData = smContext.BuildPduSessionCreateData(tunnelInfo)
+	require.Equal(t, tunnelInfo, createData.IcnTunnelInfo)
+	require.Nil(t, createData.VcnTunnelInfo)
+	require.Equal(t, smContext.PduSessionUri(), createData.IsmfPduSessionUri)
+	require.Empty(t, createData.VsmfPduSessionUri)
+
+	// The anchor SMF reaches the PSA from the I-UPF over N9
+	smContext.SmfRole = smf_context.SmfRoleAnchorSmf
+	require.True(t, smContext.ServesPeerSmf())
+	require.Equal(t, models.UpInterfaceType_N9, smContext.AccessInterfaceType())
+	smContext.SmfRole = smf_context.SmfRoleNone
+	require.Equal(t, models.UpInterfaceType_N3, smContext.AccessInterfaceType())
+}
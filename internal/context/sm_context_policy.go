@@ -6,7 +6,6 @@ import (
 
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/smf/internal/logger"
-	"github.com/free5gc/smf/pkg/factory"
 )
 
 // SM Policy related operation
@@ -26,6 +25,11 @@ func (c *SMContext) ApplySessionRules(
 		return fmt.Errorf("SmPolicyDecision is nil")
 	}
 
+	var origSessAmbr *models.Ambr
+	if origRule := c.SelectedSessionRule(); origRule != nil {
+		origSessAmbr = origRule.AuthSessAmbr
+	}
+
 	for id, r := range decision.SessRules {
 		if r == nil {
 			c.Log.Debugf("Delete SessionRule[%s]", id)
@@ -54,10 +58,38 @@ func (c *SMContext) ApplySessionRules(
 		}
 	}
 
-	// TODO: need update default data path if SessionRule changed
+	// The session AMBR QERs are modified in the UPFs with the next PFCP Session Modification,
+	// and the session AMBR is signalled to the UE and the AN (TS 23.502 4.3.3.2)
+	sessAmbr := c.SelectedSessionRule().AuthSessAmbr
+	if origSessAmbr != nil && sessAmbr != nil && !reflect.DeepEqual(origSessAmbr, sessAmbr) {
+		c.Log.Infof("Session AMBR changed to UL[%s] DL[%s]", sessAmbr.Uplink, sessAmbr.Downlink)
+		if err := c.UpdateSessionAMBRQERs(); err != nil {
+			return fmt.Errorf("ApplySessionRules: %w", err)
+		}
+		c.sessionAMBRModified = true
+		c.sessionAMBRModifiedForAN = true
+	}
 	return nil
 }
 
+// SessionAMBRModified returns true if the session AMBR is changed by the PCF and not yet
+// signalled to the UE
+func (c *SMContext) SessionAMBRModified() bool {
+	return c.sessionAMBRModified
+}
+
+// SessionAMBRSignalledToUE is called once the PDU Session Modification Command, which carries
+// the session AMBR, is sent to the UE
+func (c *SMContext) SessionAMBRSignalledToUE() {
+	c.sessionAMBRModified = false
+}
+
+// SessionAMBRModifiedForAN returns true if the session AMBR is changed by the PCF and not yet
+// acknowledged by the AN, e.g. the user plane is deactivated when the session AMBR is changed
+func (c *SMContext) SessionAMBRModifiedForAN() bool {
+	return c.sessionAMBRModifiedForAN
+}
+
 func (c *SMContext) AddQosFlow(qfi uint8, qos *models.QosData) {
 	qosFlow := NewQoSFlow(qfi, qos)
 	if qosFlow != nil {
@@ -319,6 +351,80 @@ func (c *SMContext) PostRemoveDataPath() {
 	}
 }
 
+// RerouteDataPaths replaces the activated data paths which go through a failed user plane path
+// with data paths avoiding the failure. The AN tunnel can't be moved without N2 signalling,
+// so it fails if the N3 path is affected or no other path starts from the same UPF.
+// The replaced data paths are to be removed by PostRemoveDataPath after the PFCP sessions are updated.
+func (c *SMContext) RerouteDataPaths() error {
+	anIP := c.Tunnel.ANInformation.IPAddress
+	defaultPath := c.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil {
+		return fmt.Errorf("no default data path")
+	}
+	anUPF := defaultPath.FirstDPNode.UPF
+	if anUPF.IsPathFailed(anIP) {
+		return fmt.Errorf("N3 path between UPF[%s] and AN[%s] failed", anUPF.GetUPFIP(), anIP)
+	}
+
+	var reroutedPaths []*DataPath
+	pccDataPaths := make(map[*DataPath]bool)
+	for id, pcc := range c.PCCRules {
+		if pcc.Datapath == nil {
+			continue
+		}
+		pccDataPaths[pcc.Datapath] = true
+		if !pcc.Datapath.Activated || !pcc.Datapath.IsPathFailed(anIP) {
+			continue
+		}
+
+		c.Log.Infof("Reroute data path of PCCRule[%s]", id)
+		srcDataPath := pcc.Datapath
+		srcDataPath.IsDefaultPath = false
+		if err := c.CreatePccRuleDataPath(pcc, c.TrafficControlDatas[pcc.RefTcDataID()],
+			c.QosDatas[pcc.RefQosDataID()], c.ChargingData[pcc.RefChgDataID()]); err != nil {
+			return err
+		}
+		if err := applyFlowInfoOrPFD(pcc); err != nil {
+			return err
+		}
+		c.PreRemoveDataPath(srcDataPath)
+		reroutedPaths = append(reroutedPaths, pcc.Datapath)
+	}
+	if len(reroutedPaths) != 0 {
+		c.addPduLevelChargingRuleToFlow(c.PCCRules)
+	}
+
+	// The default data path not created for any PCC rule
+	if defaultPath.Activated && !pccDataPaths[defaultPath] && defaultPath.IsPathFailed(anIP) {
+		c.Log.Infof("Reroute default data path")
+		upi := GetUserPlaneInformation()
+		source := upi.GetUPFNodeByIP(anUPF.GetUPFIP())
+		if source == nil {
+			return fmt.Errorf("UPF[%s] not found in user plane topology", anUPF.GetUPFIP())
+		}
+		dataPath := GenerateDataPath(upi.GetAlternativeUserPlanePath(c.SelectionParam, source, c.SelectedUPF))
+		if dataPath == nil {
+			return fmt.Errorf("no alternative user plane path from UPF[%s]", anUPF.GetUPFIP())
+		}
+		defaultPath.IsDefaultPath = false
+		dataPath.IsDefaultPath = true
+		c.Tunnel.AddDataPath(dataPath)
+		dataPath.ActivateTunnelAndPDR(c, DefaultPrecedence)
+		c.PreRemoveDataPath(defaultPath)
+		reroutedPaths = append(reroutedPaths, dataPath)
+	}
+
+	for _, dataPath := range reroutedPaths {
+		if !dataPath.Activated || dataPath.IsPathFailed(anIP) {
+			return fmt.Errorf("no alternative user plane path available")
+		}
+		if dataPath.FirstDPNode.UPF != anUPF {
+			return fmt.Errorf("alternative user plane path does not start from UPF[%s]", anUPF.GetUPFIP())
+		}
+	}
+	return nil
+}
+
 func applyFlowInfoOrPFD(pcc *PCCRule) error {
 	appID := pcc.AppId
 
@@ -326,6 +432,11 @@ func applyFlowInfoOrPFD(pcc *PCCRule) error {
 		return fmt.Errorf("No FlowInfo and AppID")
 	}
 
+	// Apply Ethernet flow description if it presents
+	if ethFlowDesc := pcc.EthFlowDescription(); ethFlowDesc != nil {
+		return pcc.UpdateDataPathEthernetFlowDescription(ethFlowDesc)
+	}
+
 	// Apply flow description if it presents
 	if flowDesc := pcc.FlowDescription(); flowDesc != "" {
 		if err := pcc.UpdateDataPathFlowDescription(flowDesc); err != nil {
@@ -336,27 +447,17 @@ func applyFlowInfoOrPFD(pcc *PCCRule) error {
 	logger.CfgLog.Tracef("applyFlowInfoOrPFD %+v", pcc.FlowDescription())
 
 	// Find PFD with AppID if no flow description presents
-	// TODO: Get PFD from NEF (not from config)
-	var matchedPFD *factory.PfdDataForApp
-	for _, pfdDataForApp := range factory.UERoutingConfig.PfdDatas {
-		if pfdDataForApp.AppID == appID {
-			matchedPFD = pfdDataForApp
-			break
-		}
-	}
-
-	// Workaround: UPF doesn't support PFD. Get FlowDescription from PFD.
-	// TODO: Send PFD to UPF
-	if matchedPFD == nil ||
-		len(matchedPFD.Pfds) == 0 ||
-		len(matchedPFD.Pfds[0].FlowDescriptions) == 0 {
+	matchedPFD := GetPfdDataForApp(appID)
+	if matchedPFD == nil || len(matchedPFD.Pfds) == 0 {
 		return fmt.Errorf("No PFD matched for AppID [%s]", appID)
 	}
-	if err := pcc.UpdateDataPathFlowDescription(
-		matchedPFD.Pfds[0].FlowDescriptions[0]); err != nil {
-		return err
+
+	// Workaround for the UPFs not supporting PFD management: get FlowDescription from PFD.
+	var flowDesc string
+	if len(matchedPFD.Pfds[0].FlowDescriptions) != 0 {
+		flowDesc = matchedPFD.Pfds[0].FlowDescriptions[0]
 	}
-	return nil
+	return pcc.UpdateDataPathApplicationID(appID, flowDesc)
 }
 
 func checkUpPathChangeEvt(c *SMContext,
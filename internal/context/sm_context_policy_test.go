@@ -6,6 +6,8 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/free5gc/aper"
+	"github.com/free5gc/ngap/ngapType"
 	"github.com/free5gc/openapi/models"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/pkg/factory"
@@ -342,6 +344,66 @@ func TestApplySessionRules(t *testing.T) {
 	}
 }
 
+func TestApplySessionRulesSessionAMBRModified(t *testing.T) {
+	initConfig()
+
+	sessRule := func(ambr string) *models.SmPolicyDecision {
+		return &models.SmPolicyDecision{
+			SessRules: map[string]*models.SessionRule{
+				"SessRuleId-1": {
+					SessRuleId: "SessRuleId-1",
+					AuthSessAmbr: &models.Ambr{
+						Uplink:   ambr,
+						Downlink: ambr,
+					},
+					AuthDefQos: &models.AuthorizedDefaultQos{
+						Var5qi: 9,
+						Arp: &models.Arp{
+							PriorityLevel: 8,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	smctx := smf_context.NewSMContext("imsi-208930000000002", 10)
+
+	require.NoError(t, smctx.ApplySessionRules(sessRule("1000 Kbps")))
+	require.False(t, smctx.SessionAMBRModified())
+
+	require.NoError(t, smctx.ApplySessionRules(sessRule("1000 Kbps")))
+	require.False(t, smctx.SessionAMBRModified())
+
+	require.NoError(t, smctx.ApplySessionRules(sessRule("2000 Kbps")))
+	require.True(t, smctx.SessionAMBRModified())
+
+	buf, err := smf_context.BuildPDUSessionResourceModifyRequestTransfer(smctx)
+	require.NoError(t, err)
+	transfer := ngapType.PDUSessionResourceModifyRequestTransfer{}
+	require.NoError(t, aper.UnmarshalWithParams(buf, &transfer, "valueExt"))
+	require.Len(t, transfer.ProtocolIEs.List, 1)
+	ie := transfer.ProtocolIEs.List[0]
+	require.Equal(t, int64(ngapType.ProtocolIEIDPDUSessionAggregateMaximumBitRate), ie.Id.Value)
+	require.Equal(t, int64(2000000), ie.Value.PDUSessionAggregateMaximumBitRate.PDUSessionAggregateMaximumBitRateUL.Value)
+
+	// The session AMBR is still signalled to the AN after the command is sent to the UE
+	smctx.SessionAMBRSignalledToUE()
+	require.False(t, smctx.SessionAMBRModified())
+	require.True(t, smctx.SessionAMBRModifiedForAN())
+	buf, err = smf_context.BuildPDUSessionResourceModifyRequestTransfer(smctx)
+	require.NoError(t, err)
+	transfer = ngapType.PDUSessionResourceModifyRequestTransfer{}
+	require.NoError(t, aper.UnmarshalWithParams(buf, &transfer, "valueExt"))
+	require.Len(t, transfer.ProtocolIEs.List, 1)
+
+	// The session AMBR is acknowledged by the AN
+	rspBuf, err := aper.MarshalWithParams(ngapType.PDUSessionResourceModifyResponseTransfer{}, "valueExt")
+	require.NoError(t, err)
+	require.NoError(t, smf_context.HandlePDUSessionResourceModifyResponseTransfer(rspBuf, smctx))
+	require.False(t, smctx.SessionAMBRModifiedForAN())
+}
+
 func TestApplyPccRules(t *testing.T) {
 	testCases := []struct {
 		name             string
@@ -627,6 +689,7 @@ func TestApplyPccRules(t *testing.T) {
 	}
 
 	smctx := smf_context.NewSMContext("imsi-208930000000002", 10)
+	defer smf_context.RemoveSMContext(smctx.Ref)
 
 	smctx.SMLock.Lock()
 	defer smctx.SMLock.Unlock()
@@ -0,0 +1,245 @@
+package context
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/free5gc/nas/nasType"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/util"
+)
+
+// BuildSmContext returns the complete SM context transferred with the Nsmf_PDUSession Retrieve
+// service operation (TS 29.502 5.2.2.5), e.g. for the AMF relocation
+func (c *SMContext) BuildSmContext() (*models.SmContext, error) {
+	sessRule := c.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil || sessRule.AuthDefQos == nil {
+		return nil, fmt.Errorf("no authorized session rule")
+	}
+
+	qosFlows, err := c.buildQosFlowSetupItems(sessRule)
+	if err != nil {
+		return nil, err
+	}
+
+	smContext := &models.SmContext{
+		PduSessionId:                    c.PDUSessionID,
+		Dnn:                             c.Dnn,
+		SNssai:                          c.SNssai,
+		HplmnSnssai:                     c.HplmnSnssai,
+		PduSessionType:                  c.pduSessionType(),
+		Gpsi:                            c.Gpsi,
+		PcfId:                           c.SelectedPCFProfile.NfInstanceId,
+		SelMode:                         c.SelMode,
+		SessionAmbr:                     sessRule.AuthSessAmbr,
+		QosFlowsList:                    qosFlows,
+		SmfInstanceId:                   GetSelf().NfInstanceID,
+		UeIpv6Prefix:                    c.IPv6PrefixString(),
+		MaxIntegrityProtectedDataRate:   c.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink,
+		MaxIntegrityProtectedDataRateDl: c.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForDownLink,
+		UpSecurity:                      c.UpSecurity,
+		ChargingId:                      strconv.Itoa(int(c.ChargingID)),
+	}
+	if ip := c.PDUAddress.To4(); ip != nil {
+		smContext.UeIpv4Address = ip.String()
+	}
+	return smContext, nil
+}
+
+// buildQosFlowSetupItems returns the QoS flows of the PDU session. The default QoS flow carries
+// the default QoS rule.
+// NOTE: The QoS rules of the additional QoS flows are not rebuilt since it would allocate
+// new packet filter identifiers.
+func (c *SMContext) buildQosFlowSetupItems(sessRule *SessionRule) ([]models.QosFlowSetupItem, error) {
+	defQosRule := nasType.QoSRules{
+		{
+			Identifier: c.defRuleID,
+			DQR:        true,
+			Operation:  nasType.OperationCodeCreateNewQoSRule,
+			Precedence: 255,
+			QFI:        sessRule.DefQosQFI,
+			PacketFilterList: nasType.PacketFilterList{
+				{
+					Identifier: 1,
+					Direction:  nasType.PacketFilterDirectionBidirectional,
+					Components: nasType.PacketFilterComponentList{
+						&nasType.PacketFilterMatchAll{},
+					},
+				},
+			},
+		},
+	}
+	defQosRuleBytes, err := defQosRule.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	authDefQos := sessRule.AuthDefQos
+	qosFlows := []models.QosFlowSetupItem{
+		{
+			Qfi:               int32(sessRule.DefQosQFI),
+			QosRules:          base64.StdEncoding.EncodeToString(defQosRuleBytes),
+			DefaultQosRuleInd: true,
+			QosFlowProfile: &models.SmfPduSessionQosFlowProfile{
+				Var5qi: authDefQos.Var5qi,
+				Arp:    authDefQos.Arp,
+			},
+		},
+	}
+	for qfi, qosFlow := range c.AdditonalQosFlows {
+		qosData := qosFlow.QoSProfile
+		item := models.QosFlowSetupItem{
+			Qfi: int32(qfi),
+			QosFlowProfile: &models.SmfPduSessionQosFlowProfile{
+				Var5qi: qosData.Var5qi,
+				Arp:    qosData.Arp,
+			},
+		}
+		if qosData.GbrUl != "" || qosData.GbrDl != "" {
+			item.QosFlowProfile.GbrQosFlowInfo = &models.SmfPduSessionGbrQosFlowInformation{
+				MaxFbrDl: qosData.MaxbrDl,
+				MaxFbrUl: qosData.MaxbrUl,
+				GuaFbrDl: qosData.GbrDl,
+				GuaFbrUl: qosData.GbrUl,
+			}
+		}
+		qosFlows = append(qosFlows, item)
+	}
+	return qosFlows, nil
+}
+
+// BuildAfCoordinationInfo returns the information of the source PDU session for the AF coordination
+// of the SSC mode 2/3 PDU session relocation (TS 29.502 5.2.2.5)
+func (c *SMContext) BuildAfCoordinationInfo() *models.AfCoordinationInfo {
+	info := &models.AfCoordinationInfo{
+		SourceUeIpv6Prefix: c.IPv6PrefixString(),
+	}
+	if ip := c.PDUAddress.To4(); ip != nil {
+		info.SourceUeIpv4Addr = ip.String()
+	}
+	return info
+}
+
+// ErrNoEpsBearerID is returned if no EPS bearer ID is assigned to the PDU session, the PDU session
+// is not transferred to EPS
+var ErrNoEpsBearerID = errors.New("no EPS bearer ID assigned")
+
+// GTPv2 IE types of the PDN Connection (TS 29.274 8.1)
+const (
+	gtpv2IETypeAPN           = 71
+	gtpv2IETypeAMBR          = 72
+	gtpv2IETypeEBI           = 73
+	gtpv2IETypeIPAddress     = 74
+	gtpv2IETypeBearerQoS     = 80
+	gtpv2IETypeFTEID         = 87
+	gtpv2IETypeBearerCtx     = 93
+	gtpv2IETypePDNConnection = 109
+)
+
+// F-TEID interface type of the S5/S8 PGW GTP-C interface (TS 29.274 8.22)
+const gtpv2FTEIDInterfaceS5S8PGWGTPC = 7
+
+// BuildUeEpsPdnConnection returns the UE EPS PDN connection transferred with the Nsmf_PDUSession Retrieve
+// service operation for the 5GS to EPS handover over N26 (TS 29.502 5.2.2.5), i.e. the base64 encoded
+// PDN Connection IE of the Forward Relocation Request (TS 29.274 7.3.1), which carries the default
+// EPS bearer mapped from the default QoS flow.
+// NOTE: The SMF has no S5/S8 GTP-C interface, the PGW S5/S8 F-TEID carries the N4 address of the SMF
+// with TEID 0. The EPS bearers of the additional QoS flows and their TFTs are not transferred.
+func (c *SMContext) BuildUeEpsPdnConnection() (string, error) {
+	if c.EpsBearerID == 0 {
+		return "", ErrNoEpsBearerID
+	}
+	sessRule := c.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil || sessRule.AuthDefQos == nil {
+		return "", fmt.Errorf("no authorized session rule")
+	}
+
+	pdnConnection := encodeGtpv2IE(gtpv2IETypeAPN, 0, encodeAPN(c.Dnn))
+	if ip := c.PDUAddress.To4(); ip != nil {
+		pdnConnection = append(pdnConnection, encodeGtpv2IE(gtpv2IETypeIPAddress, 0, ip)...)
+	}
+	if c.PDUIPv6Prefix != nil {
+		pdnConnection = append(pdnConnection, encodeGtpv2IE(gtpv2IETypeIPAddress, 1, c.PDUIPv6Prefix.To16())...)
+	}
+	ebi := encodeGtpv2IE(gtpv2IETypeEBI, 0, []byte{c.EpsBearerID & 0x0f})
+	pdnConnection = append(pdnConnection, ebi...)
+
+	fteid := []byte{gtpv2FTEIDInterfaceS5S8PGWGTPC, 0, 0, 0, 0}
+	nodeIP := GetSelf().CPNodeID.ResolveNodeIdToIp()
+	if ip := nodeIP.To4(); ip != nil {
+		fteid[0] |= 0x80
+		fteid = append(fteid, ip...)
+	} else if ip = nodeIP.To16(); ip != nil {
+		fteid[0] |= 0x40
+		fteid = append(fteid, ip...)
+	}
+	pdnConnection = append(pdnConnection, encodeGtpv2IE(gtpv2IETypeFTEID, 0, fteid)...)
+
+	bearerQoS, err := encodeBearerQoS(sessRule.AuthDefQos)
+	if err != nil {
+		return "", err
+	}
+	bearerContext := append(append([]byte{}, ebi...), encodeGtpv2IE(gtpv2IETypeBearerQoS, 0, bearerQoS)...)
+	pdnConnection = append(pdnConnection, encodeGtpv2IE(gtpv2IETypeBearerCtx, 0, bearerContext)...)
+
+	ambrUL, err := util.BitRateTokbps(sessRule.AuthSessAmbr.Uplink)
+	if err != nil {
+		return "", fmt.Errorf("session AMBR uplink: %w", err)
+	}
+	ambrDL, err := util.BitRateTokbps(sessRule.AuthSessAmbr.Downlink)
+	if err != nil {
+		return "", fmt.Errorf("session AMBR downlink: %w", err)
+	}
+	ambr := binary.BigEndian.AppendUint32(nil, uint32(min(ambrUL, 0xffffffff)))
+	ambr = binary.BigEndian.AppendUint32(ambr, uint32(min(ambrDL, 0xffffffff)))
+	pdnConnection = append(pdnConnection, encodeGtpv2IE(gtpv2IETypeAMBR, 0, ambr)...)
+
+	ie := encodeGtpv2IE(gtpv2IETypePDNConnection, 0, pdnConnection)
+	return base64.StdEncoding.EncodeToString(ie), nil
+}
+
+// encodeGtpv2IE encodes the GTPv2 IE of the type and the instance with the value (TS 29.274 8.2.1)
+func encodeGtpv2IE(ieType uint8, instance uint8, value []byte) []byte {
+	ie := []byte{ieType, 0, 0, instance & 0x0f}
+	binary.BigEndian.PutUint16(ie[1:3], uint16(len(value)))
+	return append(ie, value...)
+}
+
+// encodeAPN encodes the DNN as the APN in the label format (TS 23.003 9.1)
+func encodeAPN(dnn string) []byte {
+	var apn []byte
+	for _, label := range strings.Split(dnn, ".") {
+		apn = append(apn, byte(len(label)))
+		apn = append(apn, label...)
+	}
+	return apn
+}
+
+// encodeBearerQoS encodes the Bearer QoS of the default EPS bearer (TS 29.274 8.15), the 5QI is mapped to
+// the QCI as is and the bit rates are 0 since the default EPS bearer is a non-GBR bearer
+func encodeBearerQoS(authDefQos *models.AuthorizedDefaultQos) ([]byte, error) {
+	arp := authDefQos.Arp
+	if arp == nil {
+		return nil, fmt.Errorf("no ARP of default QoS")
+	}
+	if authDefQos.Var5qi < 1 || authDefQos.Var5qi > 255 {
+		return nil, fmt.Errorf("invalid 5QI %d of default QoS", authDefQos.Var5qi)
+	}
+
+	flags := byte(arp.PriorityLevel&0x0f) << 2
+	if arp.PreemptCap == models.PreemptionCapability_NOT_PREEMPT {
+		flags |= 0x40
+	}
+	if arp.PreemptVuln == models.PreemptionVulnerability_NOT_PREEMPTABLE {
+		flags |= 0x01
+	}
+	// MBR UL/DL and GBR UL/DL follow the QCI with 5 octets each
+	bearerQoS := make([]byte, 22)
+	bearerQoS[0] = flags
+	bearerQoS[1] = byte(authDefQos.Var5qi)
+	return bearerQoS, nil
+}
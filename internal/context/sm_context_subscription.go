@@ -0,0 +1,119 @@
+package context
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/smf/internal/util"
+)
+
+// SmDataChangeAction is the action on the PDU session after its session management subscription data
+// changes in the UDM (TS 23.502 4.3.3.2, 4.3.4.2)
+type SmDataChangeAction uint8
+
+const (
+	SmDataChangeNone SmDataChangeAction = iota
+	SmDataChangeModify
+	SmDataChangeRelease
+)
+
+// UpdateDnnConfiguration applies the new subscribed DNN configuration of the PDU session and returns
+// the action to take on the PDU session. A nil configuration means the DNN is no longer subscribed.
+func (c *SMContext) UpdateDnnConfiguration(dnnConfig *models.DnnConfiguration) SmDataChangeAction {
+	if dnnConfig == nil {
+		c.Log.Infof("DNN[%s] is no longer subscribed", c.Dnn)
+		return SmDataChangeRelease
+	}
+
+	// The SMF only establishes SSC mode 1 PDU sessions
+	if !isSscModeAllowed(dnnConfig.SscModes, models.SscMode__1) {
+		c.Log.Infof("SSC mode 1 is no longer allowed for DNN[%s]", c.Dnn)
+		return SmDataChangeRelease
+	}
+
+	origConfig := c.DnnConfiguration
+	c.DnnConfiguration = *dnnConfig
+
+	// The UE address can't be changed during the PDU session
+	if !isStaticIpAddressEqual(origConfig.StaticIpAddress, dnnConfig.StaticIpAddress) {
+		c.Log.Infof("Static IP address of DNN[%s] changed", c.Dnn)
+		return SmDataChangeRelease
+	}
+
+	// The framed routes are installed in the UPF at the PDU session establishment
+	if !isFramedRouteListEqual(origConfig.Ipv4FrameRouteList, dnnConfig.Ipv4FrameRouteList) ||
+		!isFramedRouteListEqual(origConfig.Ipv6FrameRouteList, dnnConfig.Ipv6FrameRouteList) {
+		c.Log.Infof("Framed routes of DNN[%s] changed", c.Dnn)
+		return SmDataChangeRelease
+	}
+
+	if !reflect.DeepEqual(origConfig.SessionAmbr, dnnConfig.SessionAmbr) ||
+		!reflect.DeepEqual(origConfig.Var5gQosProfile, dnnConfig.Var5gQosProfile) {
+		c.Log.Infof("Subscribed session AMBR or default QoS of DNN[%s] changed", c.Dnn)
+		return SmDataChangeModify
+	}
+	return SmDataChangeNone
+}
+
+// UpdateSessionAMBRQERs updates the MBR of the session AMBR QERs to the authorized session AMBR,
+// the QERs are modified in the UPFs with the next PFCP Session Modification
+func (c *SMContext) UpdateSessionAMBRQERs() error {
+	sessRule := c.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthSessAmbr == nil {
+		return fmt.Errorf("no authorized session AMBR")
+	}
+	ulMBR, err := util.BitRateTokbps(sessRule.AuthSessAmbr.Uplink)
+	if err != nil {
+		return err
+	}
+	dlMBR, err := util.BitRateTokbps(sessRule.AuthSessAmbr.Downlink)
+	if err != nil {
+		return err
+	}
+
+	for upfID, qerID := range c.AMBRQerMap {
+		upf := GetUpfById(upfID.String())
+		if upf == nil {
+			continue
+		}
+		if value, ok := upf.qerPool.Load(qerID); ok {
+			qer := value.(*QER)
+			qer.MBR = &pfcpType.MBR{
+				ULMBR: ulMBR,
+				DLMBR: dlMBR,
+			}
+			if qer.State != RULE_INITIAL {
+				qer.State = RULE_UPDATE
+			}
+		}
+	}
+	return nil
+}
+
+func isSscModeAllowed(sscModes *models.SscModes, sscMode models.SscMode) bool {
+	if sscModes == nil || sscModes.DefaultSscMode == sscMode {
+		return true
+	}
+	for _, allowed := range sscModes.AllowedSscModes {
+		if allowed == sscMode {
+			return true
+		}
+	}
+	return false
+}
+
+func isStaticIpAddressEqual(a, b []models.UdmSdmIpAddress) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isFramedRouteListEqual(a, b []models.FrameRouteInfo) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,117 @@
+package context_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestUpdateDnnConfiguration(t *testing.T) {
+	origConfig := models.DnnConfiguration{
+		SscModes: &models.SscModes{
+			DefaultSscMode: models.SscMode__1,
+		},
+		Var5gQosProfile: &models.SubscribedDefaultQos{
+			Var5qi: 9,
+			Arp:    &models.Arp{PriorityLevel: 8},
+		},
+		SessionAmbr: &models.Ambr{
+			Uplink:   "1000 Kbps",
+			Downlink: "1000 Kbps",
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		dnnConfig      func() *models.DnnConfiguration
+		expectedAction smf_context.SmDataChangeAction
+	}{
+		{
+			name:           "DNN no longer subscribed",
+			dnnConfig:      func() *models.DnnConfiguration { return nil },
+			expectedAction: smf_context.SmDataChangeRelease,
+		},
+		{
+			name: "Unchanged",
+			dnnConfig: func() *models.DnnConfiguration {
+				dnnConfig := origConfig
+				return &dnnConfig
+			},
+			expectedAction: smf_context.SmDataChangeNone,
+		},
+		{
+			name: "Session AMBR changed",
+			dnnConfig: func() *models.DnnConfiguration {
+				dnnConfig := origConfig
+				dnnConfig.SessionAmbr = &models.Ambr{
+					Uplink:   "2000 Kbps",
+					Downlink: "2000 Kbps",
+				}
+				return &dnnConfig
+			},
+			expectedAction: smf_context.SmDataChangeModify,
+		},
+		{
+			name: "Default 5QI changed",
+			dnnConfig: func() *models.DnnConfiguration {
+				dnnConfig := origConfig
+				dnnConfig.Var5gQosProfile = &models.SubscribedDefaultQos{
+					Var5qi: 8,
+					Arp:    &models.Arp{PriorityLevel: 8},
+				}
+				return &dnnConfig
+			},
+			expectedAction: smf_context.SmDataChangeModify,
+		},
+		{
+			name: "SSC mode 1 not allowed",
+			dnnConfig: func() *models.DnnConfiguration {
+				dnnConfig := origConfig
+				dnnConfig.SscModes = &models.SscModes{
+					DefaultSscMode:  models.SscMode__2,
+					AllowedSscModes: []models.SscMode{models.SscMode__3},
+				}
+				return &dnnConfig
+			},
+			expectedAction: smf_context.SmDataChangeRelease,
+		},
+		{
+			name: "Static IP address changed",
+			dnnConfig: func() *models.DnnConfiguration {
+				dnnConfig := origConfig
+				dnnConfig.StaticIpAddress = []models.UdmSdmIpAddress{
+					{Ipv4Addr: "10.60.0.100"},
+				}
+				return &dnnConfig
+			},
+			expectedAction: smf_context.SmDataChangeRelease,
+		},
+		{
+			name: "Framed routes changed",
+			dnnConfig: func() *models.DnnConfiguration {
+				dnnConfig := origConfig
+				dnnConfig.Ipv4FrameRouteList = []models.FrameRouteInfo{
+					{Ipv4Mask: "10.1.0.0/16"},
+				}
+				return &dnnConfig
+			},
+			expectedAction: smf_context.SmDataChangeRelease,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := smf_context.NewSMContext("imsi-208930000000001", 10)
+			smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+				Supi: "imsi-208930000000001",
+			}
+			defer smf_context.RemoveSMContext(smContext.Ref)
+			smContext.DnnConfiguration = origConfig
+
+			require.Equal(t, tc.expectedAction, smContext.UpdateDnnConfiguration(tc.dnnConfig()))
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package context_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+)
+
+func TestPDUAddressToNAS(t *testing.T) {
+	iid := [8]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+
+	testCases := []struct {
+		name           string
+		pduSessionType uint8
+		expectedAddr   []byte
+	}{
+		{
+			name:           "IPv4",
+			pduSessionType: nasMessage.PDUSessionTypeIPv4,
+			expectedAddr:   []byte{10, 60, 0, 1},
+		},
+		{
+			name:           "IPv6",
+			pduSessionType: nasMessage.PDUSessionTypeIPv6,
+			expectedAddr:   iid[:],
+		},
+		{
+			name:           "IPv4v6",
+			pduSessionType: nasMessage.PDUSessionTypeIPv4IPv6,
+			expectedAddr:   append(iid[:], 10, 60, 0, 1),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := &smf_context.SMContext{
+				SelectedPDUSessionType: tc.pduSessionType,
+				PDUAddress:             net.ParseIP("10.60.0.1").To4(),
+				PDUIPv6Prefix:          net.ParseIP("2001:db8:0:10::"),
+				IPv6InterfaceID:        iid,
+			}
+			addr, addrLen := smContext.PDUAddressToNAS()
+			require.Equal(t, uint8(len(tc.expectedAddr)+1), addrLen)
+			require.Equal(t, tc.expectedAddr, addr[:len(tc.expectedAddr)])
+		})
+	}
+}
+
+func TestUEIPAddress(t *testing.T) {
+	smContext := &smf_context.SMContext{
+		PDUAddress:    net.ParseIP("10.60.0.1").To4(),
+		PDUIPv6Prefix: net.ParseIP("2001:db8:0:10::"),
+	}
+
+	ueIPAddress := smContext.UEIPAddress(true)
+	require.True(t, ueIPAddress.Sd)
+	require.True(t, ueIPAddress.V4)
+	require.True(t, ueIPAddress.V6)
+	require.Equal(t, net.ParseIP("10.60.0.1").To4(), ueIPAddress.Ipv4Address)
+	require.Equal(t, net.ParseIP("2001:db8:0:10::"), ueIPAddress.Ipv6Address)
+
+	smContext.PDUAddress = nil
+	ueIPAddress = smContext.UEIPAddress(false)
+	require.False(t, ueIPAddress.Sd)
+	require.False(t, ueIPAddress.V4)
+	require.True(t, ueIPAddress.V6)
+}
+
+func TestUpdateLearnedMACAddresses(t *testing.T) {
+	mac1, _ := net.ParseMAC("00-00-5e-00-53-01")
+	mac2, _ := net.ParseMAC("00-00-5e-00-53-02")
+	mac3, _ := net.ParseMAC("00-00-5e-00-53-03")
+
+	smContext := &smf_context.SMContext{}
+	smContext.UpdateLearnedMACAddresses([]net.HardwareAddr{mac1, mac2}, nil)
+	require.Equal(t, []net.HardwareAddr{mac1, mac2}, smContext.LearnedMACAddresses())
+
+	smContext.UpdateLearnedMACAddresses([]net.HardwareAddr{mac2, mac3}, []net.HardwareAddr{mac1})
+	require.Equal(t, []net.HardwareAddr{mac2, mac3}, smContext.LearnedMACAddresses())
+}
+
+func TestULN3LocalFTEID(t *testing.T) {
+	upf := smf_context.NewUPF(mockIPv4NodeID, mockIfaces)
+	upIP := net.ParseIP("10.60.0.1").To4()
+	smContext := smf_context.NewSMContext("imsi-208930000000301", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000301",
+		PduSessionId: 10,
+	}
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.LocalULTeid = 100
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	// The SMF allocates the F-TEID if the UPF doesn't support FTUP
+	fteid := smContext.ULN3LocalFTEID(upf, upIP)
+	require.False(t, fteid.Ch)
+	require.Equal(t, uint32(100), fteid.Teid)
+
+	upf.UPFunctionFeatures = &pfcpType.UPFunctionFeatures{
+		SupportedFeatures: pfcpType.UpFunctionFeaturesFtup,
+	}
+	fteid = smContext.ULN3LocalFTEID(upf, upIP)
+	require.True(t, fteid.Ch)
+	require.True(t, fteid.Chid)
+	require.True(t, fteid.V4)
+	require.Zero(t, fteid.Teid)
+
+	smContext.PFCPContext[upf.GetUPFIP()] = &smf_context.PFCPSessionContext{
+		PDRs: map[uint16]*smf_context.PDR{
+			1: {PDRID: 1, PDI: smf_context.PDI{LocalFTeid: fteid}},
+		},
+	}
+	chosen := &pfcpType.FTEID{
+		V4:          true,
+		Teid:        0x1234,
+		Ipv4Address: net.ParseIP("10.60.0.2").To4(),
+	}
+	smContext.SetChosenN3FTEID(upf, chosen)
+	require.Equal(t, chosen, smContext.PFCPContext[upf.GetUPFIP()].PDRs[1].PDI.LocalFTeid)
+
+	// The F-TEID allocated by the UPF is used for the later PDRs and the N2 signalling
+	require.Equal(t, chosen, smContext.ULN3LocalFTEID(upf, upIP))
+	ip, teid, err := smContext.ULN3TunnelEndpoint(upf, smContext.LocalULTeid)
+	require.NoError(t, err)
+	require.Equal(t, chosen.Ipv4Address, ip)
+	require.Equal(t, uint32(0x1234), teid)
+}
+
+func TestIsAllowedPDUSessionType(t *testing.T) {
+	testCases := []struct {
+		name          string
+		allowedTypes  []models.PduSessionType
+		requestedType uint8
+		selectedType  uint8
+		acceptCause   uint8
+		rejectCause   uint8
+	}{
+		{
+			name:          "Ethernet only DNN",
+			allowedTypes:  []models.PduSessionType{models.PduSessionType_ETHERNET},
+			requestedType: nasMessage.PDUSessionTypeEthernet,
+			selectedType:  nasMessage.PDUSessionTypeEthernet,
+		},
+		{
+			name:          "IPv4v6 requested in IPv4 only DNN",
+			allowedTypes:  []models.PduSessionType{models.PduSessionType_IPV4},
+			requestedType: nasMessage.PDUSessionTypeIPv4IPv6,
+			selectedType:  nasMessage.PDUSessionTypeIPv4,
+			acceptCause:   nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+		},
+		{
+			name:          "IPv4 requested in IPv6 only DNN",
+			allowedTypes:  []models.PduSessionType{models.PduSessionType_IPV6},
+			requestedType: nasMessage.PDUSessionTypeIPv4,
+			rejectCause:   nasMessage.Cause5GSMPDUSessionTypeIPv6OnlyAllowed,
+		},
+		{
+			name:          "Ethernet requested in IPv4 only DNN",
+			allowedTypes:  []models.PduSessionType{models.PduSessionType_IPV4},
+			requestedType: nasMessage.PDUSessionTypeEthernet,
+			rejectCause:   nasMessage.Cause5GSMUnknownPDUSessionType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			smContext := &smf_context.SMContext{
+				SmfPduSessionSmContextCreateData: &models.SmfPduSessionSmContextCreateData{Dnn: "internet"},
+				DnnConfiguration: models.DnnConfiguration{
+					PduSessionTypes: &models.PduSessionTypes{AllowedSessionTypes: tc.allowedTypes},
+				},
+			}
+			err := smContext.IsAllowedPDUSessionType(tc.requestedType)
+			if tc.rejectCause != 0 {
+				require.Error(t, err)
+				require.Equal(t, tc.rejectCause, smContext.PDUSessionTypeRejectCause(tc.requestedType))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.selectedType, smContext.SelectedPDUSessionType)
+			require.Equal(t, tc.acceptCause, smContext.EstAcceptCause5gSMValue)
+		})
+	}
+}
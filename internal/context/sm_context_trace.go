@@ -0,0 +1,30 @@
+package context
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SetTraceContext keeps the span of ctx as the span of the SBI request being processed for the SM context,
+// the PFCP transactions and the outbound SBI requests of the SM context are traced as its children
+func (smContext *SMContext) SetTraceContext(ctx context.Context) {
+	smContext.traceSpanContext.Store(trace.SpanContextFromContext(ctx))
+}
+
+// WithTraceContext returns ctx with the span kept for the SM context if ctx does not have a span
+func (smContext *SMContext) WithTraceContext(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	spanContext, ok := smContext.traceSpanContext.Load().(trace.SpanContext)
+	if !ok || !spanContext.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, spanContext)
+}
+
+// TraceContext returns a context with the span kept for the SM context
+func (smContext *SMContext) TraceContext() context.Context {
+	return smContext.WithTraceContext(context.Background())
+}
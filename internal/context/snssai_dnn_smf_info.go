@@ -1,6 +1,10 @@
 package context
 
-import "net"
+import (
+	"net"
+
+	"github.com/free5gc/smf/pkg/factory"
+)
 
 // SnssaiSmfInfo records the SMF S-NSSAI related information
 type SnssaiSmfInfo struct {
@@ -10,8 +14,9 @@ type SnssaiSmfInfo struct {
 
 // SnssaiSmfDnnInfo records the SMF per S-NSSAI DNN information
 type SnssaiSmfDnnInfo struct {
-	DNS   DNS
-	PCSCF PCSCF
+	DNS         DNS
+	PCSCF       PCSCF
+	DlBuffering *factory.DlBuffering
 }
 
 type DNS struct {
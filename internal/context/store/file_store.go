@@ -0,0 +1,153 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	smContextDir  = "smcontexts"
+	smfStateFile  = "smf_state.json"
+	recordFileExt = ".json"
+)
+
+var _ Store = &FileStore{}
+
+// FileStore is the embedded Store which keeps each record in a JSON file of a directory,
+// the files are replaced atomically so that a record is never partially written
+type FileStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileStore makes a FileStore in the directory, the directory is created if it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("directory of the file store is not specified")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, smContextDir), 0o700); err != nil {
+		return nil, fmt.Errorf("create directory of the file store: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) smContextPath(ref string) string {
+	// The reference is a URN, which is encoded to be a valid file name
+	return filepath.Join(s.dir, smContextDir, base64.RawURLEncoding.EncodeToString([]byte(ref))+recordFileExt)
+}
+
+func (s *FileStore) SaveSMContext(record *SMContextRecord) error {
+	if record == nil || record.Ref == "" {
+		return fmt.Errorf("SM context record without the reference")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode SM context[%s]: %w", record.Ref, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(s.smContextPath(record.Ref), data)
+}
+
+func (s *FileStore) DeleteSMContext(ref string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.smContextPath(ref)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) LoadSMContexts() ([]*SMContextRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, smContextDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*SMContextRecord
+	var errs []error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), recordFileExt) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, smContextDir, entry.Name()))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		record := new(SMContextRecord)
+		if err = json.Unmarshal(data, record); err != nil {
+			errs = append(errs, fmt.Errorf("decode %s: %w", entry.Name(), err))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, errors.Join(errs...)
+}
+
+func (s *FileStore) SaveSmfState(state *SmfState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("encode SMF state: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileAtomic(filepath.Join(s.dir, smfStateFile), data)
+}
+
+func (s *FileStore) LoadSmfState() (*SmfState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(s.dir, smfStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	state := new(SmfState)
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("decode SMF state: %w", err)
+	}
+	return state, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it to the file
+func writeFileAtomic(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		if removeErr := os.Remove(tmpName); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			return errors.Join(err, removeErr)
+		}
+	}
+	return err
+}
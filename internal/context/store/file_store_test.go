@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/context/store"
+)
+
+func TestFileStore_SMContext(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewFileStore(dir)
+	require.NoError(t, err)
+
+	records, err := s.LoadSMContexts()
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	record := &store.SMContextRecord{
+		Ref: "urn:uuid:0a7f58e0-6a7f-4c4a-9b5a-2f2f0c0c0c01",
+		CreateData: &models.SmfPduSessionSmContextCreateData{
+			Supi:         "imsi-208930000000001",
+			PduSessionId: 1,
+			Dnn:          "internet",
+		},
+		PDUAddress:  net.ParseIP("10.60.0.1").To4(),
+		SelectedUPF: "UPF",
+		LocalULTeid: 1,
+		LocalDLTeid: 2,
+		PFCPSessions: []store.PFCPSessionRecord{
+			{NodeIP: "10.4.0.11", LocalSEID: 3},
+		},
+		QFIs: map[string]uint8{"QosData1": 2},
+	}
+	require.NoError(t, s.SaveSMContext(record))
+
+	// Replace the record
+	record.LocalDLTeid = 4
+	require.NoError(t, s.SaveSMContext(record))
+
+	records, err = s.LoadSMContexts()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, record.Ref, records[0].Ref)
+	require.Equal(t, record.CreateData.Supi, records[0].CreateData.Supi)
+	require.True(t, record.PDUAddress.Equal(records[0].PDUAddress))
+	require.Equal(t, uint32(4), records[0].LocalDLTeid)
+	require.Equal(t, record.PFCPSessions, records[0].PFCPSessions)
+	require.Equal(t, record.QFIs, records[0].QFIs)
+
+	// The records are kept over the reopening of the store
+	s, err = store.NewFileStore(dir)
+	require.NoError(t, err)
+	records, err = s.LoadSMContexts()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	require.NoError(t, s.DeleteSMContext(record.Ref))
+	require.NoError(t, s.DeleteSMContext(record.Ref))
+	records, err = s.LoadSMContexts()
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	require.Error(t, s.SaveSMContext(&store.SMContextRecord{}))
+}
+
+func TestFileStore_CorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewFileStore(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, s.SaveSMContext(&store.SMContextRecord{Ref: "urn:uuid:1"}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "smcontexts", "broken.json"), []byte("{"), 0o600))
+
+	records, err := s.LoadSMContexts()
+	require.Error(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "urn:uuid:1", records[0].Ref)
+}
+
+func TestFileStore_SmfState(t *testing.T) {
+	s, err := store.NewFileStore(t.TempDir())
+	require.NoError(t, err)
+
+	state, err := s.LoadSmfState()
+	require.NoError(t, err)
+	require.Nil(t, state)
+
+	require.NoError(t, s.SaveSmfState(&store.SmfState{
+		NfInstanceID:   "6bf6b23c-4a5f-4f3c-9d0b-8d3b4f7c6a11",
+		LocalSEIDCount: 10,
+	}))
+	state, err = s.LoadSmfState()
+	require.NoError(t, err)
+	require.Equal(t, "6bf6b23c-4a5f-4f3c-9d0b-8d3b4f7c6a11", state.NfInstanceID)
+	require.Equal(t, uint64(10), state.LocalSEIDCount)
+
+	_, err = store.NewFileStore("")
+	require.Error(t, err)
+}
@@ -0,0 +1,104 @@
+// Package store keeps the SM contexts and the ID allocator state of the SMF in a persistent storage,
+// so that the PDU sessions can be recovered when the SMF restarts
+package store
+
+import (
+	"net"
+
+	"github.com/free5gc/openapi/models"
+)
+
+// Store is the persistent storage of the SM contexts. FileStore is the embedded implementation,
+// an external storage (e.g. a database shared by SMF instances) can be used by implementing this interface.
+type Store interface {
+	// SaveSMContext creates or replaces the record of the SM context
+	SaveSMContext(record *SMContextRecord) error
+	// DeleteSMContext deletes the record of the SM context, it is not an error if the record does not exist
+	DeleteSMContext(ref string) error
+	// LoadSMContexts returns all the records of the SM contexts. The records which cannot be decoded
+	// are skipped and reported by the error along with the other records.
+	LoadSMContexts() ([]*SMContextRecord, error)
+	// SaveSmfState replaces the state of the SMF
+	SaveSmfState(state *SmfState) error
+	// LoadSmfState returns the state of the SMF, or nil if the state has not been saved
+	LoadSmfState() (*SmfState, error)
+	Close() error
+}
+
+// SmfState is the state of the SMF shared by the SM contexts
+type SmfState struct {
+	// NfInstanceID is kept for the SM context references held by the AMF and the NRF registration
+	NfInstanceID string `json:"nfInstanceId"`
+	// LocalSEIDCount is the last allocated local SEID
+	LocalSEIDCount uint64 `json:"localSeidCount"`
+}
+
+// PFCPSessionRecord is the PFCP session of the SM context on a UPF
+type PFCPSessionRecord struct {
+	NodeIP    string `json:"nodeIp"`
+	LocalSEID uint64 `json:"localSeid"`
+
+	// IDs of the rules in the PFCP session, key: rule in the data paths of the SM context
+	PDRIDs map[string]uint16 `json:"pdrIds,omitempty"`
+	FARIDs map[string]uint32 `json:"farIds,omitempty"`
+	QERIDs map[string]uint32 `json:"qerIds,omitempty"`
+	URRIDs map[string]uint32 `json:"urrIds,omitempty"`
+	BARIDs map[string]uint8  `json:"barIds,omitempty"`
+}
+
+// TunnelRecord is a GTP-U tunnel endpoint
+type TunnelRecord struct {
+	IPAddress net.IP `json:"ipAddress,omitempty"`
+	TEID      uint32 `json:"teid,omitempty"`
+}
+
+// SMContextRecord is the checkpoint of an SM context, which is the information needed to rebuild
+// the SM context and its user plane without the signaling with the UE and the other NFs
+type SMContextRecord struct {
+	Ref                    string                                   `json:"ref"`
+	CreateData             *models.SmfPduSessionSmContextCreateData `json:"createData"`
+	SmStatusNotifyUri      string                                   `json:"smStatusNotifyUri,omitempty"`
+	Pti                    uint8                                    `json:"pti,omitempty"`
+	SelectedPDUSessionType uint8                                    `json:"selectedPduSessionType"`
+	UpCnxState             models.UpCnxState                        `json:"upCnxState,omitempty"`
+	DnnConfiguration       models.DnnConfiguration                  `json:"dnnConfiguration"`
+	UpSecurity             *models.UpSecurity                       `json:"upSecurity,omitempty"`
+	UeCmRegistered         bool                                     `json:"ueCmRegistered,omitempty"`
+	SdmSubscriptionId      string                                   `json:"sdmSubscriptionId,omitempty"`
+
+	// UE address
+	PDUAddress      net.IP  `json:"pduAddress,omitempty"`
+	UseStaticIP     bool    `json:"useStaticIp,omitempty"`
+	PDUIPv6Prefix   net.IP  `json:"pduIpv6Prefix,omitempty"`
+	UseStaticIPv6   bool    `json:"useStaticIpv6,omitempty"`
+	IPv6InterfaceID [8]byte `json:"ipv6InterfaceId"`
+
+	// User plane
+	SelectedUPF  string              `json:"selectedUpf"`
+	LocalULTeid  uint32              `json:"localUlTeid"`
+	LocalDLTeid  uint32              `json:"localDlTeid"`
+	ANTunnel     TunnelRecord        `json:"anTunnel"`
+	PFCPSessions []PFCPSessionRecord `json:"pfcpSessions,omitempty"`
+	ChargingID   int32               `json:"chargingId"`
+	EpsBearerID  uint8               `json:"epsBearerId,omitempty"`
+
+	// Peer SMF of the home-routed roaming or the I-SMF
+	SmfRole           uint8        `json:"smfRole,omitempty"`
+	PeerPduSessionUri string       `json:"peerPduSessionUri,omitempty"`
+	AnchorULTunnel    TunnelRecord `json:"anchorUlTunnel"`
+
+	// NF profiles used by the SM context
+	AMFProfile                   models.NrfNfDiscoveryNfProfile `json:"amfProfile"`
+	CommunicationClientApiPrefix string                         `json:"communicationClientApiPrefix,omitempty"`
+	SelectedPCFProfile           models.NrfNfDiscoveryNfProfile `json:"selectedPcfProfile"`
+
+	// SM policy, the policy decision holds all the rules and the data installed in the SM context
+	SMPolicyID            string                   `json:"smPolicyId,omitempty"`
+	PolicyDecision        *models.SmPolicyDecision `json:"policyDecision,omitempty"`
+	SelectedSessionRuleID string                   `json:"selectedSessionRuleId,omitempty"`
+
+	// IDs signaled to the UE, key: QoS data ID, PCC rule ID and packet filter ID of the PCF respectively
+	QFIs            map[string]uint8 `json:"qfis,omitempty"`
+	QoSRuleIDs      map[string]uint8 `json:"qosRuleIds,omitempty"`
+	PacketFilterIDs map[string]uint8 `json:"packetFilterIds,omitempty"`
+}
@@ -81,7 +81,9 @@ func NewUEPreConfigPaths(paths []factory.SpecificPath) (*UEPreConfigPaths, error
 }
 
 func GetUEPreConfigPaths(supi string, upfName string) *UEPreConfigPaths {
-	groupName := GetULCLGroupNameFromSUPI(supi)
+	smfContext.reloadMu.RLock()
+	defer smfContext.reloadMu.RUnlock()
+	groupName := ulclGroupNameOf(supi)
 	if groupName == "" {
 		return nil
 	}
@@ -107,7 +109,9 @@ func GetUEPreConfigPaths(supi string, upfName string) *UEPreConfigPaths {
 }
 
 func CheckUEHasPreConfig(supi string) (exist bool) {
-	groupName := GetULCLGroupNameFromSUPI(supi)
+	smfContext.reloadMu.RLock()
+	defer smfContext.reloadMu.RUnlock()
+	groupName := ulclGroupNameOf(supi)
 	logger.CtxLog.Tracef("UE [%s] belongs to group [%s]", supi, groupName)
 	if groupName == "" {
 		return false
@@ -11,7 +11,10 @@ import (
 	"github.com/free5gc/smf/pkg/factory"
 )
 
-// UeIPPool represent IPv4 address pool for UE
+// IPv6PrefixLen is the length of the IPv6 prefix allocated to a PDU session (TS 23.501 5.8.2.2.3)
+const IPv6PrefixLen = 64
+
+// UeIPPool represent IPv4 address pool or IPv6 /64 prefix pool for UE
 type UeIPPool struct {
 	ueSubNet *net.IPNet
 	pool     *pool.LazyReusePool
@@ -24,13 +27,20 @@ func NewUEIPPool(factoryPool *factory.UEIPPool) *UeIPPool {
 		return nil
 	}
 
-	minAddr, maxAddr, err := calcAddrRange(ipNet)
+	var minAddr, maxAddr int
+	if ipNet.IP.To4() != nil {
+		var minAddrV4, maxAddrV4 uint32
+		minAddrV4, maxAddrV4, err = calcAddrRange(ipNet)
+		minAddr, maxAddr = int(minAddrV4), int(maxAddrV4)
+	} else {
+		minAddr, maxAddr, err = calcPrefixRange(ipNet)
+	}
 	if err != nil {
 		logger.InitLog.Errorln(err)
 		return nil
 	}
 
-	newPool, err := pool.NewLazyReusePool(int(minAddr), int(maxAddr))
+	newPool, err := pool.NewLazyReusePool(minAddr, maxAddr)
 	if err != nil {
 		logger.InitLog.Errorln(err)
 		return nil
@@ -43,11 +53,42 @@ func NewUEIPPool(factoryPool *factory.UEIPPool) *UeIPPool {
 	return ueIPPool
 }
 
+// IsIPv6 reports whether this pool allocates IPv6 prefixes
+func (ueIPPool *UeIPPool) IsIPv6() bool {
+	return ueIPPool.ueSubNet.IP.To4() == nil
+}
+
+// Contains reports whether the address or prefix belongs to this pool
+func (ueIPPool *UeIPPool) Contains(addr net.IP) bool {
+	return ueIPPool.ueSubNet.Contains(addr)
+}
+
+// ipToValue converts an IPv4 address, or the /64 prefix of an IPv6 address, to the value in the pool
+func (ueIPPool *UeIPPool) ipToValue(addr net.IP) int {
+	if !ueIPPool.IsIPv6() {
+		return int(binary.BigEndian.Uint32(addr.To4()))
+	}
+	ones, _ := ueIPPool.ueSubNet.Mask.Size()
+	prefix := binary.BigEndian.Uint64(addr.To16()[:8])
+	return int(prefix & (uint64(1)<<(IPv6PrefixLen-ones) - 1))
+}
+
+// valueToIP converts a value in the pool to the IPv4 address or the IPv6 /64 prefix
+func (ueIPPool *UeIPPool) valueToIP(val int) net.IP {
+	if !ueIPPool.IsIPv6() {
+		return uint32ToIP(uint32(val))
+	}
+	base := binary.BigEndian.Uint64(ueIPPool.ueSubNet.IP.To16()[:8])
+	buf := make([]byte, net.IPv6len)
+	binary.BigEndian.PutUint64(buf[:8], base|uint64(val))
+	return buf
+}
+
 func (ueIPPool *UeIPPool) Allocate(request net.IP) net.IP {
 	var allocVal int
 	var ok bool
 	if request != nil {
-		allocVal = int(binary.BigEndian.Uint32(request))
+		allocVal = ueIPPool.ipToValue(request)
 		ok = ueIPPool.pool.Use(allocVal)
 		if !ok {
 			logger.CtxLog.Warnf("IP[%s] is used in Pool[%+v]", request, ueIPPool.ueSubNet)
@@ -64,14 +105,21 @@ func (ueIPPool *UeIPPool) Allocate(request net.IP) net.IP {
 	}
 
 RETURNIP:
-	retIP := uint32ToIP(uint32(allocVal))
-	logger.CtxLog.Infof("Allocated UE IP address: %s", retIP)
+	retIP := ueIPPool.valueToIP(allocVal)
+	if ueIPPool.IsIPv6() {
+		logger.CtxLog.Infof("Allocated UE IPv6 prefix: %s/%d", retIP, IPv6PrefixLen)
+	} else {
+		logger.CtxLog.Infof("Allocated UE IP address: %s", retIP)
+	}
 	return retIP
 }
 
 func (ueIPPool *UeIPPool) Exclude(excludePool *UeIPPool) error {
-	excludeMin := excludePool.pool.Min()
-	excludeMax := excludePool.pool.Max()
+	if ueIPPool.IsIPv6() != excludePool.IsIPv6() {
+		return fmt.Errorf("exclude uePool fail: address family mismatch")
+	}
+	excludeMin := ueIPPool.ipToValue(excludePool.valueToIP(excludePool.pool.Min()))
+	excludeMax := ueIPPool.ipToValue(excludePool.valueToIP(excludePool.pool.Max()))
 	if err := ueIPPool.pool.Reserve(excludeMin, excludeMax); err != nil {
 		return fmt.Errorf("exclude uePool fail: %v", err)
 	}
@@ -89,8 +137,8 @@ func uint32ToIP(intval uint32) net.IP {
 }
 
 func (ueIPPool *UeIPPool) Release(addr net.IP) {
-	addrVal := binary.BigEndian.Uint32(addr)
-	res := ueIPPool.pool.Free(int(addrVal))
+	addrVal := ueIPPool.ipToValue(addr)
+	res := ueIPPool.pool.Free(addrVal)
 	if !res {
 		logger.CtxLog.Warnf("failed to release UE Address: %s", addr)
 	}
@@ -101,14 +149,8 @@ func (ueIPPool *UeIPPool) dump() string {
 	str := "["
 	elements := ueIPPool.pool.Dump()
 	for index, element := range elements {
-		var firstAddr net.IP
-		var lastAddr net.IP
-		buf := make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, uint32(element[0]))
-		firstAddr = buf
-		buf = make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, uint32(element[1]))
-		lastAddr = buf
+		firstAddr := ueIPPool.valueToIP(element[0])
+		lastAddr := ueIPPool.valueToIP(element[1])
 		if index > 0 {
 			str += ("->")
 		}
@@ -125,7 +167,16 @@ func isOverlap(pools []*UeIPPool) bool {
 	}
 	for i := 0; i < len(pools)-1; i++ {
 		for j := i + 1; j < len(pools); j++ {
-			if pools[i].pool.IsJoint(pools[j].pool) {
+			if pools[i].IsIPv6() != pools[j].IsIPv6() {
+				continue
+			}
+			if pools[i].IsIPv6() {
+				// values of IPv6 pools are relative to their own prefix
+				if pools[i].ueSubNet.Contains(pools[j].ueSubNet.IP) ||
+					pools[j].ueSubNet.Contains(pools[i].ueSubNet.IP) {
+					return true
+				}
+			} else if pools[i].pool.IsJoint(pools[j].pool) {
 				return true
 			}
 		}
@@ -144,3 +195,16 @@ func calcAddrRange(ipNet *net.IPNet) (minAddr, maxAddr uint32, err error) {
 	}
 	return minAddr, maxAddr, nil
 }
+
+// calcPrefixRange returns the range of /64 prefixes index in the IPv6 network
+func calcPrefixRange(ipNet *net.IPNet) (minIdx, maxIdx int, err error) {
+	ones, _ := ipNet.Mask.Size()
+	if ones > IPv6PrefixLen {
+		return 0, 0, fmt.Errorf("IPv6 prefix length of pool %s shall not be longer than %d", ipNet, IPv6PrefixLen)
+	}
+	// The pool value is a signed int
+	if IPv6PrefixLen-ones > 62 {
+		return 0, 0, fmt.Errorf("IPv6 pool %s is too large", ipNet)
+	}
+	return 0, 1<<(IPv6PrefixLen-ones) - 1, nil
+}
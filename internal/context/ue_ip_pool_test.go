@@ -79,3 +79,31 @@ func TestUeIPPool_ExcludeRange(t *testing.T) {
 		ueIPPool.Release(allocate)
 	}
 }
+
+func TestUeIPPool_IPv6Prefix(t *testing.T) {
+	ueIPPool := context.NewUEIPPool(&factory.UEIPPool{
+		Cidr: "2001:db8:0:10::/62",
+	})
+	require.NotNil(t, ueIPPool)
+	require.True(t, ueIPPool.IsIPv6())
+	require.Equal(t, 4, ueIPPool.Pool().Remain())
+
+	var prefixes []net.IP
+	for i := 0; i < 4; i++ {
+		prefix := ueIPPool.Allocate(nil)
+		require.NotNil(t, prefix)
+		require.True(t, ueIPPool.Contains(prefix))
+		require.Len(t, prefix, net.IPv6len)
+		require.NotContains(t, prefixes, prefix)
+		prefixes = append(prefixes, prefix)
+	}
+	require.Nil(t, ueIPPool.Allocate(nil))
+
+	ueIPPool.Release(prefixes[2])
+	require.Equal(t, prefixes[2], ueIPPool.Allocate(prefixes[2]))
+
+	// IPv6 pools are allocated per /64 prefix
+	require.Nil(t, context.NewUEIPPool(&factory.UEIPPool{
+		Cidr: "2001:db8::/96",
+	}))
+}
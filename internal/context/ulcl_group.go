@@ -1,8 +1,14 @@
 package context
 
 func GetULCLGroupNameFromSUPI(supi string) string {
-	ulclGroups := smfContext.ULCLGroups
-	for name, group := range ulclGroups {
+	smfContext.reloadMu.RLock()
+	defer smfContext.reloadMu.RUnlock()
+	return ulclGroupNameOf(supi)
+}
+
+// ulclGroupNameOf returns the ULCL group of the UE, the caller holds reloadMu
+func ulclGroupNameOf(supi string) string {
+	for name, group := range smfContext.ULCLGroups {
 		for _, member := range group {
 			if member == supi {
 				return name
@@ -8,6 +8,7 @@ import (
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -46,11 +47,8 @@ func (t *UPTunnel) UpdateANInformation(ip net.IP, teid uint32) {
 				DLPDR.FAR.ForwardingParameters.SendEndMarker = true
 			}
 
-			DLPDR.FAR.ForwardingParameters.OuterHeaderCreation = new(pfcpType.OuterHeaderCreation)
-			dlOuterHeaderCreation := DLPDR.FAR.ForwardingParameters.OuterHeaderCreation
-			dlOuterHeaderCreation.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
-			dlOuterHeaderCreation.Teid = t.ANInformation.TEID
-			dlOuterHeaderCreation.Ipv4Address = t.ANInformation.IPAddress.To4()
+			DLPDR.FAR.ForwardingParameters.OuterHeaderCreation = NewOuterHeaderCreation(
+				t.ANInformation.IPAddress, t.ANInformation.TEID)
 			DLPDR.FAR.State = RULE_UPDATE
 		}
 	}
@@ -69,6 +67,13 @@ type UPF struct {
 	NodeID            pfcpType.NodeID
 	Addr              string
 	RecoveryTimeStamp time.Time
+	// number of the UPF restarts detected with the Recovery Time Stamp
+	restartCount atomic.Uint64
+	// UP function features announced by the UPF, nil if not announced
+	UPFunctionFeatures *pfcpType.UPFunctionFeatures
+	// expiry of the graceful release period in Unix nanoseconds once the UPF has requested the release
+	// of the PFCP association, 0 if it hasn't and math.MaxInt64 if the period never expires
+	gracefulReleaseExpiry atomic.Int64
 
 	AssociationContext context.Context
 	CancelAssociation  context.CancelFunc
@@ -88,14 +93,38 @@ type UPF struct {
 	barIDGenerator *idgenerator.IDGenerator
 	urrIDGenerator *idgenerator.IDGenerator
 	qerIDGenerator *idgenerator.IDGenerator
+
+	// remote GTP-U peers reported in a User Plane Path Failure Report, Key: peer IP
+	failedPathPeers sync.Map
+
+	// applications whose PFDs have been accepted by the UPF, Key: AppID
+	provisionedPfds sync.Map
+
+	// retransmission timers of the PFCP requests, the timers of the SMF if nil
+	requestTimers *factory.PfcpRequestTimers
+	// counters of the PFCP requests sent to the UPF
+	n4RequestsSent          atomic.Uint64
+	n4RequestsRetransmitted atomic.Uint64
+	n4RequestsTimedOut      atomic.Uint64
+}
+
+// N4RequestStats are the counters of the PFCP requests sent to the UPF
+type N4RequestStats struct {
+	Sent          uint64 `json:"sent"`
+	Retransmitted uint64 `json:"retransmitted"`
+	TimedOut      uint64 `json:"timedOut"`
 }
 
 // UPFSelectionParams ... parameters for upf selection
 type UPFSelectionParams struct {
-	Dnn        string
-	SNssai     *SNssai
-	Dnai       string
+	Dnn            string
+	SNssai         *SNssai
+	Dnai           string
+	PDUSessionType uint8
+	// requested static IPv4 address, or IPv6 prefix of IPv6 PDU session
 	PDUAddress net.IP
+	// requested static IPv6 prefix of IPv4v6 PDU session
+	PDUIPv6Prefix net.IP
 }
 
 // UPFInterfaceInfo store the UPF interface information
@@ -143,6 +172,12 @@ func NewUPFInterfaceInfo(i *factory.InterfaceUpfInfoItem) *UPFInterfaceInfo {
 // *** add unit test ***//
 // IP returns the IP of the user plane IP information of the pduSessType
 func (i *UPFInterfaceInfo) IP(pduSessType uint8) (net.IP, error) {
+	if pduSessType == nasMessage.PDUSessionTypeEthernet ||
+		pduSessType == nasMessage.PDUSessionTypeUnstructured {
+		// Non-IP PDU session, any address family of the interface can be used
+		pduSessType = nasMessage.PDUSessionTypeIPv4IPv6
+	}
+
 	if (pduSessType == nasMessage.PDUSessionTypeIPv4 ||
 		pduSessType == nasMessage.PDUSessionTypeIPv4IPv6) && len(i.IPv4EndPointAddresses) != 0 {
 		return i.IPv4EndPointAddresses[0], nil
@@ -197,6 +232,11 @@ func (upfSelectionParams *UPFSelectionParams) String() string {
 		str += fmt.Sprintf("PDUAddress: %s\n", pduAddress)
 	}
 
+	pduIPv6Prefix := upfSelectionParams.PDUIPv6Prefix
+	if pduIPv6Prefix != nil {
+		str += fmt.Sprintf("PDUIPv6Prefix: %s\n", pduIPv6Prefix)
+	}
+
 	return str
 }
 
@@ -596,6 +636,70 @@ func (upf *UPF) ProcEachSMContext(procFunc func(*SMContext)) {
 	})
 }
 
+// ProcEachSMContextOnPath calls procFunc for every SM context with an activated data path through this UPF,
+// unlike ProcEachSMContext it also covers the sessions for which this UPF is an intermediate UPF
+func (upf *UPF) ProcEachSMContextOnPath(procFunc func(*SMContext)) {
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		if smContext.Tunnel != nil && smContext.Tunnel.DataPathPool.HasUPF(upf) {
+			procFunc(smContext)
+		}
+		return true
+	})
+}
+
+// SetPathFailure records that the user plane path to the remote GTP-U peer has failed
+func (upf *UPF) SetPathFailure(peer net.IP) {
+	upf.failedPathPeers.Store(peer.String(), struct{}{})
+}
+
+// ClearPathFailure forgets the user plane path failure to the remote GTP-U peer
+// and reports whether the path had failed
+func (upf *UPF) ClearPathFailure(peer net.IP) bool {
+	_, failed := upf.failedPathPeers.LoadAndDelete(peer.String())
+	return failed
+}
+
+// ClearPathFailures forgets all the user plane path failures of this UPF
+// and reports whether there was any
+func (upf *UPF) ClearPathFailures() bool {
+	cleared := false
+	upf.failedPathPeers.Range(func(key, value interface{}) bool {
+		upf.failedPathPeers.Delete(key)
+		cleared = true
+		return true
+	})
+	return cleared
+}
+
+// IsPathFailed reports whether the user plane path to the remote GTP-U peer has failed
+func (upf *UPF) IsPathFailed(peer net.IP) bool {
+	if peer == nil {
+		return false
+	}
+	_, failed := upf.failedPathPeers.Load(peer.String())
+	return failed
+}
+
+// IsPathFailedToUPF reports whether the user plane path to any N3/N9 endpoint of the peer UPF has failed
+func (upf *UPF) IsPathFailedToUPF(peer *UPF) bool {
+	for _, ifaces := range [][]*UPFInterfaceInfo{peer.N3Interfaces, peer.N9Interfaces} {
+		for _, iface := range ifaces {
+			for _, ip := range iface.IPv4EndPointAddresses {
+				if upf.IsPathFailed(ip) {
+					return true
+				}
+			}
+			for _, ip := range iface.IPv6EndPointAddresses {
+				if upf.IsPathFailed(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 func (upf *UPF) IsAssociated() error {
 	select {
 	case <-upf.AssociationContext.Done():
@@ -605,3 +709,158 @@ func (upf *UPF) IsAssociated() error {
 		return nil
 	}
 }
+
+// UpdateProvisionedPfds records the applications whose PFDs have been accepted by the UPF,
+// and forgets the removed ones
+func (upf *UPF) UpdateProvisionedPfds(provisionedAppIDs, removedAppIDs []string) {
+	for _, appID := range provisionedAppIDs {
+		upf.provisionedPfds.Store(appID, struct{}{})
+	}
+	for _, appID := range removedAppIDs {
+		upf.provisionedPfds.Delete(appID)
+	}
+}
+
+// ResetProvisionedPfds forgets all the PFDs provisioned to the UPF, e.g. when the association is set up again
+func (upf *UPF) ResetProvisionedPfds() {
+	upf.provisionedPfds.Range(func(key, value interface{}) bool {
+		upf.provisionedPfds.Delete(key)
+		return true
+	})
+}
+
+// IsPfdProvisioned reports whether the UPF has accepted the PFDs of the application
+func (upf *UPF) IsPfdProvisioned(appID string) bool {
+	_, provisioned := upf.provisionedPfds.Load(appID)
+	return provisioned
+}
+
+// UpdateRecoveryTimeStamp stores the Recovery Time Stamp received from the UPF,
+// it returns true if the UPF has restarted since the previous one was received
+func (upf *UPF) UpdateRecoveryTimeStamp(recoveryTimeStamp time.Time) bool {
+	restarted := !upf.RecoveryTimeStamp.IsZero() && !upf.RecoveryTimeStamp.Equal(recoveryTimeStamp)
+	upf.RecoveryTimeStamp = recoveryTimeStamp
+	if restarted {
+		upf.restartCount.Add(1)
+	}
+	return restarted
+}
+
+// RestartCount returns the number of the UPF restarts detected by the SMF
+func (upf *UPF) RestartCount() uint64 {
+	return upf.restartCount.Load()
+}
+
+// RequestTimers returns the T1 and N1 of the PFCP requests to the UPF (TS 29.244 6.4)
+func (upf *UPF) RequestTimers() (time.Duration, int) {
+	smfTimers := GetSelf().PfcpRequestTimers
+	t1, n1 := smfTimers.GetT1(), smfTimers.GetN1()
+	if upf.requestTimers != nil {
+		if upf.requestTimers.T1 != 0 {
+			t1 = upf.requestTimers.T1
+		}
+		if upf.requestTimers.N1 != 0 {
+			n1 = upf.requestTimers.N1
+		}
+	}
+	return t1, n1
+}
+
+// CountN4Request counts the PFCP request sent to the UPF with its retransmissions
+func (upf *UPF) CountN4Request(retransmissions int, timedOut bool) {
+	upf.n4RequestsSent.Add(1)
+	if retransmissions > 0 {
+		upf.n4RequestsRetransmitted.Add(uint64(retransmissions))
+	}
+	if timedOut {
+		upf.n4RequestsTimedOut.Add(1)
+	}
+}
+
+// N4RequestStats returns the counters of the PFCP requests sent to the UPF
+func (upf *UPF) N4RequestStats() N4RequestStats {
+	return N4RequestStats{
+		Sent:          upf.n4RequestsSent.Load(),
+		Retransmitted: upf.n4RequestsRetransmitted.Load(),
+		TimedOut:      upf.n4RequestsTimedOut.Load(),
+	}
+}
+
+// SupportUPFunctionFeature reports whether the UPF has announced the UP function feature,
+// e.g. pfcpType.UpFunctionFeaturesFtup
+func (upf *UPF) SupportUPFunctionFeature(feature uint16) bool {
+	return upf.UPFunctionFeatures != nil && upf.UPFunctionFeatures.SupportedFeatures&feature != 0
+}
+
+// upFunctionFeatureNames are the names of the UP function features in TS 29.244 8.2.25
+var upFunctionFeatureNames = []struct {
+	feature uint16
+	name    string
+}{
+	{pfcpType.UpFunctionFeaturesBucp, "BUCP"},
+	{pfcpType.UpFunctionFeaturesDdnd, "DDND"},
+	{pfcpType.UpFunctionFeaturesDlbd, "DLBD"},
+	{pfcpType.UpFunctionFeaturesTrst, "TRST"},
+	{pfcpType.UpFunctionFeaturesFtup, "FTUP"},
+	{pfcpType.UpFunctionFeaturesPfdm, "PFDM"},
+	{pfcpType.UpFunctionFeaturesHeeu, "HEEU"},
+	{pfcpType.UpFunctionFeaturesTreu, "TREU"},
+	{pfcpType.UpFunctionFeaturesEmpu, "EMPU"},
+	{pfcpType.UpFunctionFeaturesPdiu, "PDIU"},
+	{pfcpType.UpFunctionFeaturesUdbc, "UDBC"},
+	{pfcpType.UpFunctionFeaturesQuoac, "QUOAC"},
+	{pfcpType.UpFunctionFeaturesTrace, "TRACE"},
+	{pfcpType.UpFunctionFeaturesFrrt, "FRRT"},
+}
+
+// UPFunctionFeatureNames returns the names of the UP function features announced by the UPF
+func (upf *UPF) UPFunctionFeatureNames() []string {
+	var names []string
+	for _, f := range upFunctionFeatureNames {
+		if upf.SupportUPFunctionFeature(f.feature) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// IsUPFunctionFeatureSupported reports whether the optional IEs of the UP function feature can be sent
+// to the UPF, all the features are assumed to be supported if the UPF has not announced them
+func (upf *UPF) IsUPFunctionFeatureSupported(feature uint16) bool {
+	return upf == nil || upf.UPFunctionFeatures == nil || upf.SupportUPFunctionFeature(feature)
+}
+
+// StartGracefulRelease marks the UPF as releasing the PFCP association until the graceful release period
+// expires, so it is neither selected for new PDU sessions nor associated again in the meantime. A negative
+// period never expires. It returns false if the graceful release has already been started.
+func (upf *UPF) StartGracefulRelease(period time.Duration) bool {
+	expiry := int64(math.MaxInt64)
+	if period >= 0 {
+		expiry = time.Now().Add(period).UnixNano()
+	}
+	return upf.gracefulReleaseExpiry.CompareAndSwap(0, expiry)
+}
+
+// StopGracefulRelease makes the UPF available for new PDU sessions again
+func (upf *UPF) StopGracefulRelease() {
+	upf.gracefulReleaseExpiry.Store(0)
+}
+
+// IsInGracefulRelease reports whether the UPF is releasing the PFCP association
+func (upf *UPF) IsInGracefulRelease() bool {
+	return upf.gracefulReleaseExpiry.Load() != 0
+}
+
+// GracefulReleaseRemaining returns the time until the graceful release period expires, zero or negative
+// if the UPF isn't releasing the PFCP association or the period has expired. It returns false
+// if the period never expires.
+func (upf *UPF) GracefulReleaseRemaining() (time.Duration, bool) {
+	expiry := upf.gracefulReleaseExpiry.Load()
+	switch expiry {
+	case 0:
+		return 0, true
+	case math.MaxInt64:
+		return 0, false
+	}
+	return time.Until(time.Unix(0, expiry)), true
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -308,3 +309,130 @@ func TestAddBAR(t *testing.T) {
 		}
 	})
 }
+
+func TestUpdateRecoveryTimeStamp(t *testing.T) {
+	upf := smf_context.NewUPF(mockIPv4NodeID, mockIfaces)
+	recoveryTimeStamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	Convey("UpdateRecoveryTimeStamp should detect the restarts of the UPF", t, func() {
+		Convey("the first Recovery Time Stamp is not a restart", func() {
+			So(upf.UpdateRecoveryTimeStamp(recoveryTimeStamp), ShouldBeFalse)
+			So(upf.RecoveryTimeStamp, ShouldEqual, recoveryTimeStamp)
+		})
+		Convey("the same Recovery Time Stamp is not a restart", func() {
+			So(upf.UpdateRecoveryTimeStamp(recoveryTimeStamp), ShouldBeFalse)
+			So(upf.RestartCount(), ShouldEqual, 0)
+		})
+		Convey("a changed Recovery Time Stamp is a restart", func() {
+			recoveryTimeStamp = recoveryTimeStamp.Add(time.Hour)
+			So(upf.UpdateRecoveryTimeStamp(recoveryTimeStamp), ShouldBeTrue)
+			So(upf.RecoveryTimeStamp, ShouldEqual, recoveryTimeStamp)
+			So(upf.RestartCount(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestSupportUPFunctionFeature(t *testing.T) {
+	upf := smf_context.NewUPF(mockIPv4NodeID, mockIfaces)
+
+	Convey("SupportUPFunctionFeature should check the features announced by the UPF", t, func() {
+		So(upf.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesFtup), ShouldBeFalse)
+
+		upf.UPFunctionFeatures = &pfcpType.UPFunctionFeatures{
+			SupportedFeatures: pfcpType.UpFunctionFeaturesFtup | pfcpType.UpFunctionFeaturesBucp,
+		}
+		So(upf.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesFtup), ShouldBeTrue)
+		So(upf.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesDdnd), ShouldBeFalse)
+		So(upf.UPFunctionFeatureNames(), ShouldResemble, []string{"BUCP", "FTUP"})
+	})
+
+	Convey("IsUPFunctionFeatureSupported should assume the features not announced are supported", t, func() {
+		upf.UPFunctionFeatures = nil
+		So(upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesDdnd), ShouldBeTrue)
+
+		upf.UPFunctionFeatures = &pfcpType.UPFunctionFeatures{}
+		So(upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesDdnd), ShouldBeFalse)
+	})
+}
+
+func TestUPFRequestTimers(t *testing.T) {
+	origTimers := smf_context.GetSelf().PfcpRequestTimers
+	defer func() {
+		smf_context.GetSelf().PfcpRequestTimers = origTimers
+	}()
+
+	upi := smf_context.NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]*factory.UPNode{
+			"UPF1": {
+				Type:   "UPF",
+				NodeID: "10.4.0.21",
+			},
+			"UPF2": {
+				Type:   "UPF",
+				NodeID: "10.4.0.22",
+				RequestTimers: &factory.PfcpRequestTimers{
+					T1: 500 * time.Millisecond,
+				},
+			},
+		},
+	})
+	upf1, upf2 := upi.UPFs["UPF1"].UPF, upi.UPFs["UPF2"].UPF
+
+	Convey("RequestTimers should resolve the timers of the UPF, the SMF and the default", t, func() {
+		smf_context.GetSelf().PfcpRequestTimers = nil
+		t1, n1 := upf1.RequestTimers()
+		So(t1, ShouldEqual, factory.SmfDefaultPfcpT1)
+		So(n1, ShouldEqual, factory.SmfDefaultPfcpN1)
+
+		smf_context.GetSelf().PfcpRequestTimers = &factory.PfcpRequestTimers{T1: time.Second, N1: 5}
+		t1, n1 = upf1.RequestTimers()
+		So(t1, ShouldEqual, time.Second)
+		So(n1, ShouldEqual, 5)
+
+		t1, n1 = upf2.RequestTimers()
+		So(t1, ShouldEqual, 500*time.Millisecond)
+		So(n1, ShouldEqual, 5)
+	})
+
+	Convey("CountN4Request should count the requests, retransmissions and timeouts", t, func() {
+		upf1.CountN4Request(0, false)
+		upf1.CountN4Request(2, true)
+		So(upf1.N4RequestStats(), ShouldResemble, smf_context.N4RequestStats{
+			Sent:          2,
+			Retransmitted: 2,
+			TimedOut:      1,
+		})
+	})
+}
+
+func TestGracefulRelease(t *testing.T) {
+	upf := smf_context.NewUPF(mockIPv4NodeID, mockIfaces)
+
+	Convey("The graceful release should last for the graceful release period", t, func() {
+		So(upf.IsInGracefulRelease(), ShouldBeFalse)
+
+		So(upf.StartGracefulRelease(time.Minute), ShouldBeTrue)
+		So(upf.IsInGracefulRelease(), ShouldBeTrue)
+		remaining, expires := upf.GracefulReleaseRemaining()
+		So(expires, ShouldBeTrue)
+		So(remaining, ShouldBeBetweenOrEqual, time.Minute-time.Second, time.Minute)
+
+		// The period of the graceful release in progress isn't changed
+		So(upf.StartGracefulRelease(0), ShouldBeFalse)
+		remaining, _ = upf.GracefulReleaseRemaining()
+		So(remaining, ShouldBeGreaterThan, time.Minute-time.Second)
+
+		upf.StopGracefulRelease()
+		So(upf.IsInGracefulRelease(), ShouldBeFalse)
+		remaining, expires = upf.GracefulReleaseRemaining()
+		So(expires, ShouldBeTrue)
+		So(remaining, ShouldEqual, 0)
+	})
+
+	Convey("The graceful release period may never expire", t, func() {
+		So(upf.StartGracefulRelease(-1), ShouldBeTrue)
+		_, expires := upf.GracefulReleaseRemaining()
+		So(expires, ShouldBeFalse)
+		upf.StopGracefulRelease()
+	})
+}
@@ -2,12 +2,14 @@ package context
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
 	"reflect"
 	"sort"
 	"sync"
 
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/smf/internal/logger"
@@ -63,6 +65,19 @@ func (u *UPNode) MatchedSelection(selection *UPFSelectionParams) bool {
 	return false
 }
 
+// isPathFailedTo reports whether a user plane path failure has been reported on the link to the peer node
+func (u *UPNode) isPathFailedTo(peer *UPNode) bool {
+	switch {
+	case u.UPF != nil && peer.UPF != nil:
+		return u.UPF.IsPathFailedToUPF(peer.UPF) || peer.UPF.IsPathFailedToUPF(u.UPF)
+	case u.UPF != nil:
+		return u.UPF.IsPathFailed(peer.ANIP)
+	case peer.UPF != nil:
+		return peer.UPF.IsPathFailed(u.ANIP)
+	}
+	return false
+}
+
 // UPPath represent User Plane Sequence of this path
 type UPPath []*UPNode
 
@@ -125,6 +140,7 @@ func NewUserPlaneInformation(upTopology *factory.UserPlaneInformation) *UserPlan
 			}
 
 			upNode.UPF = NewUPF(&upNode.NodeID, node.InterfaceUpfInfoList)
+			upNode.UPF.requestTimers = node.RequestTimers
 			upNode.UPF.Addr = node.Addr
 			snssaiInfos := make([]*SnssaiUPFInfo, 0)
 			for _, snssaiInfoConfig := range node.SNssaiInfos {
@@ -250,6 +266,7 @@ func (upi *UserPlaneInformation) UpNodesToConfiguration() map[string]*factory.UP
 			u.NodeID = nodeIDtoIp.String()
 		}
 		if upNode.UPF != nil {
+			u.UPFunctionFeatures = upNode.UPF.UPFunctionFeatureNames()
 			if upNode.UPF.SNssaiInfos != nil {
 				FsNssaiInfoList := make([]*factory.SnssaiUpfInfoItem, 0)
 				for _, sNssaiInfo := range upNode.UPF.SNssaiInfos {
@@ -397,6 +414,7 @@ func (upi *UserPlaneInformation) UpNodesFromConfiguration(upTopology *factory.Us
 			}
 
 			upNode.UPF = NewUPF(&upNode.NodeID, node.InterfaceUpfInfoList)
+			upNode.UPF.requestTimers = node.RequestTimers
 			snssaiInfos := make([]*SnssaiUPFInfo, 0)
 			for _, snssaiInfoConfig := range node.SNssaiInfos {
 				snssaiInfo := &SnssaiUPFInfo{
@@ -609,6 +627,31 @@ func (upi *UserPlaneInformation) GetDefaultUserPlanePathByDNNAndUPF(selection *U
 	return nil
 }
 
+// GetAlternativeUserPlanePath returns a path from source to destination which avoids
+// the links with user plane path failure, or nil if there is no such path
+func (upi *UserPlaneInformation) GetAlternativeUserPlanePath(selection *UPFSelectionParams,
+	source, destination *UPNode,
+) UPPath {
+	visited := make(map[*UPNode]bool)
+	for _, upNode := range upi.AccessNetwork {
+		visited[upNode] = true
+	}
+	path, pathExist := getPathBetween(source, destination, visited, selection)
+	if !pathExist {
+		return nil
+	}
+	return path
+}
+
+// ResetDefaultUserPlanePath drops the cached default paths,
+// they are generated again according to the current topology and path status
+func (upi *UserPlaneInformation) ResetDefaultUserPlanePath() {
+	upi.Mu.Lock()
+	defer upi.Mu.Unlock()
+	upi.DefaultUserPlanePath = make(map[string][]*UPNode)
+	upi.DefaultUserPlanePathToUPF = make(map[string]map[string][]*UPNode)
+}
+
 func (upi *UserPlaneInformation) ExistDefaultPath(dnn string) bool {
 	_, exist := upi.DefaultUserPlanePath[dnn]
 	return exist
@@ -772,6 +815,13 @@ func getPathBetween(cur *UPNode, dest *UPNode, visited map[*UPNode]bool,
 				visited[node] = true
 				continue
 			}
+			if cur.isPathFailedTo(node) {
+				continue
+			}
+			if node.Type == UPNODE_UPF && node.UPF.IsInGracefulRelease() {
+				visited[node] = true
+				continue
+			}
 
 			path_tail, pathExistBuf := getPathBetween(node, dest, visited, selection)
 			pathExist = pathExistBuf
@@ -869,7 +919,7 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 		return nil, nil, false
 	}
 	UPFList = upi.sortUPFListByName(UPFList)
-	sortedUPFList := createUPFListForSelection(UPFList)
+	sortedUPFList := staticPoolOwnersFirst(createUPFListForSelection(UPFList), selection)
 	for _, upf := range sortedUPFList {
 		logger.CtxLog.Debugf("check start UPF: %s",
 			upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
@@ -877,6 +927,10 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 			logger.CtxLog.Infoln(err)
 			continue
 		}
+		if upf.UPF.IsInGracefulRelease() {
+			logger.CtxLog.Infof("UPF[%s] is in graceful release", upf.NodeID.ResolveNodeIdToIp())
+			continue
+		}
 
 		pools, useStaticIPPool := getUEIPPool(upf, selection)
 		if len(pools) == 0 {
@@ -903,18 +957,76 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 	return nil, nil, false
 }
 
+// SelectUPF selects an associated anchor UPF serving the DNN without allocating UE IP address,
+// it is used for Ethernet PDU session.
+func (upi *UserPlaneInformation) SelectUPF(selection *UPFSelectionParams) *UPNode {
+	source, err := upi.selectUPPathSource()
+	if err != nil {
+		return nil
+	}
+	UPFList := upi.selectAnchorUPF(source, selection)
+	if len(UPFList) == 0 {
+		logger.CtxLog.Warnf("Can't find UPF with DNN[%s] S-NSSAI[sst: %d sd: %s] DNAI[%s]\n", selection.Dnn,
+			selection.SNssai.Sst, selection.SNssai.Sd, selection.Dnai)
+		return nil
+	}
+	UPFList = upi.sortUPFListByName(UPFList)
+	for _, upf := range createUPFListForSelection(UPFList) {
+		if err = upf.UPF.IsAssociated(); err != nil {
+			logger.CtxLog.Infoln(err)
+			continue
+		}
+		if upf.UPF.IsInGracefulRelease() {
+			logger.CtxLog.Infof("UPF[%s] is in graceful release", upf.NodeID.ResolveNodeIdToIp())
+			continue
+		}
+		logger.CtxLog.Infof("Selected UPF: %s",
+			upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+		return upf
+	}
+	logger.CtxLog.Warnf("No associated UPF for DNN[%s] S-NSSAI[sst: %d sd: %s] DNAI[%s]\n", selection.Dnn,
+		selection.SNssai.Sst, selection.SNssai.Sd, selection.Dnai)
+	return nil
+}
+
 func createUPFListForSelection(inputList []*UPNode) (outputList []*UPNode) {
 	offset := rand.Intn(len(inputList))
 	return append(inputList[offset:], inputList[:offset]...)
 }
 
+// staticPoolOwnersFirst moves the UPFs whose static pools contain the requested static address to the front,
+// so that the subscribed static address is allocated from the UPF owning its static pool
+func staticPoolOwnersFirst(upfList []*UPNode, selection *UPFSelectionParams) []*UPNode {
+	if selection.PDUAddress == nil {
+		return upfList
+	}
+	owners := make([]*UPNode, 0, len(upfList))
+	var others []*UPNode
+	for _, upf := range upfList {
+		if _, useStaticIPPool := getUEIPPool(upf, selection); useStaticIPPool {
+			owners = append(owners, upf)
+		} else {
+			others = append(others, upf)
+		}
+	}
+	return append(owners, others...)
+}
+
 func createPoolListForSelection(inputList []*UeIPPool) (outputList []*UeIPPool) {
 	offset := rand.Intn(len(inputList))
 	return append(inputList[offset:], inputList[:offset]...)
 }
 
 // getUEIPPool will return IP pools and use/not use static IP pool
+// IPv6 prefix pools are returned for IPv6 PDU session, IPv4 address pools otherwise
 func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) ([]*UeIPPool, bool) {
+	return getUEIPPoolOfFamily(upNode, selection,
+		selection.PDUSessionType == nasMessage.PDUSessionTypeIPv6, selection.PDUAddress)
+}
+
+func getUEIPPoolOfFamily(upNode *UPNode, selection *UPFSelectionParams,
+	ipv6 bool, request net.IP,
+) ([]*UeIPPool, bool) {
 	for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
 		currentSnssai := snssaiInfo.SNssai
 		targetSnssai := selection.SNssai
@@ -925,10 +1037,10 @@ func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) ([]*UeIPPool, bo
 					if selection.Dnai != "" && !dnnInfo.ContainsDNAI(selection.Dnai) {
 						continue
 					}
-					if selection.PDUAddress != nil {
+					if request != nil {
 						// return static ue ip pool
 						for _, ueIPPool := range dnnInfo.StaticIPPools {
-							if ueIPPool.ueSubNet.Contains(selection.PDUAddress) {
+							if ueIPPool.ueSubNet.Contains(request) {
 								// return match IPPools
 								return []*UeIPPool{ueIPPool}, true
 							}
@@ -936,7 +1048,7 @@ func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) ([]*UeIPPool, bo
 
 						// return dynamic ue ip pool
 						for _, ueIPPool := range dnnInfo.UeIPPools {
-							if ueIPPool.ueSubNet.Contains(selection.PDUAddress) {
+							if ueIPPool.ueSubNet.Contains(request) {
 								logger.CfgLog.Infof("cannot find selected IP in static pool[%v], use dynamic pool[%+v]",
 									dnnInfo.StaticIPPools, dnnInfo.UeIPPools)
 								return []*UeIPPool{ueIPPool}, false
@@ -947,7 +1059,13 @@ func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) ([]*UeIPPool, bo
 					}
 
 					// if no specify static PDU Address
-					return dnnInfo.UeIPPools, false
+					var pools []*UeIPPool
+					for _, ueIPPool := range dnnInfo.UeIPPools {
+						if ueIPPool.IsIPv6() == ipv6 {
+							pools = append(pools, ueIPPool)
+						}
+					}
+					return pools, false
 				}
 			}
 		}
@@ -955,6 +1073,21 @@ func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) ([]*UeIPPool, bo
 	return nil, false
 }
 
+// AllocUEIPv6Prefix allocates the IPv6 prefix of IPv4v6 PDU session from the selected anchor UPF,
+// it returns the allocated prefix and use/not use static IP pool
+func (upi *UserPlaneInformation) AllocUEIPv6Prefix(upf *UPNode, selection *UPFSelectionParams) (net.IP, bool) {
+	if upf == nil {
+		return nil, false
+	}
+	pools, useStaticIPPool := getUEIPPoolOfFamily(upf, selection, true, selection.PDUIPv6Prefix)
+	for _, pool := range pools {
+		if addr := pool.Allocate(selection.PDUIPv6Prefix); addr != nil {
+			return addr, useStaticIPPool
+		}
+	}
+	return nil, false
+}
+
 func (upi *UserPlaneInformation) ReleaseUEIP(upf *UPNode, addr net.IP, static bool) {
 	pool := findPoolByAddr(upf, addr, static)
 	if pool == nil {
@@ -966,6 +1099,18 @@ func (upi *UserPlaneInformation) ReleaseUEIP(upf *UPNode, addr net.IP, static bo
 	pool.Release(addr)
 }
 
+// RestoreUEIP marks the UE IP address or IPv6 prefix allocated before the SMF restart as used
+func (upi *UserPlaneInformation) RestoreUEIP(upf *UPNode, addr net.IP, static bool) error {
+	pool := findPoolByAddr(upf, addr, static)
+	if pool == nil {
+		return fmt.Errorf("UE IP address %s is not in the pools of UPF[%s]", addr, upf.Name)
+	}
+	if pool.Allocate(addr) == nil {
+		return fmt.Errorf("UE IP address %s is in use", addr)
+	}
+	return nil
+}
+
 func findPoolByAddr(upf *UPNode, addr net.IP, static bool) *UeIPPool {
 	for _, snssaiInfo := range upf.UPF.SNssaiInfos {
 		for _, dnnInfo := range snssaiInfo.DnnList {
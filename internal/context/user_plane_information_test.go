@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -287,6 +288,32 @@ func TestSelectUPFAndAllocUEIP(t *testing.T) {
 	}
 }
 
+func TestSelectUPFAndAllocUEIPInGracefulRelease(t *testing.T) {
+	userplaneInformation := smf_context.NewUserPlaneInformation(configuration)
+	for _, upf := range userplaneInformation.UPFs {
+		upf.UPF.AssociationContext = context.Background()
+		require.True(t, upf.UPF.StartGracefulRelease(time.Minute))
+	}
+	selection := &smf_context.UPFSelectionParams{
+		Dnn: "internet",
+		SNssai: &smf_context.SNssai{
+			Sst: 1,
+			Sd:  "112232",
+		},
+	}
+
+	upf, allocatedIP, _ := userplaneInformation.SelectUPFAndAllocUEIP(selection)
+	require.Nil(t, upf)
+	require.Nil(t, allocatedIP)
+
+	for _, upf := range userplaneInformation.UPFs {
+		upf.UPF.StopGracefulRelease()
+	}
+	upf, allocatedIP, _ = userplaneInformation.SelectUPFAndAllocUEIP(selection)
+	require.NotNil(t, upf)
+	require.NotNil(t, allocatedIP)
+}
+
 var configForIPPoolAllocate = &factory.UserPlaneInformation{
 	UPNodes: map[string]*factory.UPNode{
 		"GNodeB": {
@@ -518,3 +545,90 @@ func TestGetUEIPPool(t *testing.T) {
 		})
 	}
 }
+
+func TestGetAlternativeUserPlanePath(t *testing.T) {
+	snssaiInfos := []*factory.SnssaiUpfInfoItem{
+		{
+			SNssai: &models.Snssai{
+				Sst: 1,
+				Sd:  "112232",
+			},
+			DnnUpfInfoList: []*factory.DnnUpfInfoItem{
+				{
+					Dnn: "internet",
+				},
+			},
+		},
+	}
+	newN9UPNode := func(nodeID string) *factory.UPNode {
+		return &factory.UPNode{
+			Type:        "UPF",
+			NodeID:      nodeID,
+			SNssaiInfos: snssaiInfos,
+			InterfaceUpfInfoList: []*factory.InterfaceUpfInfoItem{
+				{
+					InterfaceType:    models.UpInterfaceType_N9,
+					Endpoints:        []string{nodeID},
+					NetworkInstances: []string{"internet"},
+				},
+			},
+		}
+	}
+	config := &factory.UserPlaneInformation{
+		UPNodes: map[string]*factory.UPNode{
+			"GNodeB": {
+				Type:   "AN",
+				NodeID: "192.168.179.100",
+				ANIP:   "192.168.179.100",
+			},
+			"I-UPF":   newN9UPNode("192.168.179.1"),
+			"UPF-A":   newN9UPNode("192.168.179.2"),
+			"UPF-B":   newN9UPNode("192.168.179.3"),
+			"PSA-UPF": newN9UPNode("192.168.179.4"),
+		},
+		Links: []*factory.UPLink{
+			{A: "GNodeB", B: "I-UPF"},
+			{A: "I-UPF", B: "UPF-A"},
+			{A: "UPF-A", B: "PSA-UPF"},
+			{A: "I-UPF", B: "UPF-B"},
+			{A: "UPF-B", B: "PSA-UPF"},
+		},
+	}
+	selection := &smf_context.UPFSelectionParams{
+		Dnn: "internet",
+		SNssai: &smf_context.SNssai{
+			Sst: 1,
+			Sd:  "112232",
+		},
+	}
+
+	upi := smf_context.NewUserPlaneInformation(config)
+	source := upi.UPFs["I-UPF"]
+	destination := upi.UPFs["PSA-UPF"]
+
+	path := upi.GetAlternativeUserPlanePath(selection, source, destination)
+	require.Equal(t, smf_context.UPPath{source, upi.UPFs["UPF-A"], destination}, path)
+	dataPath := smf_context.GenerateDataPath(path)
+	require.False(t, dataPath.IsPathFailed(nil))
+
+	// N9 path between I-UPF and UPF-A failed
+	source.UPF.SetPathFailure(net.ParseIP("192.168.179.2").To4())
+	require.True(t, dataPath.IsPathFailed(nil))
+	path = upi.GetAlternativeUserPlanePath(selection, source, destination)
+	require.Equal(t, smf_context.UPPath{source, upi.UPFs["UPF-B"], destination}, path)
+
+	// N9 path between UPF-B and PSA-UPF failed as well
+	destination.UPF.SetPathFailure(net.ParseIP("192.168.179.3").To4())
+	require.Nil(t, upi.GetAlternativeUserPlanePath(selection, source, destination))
+
+	// N3 path failed
+	source.UPF.SetPathFailure(net.ParseIP("192.168.179.100"))
+	require.True(t, dataPath.IsPathFailed(net.ParseIP("192.168.179.100")))
+
+	require.True(t, source.UPF.ClearPathFailure(net.ParseIP("192.168.179.100")))
+	require.False(t, source.UPF.ClearPathFailure(net.ParseIP("192.168.179.100")))
+	require.True(t, source.UPF.ClearPathFailures())
+	require.True(t, destination.UPF.ClearPathFailures())
+	require.False(t, source.UPF.ClearPathFailures())
+	require.False(t, dataPath.IsPathFailed(net.ParseIP("192.168.179.100")))
+}
@@ -25,6 +25,7 @@ var (
 	PfcpLog     *logrus.Entry
 	PduSessLog  *logrus.Entry
 	ChargingLog *logrus.Entry
+	EeLog       *logrus.Entry
 	UtilLog     *logrus.Entry
 )
 
@@ -47,5 +48,6 @@ func init() {
 	PfcpLog = NfLog.WithField(logger_util.FieldCategory, "PFCP")
 	PduSessLog = NfLog.WithField(logger_util.FieldCategory, "PduSess")
 	ChargingLog = NfLog.WithField(logger_util.FieldCategory, "Charging")
+	EeLog = NfLog.WithField(logger_util.FieldCategory, "EE")
 	UtilLog = NfLog.WithField(logger_util.FieldCategory, "Util")
 }
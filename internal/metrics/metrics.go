@@ -0,0 +1,104 @@
+// Package metrics keeps the SMF metrics in a Prometheus registry and exposes them in the Prometheus
+// exposition format
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry is the registry of the metrics exposed by the SMF, with the metrics of the Go runtime
+// and the process
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler serves all the metrics of the Registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// funcCollector collects the values of a metric family by a function at each scrape,
+// the function emits one value for each set of label values
+type funcCollector struct {
+	desc        *prometheus.Desc
+	valueType   prometheus.ValueType
+	collectFunc func(emit func(v float64, labelValues ...string))
+}
+
+var _ prometheus.Collector = &funcCollector{}
+
+// NewGaugeFunc registers a gauge whose values are collected by collectFunc at each scrape
+func NewGaugeFunc(name, help string, collectFunc func(emit func(v float64, labelValues ...string)),
+	labelNames ...string,
+) prometheus.Collector {
+	return newFuncCollector(name, help, prometheus.GaugeValue, collectFunc, labelNames)
+}
+
+// NewCounterFunc registers a counter whose values are collected by collectFunc at each scrape,
+// e.g. for a counter kept by the SMF context
+func NewCounterFunc(name, help string, collectFunc func(emit func(v float64, labelValues ...string)),
+	labelNames ...string,
+) prometheus.Collector {
+	return newFuncCollector(name, help, prometheus.CounterValue, collectFunc, labelNames)
+}
+
+func newFuncCollector(name, help string, valueType prometheus.ValueType,
+	collectFunc func(emit func(v float64, labelValues ...string)), labelNames []string,
+) prometheus.Collector {
+	c := &funcCollector{
+		desc:        prometheus.NewDesc(name, help, labelNames, nil),
+		valueType:   valueType,
+		collectFunc: collectFunc,
+	}
+	Registry.MustRegister(c)
+	return c
+}
+
+func (c *funcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *funcCollector) Collect(ch chan<- prometheus.Metric) {
+	type sample struct {
+		labelValues []string
+		value       float64
+	}
+	var samples []*sample
+	index := make(map[string]*sample)
+	c.collectFunc(func(v float64, labelValues ...string) {
+		// the values emitted for the same label values are summed up
+		key := labelKey(labelValues)
+		if s, ok := index[key]; ok {
+			s.value += v
+			return
+		}
+		s := &sample{labelValues: append([]string(nil), labelValues...), value: v}
+		index[key] = s
+		samples = append(samples, s)
+	})
+	for _, s := range samples {
+		m, err := prometheus.NewConstMetric(c.desc, c.valueType, s.value, s.labelValues...)
+		if err != nil {
+			m = prometheus.NewInvalidMetric(c.desc, err)
+		}
+		ch <- m
+	}
+}
+
+// labelKey joins the label values with a separator which is not valid in UTF-8
+func labelKey(labelValues []string) string {
+	key := make([]byte, 0, 64)
+	for _, v := range labelValues {
+		key = append(key, v...)
+		key = append(key, 0xff)
+	}
+	return string(key)
+}
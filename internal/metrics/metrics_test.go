@@ -0,0 +1,67 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/smf/internal/metrics"
+)
+
+func TestNewGaugeFunc(t *testing.T) {
+	gauge := metrics.NewGaugeFunc("test_sessions", "Number of the sessions.",
+		func(emit func(v float64, labelValues ...string)) {
+			emit(1, "internet")
+			emit(1, "internet")
+			emit(1, "ims")
+		}, "dnn")
+	counter := metrics.NewCounterFunc("test_restarts_total", "Number of the restarts.",
+		func(emit func(v float64, labelValues ...string)) {
+			emit(2, "UPF1")
+		}, "upf")
+
+	// The values emitted for the same label values are summed up
+	require.NoError(t, testutil.CollectAndCompare(gauge, strings.NewReader(
+		"# HELP test_sessions Number of the sessions.\n"+
+			"# TYPE test_sessions gauge\n"+
+			"test_sessions{dnn=\"ims\"} 1\n"+
+			"test_sessions{dnn=\"internet\"} 2\n")))
+	require.NoError(t, testutil.CollectAndCompare(counter, strings.NewReader(
+		"# HELP test_restarts_total Number of the restarts.\n"+
+			"# TYPE test_restarts_total counter\n"+
+			"test_restarts_total{upf=\"UPF1\"} 2\n")))
+
+	require.Panics(t, func() {
+		metrics.NewGaugeFunc("test_sessions", "Registered twice.",
+			func(emit func(v float64, labelValues ...string)) {}, "dnn")
+	})
+}
+
+func TestObservePfcpRequest(t *testing.T) {
+	metrics.ObservePfcpRequest("10.4.0.11", "session_establishment", 6*time.Second, 2, false)
+	metrics.ObservePfcpRequest("10.4.0.11", "session_establishment", 9*time.Second, 2, true)
+	metrics.CountPduSessionProcedure(metrics.ProcedureEstablishment, false, 26)
+
+	require.Equal(t, 1, testutil.CollectAndCount(metrics.PfcpRequestDuration))
+	require.Equal(t, float64(4), testutil.ToFloat64(
+		metrics.PfcpRequestRetransmissions.WithLabelValues("10.4.0.11", "session_establishment")))
+	require.Equal(t, float64(1), testutil.ToFloat64(
+		metrics.PfcpRequestTimeouts.WithLabelValues("10.4.0.11", "session_establishment")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.PduSessionProcedures.WithLabelValues(
+		metrics.ProcedureEstablishment, metrics.ResultFailure, "26")))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Header().Get("Content-Type"), "text/plain; version=0.0.4")
+	require.Contains(t, rec.Body.String(),
+		"smf_pfcp_request_timeouts_total{message_type=\"session_establishment\",upf=\"10.4.0.11\"} 1\n")
+	require.Contains(t, rec.Body.String(),
+		"smf_pfcp_request_duration_seconds_count{message_type=\"session_establishment\",upf=\"10.4.0.11\"} 2\n")
+	require.Contains(t, rec.Body.String(), "go_goroutines ")
+}
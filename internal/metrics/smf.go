@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Procedures of the PDU session procedure metrics
+const (
+	ProcedureEstablishment = "establishment"
+	ProcedureModification  = "modification"
+	ProcedureRelease       = "release"
+)
+
+// Results of the PDU session procedure metrics
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	factory = promauto.With(Registry)
+
+	// PduSessionProcedures counts the PDU session procedures by the result,
+	// the cause of a failure is the 5GSM cause value
+	PduSessionProcedures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "smf_pdu_session_procedures_total",
+		Help: "Number of the completed PDU session procedures by the result and the 5GSM cause of a failure.",
+	}, []string{"procedure", "result", "cause"})
+
+	PfcpRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "smf_pfcp_request_duration_seconds",
+		Help: "Time from sending a PFCP request to a UPF to receiving the response or giving up, " +
+			"including the retransmissions.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"upf", "message_type"})
+	PfcpRequestRetransmissions = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "smf_pfcp_request_retransmissions_total",
+		Help: "Number of the retransmitted PFCP requests to a UPF, derived from the request duration and " +
+			"the fixed retransmission period of the PFCP stack.",
+	}, []string{"upf", "message_type"})
+	PfcpRequestTimeouts = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "smf_pfcp_request_timeouts_total",
+		Help: "Number of the PFCP requests to a UPF without a response after all the retransmissions.",
+	}, []string{"upf", "message_type"})
+	PfcpHeartbeatFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "smf_pfcp_heartbeat_failures_total",
+		Help: "Number of the PFCP Heartbeat Requests to a UPF without a response.",
+	}, []string{"upf"})
+
+	SbiClientRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "smf_sbi_client_request_duration_seconds",
+		Help: "Time of the SBI requests sent by the SMF by the target NF type and the HTTP status code, " +
+			"the status code is 0 if no response is received.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"target_nf", "status"})
+)
+
+// CountPduSessionProcedure counts a PDU session procedure,
+// the 5GSM cause is only labeled on the failure
+func CountPduSessionProcedure(procedure string, success bool, cause uint8) {
+	if success {
+		PduSessionProcedures.WithLabelValues(procedure, ResultSuccess, "").Inc()
+		return
+	}
+	PduSessionProcedures.WithLabelValues(procedure, ResultFailure, strconv.Itoa(int(cause))).Inc()
+}
+
+// ObservePfcpRequest records a PFCP request which took the duration with the number of retransmissions,
+// timedOut reports that no response is received
+func ObservePfcpRequest(upf, messageType string, duration time.Duration, retransmissions int, timedOut bool) {
+	PfcpRequestDuration.WithLabelValues(upf, messageType).Observe(duration.Seconds())
+	if retransmissions > 0 {
+		PfcpRequestRetransmissions.WithLabelValues(upf, messageType).Add(float64(retransmissions))
+	}
+	if timedOut {
+		PfcpRequestTimeouts.WithLabelValues(upf, messageType).Inc()
+	}
+}
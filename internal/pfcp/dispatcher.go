@@ -1,13 +1,16 @@
 package pfcp
 
 import (
+	"context"
+
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpUdp"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/internal/pfcp/handler"
 )
 
-func Dispatch(msg *pfcpUdp.Message) {
+// Dispatch handles the PFCP request, the procedures triggered by the request are traced as children of ctx
+func Dispatch(ctx context.Context, msg *pfcpUdp.Message) {
 	switch msg.PfcpMessage.Header.MessageType {
 	case pfcp.PFCP_HEARTBEAT_REQUEST:
 		handler.HandlePfcpHeartbeatRequest(msg)
@@ -20,11 +23,11 @@ func Dispatch(msg *pfcpUdp.Message) {
 	case pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST:
 		handler.HandlePfcpAssociationReleaseRequest(msg)
 	case pfcp.PFCP_NODE_REPORT_REQUEST:
-		handler.HandlePfcpNodeReportRequest(msg)
+		handler.HandlePfcpNodeReportRequest(ctx, msg)
 	case pfcp.PFCP_SESSION_SET_DELETION_REQUEST:
 		handler.HandlePfcpSessionSetDeletionRequest(msg)
 	case pfcp.PFCP_SESSION_REPORT_REQUEST:
-		handler.HandlePfcpSessionReportRequest(msg)
+		handler.HandlePfcpSessionReportRequest(ctx, msg)
 	default:
 		logger.PfcpLog.Errorf("Unknown PFCP message type: %d", msg.PfcpMessage.Header.MessageType)
 		return
@@ -1,7 +1,11 @@
 package handler
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/pfcp"
@@ -10,6 +14,7 @@ import (
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 	pfcp_message "github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/internal/pfcp/udp"
 	"github.com/free5gc/smf/pkg/service"
 )
 
@@ -18,8 +23,10 @@ func HandlePfcpHeartbeatRequest(msg *pfcpUdp.Message) {
 	pfcp_message.SendHeartbeatResponse(msg.RemoteAddr, h.SequenceNumber)
 }
 
+// HandlePfcpPfdManagementRequest ignores the request, the PFDs are only provisioned by the CP function
+// (TS 29.244 6.2.5)
 func HandlePfcpPfdManagementRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP PFD Management Request handling is not implemented")
+	logger.PfcpLog.Warnf("PFCP PFD Management Request from the UP function is not expected")
 }
 
 func HandlePfcpAssociationSetupRequest(msg *pfcpUdp.Message) {
@@ -38,6 +45,7 @@ func HandlePfcpAssociationSetupRequest(msg *pfcpUdp.Message) {
 		logger.PfcpLog.Errorf("can't find UPF[%s]", nodeID.ResolveNodeIdToIp().String())
 		return
 	}
+	upf.UPFunctionFeatures = req.UPFunctionFeatures
 
 	// Response with PFCP Association Setup Response
 	cause := pfcpType.Cause{
@@ -47,7 +55,77 @@ func HandlePfcpAssociationSetupRequest(msg *pfcpUdp.Message) {
 }
 
 func HandlePfcpAssociationUpdateRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Association Update Request handling is not implemented")
+	var cause pfcpType.Cause
+
+	req := msg.PfcpMessage.Body.(pfcp.PFCPAssociationUpdateRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	if req.NodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Association Update Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	nodeIDtoIP := req.NodeID.ResolveNodeIdToIp().String()
+	logger.PfcpLog.Infof("Handle PFCP Association Update Request with NodeID[%s]", nodeIDtoIP)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*req.NodeID)
+	if upf == nil {
+		logger.PfcpLog.Errorf("can't find UPF[%s]", nodeIDtoIP)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	if err := upf.IsAssociated(); err != nil {
+		logger.PfcpLog.Warnf("PFCP Association Update Request rejected: %+v", err)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	if req.UPFunctionFeatures != nil {
+		upf.UPFunctionFeatures = req.UPFunctionFeatures
+	}
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	// NOTE: free5gc/pfcp can't decode the SARR flag of the PFCP Association Release Request IE,
+	// the SMF starts the graceful release whenever the UPF includes it.
+	if req.PFCPAssociationReleaseRequest != nil {
+		period, err := gracefulReleasePeriod(req.GracefulReleasePeriod)
+		if err != nil {
+			logger.PfcpLog.Warnf("Invalid Graceful Release Period from UPF[%s]: %+v", nodeIDtoIP, err)
+		}
+		service.GetApp().Processor().HandleUPFGracefulRelease(upf, period)
+	}
+}
+
+// gracefulReleasePeriod decodes the Graceful Release Period IE (TS 29.244 8.2.78), the period is
+// negative if it's infinite and zero if the IE is absent or the timer is stopped
+func gracefulReleasePeriod(ie *pfcpType.GracefulReleasePeriod) (time.Duration, error) {
+	if ie == nil {
+		return 0, nil
+	}
+	if len(ie.GracefulReleasePerioddata) < 1 {
+		return 0, fmt.Errorf("graceful release period is empty")
+	}
+	unit, value := ie.GracefulReleasePerioddata[0]>>5, time.Duration(ie.GracefulReleasePerioddata[0]&0x1f)
+	switch unit {
+	case 0:
+		return value * 2 * time.Second, nil
+	case 2:
+		return value * 10 * time.Minute, nil
+	case 3:
+		return value * time.Hour, nil
+	case 4:
+		return value * 10 * time.Hour, nil
+	case 7:
+		return -1, nil
+	default:
+		// The other timer units are interpreted as 1 minute
+		return value * time.Minute, nil
+	}
 }
 
 func HandlePfcpAssociationReleaseRequest(msg *pfcpUdp.Message) {
@@ -66,19 +144,201 @@ func HandlePfcpAssociationReleaseRequest(msg *pfcpUdp.Message) {
 	pfcp_message.SendPfcpAssociationReleaseResponse(msg.RemoteAddr, cause)
 }
 
-func HandlePfcpNodeReportRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Node Report Request handling is not implemented")
+func HandlePfcpNodeReportRequest(ctx context.Context, msg *pfcpUdp.Message) {
+	var cause pfcpType.Cause
+
+	req := msg.PfcpMessage.Body.(udp.NodeReportRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	if req.NodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Node Report Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	nodeIDtoIP := req.NodeID.ResolveNodeIdToIp().String()
+	logger.PfcpLog.Infof("Handle PFCP Node Report Request with NodeID[%s]", nodeIDtoIP)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*req.NodeID)
+	if upf == nil {
+		logger.PfcpLog.Errorf("can't find UPF[%s]", nodeIDtoIP)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	if err := upf.IsAssociated(); err != nil {
+		logger.PfcpLog.Warnf("PFCP Node Report Request rejected: %+v", err)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	if req.NodeReportType == nil {
+		logger.PfcpLog.Errorln("PFCP Node Report Request needs NodeReportType")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	var remotePeers, recoveredPeers []net.IP
+	if req.NodeReportType.Upfr {
+		if req.UserPlanePathFailureReport == nil {
+			logger.PfcpLog.Errorln("PFCP Node Report Request needs UserPlanePathFailureReport")
+			cause.CauseValue = pfcpType.CauseConditionalIeMissing
+			pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+			return
+		}
+		var err error
+		if remotePeers, err = parseRemoteGTPUPeers(
+			req.UserPlanePathFailureReport.UserPlanePathFailureReportdata); err != nil {
+			logger.PfcpLog.Errorf("Invalid UserPlanePathFailureReport: %+v", err)
+			cause.CauseValue = pfcpType.CauseMandatoryIeIncorrect
+			pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+			return
+		}
+	}
+
+	if req.Uprr {
+		if req.UserPlanePathRecoveryReport == nil {
+			logger.PfcpLog.Errorln("PFCP Node Report Request needs UserPlanePathRecoveryReport")
+			cause.CauseValue = pfcpType.CauseConditionalIeMissing
+			pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+			return
+		}
+		var err error
+		if recoveredPeers, err = parseRemoteGTPUPeers(req.UserPlanePathRecoveryReport); err != nil {
+			logger.PfcpLog.Errorf("Invalid UserPlanePathRecoveryReport: %+v", err)
+			cause.CauseValue = pfcpType.CauseMandatoryIeIncorrect
+			pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+			return
+		}
+	}
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	if len(remotePeers) != 0 {
+		service.GetApp().Processor().HandleUserPlanePathFailure(ctx, upf, remotePeers)
+	}
+	if len(recoveredPeers) != 0 {
+		service.GetApp().Processor().HandleUserPlanePathRecovery(upf, recoveredPeers)
+	}
+}
+
+// IE type of Remote GTP-U Peer (TS 29.244 8.1.2)
+const ieTypeRemoteGTPUPeer = 103
+
+// parseRemoteGTPUPeers returns the addresses of the Remote GTP-U Peer IEs
+// grouped in a User Plane Path Failure Report or a User Plane Path Recovery Report IE
+func parseRemoteGTPUPeers(data []byte) ([]net.IP, error) {
+	var peers []net.IP
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("inadequate IE header length: %d", len(data))
+		}
+		ieType := binary.BigEndian.Uint16(data[0:2])
+		ieLen := int(binary.BigEndian.Uint16(data[2:4]))
+		if len(data) < 4+ieLen {
+			return nil, fmt.Errorf("inadequate IE length: %d", len(data))
+		}
+		value := data[4 : 4+ieLen]
+		data = data[4+ieLen:]
+
+		if ieType != ieTypeRemoteGTPUPeer || ieLen == 0 {
+			continue
+		}
+		// Only the addresses are decoded, the optional fields of newer releases are ignored
+		addrLen := 1
+		if value[0]&pfcpType.BitMask2 != 0 {
+			addrLen += net.IPv4len
+		}
+		if value[0]&pfcpType.BitMask1 != 0 {
+			addrLen += net.IPv6len
+		}
+		if len(value) < addrLen {
+			return nil, fmt.Errorf("inadequate Remote GTP-U Peer length: %d", len(value))
+		}
+		var peer pfcpType.RemoteGTPUPeer
+		if err := peer.UnmarshalBinary(value[:addrLen]); err != nil {
+			return nil, err
+		}
+		if peer.V4 {
+			peers = append(peers, peer.Ipv4Address)
+		} else {
+			peers = append(peers, peer.Ipv6Address)
+		}
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("no Remote GTP-U Peer")
+	}
+	return peers, nil
 }
 
 func HandlePfcpSessionSetDeletionRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Session Set Deletion Request handling is not implemented")
+	var cause pfcpType.Cause
+
+	req := msg.PfcpMessage.Body.(pfcp.PFCPSessionSetDeletionRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	if req.NodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Session Set Deletion Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	nodeIDtoIP := req.NodeID.ResolveNodeIdToIp().String()
+	logger.PfcpLog.Infof("Handle PFCP Session Set Deletion Request with NodeID[%s]", nodeIDtoIP)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*req.NodeID)
+	if upf == nil {
+		logger.PfcpLog.Errorf("can't find UPF[%s]", nodeIDtoIP)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	if err := upf.IsAssociated(); err != nil {
+		logger.PfcpLog.Warnf("PFCP Session Set Deletion Request rejected: %+v", err)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	// The FQ-CSIDs are decoded into the FQ-CSID fields in the order they are received,
+	// the node of each FQ-CSID is identified by its address
+	var fqCSIDs []*smf_context.FQCSID
+	for _, ie := range []*pfcpType.FQCSID{
+		req.SGWCFQCSID, req.PGWCFQCSID, req.SGWUFQCSID, req.PGWUFQCSID,
+		req.TWANFQCSID, req.EPDGFQCSID, req.MMEFQCSID,
+	} {
+		if ie == nil {
+			continue
+		}
+		fqCSID, err := smf_context.ParseFQCSID(ie.FQCSIDdata)
+		if err != nil {
+			logger.PfcpLog.Errorf("PFCP Session Set Deletion Request with invalid FQ-CSID: %+v", err)
+			cause.CauseValue = pfcpType.CauseMandatoryIeIncorrect
+			pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+			return
+		}
+		fqCSIDs = append(fqCSIDs, fqCSID)
+	}
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	if len(fqCSIDs) == 0 {
+		logger.PfcpLog.Warnf("PFCP Session Set Deletion Request from UPF[%s] identifies no PDU session set",
+			nodeIDtoIP)
+		return
+	}
+	service.GetApp().Processor().ReleasePDUSessionSetsOfUPF(upf, fqCSIDs)
 }
 
 func HandlePfcpSessionSetDeletionResponse(msg *pfcpUdp.Message) {
 	logger.PfcpLog.Warnf("PFCP Session Set Deletion Response handling is not implemented")
 }
 
-func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
+func HandlePfcpSessionReportRequest(ctx context.Context, msg *pfcpUdp.Message) {
 	var cause pfcpType.Cause
 
 	req := msg.PfcpMessage.Body.(pfcp.PFCPSessionReportRequest)
@@ -95,6 +355,7 @@ func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
 
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(ctx)
 
 	upfNodeID := smContext.GetNodeIDByLocalSEID(SEID)
 	upfNodeIDtoIP := upfNodeID.ResolveNodeIdToIp()
@@ -128,66 +389,18 @@ func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
 		pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, cause, seqFromUPF, 0)
 	}
 
+	var updateBAR *pfcp.UpdateBARIEInPFCPSessionReportResponse
+	var dropBuffered bool
 	if smContext.UpCnxState == models.UpCnxState_DEACTIVATED {
-		if req.ReportType.Dldr {
-			downlinkDataReport := req.DownlinkDataReport
-
-			if downlinkDataReport.DownlinkDataServiceInformation != nil {
-				logger.PfcpLog.Warnf(
-					"PFCP Session Report Request DownlinkDataServiceInformation handling is not implemented")
-			}
-
-			n1n2Request := models.N1N2MessageTransferRequest{}
-
-			// TS 23.502 4.2.3.3 3a. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
-			if n2SmBuf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
-				logger.PduSessLog.Errorln("Build PDUSessionResourceSetupRequestTransfer failed:", err)
-			} else {
-				n1n2Request.BinaryDataN2Information = n2SmBuf
-			}
-
-			n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
-				PduSessionId: smContext.PDUSessionID,
-				// Temporarily assign SMF itself,
-				// TODO: TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
-				N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d",
-					smf_context.GetSelf().URIScheme,
-					smf_context.GetSelf().RegisterIPv4,
-					smf_context.GetSelf().SBIPort),
-				N2InfoContainer: &models.N2InfoContainer{
-					N2InformationClass: models.N2InformationClass_SM,
-					SmInfo: &models.N2SmInformation{
-						PduSessionId: smContext.PDUSessionID,
-						N2InfoContent: &models.N2InfoContent{
-							NgapIeType: models.AmfCommunicationNgapIeType_PDU_RES_SETUP_REQ,
-							NgapData: &models.RefToBinaryData{
-								ContentId: "N2SmInformation",
-							},
-						},
-						SNssai: smContext.SNssai,
-					},
-				},
-			}
-
-			ctx, _, errToken := smf_context.GetSelf().GetTokenCtx(models.ServiceName_NAMF_COMM, models.NrfNfManagementNfType_AMF)
-			if errToken != nil {
-				logger.PfcpLog.Warnf("Get NAMF_COMM context failed: %s", errToken)
-				return
-			}
-			rspData, err := service.GetApp().Consumer().
-				N1N2MessageTransfer(ctx, smContext.Supi, n1n2Request, smContext.CommunicationClientApiPrefix)
-			if err != nil {
-				logger.ConsumerLog.Warnf("Send N1N2Transfer failed: %s", err)
-				return
-			}
-
-			if rspData.Cause == models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE {
-				logger.PfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
-			}
-			if rspData.Cause == models.N1N2MessageTransferCause_UE_NOT_RESPONDING {
-				logger.PfcpLog.Warnf("%v", rspData.Cause)
-				// TODO: TS 23.502 4.2.3.3 3c. Failure indication
+		if req.ReportType.Dldr && !smContext.ShouldNotifyDownlinkData() {
+			// TS 23.502 4.2.3.3 3a. The UE is being paged or the DDN back-off is running
+			smContext.Log.Debugf("Downlink Data Report is not notified while paging or backing off")
+		} else if req.ReportType.Dldr {
+			var ddsi *pfcpType.DownlinkDataServiceInformation
+			if req.DownlinkDataReport != nil {
+				ddsi = req.DownlinkDataReport.DownlinkDataServiceInformation
 			}
+			updateBAR, dropBuffered = service.GetApp().Processor().NotifyDownlinkData(smContext, ddsi)
 		}
 	}
 
@@ -201,5 +414,10 @@ func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
 
 	// TS 23.502 4.2.3.3 2b. Send Data Notification Ack, SMF->UPF
 	cause.CauseValue = pfcpType.CauseRequestAccepted
-	pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, cause, seqFromUPF, remoteSEID)
+	if updateBAR != nil || dropBuffered {
+		pfcp_message.SendPfcpSessionReportResponseWithBuffering(msg.RemoteAddr, cause, seqFromUPF, remoteSEID,
+			updateBAR, dropBuffered)
+	} else {
+		pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, cause, seqFromUPF, remoteSEID)
+	}
 }
@@ -2,20 +2,29 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/require"
 
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/internal/pfcp/handler"
+	"github.com/free5gc/smf/internal/pfcp/pfcptest"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/pkg/factory"
+	"github.com/free5gc/smf/pkg/service"
 )
 
 type LogCapture struct {
@@ -46,7 +55,7 @@ func TestHandlePfcpManagementRequest(t *testing.T) {
 
 		logCaptureExp := &LogCapture{}
 		logger.Log.SetOutput(io.MultiWriter(logCaptureExp))
-		logger.PfcpLog.Warnf("PFCP PFD Management Request handling is not implemented")
+		logger.PfcpLog.Warnf("PFCP PFD Management Request from the UP function is not expected")
 		capturedLogsExp := re.FindStringSubmatch(logCaptureExp.String())
 		So(capturedLogs[2], ShouldEqual, capturedLogsExp[2])
 	})
@@ -142,24 +151,176 @@ func TestHandlePfcpAssociationSetupRequest(t *testing.T) {
 	})
 }
 
+type testApp struct {
+	service.SmfAppInterface
+	processor *processor.Processor
+}
+
+func (a *testApp) Processor() *processor.Processor {
+	return a.processor
+}
+
+// startTestPfcpServer starts the PFCP server and the processor used by the handlers, it returns the function
+// calling the handler with the request from the UPF and returning the response
+func startTestPfcpServer(t *testing.T) func(handle func(*pfcpUdp.Message), req *pfcp.Message) *pfcp.Message {
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.5").To4(),
+	}
+	smf_context.GetSelf().ListenAddr = "127.0.0.5"
+	udp.Run(nil)
+	t.Cleanup(func() {
+		require.NoError(t, udp.Server.Close())
+	})
+
+	p, err := processor.NewProcessor(nil)
+	require.NoError(t, err)
+	origApp := service.SMF
+	service.SMF = &testApp{processor: p}
+	t.Cleanup(func() {
+		service.SMF = origApp
+	})
+	smf_context.GetSelf().UserPlaneInformation = smf_context.NewUserPlaneInformation(
+		&factory.UserPlaneInformation{})
+
+	upf := pfcptest.NewUPF(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, nil)
+	var seq uint32
+	return func(handle func(*pfcpUdp.Message), req *pfcp.Message) *pfcp.Message {
+		// The responses are cached by the sequence number
+		seq++
+		req.Header.Version = pfcp.PfcpVersion
+		req.Header.SequenceNumber = seq
+		handle(pfcpUdp.NewMessage(upf.Addr(), req))
+		// The type of the response follows the type of the request
+		return upf.Next(t, req.Header.MessageType+1)
+	}
+}
+
 func TestHandlePfcpAssociationUpdateRequest(t *testing.T) {
+	request := startTestPfcpServer(t)
+	handle := func(req pfcp.PFCPAssociationUpdateRequest) *pfcpType.Cause {
+		rsp := request(handler.HandlePfcpAssociationUpdateRequest, &pfcp.Message{
+			Header: pfcp.Header{MessageType: pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST},
+			Body:   req,
+		})
+		So(rsp.Header.MessageType, ShouldEqual, pfcp.PFCP_ASSOCIATION_UPDATE_RESPONSE)
+		return rsp.Body.(pfcp.PFCPAssociationUpdateResponse).Cause
+	}
+
+	upfNodeID := &pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.201.0.1").To4(),
+	}
+
 	re := regexp.MustCompile(`(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{9}Z)(.*)`)
-	Convey("Test logger message", t, func() {
-		remoteAddr := &net.UDPAddr{}
-		testPfcpReq := &pfcp.Message{}
-		msg := pfcpUdp.NewMessage(remoteAddr, testPfcpReq)
+	Convey("Test if NodeID is Nil", t, func() {
 		logCapture := &LogCapture{}
 		logger.Log.SetOutput(io.MultiWriter(logCapture, logrus.StandardLogger().Out))
-		handler.HandlePfcpAssociationUpdateRequest(msg)
+		cause := handle(pfcp.PFCPAssociationUpdateRequest{})
 		capturedLogs := re.FindStringSubmatch(logCapture.String())
 
 		logCaptureExp := &LogCapture{}
 		logger.Log.SetOutput(io.MultiWriter(logCaptureExp))
-		logger.PfcpLog.Warnf("PFCP Association Update Request handling is not implemented")
+		logger.PfcpLog.Errorln("PFCP Association Update Request needs NodeID")
 		capturedLogsExp := re.FindStringSubmatch(logCaptureExp.String())
 		So(capturedLogs[2], ShouldEqual, capturedLogsExp[2])
+		So(cause.CauseValue, ShouldEqual, pfcpType.CauseMandatoryIeMissing)
+	})
+
+	Convey("Test if UPF is not associated", t, func() {
+		cause := handle(pfcp.PFCPAssociationUpdateRequest{NodeID: upfNodeID})
+		So(cause.CauseValue, ShouldEqual, pfcpType.CauseNoEstablishedPfcpAssociation)
+	})
+
+	upf := smf_context.NewUPF(upfNodeID, nil)
+	defer smf_context.RemoveUPFNodeByNodeID(*upfNodeID)
+	upf.AssociationContext, upf.CancelAssociation = context.WithCancel(context.Background())
+	defer upf.CancelAssociation()
+
+	Convey("Test if UP function features are updated", t, func() {
+		features := &pfcpType.UPFunctionFeatures{SupportedFeatures: pfcpType.UpFunctionFeaturesPfdm}
+		cause := handle(pfcp.PFCPAssociationUpdateRequest{NodeID: upfNodeID, UPFunctionFeatures: features})
+		So(cause.CauseValue, ShouldEqual, pfcpType.CauseRequestAccepted)
+		So(upf.UPFunctionFeatures, ShouldEqual, features)
+		So(upf.IsInGracefulRelease(), ShouldBeFalse)
+	})
+
+	Convey("Test if UPF requests the release in the graceful release period", t, func() {
+		cause := handle(pfcp.PFCPAssociationUpdateRequest{
+			NodeID:                        upfNodeID,
+			PFCPAssociationReleaseRequest: &pfcp.PFCPAssociationReleaseRequest{},
+			// 1 minute
+			GracefulReleasePeriod: &pfcpType.GracefulReleasePeriod{GracefulReleasePerioddata: []byte{0x21}},
+		})
+		So(cause.CauseValue, ShouldEqual, pfcpType.CauseRequestAccepted)
+
+		// The UPF keeps the association until the graceful release period expires
+		So(upf.IsInGracefulRelease(), ShouldBeTrue)
+		remaining, expires := upf.GracefulReleaseRemaining()
+		So(expires, ShouldBeTrue)
+		So(remaining, ShouldBeBetweenOrEqual, time.Minute-time.Second, time.Minute)
+		So(upf.IsAssociated(), ShouldBeNil)
 	})
 }
 
 // func TestHandlePfcpAssociationReleaseRequest(t *testing.T) {
 // }
+
+func TestHandlePfcpNodeReportRequest(t *testing.T) {
+	request := startTestPfcpServer(t)
+	handle := func(req udp.NodeReportRequest) *pfcpType.Cause {
+		rsp := request(func(msg *pfcpUdp.Message) {
+			handler.HandlePfcpNodeReportRequest(context.Background(), msg)
+		}, &pfcp.Message{
+			Header: pfcp.Header{MessageType: pfcp.PFCP_NODE_REPORT_REQUEST},
+			Body:   req,
+		})
+		So(rsp.Header.MessageType, ShouldEqual, pfcp.PFCP_NODE_REPORT_RESPONSE)
+		return rsp.Body.(pfcp.PFCPNodeReportResponse).Cause
+	}
+
+	upfNodeID := &pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.201.0.2").To4(),
+	}
+	upf := smf_context.NewUPF(upfNodeID, nil)
+	defer smf_context.RemoveUPFNodeByNodeID(*upfNodeID)
+	upf.AssociationContext, upf.CancelAssociation = context.WithCancel(context.Background())
+	defer upf.CancelAssociation()
+
+	// Remote GTP-U Peer IEs of 10.201.0.3 and 10.201.0.4
+	remoteGTPUPeers := []byte{
+		0, 103, 0, 5, 0x02, 10, 201, 0, 3,
+		0, 103, 0, 5, 0x02, 10, 201, 0, 4,
+	}
+	peer1, peer2 := net.ParseIP("10.201.0.3").To4(), net.ParseIP("10.201.0.4").To4()
+
+	Convey("Test if User Plane Path Recovery Report is missing", t, func() {
+		upf.SetPathFailure(peer1)
+		cause := handle(udp.NodeReportRequest{
+			PFCPNodeReportRequest: pfcp.PFCPNodeReportRequest{
+				NodeID:         upfNodeID,
+				NodeReportType: &pfcpType.NodeReportType{},
+			},
+			Uprr: true,
+		})
+		So(cause.CauseValue, ShouldEqual, pfcpType.CauseConditionalIeMissing)
+		So(upf.IsPathFailed(peer1), ShouldBeTrue)
+	})
+
+	Convey("Test if user plane paths recover", t, func() {
+		upf.SetPathFailure(peer1)
+		upf.SetPathFailure(peer2)
+		cause := handle(udp.NodeReportRequest{
+			PFCPNodeReportRequest: pfcp.PFCPNodeReportRequest{
+				NodeID:         upfNodeID,
+				NodeReportType: &pfcpType.NodeReportType{},
+			},
+			Uprr:                        true,
+			UserPlanePathRecoveryReport: remoteGTPUPeers,
+		})
+		So(cause.CauseValue, ShouldEqual, pfcpType.CauseRequestAccepted)
+		So(upf.IsPathFailed(peer1), ShouldBeFalse)
+		So(upf.IsPathFailed(peer2), ShouldBeFalse)
+	})
+}
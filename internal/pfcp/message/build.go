@@ -4,10 +4,12 @@ import (
 	"net"
 	"time"
 
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
 func BuildPfcpAssociationSetupRequest() (pfcp.PFCPAssociationSetupRequest, error) {
@@ -44,6 +46,16 @@ func BuildPfcpAssociationSetupResponse(cause pfcpType.Cause) (pfcp.PFCPAssociati
 	return msg, nil
 }
 
+func BuildPfcpAssociationUpdateResponse(cause pfcpType.Cause) (pfcp.PFCPAssociationUpdateResponse, error) {
+	msg := pfcp.PFCPAssociationUpdateResponse{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
 func BuildPfcpAssociationReleaseRequest() (pfcp.PFCPAssociationReleaseRequest, error) {
 	msg := pfcp.PFCPAssociationReleaseRequest{}
 
@@ -62,6 +74,84 @@ func BuildPfcpAssociationReleaseResponse(cause pfcpType.Cause) (pfcp.PFCPAssocia
 	return msg, nil
 }
 
+// BuildPfcpPfdManagementRequest provisions the PFDs of the applications and removes all the PFDs
+// of the removed applications, which are sent without PFD context (TS 29.244 6.2.5.2).
+// NOTE: free5gc/pfcp holds a single PFD context per application, so the PFDs of an application
+// are all carried in the same PFD context.
+func BuildPfcpPfdManagementRequest(
+	pfdDatas []*factory.PfdDataForApp,
+	removedAppIDs []string,
+) (PfdManagementRequest, error) {
+	msg := PfdManagementRequest{}
+
+	for _, pfdData := range pfdDatas {
+		var pfdContents []pfcpType.PFDContents
+		for _, pfd := range pfdData.Pfds {
+			for _, flowDesc := range pfd.FlowDescriptions {
+				pfdContents = append(pfdContents, pfcpType.PFDContents{FlowDescription: flowDesc})
+			}
+			for _, url := range pfd.Urls {
+				pfdContents = append(pfdContents, pfcpType.PFDContents{URL: url})
+			}
+			for _, domainName := range pfd.DomainNames {
+				pfdContents = append(pfdContents, pfcpType.PFDContents{DomainName: domainName})
+			}
+		}
+
+		appIDsPFDs := pfcp.ApplicationIDsPFDs{
+			ApplicationID: pfcpType.ApplicationID{
+				ApplicationIdentifier: []byte(pfdData.AppID),
+			},
+		}
+		if len(pfdContents) != 0 {
+			appIDsPFDs.PFD = &pfcp.PFD{
+				PFDContents: pfdContents,
+			}
+		}
+		msg.ApplicationIDsPFDs = append(msg.ApplicationIDsPFDs, appIDsPFDs)
+	}
+
+	for _, appID := range removedAppIDs {
+		msg.ApplicationIDsPFDs = append(msg.ApplicationIDsPFDs, pfcp.ApplicationIDsPFDs{
+			ApplicationID: pfcpType.ApplicationID{
+				ApplicationIdentifier: []byte(appID),
+			},
+		})
+	}
+
+	return msg, nil
+}
+
+// NOTE: free5gc/pfcp can't marshal the FQ-CSID IE yet, so the request only carries the Node ID
+// and asks the UP function to delete all the PFCP sessions set up by this SMF.
+func BuildPfcpSessionSetDeletionRequest() (pfcp.PFCPSessionSetDeletionRequest, error) {
+	msg := pfcp.PFCPSessionSetDeletionRequest{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	return msg, nil
+}
+
+func BuildPfcpSessionSetDeletionResponse(cause pfcpType.Cause) (pfcp.PFCPSessionSetDeletionResponse, error) {
+	msg := pfcp.PFCPSessionSetDeletionResponse{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
+func BuildPfcpNodeReportResponse(cause pfcpType.Cause) (pfcp.PFCPNodeReportResponse, error) {
+	msg := pfcp.PFCPNodeReportResponse{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
 func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 	createPDR := new(pfcp.CreatePDR)
 
@@ -113,7 +203,23 @@ func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 	return createPDR
 }
 
-func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
+// pdnType converts the NAS PDU session type to the PFCP PDN type
+func pdnType(pduSessType uint8) uint8 {
+	switch pduSessType {
+	case nasMessage.PDUSessionTypeIPv6:
+		return pfcpType.PDNTypeIpv6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return pfcpType.PDNTypeIpv4v6
+	case nasMessage.PDUSessionTypeUnstructured:
+		return pfcpType.PDNTypeNonIp
+	case nasMessage.PDUSessionTypeEthernet:
+		return pfcpType.PDNTypeEthernet
+	default:
+		return pfcpType.PDNTypeIpv4
+	}
+}
+
+func farToCreateFAR(far *context.FAR, upf *context.UPF) *pfcp.CreateFAR {
 	createFAR := new(pfcp.CreateFAR)
 
 	createFAR.FARID = new(pfcpType.FARID)
@@ -143,7 +249,8 @@ func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
 			NetworkInstance:      far.ForwardingParameters.NetworkInstance,
 			OuterHeaderCreation:  far.ForwardingParameters.OuterHeaderCreation,
 		}
-		if far.ForwardingParameters.ForwardingPolicyID != "" {
+		if far.ForwardingParameters.ForwardingPolicyID != "" &&
+			upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesTrst) {
 			createFAR.ForwardingParameters.ForwardingPolicy = &pfcpType.ForwardingPolicy{
 				ForwardingPolicyIdentifierLength: uint8(len(far.ForwardingParameters.ForwardingPolicyID)),
 				ForwardingPolicyIdentifier:       []byte(far.ForwardingParameters.ForwardingPolicyID),
@@ -154,19 +261,40 @@ func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
 	return createFAR
 }
 
-func barToCreateBAR(bar *context.BAR) *pfcp.CreateBAR {
+func barToCreateBAR(bar *context.BAR, upf *context.UPF) *pfcp.CreateBAR {
 	createBAR := new(pfcp.CreateBAR)
 
 	createBAR.BARID = new(pfcpType.BARID)
 	createBAR.BARID.BarIdValue = bar.BARID
 
-	createBAR.DownlinkDataNotificationDelay = new(pfcpType.DownlinkDataNotificationDelay)
+	if upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesDdnd) {
+		createBAR.DownlinkDataNotificationDelay = &pfcpType.DownlinkDataNotificationDelay{
+			DelayValue: bar.DownlinkDataNotificationDelay.DelayValue,
+		}
+	}
 
-	// createBAR.SuggestedBufferingPacketsCount = new(pfcpType.SuggestedBufferingPacketsCount)
+	createBAR.SuggestedBufferingPacketsCount = bar.SuggestedBufferingPacketsCount
 
 	return createBAR
 }
 
+func barToUpdateBAR(bar *context.BAR, upf *context.UPF) *pfcp.UpdateBARPFCPSessionModificationRequest {
+	updateBAR := new(pfcp.UpdateBARPFCPSessionModificationRequest)
+
+	updateBAR.BARID = new(pfcpType.BARID)
+	updateBAR.BARID.BarIdValue = bar.BARID
+
+	if upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesDdnd) {
+		updateBAR.DownlinkDataNotificationDelay = &pfcpType.DownlinkDataNotificationDelay{
+			DelayValue: bar.DownlinkDataNotificationDelay.DelayValue,
+		}
+	}
+
+	updateBAR.SuggestedBufferingPacketsCount = bar.SuggestedBufferingPacketsCount
+
+	return updateBAR
+}
+
 func qerToCreateQER(qer *context.QER) *pfcp.CreateQER {
 	createQER := new(pfcp.CreateQER)
 
@@ -181,6 +309,20 @@ func qerToCreateQER(qer *context.QER) *pfcp.CreateQER {
 	return createQER
 }
 
+func qerToUpdateQER(qer *context.QER) *pfcp.UpdateQER {
+	updateQER := new(pfcp.UpdateQER)
+
+	updateQER.QERID = new(pfcpType.QERID)
+	updateQER.QERID.QERID = qer.QERID
+	updateQER.GateStatus = qer.GateStatus
+
+	updateQER.QoSFlowIdentifier = &qer.QFI
+	updateQER.MaximumBitrate = qer.MBR
+	updateQER.GuaranteedBitrate = qer.GBR
+
+	return updateQER
+}
+
 func urrToCreateURR(urr *context.URR) *pfcp.CreateURR {
 	createURR := new(pfcp.CreateURR)
 
@@ -278,7 +420,7 @@ func pdrToUpdatePDR(pdr *context.PDR) *pfcp.UpdatePDR {
 	return updatePDR
 }
 
-func farToUpdateFAR(far *context.FAR) *pfcp.UpdateFAR {
+func farToUpdateFAR(far *context.FAR, upf *context.UPF) *pfcp.UpdateFAR {
 	updateFAR := new(pfcp.UpdateFAR)
 
 	updateFAR.FARID = new(pfcpType.FARID)
@@ -302,10 +444,12 @@ func farToUpdateFAR(far *context.FAR) *pfcp.UpdateFAR {
 			NetworkInstance:      far.ForwardingParameters.NetworkInstance,
 			OuterHeaderCreation:  far.ForwardingParameters.OuterHeaderCreation,
 			PFCPSMReqFlags: &pfcpType.PFCPSMReqFlags{
-				Sndem: far.ForwardingParameters.SendEndMarker,
+				Sndem: far.ForwardingParameters.SendEndMarker &&
+					upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesEmpu),
 			},
 		}
-		if far.ForwardingParameters.ForwardingPolicyID != "" {
+		if far.ForwardingParameters.ForwardingPolicyID != "" &&
+			upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesTrst) {
 			updateFAR.UpdateForwardingParameters.ForwardingPolicy = &pfcpType.ForwardingPolicy{
 				ForwardingPolicyIdentifierLength: uint8(len(far.ForwardingParameters.ForwardingPolicyID)),
 				ForwardingPolicyIdentifier:       []byte(far.ForwardingParameters.ForwardingPolicyID),
@@ -367,6 +511,22 @@ func urrToUpdateURR(urr *context.URR) *pfcp.UpdateURR {
 	return updateURR
 }
 
+// cpFSEID returns the CP F-SEID of the PFCP session with the IPv4 or IPv6 address of the SMF N4 interface
+func cpFSEID(localSEID uint64) *pfcpType.FSEID {
+	fseid := &pfcpType.FSEID{
+		Seid: localSEID,
+	}
+	externalIP := context.GetSelf().ExternalIP()
+	if ipv4 := externalIP.To4(); ipv4 != nil {
+		fseid.V4 = true
+		fseid.Ipv4Address = ipv4
+	} else if externalIP != nil {
+		fseid.V6 = true
+		fseid.Ipv6Address = externalIP
+	}
+	return fseid
+}
+
 func BuildPfcpSessionEstablishmentRequest(
 	upNodeID pfcpType.NodeID,
 	upN4Addr string,
@@ -380,18 +540,14 @@ func BuildPfcpSessionEstablishmentRequest(
 	msg := pfcp.PFCPSessionEstablishmentRequest{}
 
 	msg.NodeID = &context.GetSelf().CPNodeID
+	// the optional IEs of the UP function features the UPF does not support are skipped
+	upf := context.RetrieveUPFNodeByNodeID(upNodeID)
 
-	isv4 := context.GetSelf().ExternalIP().To4() != nil
 	nodeIDtoIP := upNodeID.ResolveNodeIdToIp().String()
 
 	localSEID := smContext.PFCPContext[nodeIDtoIP].LocalSEID
 
-	msg.CPFSEID = &pfcpType.FSEID{
-		V4:          isv4,
-		V6:          !isv4,
-		Seid:        localSEID,
-		Ipv4Address: context.GetSelf().ExternalIP().To4(),
-	}
+	msg.CPFSEID = cpFSEID(localSEID)
 
 	msg.CreatePDR = make([]*pfcp.CreatePDR, 0)
 	msg.CreateFAR = make([]*pfcp.CreateFAR, 0)
@@ -405,14 +561,14 @@ func BuildPfcpSessionEstablishmentRequest(
 
 	for _, far := range farList {
 		if far.State == context.RULE_INITIAL {
-			msg.CreateFAR = append(msg.CreateFAR, farToCreateFAR(far))
+			msg.CreateFAR = append(msg.CreateFAR, farToCreateFAR(far, upf))
 		}
 		far.State = context.RULE_CREATE
 	}
 
 	for _, bar := range barList {
 		if bar.State == context.RULE_INITIAL {
-			msg.CreateBAR = append(msg.CreateBAR, barToCreateBAR(bar))
+			msg.CreateBAR = append(msg.CreateBAR, barToCreateBAR(bar, upf))
 		}
 		bar.State = context.RULE_CREATE
 	}
@@ -443,7 +599,7 @@ func BuildPfcpSessionEstablishmentRequest(
 	}
 
 	msg.PDNType = &pfcpType.PDNType{
-		PdnType: pfcpType.PDNTypeIpv4,
+		PdnType: pdnType(smContext.SelectedPDUSessionType),
 	}
 
 	// for _, far := range msg.CreateFAR {
@@ -502,6 +658,7 @@ func BuildPfcpSessionModificationRequest(
 	urrList []*context.URR,
 ) (pfcp.PFCPSessionModificationRequest, error) {
 	msg := pfcp.PFCPSessionModificationRequest{}
+	upf := context.RetrieveUPFNodeByNodeID(upNodeID)
 
 	msg.UpdatePDR = make([]*pfcp.UpdatePDR, 0, 2)
 	msg.UpdateFAR = make([]*pfcp.UpdateFAR, 0, 2)
@@ -511,12 +668,7 @@ func BuildPfcpSessionModificationRequest(
 
 	localSEID := smContext.PFCPContext[nodeIDtoIP].LocalSEID
 
-	msg.CPFSEID = &pfcpType.FSEID{
-		V4:          true,
-		V6:          false,
-		Seid:        localSEID,
-		Ipv4Address: context.GetSelf().ExternalIP().To4(),
-	}
+	msg.CPFSEID = cpFSEID(localSEID)
 
 	for _, pdr := range pdrList {
 		switch pdr.State {
@@ -537,9 +689,9 @@ func BuildPfcpSessionModificationRequest(
 	for _, far := range farList {
 		switch far.State {
 		case context.RULE_INITIAL:
-			msg.CreateFAR = append(msg.CreateFAR, farToCreateFAR(far))
+			msg.CreateFAR = append(msg.CreateFAR, farToCreateFAR(far, upf))
 		case context.RULE_UPDATE:
-			msg.UpdateFAR = append(msg.UpdateFAR, farToUpdateFAR(far))
+			msg.UpdateFAR = append(msg.UpdateFAR, farToUpdateFAR(far, upf))
 		case context.RULE_REMOVE:
 			msg.RemoveFAR = append(msg.RemoveFAR, &pfcp.RemoveFAR{
 				FARID: &pfcpType.FARID{
@@ -551,14 +703,21 @@ func BuildPfcpSessionModificationRequest(
 	}
 
 	for _, bar := range barList {
-		if bar.State == context.RULE_INITIAL {
-			msg.CreateBAR = append(msg.CreateBAR, barToCreateBAR(bar))
+		switch bar.State {
+		case context.RULE_INITIAL:
+			msg.CreateBAR = append(msg.CreateBAR, barToCreateBAR(bar, upf))
+		case context.RULE_UPDATE:
+			msg.UpdateBAR = barToUpdateBAR(bar, upf)
 		}
+		bar.State = context.RULE_CREATE
 	}
 
 	for _, qer := range qerList {
-		if qer.State == context.RULE_INITIAL {
+		switch qer.State {
+		case context.RULE_INITIAL:
 			msg.CreateQER = append(msg.CreateQER, qerToCreateQER(qer))
+		case context.RULE_UPDATE:
+			msg.UpdateQER = append(msg.UpdateQER, qerToUpdateQER(qer))
 		}
 		qer.State = context.RULE_CREATE
 	}
@@ -649,6 +808,57 @@ func BuildPfcpSessionReportResponse(cause pfcpType.Cause) (pfcp.PFCPSessionRepor
 	return msg, nil
 }
 
+// PFCPSessionReportResponseWithBuffering is the PFCP Session Report Response with the Update BAR IE,
+// which can't be encoded with pfcp.PFCPSessionReportResponse
+type PFCPSessionReportResponseWithBuffering struct {
+	Cause        *pfcpType.Cause                              `tlv:"19"`
+	UpdateBAR    *pfcp.UpdateBARIEInPFCPSessionReportResponse `tlv:"12"`
+	SxSRRspFlags *pfcpType.PFCPSRRspFlags                     `tlv:"50"`
+}
+
+// BuildPfcpSessionReportResponseWithBuffering builds the PFCP Session Report Response updating the buffering
+// of the downlink data in the UPF, the buffered data is dropped if dropBuffered is true (TS 29.244 7.5.9)
+func BuildPfcpSessionReportResponseWithBuffering(
+	cause pfcpType.Cause,
+	updateBAR *pfcp.UpdateBARIEInPFCPSessionReportResponse,
+	dropBuffered bool,
+) (PFCPSessionReportResponseWithBuffering, error) {
+	msg := PFCPSessionReportResponseWithBuffering{}
+
+	msg.Cause = &cause
+	msg.UpdateBAR = updateBAR
+	if dropBuffered {
+		msg.SxSRRspFlags = &pfcpType.PFCPSRRspFlags{Drobu: true}
+	}
+
+	return msg, nil
+}
+
+// dlBufferingDurationUnits are the timer units of the DL Buffering Duration IE (TS 29.244 8.2.45)
+var dlBufferingDurationUnits = []struct {
+	unit     uint8
+	duration time.Duration
+}{
+	{0, 2 * time.Second},
+	{1, time.Minute},
+	{2, 10 * time.Minute},
+	{3, time.Hour},
+	{4, 10 * time.Hour},
+}
+
+// NewDLBufferingDuration encodes the duration with the smallest timer unit, rounding the duration up,
+// the duration is infinite if it exceeds the largest timer value
+func NewDLBufferingDuration(duration time.Duration) *pfcpType.DLBufferingDuration {
+	const maxTimerValue = 1<<5 - 1
+	for _, u := range dlBufferingDurationUnits {
+		value := (duration + u.duration - 1) / u.duration
+		if value <= maxTimerValue {
+			return &pfcpType.DLBufferingDuration{TimerUnit: u.unit, TimerValue: uint8(value)}
+		}
+	}
+	return &pfcpType.DLBufferingDuration{TimerUnit: 7}
+}
+
 func BuildPfcpHeartbeatRequest() (pfcp.HeartbeatRequest, error) {
 	msg := pfcp.HeartbeatRequest{}
 
@@ -3,10 +3,15 @@ package message_test
 import (
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
 	"github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/pfcp/message"
 	"github.com/free5gc/smf/internal/pfcp/udp"
@@ -153,6 +158,18 @@ func TestBuildPfcpAssociationReleaseResponse(t *testing.T) {
 	assert.Equal(t, cause, *rsp.Cause)
 }
 
+func TestBuildPfcpNodeReportResponse(t *testing.T) {
+	cause := pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+	rsp, err := message.BuildPfcpNodeReportResponse(cause)
+	if err != nil {
+		t.Errorf("TestBuildPfcpNodeReportResponse failed: %v", err)
+	}
+
+	assert.Equal(t, uint8(0), rsp.NodeID.NodeIdType)
+	assert.Equal(t, cause, *rsp.Cause)
+	assert.Nil(t, rsp.OffendingIE)
+}
+
 func TestBuildPfcpSessionEstablishmentRequest(t *testing.T) {
 	initSmfContext()
 	smctx := context.NewSMContext("imsi-208930000000001", 10)
@@ -191,6 +208,35 @@ func TestBuildPfcpSessionEstablishmentRequest(t *testing.T) {
 	assert.Equal(t, len(req2.CreateURR), 0)
 }
 
+func TestBuildPfcpSessionEstablishmentRequestEthernet(t *testing.T) {
+	initSmfContext()
+	smctx := context.NewSMContext("imsi-208930000000001", 11)
+	smctx.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+	smctx.PFCPContext["10.4.0.1"] = &context.PFCPSessionContext{}
+	pdrList, farList, barList, qerList, urrList := initRuleList()
+
+	mac, _ := net.ParseMAC("00-00-5e-00-53-01")
+	pdrList[0].PDI.EthernetPDUSessionInformation = true
+	pdrList[0].PDI.EthernetPacketFilter = &context.EthernetPacketFilter{
+		EthernetFilterID:      1,
+		Bidirectional:         true,
+		DestinationMACAddress: mac,
+		Ethertype:             0x0800,
+		CTag:                  &context.VLANTag{PCP: 5, VID: 10},
+	}
+
+	req, err := message.BuildPfcpSessionEstablishmentRequest(
+		*testNodeID, "10.4.0.1", smctx, pdrList, farList, barList, qerList, urrList)
+	assert.NoError(t, err)
+	assert.Equal(t, &pfcpType.PDNType{PdnType: pfcpType.PDNTypeEthernet}, req.PDNType)
+
+	pdi := req.CreatePDR[0].PDI
+	assert.Nil(t, pdi.UEIPAddress)
+	// The Ethernet IEs are encoded when the request is sent, the pfcp library can't marshal them
+	assert.Nil(t, pdi.EthernetPDUSessionInformation)
+	assert.Nil(t, pdi.EthernetPacketFilter)
+}
+
 // hsien
 func TestBuildPfcpSessionEstablishmentResponse(t *testing.T) {
 	initSmfContext()
@@ -219,7 +265,7 @@ func TestBuildPfcpSessionModificationRequest(t *testing.T) {
 
 	assert.Equal(t, context.RULE_CREATE, pdrList[0].State)
 	assert.Equal(t, context.RULE_CREATE, farList[0].State)
-	assert.Equal(t, context.RULE_INITIAL, barList[0].State)
+	assert.Equal(t, context.RULE_CREATE, barList[0].State)
 	assert.Equal(t, context.RULE_CREATE, qerList[0].State)
 	assert.Equal(t, context.RULE_CREATE, urrList[0].State)
 
@@ -258,6 +304,69 @@ func TestBuildPfcpSessionReportResponse(t *testing.T) {
 	assert.Equal(t, cause, *rsp.Cause)
 }
 
+func TestBuildPfcpSessionModificationRequestUpdateBAR(t *testing.T) {
+	initSmfContext()
+	smctx := context.NewSMContext("imsi-208930000000001", 11)
+	smctx.PFCPContext["10.4.0.1"] = &context.PFCPSessionContext{}
+	bar := &context.BAR{
+		BARID:                          1,
+		SuggestedBufferingPacketsCount: &pfcpType.SuggestedBufferingPacketsCount{PacketCountValue: 5},
+		State:                          context.RULE_UPDATE,
+	}
+
+	req, err := message.BuildPfcpSessionModificationRequest(
+		*testNodeID, "10.4.0.1", smctx, nil, nil, []*context.BAR{bar}, nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, req.CreateBAR)
+	assert.NotNil(t, req.UpdateBAR)
+	assert.Equal(t, uint8(1), req.UpdateBAR.BARID.BarIdValue)
+	assert.Equal(t, uint8(5), req.UpdateBAR.SuggestedBufferingPacketsCount.PacketCountValue)
+	assert.Equal(t, context.RULE_CREATE, bar.State)
+}
+
+func TestBuildPfcpSessionReportResponseWithBuffering(t *testing.T) {
+	cause := pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+	updateBAR := &pfcp.UpdateBARIEInPFCPSessionReportResponse{
+		BARID:               &pfcpType.BARID{BarIdValue: 1},
+		DLBufferingDuration: message.NewDLBufferingDuration(90 * time.Second),
+	}
+	rsp, err := message.BuildPfcpSessionReportResponseWithBuffering(cause, updateBAR, true)
+	assert.NoError(t, err)
+
+	buf, err := (&pfcp.Message{
+		Header: pfcp.Header{
+			Version:     pfcp.PfcpVersion,
+			S:           pfcp.SEID_PRESENT,
+			MessageType: pfcp.PFCP_SESSION_REPORT_RESPONSE,
+		},
+		Body: rsp,
+	}).Marshal()
+	assert.NoError(t, err)
+	// Cause, Update BAR with the BAR ID and the DL Buffering Duration, and the PFCPSRRsp-Flags
+	assert.Equal(t, []byte{
+		0x00, 0x13, 0x00, 0x01, 0x01,
+		0x00, 0x0c, 0x00, 0x0a, 0x00, 0x58, 0x00, 0x01, 0x01, 0x00, 0x2f, 0x00, 0x01, 0x22,
+		0x00, 0x32, 0x00, 0x01, 0x01,
+	}, buf[16:])
+}
+
+func TestNewDLBufferingDuration(t *testing.T) {
+	testCases := []struct {
+		duration time.Duration
+		expected pfcpType.DLBufferingDuration
+	}{
+		{10 * time.Second, pfcpType.DLBufferingDuration{TimerUnit: 0, TimerValue: 5}},
+		{61 * time.Second, pfcpType.DLBufferingDuration{TimerUnit: 0, TimerValue: 31}},
+		{90 * time.Second, pfcpType.DLBufferingDuration{TimerUnit: 1, TimerValue: 2}},
+		{2 * time.Hour, pfcpType.DLBufferingDuration{TimerUnit: 2, TimerValue: 12}},
+		{24 * time.Hour, pfcpType.DLBufferingDuration{TimerUnit: 3, TimerValue: 24}},
+		{400 * time.Hour, pfcpType.DLBufferingDuration{TimerUnit: 7}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, *message.NewDLBufferingDuration(tc.duration), tc.duration.String())
+	}
+}
+
 func TestBuildPfcpHeartbeatRequest(t *testing.T) {
 	rsq, err := message.BuildPfcpHeartbeatRequest()
 	if err != nil {
@@ -266,3 +375,229 @@ func TestBuildPfcpHeartbeatRequest(t *testing.T) {
 
 	assert.Equal(t, udp.ServerStartTime, rsq.RecoveryTimeStamp.RecoveryTimeStamp)
 }
+
+func TestBuildPfcpSessionSetDeletionRequest(t *testing.T) {
+	req, err := message.BuildPfcpSessionSetDeletionRequest()
+	if err != nil {
+		t.Errorf("TestBuildPfcpSessionSetDeletionRequest failed: %v", err)
+	}
+
+	assert.Equal(t, &context.GetSelf().CPNodeID, req.NodeID)
+}
+
+func TestBuildPfcpSessionSetDeletionResponse(t *testing.T) {
+	cause := pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+	rsp, err := message.BuildPfcpSessionSetDeletionResponse(cause)
+	if err != nil {
+		t.Errorf("TestBuildPfcpSessionSetDeletionResponse failed: %v", err)
+	}
+
+	assert.Equal(t, &context.GetSelf().CPNodeID, rsp.NodeID)
+	assert.Equal(t, cause, *rsp.Cause)
+}
+
+func TestBuildPfcpAssociationUpdateResponse(t *testing.T) {
+	cause := pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+	rsp, err := message.BuildPfcpAssociationUpdateResponse(cause)
+	if err != nil {
+		t.Errorf("TestBuildPfcpAssociationUpdateResponse failed: %v", err)
+	}
+
+	assert.Equal(t, &context.GetSelf().CPNodeID, rsp.NodeID)
+	assert.Equal(t, cause, *rsp.Cause)
+}
+
+func TestBuildPfcpPfdManagementRequest(t *testing.T) {
+	pfdDatas := []*factory.PfdDataForApp{
+		{
+			AppID: "app1",
+			Pfds: []factory.PfdContent{
+				{
+					PfdID:            "pfd1",
+					FlowDescriptions: []string{"permit out ip from 10.100.0.1 to assigned"},
+					Urls:             []string{"https://app1.example.com"},
+				},
+				{
+					PfdID:       "pfd2",
+					DomainNames: []string{"app1.example.org"},
+				},
+			},
+		},
+	}
+	req, err := message.BuildPfcpPfdManagementRequest(pfdDatas, []string{"app2"})
+	require.NoError(t, err)
+
+	buf, err := (&pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_PFD_MANAGEMENT_REQUEST,
+			SequenceNumber: 1,
+		},
+		Body: req,
+	}).Marshal()
+	require.NoError(t, err)
+
+	msg := &pfcp.Message{}
+	require.NoError(t, msg.Unmarshal(buf))
+	rcvReq, ok := msg.Body.(pfcp.PFCPPFDManagementRequest)
+	require.True(t, ok)
+	require.Len(t, rcvReq.ApplicationIDsPFDs, 2)
+	assert.Equal(t, []byte("app1"), rcvReq.ApplicationIDsPFDs[0].ApplicationID.ApplicationIdentifier)
+	require.NotNil(t, rcvReq.ApplicationIDsPFDs[0].PFD)
+	assert.Equal(t, []pfcpType.PFDContents{
+		{FlowDescription: "permit out ip from 10.100.0.1 to assigned"},
+		{URL: "https://app1.example.com"},
+		{DomainName: "app1.example.org"},
+	}, rcvReq.ApplicationIDsPFDs[0].PFD.PFDContents)
+	// The PFDs of the removed application are deleted by omitting the PFD context
+	assert.Equal(t, []byte("app2"), rcvReq.ApplicationIDsPFDs[1].ApplicationID.ApplicationIdentifier)
+	assert.Nil(t, rcvReq.ApplicationIDsPFDs[1].PFD)
+}
+
+// setSmfN4Address sets the Node ID and the N4 address of the SMF until the test ends
+func setSmfN4Address(t *testing.T, nodeID pfcpType.NodeID, externalAddr string) {
+	smfSelf := context.GetSelf()
+	origNodeID, origExternalAddr := smfSelf.CPNodeID, smfSelf.ExternalAddr
+	t.Cleanup(func() {
+		smfSelf.CPNodeID, smfSelf.ExternalAddr = origNodeID, origExternalAddr
+	})
+	smfSelf.CPNodeID = nodeID
+	smfSelf.ExternalAddr = externalAddr
+}
+
+func TestBuildPfcpSessionEstablishmentRequestIPv6(t *testing.T) {
+	initSmfContext()
+	setSmfN4Address(t, pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv6Address,
+		IP:         net.ParseIP("2001:db8::1"),
+	}, "2001:db8::1")
+
+	smctx := context.NewSMContext("imsi-208930000000001", 12)
+	smctx.PFCPContext["10.4.0.1"] = &context.PFCPSessionContext{LocalSEID: 12}
+	pdrList, farList, barList, qerList, _ := initRuleList()
+
+	req, err := message.BuildPfcpSessionEstablishmentRequest(
+		*testNodeID, "10.4.0.1", smctx, pdrList, farList, barList, qerList, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &pfcpType.FSEID{
+		V6:          true,
+		Seid:        12,
+		Ipv6Address: net.ParseIP("2001:db8::1"),
+	}, req.CPFSEID)
+
+	buf, err := (&pfcp.Message{
+		Header: pfcp.Header{
+			Version:     pfcp.PfcpVersion,
+			S:           pfcp.SEID_PRESENT,
+			MessageType: pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST,
+		},
+		Body: req,
+	}).Marshal()
+	assert.NoError(t, err)
+
+	decoded := pfcp.Message{}
+	assert.NoError(t, decoded.Unmarshal(buf))
+	decodedReq := decoded.Body.(pfcp.PFCPSessionEstablishmentRequest)
+	assert.Equal(t, uint8(pfcpType.NodeIdTypeIpv6Address), decodedReq.NodeID.NodeIdType)
+	assert.True(t, net.ParseIP("2001:db8::1").Equal(decodedReq.NodeID.IP))
+	assert.True(t, decodedReq.CPFSEID.V6)
+	assert.True(t, net.ParseIP("2001:db8::1").Equal(decodedReq.CPFSEID.Ipv6Address))
+}
+
+func TestBuildPfcpSessionEstablishmentRequestLarge(t *testing.T) {
+	initSmfContext()
+	setSmfN4Address(t, *testNodeID, "10.4.0.1")
+	smctx := context.NewSMContext("imsi-208930000000001", 13)
+	smctx.PFCPContext["10.4.0.1"] = &context.PFCPSessionContext{LocalSEID: 13}
+
+	// The PDRs of the ULCL and the PCC rules of a session
+	const numOfRules = 64
+	var pdrList []*context.PDR
+	var farList []*context.FAR
+	var qerList []*context.QER
+	var urrList []*context.URR
+	for i := 1; i <= numOfRules; i++ {
+		qer := &context.QER{
+			QERID: uint32(i),
+			State: context.RULE_INITIAL,
+			QFI:   pfcpType.QFI{QFI: uint8(i % 64)},
+			GateStatus: &pfcpType.GateStatus{
+				ULGate: pfcpType.GateOpen,
+				DLGate: pfcpType.GateOpen,
+			},
+			MBR: &pfcpType.MBR{ULMBR: 100000, DLMBR: 100000},
+		}
+		urr := &context.URR{
+			URRID:         uint32(i),
+			MeasureMethod: context.MesureMethodVol,
+			State:         context.RULE_INITIAL,
+		}
+		far := &context.FAR{
+			FARID: uint32(i),
+			State: context.RULE_INITIAL,
+			ApplyAction: pfcpType.ApplyAction{
+				Forw: true,
+			},
+			ForwardingParameters: &context.ForwardingParameters{
+				DestinationInterface: pfcpType.DestinationInterface{
+					InterfaceValue: pfcpType.DestinationInterfaceCore,
+				},
+				NetworkInstance: &pfcpType.NetworkInstance{NetworkInstance: "internet"},
+			},
+		}
+		pdr := &context.PDR{
+			PDRID:      uint16(i),
+			State:      context.RULE_INITIAL,
+			Precedence: uint32(i),
+			PDI: context.PDI{
+				SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceAccess},
+				LocalFTeid: &pfcpType.FTEID{
+					V4:          true,
+					Teid:        uint32(i),
+					Ipv4Address: net.ParseIP("10.4.0.1").To4(),
+				},
+				NetworkInstance: &pfcpType.NetworkInstance{NetworkInstance: "internet"},
+				SDFFilter: &pfcpType.SDFFilter{
+					Fd:                      true,
+					FlowDescription:         []byte("permit out ip from 10.60.0.1 to 192.168.100.0/24 1000-2000"),
+					LengthOfFlowDescription: uint16(len("permit out ip from 10.60.0.1 to 192.168.100.0/24 1000-2000")),
+				},
+			},
+			OuterHeaderRemoval: &pfcpType.OuterHeaderRemoval{
+				OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+			},
+			FAR: far,
+			QER: []*context.QER{qer},
+			URR: []*context.URR{urr},
+		}
+		pdrList = append(pdrList, pdr)
+		farList = append(farList, far)
+		qerList = append(qerList, qer)
+		urrList = append(urrList, urr)
+	}
+
+	req, err := message.BuildPfcpSessionEstablishmentRequest(
+		*testNodeID, "10.4.0.1", smctx, pdrList, farList, nil, qerList, urrList)
+	assert.NoError(t, err)
+
+	buf, err := (&pfcp.Message{
+		Header: pfcp.Header{
+			Version:     pfcp.PfcpVersion,
+			S:           pfcp.SEID_PRESENT,
+			MessageType: pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST,
+		},
+		Body: req,
+	}).Marshal()
+	assert.NoError(t, err)
+	assert.Greater(t, len(buf), pfcpUdp.PFCP_MAX_UDP_LEN)
+	assert.LessOrEqual(t, len(buf), factory.PfcpMaxMessageSize)
+
+	decoded := pfcp.Message{}
+	assert.NoError(t, decoded.Unmarshal(buf))
+	decodedReq := decoded.Body.(pfcp.PFCPSessionEstablishmentRequest)
+	assert.Len(t, decodedReq.CreatePDR, numOfRules)
+	assert.Len(t, decodedReq.CreateFAR, numOfRules)
+	assert.Len(t, decodedReq.CreateQER, numOfRules)
+	assert.Len(t, decodedReq.CreateURR, numOfRules)
+}
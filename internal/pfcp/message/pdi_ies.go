@@ -0,0 +1,179 @@
+package message
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/tlv"
+)
+
+// The Framed-Route, Framed-IPv6-Route, Ethernet PDU Session Information and Ethernet Packet Filter IEs
+// of the PDI can't be marshaled by the pfcp library, they are encoded here and appended to the PDIs
+// of the Create PDR and Update PDR IEs once the rest of the message is marshaled.
+
+// pdiIEsBody is the body of the PFCP Session Establishment or Modification Request whose PDIs
+// carry the IEs encoded by the SMF
+type pdiIEsBody struct {
+	body interface{}
+	// pdis are the PDIs with such IEs by the PDR ID
+	pdis map[uint16]*context.PDI
+}
+
+// withPDIIEs returns the message body encoding the PDI IEs of the PDRs which the pfcp library can't encode,
+// the body is returned as is if no PDR has such an IE
+func withPDIIEs(body interface{}, pdrList []*context.PDR) interface{} {
+	pdis := make(map[uint16]*context.PDI)
+	for _, pdr := range pdrList {
+		if pdr != nil && (pdr.PDI.FramedRoute != nil || pdr.PDI.EthernetPDUSessionInformation ||
+			pdr.PDI.EthernetPacketFilter != nil) {
+			pdis[pdr.PDRID] = &pdr.PDI
+		}
+	}
+	if len(pdis) == 0 {
+		return body
+	}
+	return pdiIEsBody{body: body, pdis: pdis}
+}
+
+func (b pdiIEsBody) MarshalBinary() ([]byte, error) {
+	buf, err := tlv.Marshal(b.body)
+	if err != nil {
+		return nil, err
+	}
+	ies, err := parseIEs(buf)
+	if err != nil {
+		return nil, err
+	}
+	for i, ie := range ies {
+		if ie.ieType != ieTypeCreatePDR && ie.ieType != ieTypeUpdatePDR {
+			continue
+		}
+		if ies[i].value, err = b.appendPDIIEs(ie.value); err != nil {
+			return nil, err
+		}
+	}
+	return marshalIEs(ies), nil
+}
+
+// appendPDIIEs appends the IEs of the PDR to its PDI
+func (b pdiIEsBody) appendPDIIEs(pdrValue []byte) ([]byte, error) {
+	ies, err := parseIEs(pdrValue)
+	if err != nil {
+		return nil, err
+	}
+	var pdi *context.PDI
+	for _, ie := range ies {
+		if ie.ieType == ieTypePDRID && len(ie.value) >= 2 {
+			pdi = b.pdis[binary.BigEndian.Uint16(ie.value)]
+		}
+	}
+	if pdi == nil {
+		return pdrValue, nil
+	}
+	pdiIEs, err := pdiIEsOf(pdi)
+	if err != nil {
+		return nil, err
+	}
+	for i, ie := range ies {
+		if ie.ieType == ieTypePDI {
+			ies[i].value = append(ie.value, marshalIEs(pdiIEs)...)
+		}
+	}
+	return marshalIEs(ies), nil
+}
+
+func pdiIEsOf(pdi *context.PDI) ([]rawIE, error) {
+	var ies []rawIE
+	if pdi.FramedRoute != nil {
+		ies = append(ies, framedRouteIE(pdi.FramedRoute))
+	}
+	if pdi.EthernetPDUSessionInformation {
+		// ETHI (TS 29.244 8.2.102)
+		ies = append(ies, rawIE{ieType: ieTypeEthernetPDUSessionInformation, value: []byte{0x01}})
+	}
+	if pdi.EthernetPacketFilter != nil {
+		ie, err := ethernetPacketFilterIE(pdi.EthernetPacketFilter)
+		if err != nil {
+			return nil, err
+		}
+		ies = append(ies, ie)
+	}
+	return ies, nil
+}
+
+// framedRouteIE encodes the route as the value of the Framed-Route (RFC 2865 5.22) or the Framed-IPv6-Route
+// (RFC 3162 2.5) RADIUS attribute, the unspecified gateway is the UE address (TS 29.244 8.2.109, 8.2.111)
+func framedRouteIE(route *net.IPNet) rawIE {
+	if route.IP.To4() != nil {
+		return rawIE{ieType: ieTypeFramedRoute, value: []byte(route.String() + " 0.0.0.0 1")}
+	}
+	return rawIE{ieType: ieTypeFramedIPv6Route, value: []byte(route.String() + " :: 1")}
+}
+
+// ethernetPacketFilterIE encodes the grouped Ethernet Packet Filter IE (TS 29.244 7.5.2.2-3)
+func ethernetPacketFilterIE(epf *context.EthernetPacketFilter) (rawIE, error) {
+	var ies []rawIE
+
+	if epf.EthernetFilterID != 0 {
+		// TS 29.244 8.2.98
+		ies = append(ies, rawIE{
+			ieType: ieTypeEthernetFilterID,
+			value:  binary.BigEndian.AppendUint32(nil, epf.EthernetFilterID),
+		})
+	}
+
+	if epf.Bidirectional {
+		// TS 29.244 8.2.99, BIDE
+		ies = append(ies, rawIE{ieType: ieTypeEthernetFilterProperties, value: []byte{0x01}})
+	}
+
+	if epf.SourceMACAddress != nil || epf.DestinationMACAddress != nil {
+		// TS 29.244 8.2.93, the flags are followed by the source and the destination MAC address
+		data := []byte{0}
+		if epf.SourceMACAddress != nil {
+			data[0] |= 0x01
+			data = append(data, epf.SourceMACAddress...)
+		}
+		if epf.DestinationMACAddress != nil {
+			data[0] |= 0x02
+			data = append(data, epf.DestinationMACAddress...)
+		}
+		ies = append(ies, rawIE{ieType: ieTypeMACAddress, value: data})
+	}
+
+	if epf.Ethertype != 0 {
+		// TS 29.244 8.2.96
+		ies = append(ies, rawIE{ieType: ieTypeEthertype, value: binary.BigEndian.AppendUint16(nil, epf.Ethertype)})
+	}
+
+	if epf.CTag != nil {
+		ies = append(ies, rawIE{ieType: ieTypeCTAG, value: vlanTagValue(epf.CTag)})
+	}
+
+	if epf.STag != nil {
+		ies = append(ies, rawIE{ieType: ieTypeSTAG, value: vlanTagValue(epf.STag)})
+	}
+
+	if epf.SDFFilter != nil {
+		sdfFilter, err := epf.SDFFilter.MarshalBinary()
+		if err != nil {
+			return rawIE{}, err
+		}
+		ies = append(ies, rawIE{ieType: ieTypeSDFFilter, value: sdfFilter})
+	}
+
+	return rawIE{ieType: ieTypeEthernetPacketFilter, value: marshalIEs(ies)}, nil
+}
+
+// vlanTagValue encodes the C-TAG/S-TAG IE value (TS 29.244 8.2.94, 8.2.95)
+func vlanTagValue(tag *context.VLANTag) []byte {
+	// PCP, DEI and VID flags
+	data := []byte{0x07, 0, 0}
+	data[1] = byte(tag.VID>>8)<<4 | tag.PCP&0x07
+	if tag.DEI {
+		data[1] |= 0x08
+	}
+	data[2] = byte(tag.VID)
+	return data
+}
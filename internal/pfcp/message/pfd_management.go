@@ -0,0 +1,38 @@
+package message
+
+import (
+	"github.com/free5gc/pfcp"
+)
+
+// PfdManagementRequest is the body of the PFCP PFD Management Request. The pfcp library can't marshal
+// the Application ID IE of pfcp.ApplicationIDsPFDs, so the body is encoded here, the received request
+// is decoded by the library as pfcp.PFCPPFDManagementRequest.
+type PfdManagementRequest struct {
+	pfcp.PFCPPFDManagementRequest
+}
+
+func (r PfdManagementRequest) MarshalBinary() ([]byte, error) {
+	var ies []rawIE
+	for i := range r.ApplicationIDsPFDs {
+		appIDsPFDs := &r.ApplicationIDsPFDs[i]
+		appID, err := appIDsPFDs.ApplicationID.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		appIDsPFDsIEs := []rawIE{{ieType: ieTypeApplicationID, value: appID}}
+		// The PFDs of the application are deleted if the PFD context is absent (TS 29.244 6.2.5.2)
+		if appIDsPFDs.PFD != nil {
+			var pfdContentsIEs []rawIE
+			for j := range appIDsPFDs.PFD.PFDContents {
+				pfdContents, errMarshal := appIDsPFDs.PFD.PFDContents[j].MarshalBinary()
+				if errMarshal != nil {
+					return nil, errMarshal
+				}
+				pfdContentsIEs = append(pfdContentsIEs, rawIE{ieType: ieTypePFDContents, value: pfdContents})
+			}
+			appIDsPFDsIEs = append(appIDsPFDsIEs, rawIE{ieType: ieTypePFDContext, value: marshalIEs(pfdContentsIEs)})
+		}
+		ies = append(ies, rawIE{ieType: ieTypeApplicationIDsPFDs, value: marshalIEs(appIDsPFDsIEs)})
+	}
+	return marshalIEs(ies), nil
+}
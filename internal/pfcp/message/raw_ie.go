@@ -0,0 +1,64 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// types of the PFCP IEs encoded by the SMF (TS 29.244 8.1.2)
+const (
+	ieTypeCreatePDR                     uint16 = 1
+	ieTypePDI                           uint16 = 2
+	ieTypeUpdatePDR                     uint16 = 9
+	ieTypeSDFFilter                     uint16 = 23
+	ieTypeApplicationID                 uint16 = 24
+	ieTypePDRID                         uint16 = 56
+	ieTypeApplicationIDsPFDs            uint16 = 58
+	ieTypePFDContext                    uint16 = 59
+	ieTypePFDContents                   uint16 = 61
+	ieTypeEthernetPacketFilter          uint16 = 132
+	ieTypeMACAddress                    uint16 = 133
+	ieTypeCTAG                          uint16 = 134
+	ieTypeSTAG                          uint16 = 135
+	ieTypeEthertype                     uint16 = 136
+	ieTypeEthernetFilterID              uint16 = 138
+	ieTypeEthernetFilterProperties      uint16 = 139
+	ieTypeEthernetPDUSessionInformation uint16 = 142
+	ieTypeFramedRoute                   uint16 = 153
+	ieTypeFramedIPv6Route               uint16 = 155
+)
+
+// rawIE is an encoded PFCP IE (TS 29.244 8.1.1)
+type rawIE struct {
+	ieType uint16
+	value  []byte
+}
+
+func parseIEs(b []byte) ([]rawIE, error) {
+	var ies []rawIE
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, errors.New("PFCP IE header is truncated")
+		}
+		ieLen := int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < 4+ieLen {
+			return nil, errors.New("PFCP IE is truncated")
+		}
+		ies = append(ies, rawIE{
+			ieType: binary.BigEndian.Uint16(b[0:2]),
+			value:  b[4 : 4+ieLen : 4+ieLen],
+		})
+		b = b[4+ieLen:]
+	}
+	return ies, nil
+}
+
+func marshalIEs(ies []rawIE) []byte {
+	var buf []byte
+	for _, ie := range ies {
+		buf = binary.BigEndian.AppendUint16(buf, ie.ieType)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(ie.value)))
+		buf = append(buf, ie.value...)
+	}
+	return buf
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
 var seq uint32
@@ -19,6 +20,19 @@ func getSeqNumber() uint32 {
 	return atomic.AddUint32(&seq, 1)
 }
 
+// sendPfcpRequestToNode sends the PFCP request to the UPF of the node ID, or to the node ID address
+// if the UPF isn't configured
+func sendPfcpRequestToNode(message *pfcp.Message, upNodeID pfcpType.NodeID) (*pfcpUdp.Message, error) {
+	if upf := context.RetrieveUPFNodeByNodeID(upNodeID); upf != nil {
+		return udp.SendPfcpRequestToUPF(message, upf)
+	}
+	addr := &net.UDPAddr{
+		IP:   upNodeID.ResolveNodeIdToIp(),
+		Port: pfcpUdp.PFCP_PORT,
+	}
+	return udp.SendPfcpRequest(message, addr)
+}
+
 func SendPfcpAssociationSetupRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUdp.Message, err error) {
 	pfcpMsg, err := BuildPfcpAssociationSetupRequest()
 	if err != nil {
@@ -36,12 +50,7 @@ func SendPfcpAssociationSetupRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUdp.
 		Body: pfcpMsg,
 	}
 
-	addr := &net.UDPAddr{
-		IP:   upNodeID.ResolveNodeIdToIp(),
-		Port: pfcpUdp.PFCP_PORT,
-	}
-
-	resMsg, err = udp.SendPfcpRequest(message, addr)
+	resMsg, err = sendPfcpRequestToNode(message, upNodeID)
 	if err != nil {
 		return nil, err
 	}
@@ -74,6 +83,27 @@ func SendPfcpAssociationSetupResponse(addr *net.UDPAddr, cause pfcpType.Cause) {
 	udp.SendPfcpResponse(message, addr)
 }
 
+func SendPfcpAssociationUpdateResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpAssociationUpdateResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Association Update Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_ASSOCIATION_UPDATE_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
 func SendPfcpAssociationReleaseRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUdp.Message, err error) {
 	pfcpMsg, err := BuildPfcpAssociationReleaseRequest()
 	if err != nil {
@@ -92,12 +122,7 @@ func SendPfcpAssociationReleaseRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUd
 		Body: pfcpMsg,
 	}
 
-	addr := &net.UDPAddr{
-		IP:   upNodeID.ResolveNodeIdToIp(),
-		Port: pfcpUdp.PFCP_PORT,
-	}
-
-	resMsg, err = udp.SendPfcpRequest(message, addr)
+	resMsg, err = sendPfcpRequestToNode(message, upNodeID)
 	if err != nil {
 		return nil, err
 	}
@@ -130,6 +155,120 @@ func SendPfcpAssociationReleaseResponse(addr *net.UDPAddr, cause pfcpType.Cause)
 	udp.SendPfcpResponse(message, addr)
 }
 
+func SendPfcpNodeReportResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpNodeReportResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Node Report Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_NODE_REPORT_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
+func SendPfcpPfdManagementRequest(
+	upf *context.UPF,
+	pfdDatas []*factory.PfdDataForApp,
+	removedAppIDs []string,
+) (resMsg *pfcpUdp.Message, err error) {
+	if err = upf.IsAssociated(); err != nil {
+		return nil, err
+	}
+
+	pfcpMsg, err := BuildPfcpPfdManagementRequest(pfdDatas, removedAppIDs)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP PFD Management Request failed: %v", err)
+		return nil, err
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_PFD_MANAGEMENT_REQUEST,
+			SequenceNumber: getSeqNumber(),
+		},
+		Body: pfcpMsg,
+	}
+
+	resMsg, err = udp.SendPfcpRequestToUPF(message, upf)
+	if err != nil {
+		return nil, err
+	}
+
+	if resMsg.MessageType() != pfcp.PFCP_PFD_MANAGEMENT_RESPONSE {
+		return resMsg, fmt.Errorf("received unexpected response message")
+	}
+
+	return resMsg, nil
+}
+
+func SendPfcpSessionSetDeletionRequest(upf *context.UPF) (resMsg *pfcpUdp.Message, err error) {
+	if err = upf.IsAssociated(); err != nil {
+		return nil, err
+	}
+
+	pfcpMsg, err := BuildPfcpSessionSetDeletionRequest()
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Set Deletion Request failed: %v", err)
+		return nil, err
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_SET_DELETION_REQUEST,
+			SequenceNumber: getSeqNumber(),
+		},
+		Body: pfcpMsg,
+	}
+
+	resMsg, err = udp.SendPfcpRequestToUPF(message, upf)
+	if err != nil {
+		return nil, err
+	}
+
+	if resMsg.MessageType() != pfcp.PFCP_SESSION_SET_DELETION_RESPONSE {
+		return resMsg, fmt.Errorf("received unexpected response message")
+	}
+
+	return resMsg, nil
+}
+
+func SendPfcpSessionSetDeletionResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpSessionSetDeletionResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Set Deletion Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_SET_DELETION_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
 func SendPfcpSessionEstablishmentRequest(
 	upf *context.UPF,
 	ctx *context.SMContext,
@@ -161,17 +300,13 @@ func SendPfcpSessionEstablishmentRequest(
 			SequenceNumber:  getSeqNumber(),
 			MessagePriority: 0,
 		},
-		Body: pfcpMsg,
+		Body: withPDIIEs(pfcpMsg, pdrList),
 	}
 
-	upaddr := &net.UDPAddr{
-		IP:   nodeIDtoIP,
-		Port: pfcpUdp.PFCP_PORT,
-	}
 	logger.PduSessLog.Traceln("[SMF] Send SendPfcpSessionEstablishmentRequest")
-	logger.PduSessLog.Traceln("Send to addr ", upaddr.String())
+	logger.PduSessLog.Traceln("Send to addr ", upf.PFCPAddr().String())
 
-	resMsg, err = udp.SendPfcpRequest(message, upaddr)
+	resMsg, err = udp.SendPfcpRequestToUPFContext(ctx.TraceContext(), message, upf)
 	if err != nil {
 		return nil, err
 	}
@@ -234,6 +369,40 @@ func SendPfcpSessionModificationRequest(
 		return nil, err
 	}
 
+	return sendPfcpSessionModificationRequest(upf, ctx, withPDIIEs(pfcpMsg, pdrList))
+}
+
+// SendPfcpSessionModificationRequestDropBufferedData updates the FARs and requests the UPF to drop
+// the downlink data buffered for the PFCP session (TS 29.244 7.5.4.1)
+func SendPfcpSessionModificationRequestDropBufferedData(
+	upf *context.UPF,
+	ctx *context.SMContext,
+	farList []*context.FAR,
+) (resMsg *pfcpUdp.Message, err error) {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp()
+	if err = upf.IsAssociated(); err != nil {
+		return nil, err
+	}
+
+	pfcpMsg, err := BuildPfcpSessionModificationRequest(upf.NodeID, nodeIDtoIP.String(),
+		ctx, nil, farList, nil, nil, nil)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Modification Request failed: %v", err)
+		return nil, err
+	}
+	pfcpMsg.PFCPSMReqFlags = &pfcpType.PFCPSMReqFlags{
+		Drobu: true,
+	}
+
+	return sendPfcpSessionModificationRequest(upf, ctx, pfcpMsg)
+}
+
+func sendPfcpSessionModificationRequest(
+	upf *context.UPF,
+	ctx *context.SMContext,
+	pfcpMsg interface{},
+) (resMsg *pfcpUdp.Message, err error) {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp()
 	seqNum := getSeqNumber()
 	remoteSEID := ctx.PFCPContext[nodeIDtoIP.String()].RemoteSEID
 	message := &pfcp.Message{
@@ -249,12 +418,7 @@ func SendPfcpSessionModificationRequest(
 		Body: pfcpMsg,
 	}
 
-	upaddr := &net.UDPAddr{
-		IP:   nodeIDtoIP,
-		Port: pfcpUdp.PFCP_PORT,
-	}
-
-	resMsg, err = udp.SendPfcpRequest(message, upaddr)
+	resMsg, err = udp.SendPfcpRequestToUPFContext(ctx.TraceContext(), message, upf)
 	if err != nil {
 		return nil, err
 	}
@@ -325,12 +489,7 @@ func SendPfcpSessionDeletionRequest(
 		Body: pfcpMsg,
 	}
 
-	upaddr := &net.UDPAddr{
-		IP:   nodeIDtoIP,
-		Port: pfcpUdp.PFCP_PORT,
-	}
-
-	resMsg, err = udp.SendPfcpRequest(message, upaddr)
+	resMsg, err = udp.SendPfcpRequestToUPFContext(ctx.TraceContext(), message, upf)
 	if err != nil {
 		return nil, err
 	}
@@ -394,6 +553,37 @@ func SendPfcpSessionReportResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqF
 	udp.SendPfcpResponse(message, addr)
 }
 
+// SendPfcpSessionReportResponseWithBuffering sends the PFCP Session Report Response updating the buffering
+// of the downlink data in the UPF
+func SendPfcpSessionReportResponseWithBuffering(
+	addr *net.UDPAddr,
+	cause pfcpType.Cause,
+	seqFromUPF uint32,
+	seid uint64,
+	updateBAR *pfcp.UpdateBARIEInPFCPSessionReportResponse,
+	dropBuffered bool,
+) {
+	pfcpMsg, err := BuildPfcpSessionReportResponseWithBuffering(cause, updateBAR, dropBuffered)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Report Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_REPORT_RESPONSE,
+			SequenceNumber: seqFromUPF,
+			SEID:           seid,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
 func SendPfcpHeartbeatRequest(upf *context.UPF) (resMsg *pfcpUdp.Message, err error) {
 	pfcpMsg, err := BuildPfcpHeartbeatRequest()
 	if err != nil {
@@ -411,12 +601,7 @@ func SendPfcpHeartbeatRequest(upf *context.UPF) (resMsg *pfcpUdp.Message, err er
 		Body: pfcpMsg,
 	}
 
-	upfAddr := &net.UDPAddr{
-		IP:   upf.NodeID.ResolveNodeIdToIp(),
-		Port: pfcpUdp.PFCP_PORT,
-	}
-
-	resMsg, err = udp.SendPfcpRequest(reqMsg, upfAddr)
+	resMsg, err = udp.SendPfcpRequestToUPF(reqMsg, upf)
 	if err != nil {
 		return nil, err
 	}
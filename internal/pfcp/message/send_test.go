@@ -1,17 +1,24 @@
 package message_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
 	smf_context "github.com/free5gc/smf/internal/context"
 	smf_pfcp "github.com/free5gc/smf/internal/pfcp"
 	"github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/internal/pfcp/pfcptest"
 	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
 func TestSendPfcpAssociationSetupRequest(t *testing.T) {
@@ -39,3 +46,92 @@ func TestSendHeartbeatResponse(t *testing.T) {
 	err := udp.ClosePfcp()
 	require.NoError(t, err)
 }
+
+// sendPfcpSessionEstablishmentRequestToFakeUPF sends the request with the PDRs to a UPF which doesn't
+// respond, and returns the request received by the UPF
+func sendPfcpSessionEstablishmentRequestToFakeUPF(t *testing.T, smctx *smf_context.SMContext,
+	pdrList []*smf_context.PDR, farList []*smf_context.FAR, barList []*smf_context.BAR, qerList []*smf_context.QER,
+) []byte {
+	fakeUPF := pfcptest.NewUPF(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: pfcpUdp.PFCP_PORT}, nil)
+
+	smfContext := smf_context.GetSelf()
+	setSmfN4Address(t, pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.1").To4(),
+	}, "127.0.0.1")
+	smfContext.ListenAddr = "127.0.0.1"
+	// The fake UPF doesn't respond
+	origTimers := smfContext.PfcpRequestTimers
+	t.Cleanup(func() {
+		smfContext.PfcpRequestTimers = origTimers
+	})
+	smfContext.PfcpRequestTimers = &factory.PfcpRequestTimers{T1: 100 * time.Millisecond, N1: 1}
+	smfContext.PfcpContext, smfContext.PfcpCancelFunc = context.WithCancel(context.Background())
+	udp.Run(smf_pfcp.Dispatch)
+	defer func() {
+		require.NoError(t, udp.ClosePfcp())
+	}()
+
+	upf := smf_context.NewUPF(&pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         fakeUPF.Addr().IP.To4(),
+	}, nil)
+	upf.AssociationContext = context.Background()
+	smctx.PFCPContext[fakeUPF.Addr().IP.String()] = &smf_context.PFCPSessionContext{LocalSEID: 1}
+
+	_, err := message.SendPfcpSessionEstablishmentRequest(upf, smctx, pdrList, farList, barList, qerList, nil)
+	require.ErrorIs(t, err, udp.ErrRequestTimeout)
+
+	req := fakeUPF.Receive(t)
+	require.Equal(t, len(req)-4, int(binary.BigEndian.Uint16(req[2:4])))
+	return req
+}
+
+func TestSendPfcpSessionEstablishmentRequestFramedRoute(t *testing.T) {
+	initSmfContext()
+	smctx := smf_context.NewSMContext("imsi-208930000000001", 10)
+
+	pdrList, farList, barList, qerList, _ := initRuleList()
+	_, route, err := net.ParseCIDR("10.1.0.0/16")
+	require.NoError(t, err)
+	pdrList[0].PDI.FramedRoute = route
+
+	req := sendPfcpSessionEstablishmentRequestToFakeUPF(t, smctx, pdrList, farList, barList, qerList)
+
+	// The Framed-Route IE is in the PDI of the Create PDR
+	require.True(t, bytes.Contains(req, pfcptest.EncodeIE(153, []byte("10.1.0.0/16 0.0.0.0 1"))))
+}
+
+func TestSendPfcpSessionEstablishmentRequestEthernet(t *testing.T) {
+	initSmfContext()
+	smctx := smf_context.NewSMContext("imsi-208930000000001", 11)
+	smctx.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+
+	pdrList, farList, barList, qerList, _ := initRuleList()
+	mac, err := net.ParseMAC("00-00-5e-00-53-01")
+	require.NoError(t, err)
+	pdrList[0].PDI.UEIPAddress = nil
+	pdrList[0].PDI.EthernetPDUSessionInformation = true
+	pdrList[0].PDI.EthernetPacketFilter = &smf_context.EthernetPacketFilter{
+		EthernetFilterID:      1,
+		Bidirectional:         true,
+		DestinationMACAddress: mac,
+		Ethertype:             0x0800,
+		CTag:                  &smf_context.VLANTag{PCP: 5, VID: 10},
+	}
+
+	req := sendPfcpSessionEstablishmentRequestToFakeUPF(t, smctx, pdrList, farList, barList, qerList)
+
+	// The Ethernet PDU Session Information and the Ethernet Packet Filter IEs are in the PDI of the Create PDR
+	ethernetPacketFilter := bytes.Join([][]byte{
+		pfcptest.EncodeIE(138, []byte{0, 0, 0, 1}),
+		pfcptest.EncodeIE(139, []byte{0x01}),
+		pfcptest.EncodeIE(133, append([]byte{0x02}, mac...)),
+		pfcptest.EncodeIE(136, []byte{0x08, 0x00}),
+		pfcptest.EncodeIE(134, []byte{0x07, 0x05, 0x0a}),
+	}, nil)
+	pdiIEs := append(pfcptest.EncodeIE(142, []byte{0x01}), pfcptest.EncodeIE(132, ethernetPacketFilter)...)
+	require.True(t, bytes.Contains(req, pdiIEs))
+	// The PDN Type is Ethernet
+	require.True(t, bytes.Contains(req, pfcptest.EncodeIE(113, []byte{pfcpType.PDNTypeEthernet})))
+}
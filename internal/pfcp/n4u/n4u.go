@@ -0,0 +1,213 @@
+// Package n4u is the N4-u endpoint of the SMF, over which the UPF forwards the downlink data to be buffered
+// at the SMF and the SMF forwards the buffered data back (TS 23.501 5.8.3.2). The data is tunneled
+// with GTP-U (TS 29.281).
+package n4u
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/free5gc/smf/internal/logger"
+)
+
+const GtpuPort = 2152
+
+const (
+	MsgTypeEchoRequest  uint8 = 1
+	MsgTypeEchoResponse uint8 = 2
+	MsgTypeGPDU         uint8 = 255
+)
+
+const (
+	// version 1 and protocol type GTP
+	flagsVersion   uint8 = 0x30
+	flagExtHeader  uint8 = 0x04
+	flagSeqNumber  uint8 = 0x02
+	flagNPDUNumber uint8 = 0x01
+
+	headerLen = 8
+	// the sequence number, N-PDU number and next extension header type
+	optionalFieldsLen = 4
+
+	ieTypeRecovery uint8 = 14
+)
+
+var ErrNotRunning = errors.New("N4-u endpoint is not running")
+
+// Handler handles the downlink data of the tunnel
+type Handler func(teid uint32, packet []byte)
+
+// Message is a GTP-U message
+type Message struct {
+	Type uint8
+	TEID uint32
+	// SequenceNumber is present if HasSequenceNumber is true
+	SequenceNumber    uint16
+	HasSequenceNumber bool
+	Payload           []byte
+}
+
+var conn atomic.Pointer[net.UDPConn]
+
+// Run listens on the IP for the downlink data forwarded by the UPFs until ctx is done
+func Run(ctx context.Context, ip net.IP, handle Handler) error {
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: GtpuPort})
+	if err != nil {
+		return fmt.Errorf("listen N4-u failed: %w", err)
+	}
+	conn.Store(c)
+	logger.PfcpLog.Infof("N4-u listen on %s", c.LocalAddr().String())
+
+	go func() {
+		<-ctx.Done()
+		conn.CompareAndSwap(c, nil)
+		if errClose := c.Close(); errClose != nil {
+			logger.PfcpLog.Warnf("Close N4-u failed: %+v", errClose)
+		}
+	}()
+	go serve(c, handle)
+	return nil
+}
+
+// IsRunning returns true if the N4-u endpoint is listening
+func IsRunning() bool {
+	return conn.Load() != nil
+}
+
+// Send forwards the downlink data to the tunnel of the UPF
+func Send(addr *net.UDPAddr, teid uint32, packet []byte) error {
+	c := conn.Load()
+	if c == nil {
+		return ErrNotRunning
+	}
+	buf, err := Encode(&Message{Type: MsgTypeGPDU, TEID: teid, Payload: packet})
+	if err != nil {
+		return err
+	}
+	_, err = c.WriteToUDP(buf, addr)
+	return err
+}
+
+func serve(c *net.UDPConn, handle Handler) {
+	defer func() {
+		if p := recover(); p != nil {
+			// Print stack for panic to log. Fatalf() will let program exit.
+			logger.PfcpLog.Fatalf("panic: %v\n%s", p, string(debug.Stack()))
+		}
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.PfcpLog.Warnf("Read N4-u error: %+v", err)
+			continue
+		}
+
+		msg, err := Decode(buf[:n])
+		if err != nil {
+			logger.PfcpLog.Warnf("Decode N4-u message from %s failed: %+v", addr, err)
+			continue
+		}
+		switch msg.Type {
+		case MsgTypeGPDU:
+			// The buffer is reused for the next message
+			handle(msg.TEID, append([]byte(nil), msg.Payload...))
+		case MsgTypeEchoRequest:
+			// TS 29.281 7.2.2 the Recovery IE is set to zero
+			rsp, errEncode := Encode(&Message{
+				Type:              MsgTypeEchoResponse,
+				SequenceNumber:    msg.SequenceNumber,
+				HasSequenceNumber: true,
+				Payload:           []byte{ieTypeRecovery, 0},
+			})
+			if errEncode == nil {
+				_, err = c.WriteToUDP(rsp, addr)
+			}
+			if errEncode != nil || err != nil {
+				logger.PfcpLog.Warnf("Send N4-u Echo Response to %s failed: %+v", addr, errors.Join(errEncode, err))
+			}
+		default:
+			logger.PfcpLog.Debugf("N4-u message type %d from %s is ignored", msg.Type, addr)
+		}
+	}
+}
+
+// Encode encodes the GTP-U message without extension headers
+func Encode(msg *Message) ([]byte, error) {
+	bodyLen := len(msg.Payload)
+	flags := flagsVersion
+	if msg.HasSequenceNumber {
+		flags |= flagSeqNumber
+		bodyLen += optionalFieldsLen
+	}
+	if bodyLen > 0xffff {
+		return nil, fmt.Errorf("GTP-U message length %d exceeds the maximum", bodyLen)
+	}
+
+	buf := make([]byte, headerLen, headerLen+bodyLen)
+	buf[0] = flags
+	buf[1] = msg.Type
+	binary.BigEndian.PutUint16(buf[2:4], uint16(bodyLen))
+	binary.BigEndian.PutUint32(buf[4:8], msg.TEID)
+	if msg.HasSequenceNumber {
+		buf = binary.BigEndian.AppendUint16(buf, msg.SequenceNumber)
+		// N-PDU number and no extension header
+		buf = append(buf, 0, 0)
+	}
+	return append(buf, msg.Payload...), nil
+}
+
+// Decode decodes the GTP-U message, the extension headers are skipped
+func Decode(b []byte) (*Message, error) {
+	if len(b) < headerLen {
+		return nil, fmt.Errorf("GTP-U message length %d is too short", len(b))
+	}
+	flags := b[0]
+	if flags&0xf0 != flagsVersion {
+		return nil, fmt.Errorf("GTP-U version or protocol type flags 0x%x is not supported", flags)
+	}
+	end := headerLen + int(binary.BigEndian.Uint16(b[2:4]))
+	if end > len(b) {
+		return nil, fmt.Errorf("GTP-U message length %d exceeds the received %d", end, len(b))
+	}
+
+	msg := &Message{
+		Type: b[1],
+		TEID: binary.BigEndian.Uint32(b[4:8]),
+	}
+	offset := headerLen
+	if flags&(flagExtHeader|flagSeqNumber|flagNPDUNumber) != 0 {
+		if end < offset+optionalFieldsLen {
+			return nil, errors.New("GTP-U optional fields are truncated")
+		}
+		if flags&flagSeqNumber != 0 {
+			msg.SequenceNumber = binary.BigEndian.Uint16(b[offset:])
+			msg.HasSequenceNumber = true
+		}
+		nextExtType := b[offset+3]
+		offset += optionalFieldsLen
+		for flags&flagExtHeader != 0 && nextExtType != 0 {
+			if end <= offset || b[offset] == 0 {
+				return nil, errors.New("GTP-U extension header is malformed")
+			}
+			// The extension header length is in 4 octets, the last octet is the next extension header type
+			extLen := int(b[offset]) * 4
+			if end < offset+extLen {
+				return nil, errors.New("GTP-U extension header is truncated")
+			}
+			nextExtType = b[offset+extLen-1]
+			offset += extLen
+		}
+	}
+	msg.Payload = b[offset:end]
+	return msg, nil
+}
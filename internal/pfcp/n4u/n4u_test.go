@@ -0,0 +1,96 @@
+package n4u_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/smf/internal/pfcp/n4u"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	buf, err := n4u.Encode(&n4u.Message{Type: n4u.MsgTypeGPDU, TEID: 0x01020304, Payload: []byte{0x45, 0xb8}})
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x30, 0xff, 0x00, 0x02, 0x01, 0x02, 0x03, 0x04, 0x45, 0xb8}, buf)
+
+	msg, err := n4u.Decode(buf)
+	require.NoError(t, err)
+	require.Equal(t, &n4u.Message{Type: n4u.MsgTypeGPDU, TEID: 0x01020304, Payload: []byte{0x45, 0xb8}}, msg)
+
+	// G-PDU with the sequence number and a PDU Session Container extension header
+	msg, err = n4u.Decode([]byte{
+		0x36, 0xff, 0x00, 0x0d, 0x00, 0x00, 0x00, 0x07,
+		0x00, 0x2a, 0x00, 0x85,
+		0x01, 0x00, 0x01, 0x00,
+		0x60, 0x00, 0x00, 0x00, 0x00,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), msg.TEID)
+	require.True(t, msg.HasSequenceNumber)
+	require.Equal(t, uint16(42), msg.SequenceNumber)
+	require.Equal(t, []byte{0x60, 0x00, 0x00, 0x00, 0x00}, msg.Payload)
+
+	_, err = n4u.Decode([]byte{0x30, 0xff, 0x00, 0x08, 0x00, 0x00, 0x00, 0x01, 0x45})
+	require.Error(t, err)
+	_, err = n4u.Decode([]byte{0x34, 0xff, 0x00, 0x05, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x85, 0x00})
+	require.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan []byte, 1)
+	require.NoError(t, n4u.Run(ctx, net.ParseIP("127.0.0.1"), func(teid uint32, packet []byte) {
+		if teid == 5 {
+			received <- packet
+		}
+	}))
+	require.True(t, n4u.IsRunning())
+
+	peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer peer.Close()
+	smfAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: n4u.GtpuPort}
+
+	// The downlink data forwarded by the UPF
+	buf, err := n4u.Encode(&n4u.Message{Type: n4u.MsgTypeGPDU, TEID: 5, Payload: []byte{0x45, 0x00}})
+	require.NoError(t, err)
+	_, err = peer.WriteToUDP(buf, smfAddr)
+	require.NoError(t, err)
+	select {
+	case packet := <-received:
+		require.Equal(t, []byte{0x45, 0x00}, packet)
+	case <-time.After(time.Second):
+		t.Fatal("downlink data is not received")
+	}
+
+	// Echo Request
+	buf, err = n4u.Encode(&n4u.Message{Type: n4u.MsgTypeEchoRequest, SequenceNumber: 9, HasSequenceNumber: true})
+	require.NoError(t, err)
+	_, err = peer.WriteToUDP(buf, smfAddr)
+	require.NoError(t, err)
+	rsp := make([]byte, 64)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := peer.ReadFromUDP(rsp)
+	require.NoError(t, err)
+	msg, err := n4u.Decode(rsp[:n])
+	require.NoError(t, err)
+	require.Equal(t, n4u.MsgTypeEchoResponse, msg.Type)
+	require.Equal(t, uint16(9), msg.SequenceNumber)
+
+	// The buffered downlink data forwarded back to the UPF
+	require.NoError(t, n4u.Send(peer.LocalAddr().(*net.UDPAddr), 6, []byte{0x60}))
+	n, _, err = peer.ReadFromUDP(rsp)
+	require.NoError(t, err)
+	msg, err = n4u.Decode(rsp[:n])
+	require.NoError(t, err)
+	require.Equal(t, &n4u.Message{Type: n4u.MsgTypeGPDU, TEID: 6, Payload: []byte{0x60}}, msg)
+
+	cancel()
+	require.Eventually(t, func() bool { return !n4u.IsRunning() }, time.Second, 10*time.Millisecond)
+	require.ErrorIs(t, n4u.Send(peer.LocalAddr().(*net.UDPAddr), 6, []byte{0x60}), n4u.ErrNotRunning)
+}
@@ -0,0 +1,160 @@
+// Package pfcptest provides the fake UPF and the PFCP encoding helpers shared by the PFCP tests
+package pfcptest
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+// EncodeIE encodes the PFCP IE of the type and the value (TS 29.244 8.1.1)
+func EncodeIE(ieType uint16, value []byte) []byte {
+	ie := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, ieType), uint16(len(value)))
+	return append(ie, value...)
+}
+
+// EncodeNodeMessage encodes the node related PFCP message of the type with the IEs (TS 29.244 7.2.2)
+func EncodeNodeMessage(messageType pfcp.MessageType, seq uint32, ies ...[]byte) []byte {
+	msg := []byte{pfcp.PfcpVersion << 5, byte(messageType), 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[4:8], seq<<8)
+	for _, ie := range ies {
+		msg = append(msg, ie...)
+	}
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-4))
+	return msg
+}
+
+// UPF is a fake UPF, it records the PFCP messages received from the SMF and responds to them. The UPF keeps
+// the last 64 messages not yet returned by Receive or Next.
+type UPF struct {
+	conn     *net.UDPConn
+	respond  func(req *pfcp.Message) *pfcp.Message
+	received chan []byte
+}
+
+// NewUPF starts the fake UPF on the address. respond returns the response to the request received by the UPF,
+// or nil if the UPF doesn't respond, a nil respond never responds. The test is skipped if the address is not
+// available, and the UPF is stopped when the test ends.
+func NewUPF(t *testing.T, addr *net.UDPAddr, respond func(req *pfcp.Message) *pfcp.Message) *UPF {
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Skipf("%s is not available: %+v", addr, err)
+	}
+	upf := &UPF{
+		conn:     conn,
+		respond:  respond,
+		received: make(chan []byte, 64),
+	}
+	t.Cleanup(func() {
+		require.NoError(t, conn.Close())
+	})
+	go upf.serve()
+	return upf
+}
+
+// Addr returns the address of the UPF
+func (u *UPF) Addr() *net.UDPAddr {
+	return u.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (u *UPF) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := u.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		msg := append([]byte(nil), buf[:n]...)
+		for recorded := false; !recorded; {
+			select {
+			case u.received <- msg:
+				recorded = true
+			default:
+				<-u.received
+			}
+		}
+
+		req := new(pfcp.Message)
+		if u.respond == nil || req.Unmarshal(msg) != nil || !req.IsRequest() {
+			continue
+		}
+		if rsp := u.respond(req); rsp != nil {
+			if b, errMarshal := rsp.Marshal(); errMarshal == nil {
+				_, _ = u.conn.WriteToUDP(b, addr)
+			}
+		}
+	}
+}
+
+// Receive returns the next PFCP message received by the UPF as it's encoded
+func (u *UPF) Receive(t *testing.T) []byte {
+	select {
+	case msg := <-u.received:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("PFCP message is not received by the UPF")
+		return nil
+	}
+}
+
+// Next returns the next PFCP message received by the UPF, which is of the type
+func (u *UPF) Next(t *testing.T, msgType pfcp.MessageType) *pfcp.Message {
+	msg := new(pfcp.Message)
+	require.NoError(t, msg.Unmarshal(u.Receive(t)))
+	require.Equal(t, msgType, msg.Header.MessageType)
+	return msg
+}
+
+// AcceptSessions returns the respond function of the UPF accepting the PFCP sessions of the SMF, the UP F-SEID
+// of a PFCP session is the CP F-SEID
+func AcceptSessions(nodeIP net.IP) func(req *pfcp.Message) *pfcp.Message {
+	return func(req *pfcp.Message) *pfcp.Message {
+		accepted := &pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+		// The response of the PFCP session is sent with the SEID of the request
+		rsp := &pfcp.Message{
+			Header: pfcp.Header{
+				Version:        pfcp.PfcpVersion,
+				S:              pfcp.SEID_PRESENT,
+				SEID:           req.Header.SEID,
+				SequenceNumber: req.Header.SequenceNumber,
+			},
+		}
+		switch body := req.Body.(type) {
+		case pfcp.PFCPSessionEstablishmentRequest:
+			rsp.Header.MessageType = pfcp.PFCP_SESSION_ESTABLISHMENT_RESPONSE
+			rsp.Header.SEID = body.CPFSEID.Seid
+			rsp.Body = pfcp.PFCPSessionEstablishmentResponse{
+				NodeID: &pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: nodeIP},
+				Cause:  accepted,
+				UPFSEID: &pfcpType.FSEID{
+					V4:          true,
+					Seid:        body.CPFSEID.Seid,
+					Ipv4Address: nodeIP,
+				},
+			}
+		case pfcp.PFCPSessionModificationRequest:
+			rsp.Header.MessageType = pfcp.PFCP_SESSION_MODIFICATION_RESPONSE
+			rsp.Body = pfcp.PFCPSessionModificationResponse{Cause: accepted}
+		case pfcp.PFCPSessionDeletionRequest:
+			rsp.Header.MessageType = pfcp.PFCP_SESSION_DELETION_RESPONSE
+			rsp.Body = pfcp.PFCPSessionDeletionResponse{Cause: accepted}
+		default:
+			return nil
+		}
+		return rsp
+	}
+}
+
+// Send sends the PFCP message from the UPF to the address
+func (u *UPF) Send(t *testing.T, msg *pfcp.Message, addr *net.UDPAddr) {
+	b, err := msg.Marshal()
+	require.NoError(t, err)
+	_, err = u.conn.WriteToUDP(b, addr)
+	require.NoError(t, err)
+}
@@ -0,0 +1,388 @@
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+const (
+	DefaultCaptureMaxFileSize int64 = 10 * 1024 * 1024
+	DefaultCaptureMaxFiles          = 5
+
+	pcapMagicNumber uint32 = 0xa1b2c3d4
+	pcapSnapLen     uint32 = 0xffff
+	// LINKTYPE_RAW, the packet begins with an IPv4 or IPv6 header
+	pcapLinkTypeRaw uint32 = 101
+
+	pcapFileHeaderLen   = 24
+	pcapRecordHeaderLen = 16
+	ipv4HeaderLen       = 20
+	ipv6HeaderLen       = 40
+	udpHeaderLen        = 8
+	ipProtocolUDP       = 17
+)
+
+var (
+	ErrCaptureRunning  = errors.New("PFCP capture is already running")
+	ErrCaptureDisabled = errors.New("PFCP capture is disabled, no capture directory is configured")
+)
+
+// CaptureConfig is the configuration of the capture of the PFCP messages sent and received by the SMF
+type CaptureConfig struct {
+	// File is the path of the pcap file in the capture directory, a relative path is relative to the capture
+	// directory. The rotated files are suffixed with .1, .2, ...
+	File string `json:"file"`
+	// MaxFileSize is the size in bytes at which the file is rotated, DefaultCaptureMaxFileSize if 0
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// MaxFiles is the number of the files kept including the current one, DefaultCaptureMaxFiles if 0
+	MaxFiles int `json:"maxFiles,omitempty"`
+	// NodeID captures only the messages exchanged with the UPF of the Node ID if it's not empty
+	NodeID string `json:"nodeID,omitempty"`
+	// SEID captures only the messages whose header carries the SEID if it's not 0
+	SEID uint64 `json:"seid,omitempty"`
+}
+
+// CaptureStatus is the state of the capture of the PFCP messages
+type CaptureStatus struct {
+	Running bool           `json:"running"`
+	Config  *CaptureConfig `json:"config,omitempty"`
+	Packets uint64         `json:"packets"`
+}
+
+type capture struct {
+	mu      sync.Mutex
+	config  CaptureConfig
+	peerIP  net.IP
+	file    *os.File
+	size    int64
+	packets uint64
+}
+
+var activeCapture atomic.Pointer[capture]
+
+// StartCapture starts to write the PFCP messages sent and received by the SMF to the pcap file
+func StartCapture(config CaptureConfig) error {
+	if config.File == "" {
+		return errors.New("no capture file is specified")
+	}
+	if config.MaxFileSize < 0 || config.MaxFiles < 0 {
+		return fmt.Errorf("maxFileSize(%d) and maxFiles(%d) should not be negative", config.MaxFileSize, config.MaxFiles)
+	}
+	if config.MaxFileSize == 0 {
+		config.MaxFileSize = DefaultCaptureMaxFileSize
+	}
+	if config.MaxFileSize < pcapFileHeaderLen+pcapRecordHeaderLen {
+		return fmt.Errorf("maxFileSize(%d) is too small", config.MaxFileSize)
+	}
+	if config.MaxFiles == 0 {
+		config.MaxFiles = DefaultCaptureMaxFiles
+	}
+	file, err := captureFilePath(smf_context.GetSelf().PfcpCaptureDir, config.File)
+	if err != nil {
+		return err
+	}
+	config.File = file
+	if activeCapture.Load() != nil {
+		return ErrCaptureRunning
+	}
+
+	c := &capture{config: config}
+	if config.NodeID != "" {
+		nodeID := nodeIDOf(config.NodeID)
+		c.peerIP = nodeID.ResolveNodeIdToIp()
+		if c.peerIP == nil || c.peerIP.IsUnspecified() {
+			return fmt.Errorf("resolve the IP of Node ID[%s] failed", config.NodeID)
+		}
+	}
+	if err := c.open(); err != nil {
+		return err
+	}
+	if !activeCapture.CompareAndSwap(nil, c) {
+		if err := c.file.Close(); err != nil {
+			logger.PfcpLog.Warnf("Close capture file failed: %+v", err)
+		}
+		return ErrCaptureRunning
+	}
+	logger.PfcpLog.Infof("Start PFCP capture to %s", config.File)
+	return nil
+}
+
+// StopCapture stops the capture of the PFCP messages, it's a no-op if the capture isn't running
+func StopCapture() error {
+	c := activeCapture.Swap(nil)
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logger.PfcpLog.Infof("Stop PFCP capture to %s, %d packets are captured", c.config.File, c.packets)
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// GetCaptureStatus returns the state of the capture of the PFCP messages
+func GetCaptureStatus() CaptureStatus {
+	c := activeCapture.Load()
+	if c == nil {
+		return CaptureStatus{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	config := c.config
+	return CaptureStatus{
+		Running: true,
+		Config:  &config,
+		Packets: c.packets,
+	}
+}
+
+// captureFilePath returns the path of the capture file with the symbolic links of its directory resolved,
+// the file must be in the capture directory or one of its subdirectories
+func captureFilePath(captureDir, file string) (string, error) {
+	if captureDir == "" {
+		return "", ErrCaptureDisabled
+	}
+	dir, err := filepath.EvalSymlinks(captureDir)
+	if err == nil {
+		dir, err = filepath.Abs(dir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve capture directory %s failed: %w", captureDir, err)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	fileDir, err := filepath.EvalSymlinks(filepath.Dir(file))
+	if err == nil {
+		fileDir, err = filepath.Abs(fileDir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve directory of capture file %s failed: %w", file, err)
+	}
+	if rel, errRel := filepath.Rel(dir, fileDir); errRel != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("capture file %s is outside the capture directory %s", file, captureDir)
+	}
+	path := filepath.Join(fileDir, filepath.Base(file))
+	// The symbolic link may point outside the capture directory
+	if info, errStat := os.Lstat(path); errStat == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("capture file %s is a symbolic link", file)
+	}
+	return path, nil
+}
+
+func nodeIDOf(nodeID string) pfcpType.NodeID {
+	if ip := net.ParseIP(nodeID); ip != nil {
+		if ip.To4() != nil {
+			return pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv4Address, IP: ip.To4()}
+		}
+		return pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeIpv6Address, IP: ip}
+	}
+	return pfcpType.NodeID{NodeIdType: pfcpType.NodeIdTypeFqdn, FQDN: nodeID}
+}
+
+// captureSent captures the PFCP message sent to the peer
+func captureSent(header *pfcp.Header, buf []byte, peer *net.UDPAddr) {
+	if c := activeCapture.Load(); c != nil {
+		c.write(header, buf, localAddrTo(peer), peer, peer)
+	}
+}
+
+// captureReceived captures the PFCP message received from the peer
+func captureReceived(header *pfcp.Header, buf []byte, peer *net.UDPAddr) {
+	if c := activeCapture.Load(); c != nil {
+		c.write(header, buf, peer, localAddrTo(peer), peer)
+	}
+}
+
+// localAddrTo returns the address of the SMF in the IP family of the peer
+func localAddrTo(peer *net.UDPAddr) *net.UDPAddr {
+	local := &net.UDPAddr{Port: pfcpUdp.PFCP_PORT}
+	isV4 := peer.IP.To4() != nil
+	candidates := []net.IP{smf_context.GetSelf().ExternalIP()}
+	if Server != nil && Server.Conn != nil {
+		candidates = append([]net.IP{Server.Conn.LocalAddr().(*net.UDPAddr).IP}, candidates...)
+	}
+	for _, ip := range candidates {
+		if ip != nil && !ip.IsUnspecified() && (ip.To4() != nil) == isV4 {
+			local.IP = ip
+			return local
+		}
+	}
+	if isV4 {
+		local.IP = net.IPv4zero
+	} else {
+		local.IP = net.IPv6unspecified
+	}
+	return local
+}
+
+func (c *capture) match(header *pfcp.Header, peer *net.UDPAddr) bool {
+	if c.peerIP != nil && !c.peerIP.Equal(peer.IP) {
+		return false
+	}
+	if c.config.SEID != 0 && (header.S == 0 || header.SEID != c.config.SEID) {
+		return false
+	}
+	return true
+}
+
+func (c *capture) write(header *pfcp.Header, payload []byte, src, dst, peer *net.UDPAddr) {
+	if !c.match(header, peer) {
+		return
+	}
+	packet := ipPacket(payload, src, dst)
+	now := time.Now()
+	record := make([]byte, pcapRecordHeaderLen, pcapRecordHeaderLen+len(packet))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet)))
+	record = append(record, packet...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		// The capture is stopped
+		return
+	}
+	if c.size+int64(len(record)) > c.config.MaxFileSize && c.size > pcapFileHeaderLen {
+		if err := c.rotate(); err != nil {
+			logger.PfcpLog.Errorf("Rotate capture file failed, the capture is stopped: %+v", err)
+			activeCapture.CompareAndSwap(c, nil)
+			return
+		}
+	}
+	n, err := c.file.Write(record)
+	c.size += int64(n)
+	if err != nil {
+		logger.PfcpLog.Warnf("Write capture file failed: %+v", err)
+		return
+	}
+	c.packets++
+}
+
+// open creates the capture file and writes the pcap file header, the caller holds mu if the capture is active
+func (c *capture) open() error {
+	file, err := os.OpenFile(c.config.File, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open capture file failed: %w", err)
+	}
+	header := make([]byte, pcapFileHeaderLen)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicNumber)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeRaw)
+	if _, err = file.Write(header); err != nil {
+		return errors.Join(fmt.Errorf("write capture file header failed: %w", err), file.Close())
+	}
+	c.file = file
+	c.size = pcapFileHeaderLen
+	return nil
+}
+
+// rotate renames the file to <file>.1 after shifting the older files and opens a new file, the caller holds mu
+func (c *capture) rotate() error {
+	if err := c.file.Close(); err != nil {
+		logger.PfcpLog.Warnf("Close capture file failed: %+v", err)
+	}
+	c.file = nil
+	for i := c.config.MaxFiles - 1; i > 0; i-- {
+		src := c.config.File
+		if i > 1 {
+			src = fmt.Sprintf("%s.%d", c.config.File, i-1)
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", c.config.File, i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return c.open()
+}
+
+// ipPacket encapsulates the PFCP message in the UDP and IP headers, so that it's decoded as PFCP by Wireshark
+func ipPacket(payload []byte, src, dst *net.UDPAddr) []byte {
+	udpLen := udpHeaderLen + len(payload)
+	var packet, pseudoHeader []byte
+	var udpOffset int
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		udpOffset = ipv4HeaderLen
+		packet = make([]byte, udpOffset+udpLen)
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+		// Don't fragment
+		packet[6] = 0x40
+		packet[8] = 64
+		packet[9] = ipProtocolUDP
+		copy(packet[12:16], src4)
+		copy(packet[16:20], dst4)
+		binary.BigEndian.PutUint16(packet[10:12], checksum(packet[:ipv4HeaderLen], 0))
+
+		pseudoHeader = make([]byte, 0, 12)
+		pseudoHeader = append(pseudoHeader, src4...)
+		pseudoHeader = append(pseudoHeader, dst4...)
+		pseudoHeader = append(pseudoHeader, 0, ipProtocolUDP)
+		pseudoHeader = binary.BigEndian.AppendUint16(pseudoHeader, uint16(udpLen))
+	} else {
+		udpOffset = ipv6HeaderLen
+		packet = make([]byte, udpOffset+udpLen)
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:6], uint16(udpLen))
+		packet[6] = ipProtocolUDP
+		packet[7] = 64
+		copy(packet[8:24], src.IP.To16())
+		copy(packet[24:40], dst.IP.To16())
+
+		pseudoHeader = make([]byte, 0, 40)
+		pseudoHeader = append(pseudoHeader, packet[8:40]...)
+		pseudoHeader = binary.BigEndian.AppendUint32(pseudoHeader, uint32(udpLen))
+		pseudoHeader = append(pseudoHeader, 0, 0, 0, ipProtocolUDP)
+	}
+
+	udpHeader := packet[udpOffset:]
+	binary.BigEndian.PutUint16(udpHeader[0:2], uint16(src.Port))
+	binary.BigEndian.PutUint16(udpHeader[2:4], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udpHeader[4:6], uint16(udpLen))
+	copy(udpHeader[udpHeaderLen:], payload)
+	sum := checksum(udpHeader, checksumPartial(pseudoHeader, 0))
+	if sum == 0 {
+		// The checksum 0 means no checksum in UDP over IPv4 (RFC 768)
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udpHeader[6:8], sum)
+	return packet
+}
+
+// checksum returns the Internet checksum of the data (RFC 1071)
+func checksum(data []byte, initial uint32) uint16 {
+	sum := checksumPartial(data, initial)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+func checksumPartial(data []byte, sum uint32) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
@@ -0,0 +1,218 @@
+package udp_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+)
+
+// readPcap returns the packets of the pcap file with the raw IP link type
+func readPcap(t *testing.T, file string) [][]byte {
+	b, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(b), 24)
+	require.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(b[0:4]))
+	require.Equal(t, uint32(101), binary.LittleEndian.Uint32(b[20:24]))
+
+	var packets [][]byte
+	for offset := 24; offset < len(b); {
+		require.GreaterOrEqual(t, len(b), offset+16)
+		inclLen := int(binary.LittleEndian.Uint32(b[offset+8:]))
+		offset += 16
+		require.GreaterOrEqual(t, len(b), offset+inclLen)
+		packets = append(packets, b[offset:offset+inclLen])
+		offset += inclLen
+	}
+	return packets
+}
+
+// pfcpOfPacket returns the UDP ports and the PFCP message of the IPv4 packet
+func pfcpOfPacket(t *testing.T, packet []byte) (int, int, *pfcp.Message) {
+	require.Equal(t, byte(0x45), packet[0])
+	require.Equal(t, byte(17), packet[9])
+	udpHeader := packet[20:]
+	msg := &pfcp.Message{}
+	require.NoError(t, msg.Unmarshal(udpHeader[8:]))
+	return int(binary.BigEndian.Uint16(udpHeader[0:2])), int(binary.BigEndian.Uint16(udpHeader[2:4])), msg
+}
+
+func TestCapture(t *testing.T) {
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.1").To4(),
+	}
+	smf_context.GetSelf().ExternalAddr = "127.0.0.1"
+	smf_context.GetSelf().ListenAddr = "127.0.0.1"
+
+	udp.Run(func(_ context.Context, msg *pfcpUdp.Message) {
+		udp.SendPfcpResponse(&pfcp.Message{
+			Header: pfcp.Header{
+				Version:        pfcp.PfcpVersion,
+				S:              pfcp.SEID_NOT_PRESENT,
+				MessageType:    pfcp.PFCP_HEARTBEAT_RESPONSE,
+				SequenceNumber: msg.PfcpMessage.Header.SequenceNumber,
+			},
+			Body: pfcp.HeartbeatResponse{
+				RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()},
+			},
+		}, msg.RemoteAddr)
+	})
+	defer func() {
+		require.NoError(t, udp.Server.Close())
+	}()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: pfcpUdp.PFCP_PORT})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, conn.Close())
+	}()
+	heartbeat := func(seq uint32) {
+		req, errMarshal := (&pfcp.Message{
+			Header: pfcp.Header{
+				Version:        pfcp.PfcpVersion,
+				S:              pfcp.SEID_NOT_PRESENT,
+				MessageType:    pfcp.PFCP_HEARTBEAT_REQUEST,
+				SequenceNumber: seq,
+			},
+			Body: pfcp.HeartbeatRequest{
+				RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()},
+			},
+		}).Marshal()
+		require.NoError(t, errMarshal)
+		_, errWrite := conn.Write(req)
+		require.NoError(t, errWrite)
+
+		buf := make([]byte, 1024)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, errRead := conn.Read(buf)
+		require.NoError(t, errRead)
+	}
+
+	captureDir := t.TempDir()
+	smf_context.GetSelf().PfcpCaptureDir = captureDir
+	defer func() {
+		smf_context.GetSelf().PfcpCaptureDir = ""
+	}()
+	file := filepath.Join(captureDir, "n4.pcap")
+
+	// The messages without the SEID are filtered
+	require.NoError(t, udp.StartCapture(udp.CaptureConfig{File: file, SEID: 1}))
+	require.ErrorIs(t, udp.StartCapture(udp.CaptureConfig{File: file}), udp.ErrCaptureRunning)
+	heartbeat(1)
+	require.Equal(t, uint64(0), udp.GetCaptureStatus().Packets)
+	require.NoError(t, udp.StopCapture())
+	require.False(t, udp.GetCaptureStatus().Running)
+
+	// Each file holds one message
+	require.NoError(t, udp.StartCapture(udp.CaptureConfig{
+		File:        file,
+		MaxFileSize: 100,
+		MaxFiles:    2,
+		NodeID:      "127.0.0.1",
+	}))
+	heartbeat(2)
+	heartbeat(3)
+	status := udp.GetCaptureStatus()
+	require.True(t, status.Running)
+	require.Equal(t, uint64(4), status.Packets)
+	require.NoError(t, udp.StopCapture())
+
+	// The request of heartbeat 3 is in the rotated file and its response is in the current file
+	packets := readPcap(t, file+".1")
+	require.Len(t, packets, 1)
+	srcPort, dstPort, msg := pfcpOfPacket(t, packets[0])
+	require.Equal(t, pfcpUdp.PFCP_PORT, dstPort)
+	require.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, srcPort)
+	require.Equal(t, pfcp.PFCP_HEARTBEAT_REQUEST, msg.Header.MessageType)
+	require.Equal(t, uint32(3), msg.Header.SequenceNumber)
+
+	packets = readPcap(t, file)
+	require.Len(t, packets, 1)
+	srcPort, dstPort, msg = pfcpOfPacket(t, packets[0])
+	require.Equal(t, pfcpUdp.PFCP_PORT, srcPort)
+	require.Equal(t, conn.LocalAddr().(*net.UDPAddr).Port, dstPort)
+	require.Equal(t, pfcp.PFCP_HEARTBEAT_RESPONSE, msg.Header.MessageType)
+	require.Equal(t, uint32(3), msg.Header.SequenceNumber)
+
+	_, err = os.Stat(file + ".2")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCaptureFileInCaptureDir(t *testing.T) {
+	// The capture is disabled without the capture directory
+	require.ErrorIs(t, udp.StartCapture(udp.CaptureConfig{File: "n4.pcap"}), udp.ErrCaptureDisabled)
+
+	captureDir := t.TempDir()
+	outsideDir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(captureDir, "sub"), 0o700))
+	require.NoError(t, os.Symlink(outsideDir, filepath.Join(captureDir, "outside")))
+	require.NoError(t, os.Symlink(filepath.Join(outsideDir, "n4.pcap"), filepath.Join(captureDir, "link.pcap")))
+	smf_context.GetSelf().PfcpCaptureDir = captureDir
+	defer func() {
+		smf_context.GetSelf().PfcpCaptureDir = ""
+	}()
+
+	testCases := []struct {
+		name     string
+		file     string
+		expected string
+	}{
+		{
+			name:     "relative to the capture directory",
+			file:     "n4.pcap",
+			expected: filepath.Join(captureDir, "n4.pcap"),
+		},
+		{
+			name:     "in a subdirectory",
+			file:     filepath.Join(captureDir, "sub", "..", "sub", "n4.pcap"),
+			expected: filepath.Join(captureDir, "sub", "n4.pcap"),
+		},
+		{
+			name: "parent of the capture directory",
+			file: "../n4.pcap",
+		},
+		{
+			name: "outside the capture directory",
+			file: filepath.Join(outsideDir, "n4.pcap"),
+		},
+		{
+			name: "symbolic link to a directory outside",
+			file: filepath.Join("outside", "n4.pcap"),
+		},
+		{
+			name: "symbolic link to a file outside",
+			file: "link.pcap",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := udp.StartCapture(udp.CaptureConfig{File: tc.file})
+			if tc.expected == "" {
+				require.Error(t, err)
+				require.False(t, udp.GetCaptureStatus().Running)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, udp.GetCaptureStatus().Config.File)
+			require.NoError(t, udp.StopCapture())
+			require.FileExists(t, tc.expected)
+		})
+	}
+
+	entries, err := os.ReadDir(outsideDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,142 @@
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+)
+
+// rawIETypes are the IEs which the pfcp library keeps as raw octets without decoder, a message carrying
+// any of them fails to be unmarshaled by the library (TS 29.244 8.1.2)
+var rawIETypes = map[uint16]reflect.Type{
+	65:  reflect.TypeOf(pfcpType.FQCSID{}),
+	112: reflect.TypeOf(pfcpType.GracefulReleasePeriod{}),
+}
+
+const (
+	ieTypeNodeReportType              = 101
+	ieTypeUserPlanePathRecoveryReport = 187
+)
+
+// NodeReportRequest is the body of the PFCP Node Report Request with the User Plane Path Recovery Report
+// (TS 29.244 7.4.5.1), which the pfcp library doesn't decode
+type NodeReportRequest struct {
+	pfcp.PFCPNodeReportRequest
+	// Uprr is the UPRR flag of the Node Report Type (TS 29.244 8.2.69)
+	Uprr bool
+	// UserPlanePathRecoveryReport is the value of the User Plane Path Recovery Report IE, nil if absent
+	UserPlanePathRecoveryReport []byte
+}
+
+// unmarshalMessage unmarshals the PFCP message like pfcp.Message.Unmarshal, the body of the PFCP Node Report
+// Request is a NodeReportRequest
+func unmarshalMessage(msg *pfcp.Message, data []byte) error {
+	if err := unmarshalRawIEs(msg, data); err != nil {
+		return err
+	}
+	if body, ok := msg.Body.(pfcp.PFCPNodeReportRequest); ok {
+		req := NodeReportRequest{PFCPNodeReportRequest: body}
+		err := forEachIE(data[msg.Header.Len():], func(ieType uint16, value []byte) {
+			switch {
+			case ieType == ieTypeNodeReportType && len(value) != 0:
+				req.Uprr = value[0]&pfcpType.BitMask2 != 0
+			case ieType == ieTypeUserPlanePathRecoveryReport:
+				req.UserPlanePathRecoveryReport = append([]byte(nil), value...)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		msg.Body = req
+	}
+	return nil
+}
+
+// forEachIE calls f for every IE of the data
+func forEachIE(b []byte, f func(ieType uint16, value []byte)) error {
+	for len(b) > 0 {
+		if len(b) < 4 || len(b) < 4+int(binary.BigEndian.Uint16(b[2:4])) {
+			return fmt.Errorf("inadequate IE length: %d", len(b))
+		}
+		ieType, ieLen := binary.BigEndian.Uint16(b[0:2]), int(binary.BigEndian.Uint16(b[2:4]))
+		f(ieType, b[4:4+ieLen])
+		b = b[4+ieLen:]
+	}
+	return nil
+}
+
+// unmarshalRawIEs unmarshals the PFCP message like pfcp.Message.Unmarshal. The top-level IEs of rawIETypes
+// are taken out of the message before it's unmarshaled by the library, then their values are set to the
+// fields of the body with the same tag in the order they are received.
+func unmarshalRawIEs(msg *pfcp.Message, data []byte) error {
+	err := msg.Unmarshal(data)
+	if err == nil {
+		return nil
+	}
+	if errHeader := msg.Header.UnmarshalBinary(data); errHeader != nil || len(data) < msg.Header.Len() {
+		return err
+	}
+
+	headerLen := msg.Header.Len()
+	stripped := append([]byte(nil), data[:headerLen]...)
+	rawIEs := make(map[uint16][][]byte)
+	errIE := forEachIE(data[headerLen:], func(ieType uint16, value []byte) {
+		if _, raw := rawIETypes[ieType]; raw {
+			rawIEs[ieType] = append(rawIEs[ieType], append([]byte(nil), value...))
+		} else {
+			stripped = binary.BigEndian.AppendUint16(stripped, ieType)
+			stripped = binary.BigEndian.AppendUint16(stripped, uint16(len(value)))
+			stripped = append(stripped, value...)
+		}
+	})
+	if errIE != nil || len(rawIEs) == 0 {
+		return err
+	}
+	binary.BigEndian.PutUint16(stripped[2:4], uint16(len(stripped)-4))
+	if err = msg.Unmarshal(stripped); err != nil {
+		return err
+	}
+	// The message length is the one received
+	msg.Header.MessageLength = uint16(len(data) - 4)
+	body, err := setRawIEs(msg.Body, rawIEs)
+	if err != nil {
+		return fmt.Errorf("set raw IEs of PFCP message type %d failed: %w", msg.Header.MessageType, err)
+	}
+	msg.Body = body
+	return nil
+}
+
+// setRawIEs returns the copy of the body with the raw IE values set to its fields with the same tag
+func setRawIEs(body interface{}, rawIEs map[uint16][][]byte) (interface{}, error) {
+	if body == nil || reflect.TypeOf(body).Kind() != reflect.Struct {
+		return nil, errors.New("message body is not a struct")
+	}
+	v := reflect.New(reflect.TypeOf(body)).Elem()
+	v.Set(reflect.ValueOf(body))
+	for i := 0; i < v.NumField() && len(rawIEs) > 0; i++ {
+		field, fieldType := v.Field(i), v.Type().Field(i)
+		tag, err := strconv.ParseUint(fieldType.Tag.Get("tlv"), 10, 16)
+		if err != nil {
+			continue
+		}
+		ieType := uint16(tag)
+		values := rawIEs[ieType]
+		if len(values) == 0 || fieldType.Type.Kind() != reflect.Ptr ||
+			fieldType.Type.Elem() != rawIETypes[ieType] || !field.IsNil() {
+			continue
+		}
+		// The raw IE types have a single []byte field
+		ie := reflect.New(rawIETypes[ieType])
+		ie.Elem().Field(0).SetBytes(values[0])
+		field.Set(ie)
+		if rawIEs[ieType] = values[1:]; len(rawIEs[ieType]) == 0 {
+			delete(rawIEs, ieType)
+		}
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,84 @@
+package udp_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/pfcp/pfcptest"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+)
+
+func TestReadMessageWithRawIEs(t *testing.T) {
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.1").To4(),
+	}
+	smf_context.GetSelf().ExternalAddr = "127.0.0.1"
+	smf_context.GetSelf().ListenAddr = "127.0.0.1"
+
+	received := make(chan *pfcpUdp.Message, 1)
+	udp.Run(func(_ context.Context, msg *pfcpUdp.Message) {
+		received <- msg
+	})
+	defer func() {
+		require.NoError(t, udp.Server.Close())
+	}()
+
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: pfcpUdp.PFCP_PORT})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, conn.Close())
+	}()
+	receive := func(msg []byte) *pfcp.Message {
+		_, errWrite := conn.Write(msg)
+		require.NoError(t, errWrite)
+		select {
+		case rcvMsg := <-received:
+			return rcvMsg.PfcpMessage
+		case <-time.After(time.Second):
+			require.FailNow(t, "no message is received")
+			return nil
+		}
+	}
+	nodeID := pfcptest.EncodeIE(60, []byte{0, 10, 0, 0, 1})
+
+	// The FQ-CSIDs are set to the FQ-CSID fields in order
+	fqCSID1 := []byte{0x01, 10, 0, 0, 1, 0, 5}
+	fqCSID2 := []byte{0x02, 10, 0, 0, 1, 0, 6, 0, 7}
+	msg := receive(pfcptest.EncodeNodeMessage(pfcp.PFCP_SESSION_SET_DELETION_REQUEST, 1,
+		nodeID, pfcptest.EncodeIE(65, fqCSID1), pfcptest.EncodeIE(65, fqCSID2)))
+	req, ok := msg.Body.(pfcp.PFCPSessionSetDeletionRequest)
+	require.True(t, ok)
+	require.Equal(t, net.ParseIP("10.0.0.1").To4(), req.NodeID.IP.To4())
+	require.Equal(t, &pfcpType.FQCSID{FQCSIDdata: fqCSID1}, req.SGWCFQCSID)
+	require.Equal(t, &pfcpType.FQCSID{FQCSIDdata: fqCSID2}, req.PGWCFQCSID)
+	require.Nil(t, req.SGWUFQCSID)
+
+	// The Graceful Release Period is set with the other IEs decoded by the library
+	msg = receive(pfcptest.EncodeNodeMessage(pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST, 2,
+		nodeID, pfcptest.EncodeIE(111, []byte{0x02}), pfcptest.EncodeIE(112, []byte{0x21})))
+	updateReq, ok := msg.Body.(pfcp.PFCPAssociationUpdateRequest)
+	require.True(t, ok)
+	require.NotNil(t, updateReq.PFCPAssociationReleaseRequest)
+	require.Equal(t, &pfcpType.GracefulReleasePeriod{GracefulReleasePerioddata: []byte{0x21}},
+		updateReq.GracefulReleasePeriod)
+
+	// The UPRR flag and the User Plane Path Recovery Report are decoded
+	remoteGTPUPeer := pfcptest.EncodeIE(103, []byte{0x02, 10, 0, 0, 2})
+	msg = receive(pfcptest.EncodeNodeMessage(pfcp.PFCP_NODE_REPORT_REQUEST, 3,
+		nodeID, pfcptest.EncodeIE(101, []byte{0x02}), pfcptest.EncodeIE(187, remoteGTPUPeer)))
+	nodeReportReq, ok := msg.Body.(udp.NodeReportRequest)
+	require.True(t, ok)
+	require.NotNil(t, nodeReportReq.NodeID)
+	require.False(t, nodeReportReq.NodeReportType.Upfr)
+	require.True(t, nodeReportReq.Uprr)
+	require.Equal(t, remoteGTPUPeer, nodeReportReq.UserPlanePathRecoveryReport)
+}
@@ -1,25 +1,32 @@
 package udp
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpUdp"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
+	"github.com/free5gc/smf/internal/tracing"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
-const MaxPfcpUdpDataSize = 1024
-
 var Server *pfcpUdp.PfcpServer
 
 var ServerStartTime time.Time
 
-func Run(dispatch func(*pfcpUdp.Message)) {
+// Run starts the PFCP server, each request is dispatched with the context of its server span
+func Run(dispatch func(context.Context, *pfcpUdp.Message)) {
 	defer func() {
 		if p := recover(); p != nil {
 			// Print stack for panic to log. Fatalf() will let program exit.
@@ -29,8 +36,13 @@ func Run(dispatch func(*pfcpUdp.Message)) {
 
 	smfContext := smf_context.GetSelf()
 
-	serverIP := smfContext.ListenIP().To4()
-	Server = pfcpUdp.NewPfcpServer(serverIP.String())
+	// The server listens on the IPv4 or IPv6 address, or on both if the address is unspecified
+	var serverAddr string
+	serverIP := smfContext.ListenIP()
+	if serverIP != nil {
+		serverAddr = serverIP.String()
+	}
+	Server = pfcpUdp.NewPfcpServer(serverAddr)
 
 	err := Server.Listen()
 	if err != nil {
@@ -39,6 +51,15 @@ func Run(dispatch func(*pfcpUdp.Message)) {
 
 	logger.PfcpLog.Infof("Listen on %s", Server.Conn.LocalAddr().String())
 
+	maxMessageSize := smfContext.PfcpMaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = factory.PfcpMaxMessageSize
+	}
+	// No message over IPv4 exceeds the UDP payload of IPv4
+	if serverIP.To4() != nil && maxMessageSize > factory.UdpMaxPayloadSizeIPv4 {
+		maxMessageSize = factory.UdpMaxPayloadSizeIPv4
+	}
+
 	go func(p *pfcpUdp.PfcpServer) {
 		defer func() {
 			if p := recover(); p != nil {
@@ -47,13 +68,16 @@ func Run(dispatch func(*pfcpUdp.Message)) {
 			}
 		}()
 
+		// The buffer has one more octet to detect the message exceeding the maximum size
+		buf := make([]byte, maxMessageSize+1)
 		for {
-			msg, errReadFrom := p.ReadFrom()
+			msg, errReadFrom := readFrom(p, buf)
 			if errReadFrom != nil {
 				if errReadFrom == pfcpUdp.ErrReceivedResentRequest {
 					logger.PfcpLog.Infoln(errReadFrom)
 				} else if strings.Contains(errReadFrom.Error(), "use of closed network connection") {
-					continue
+					// The server is closed
+					return
 				} else {
 					logger.PfcpLog.Warnf("Read PFCP error: %v, msg: [%v]", errReadFrom, msg)
 					select {
@@ -68,7 +92,13 @@ func Run(dispatch func(*pfcpUdp.Message)) {
 			}
 
 			if msg.PfcpMessage.IsRequest() {
-				go dispatch(msg)
+				go func(msg *pfcpUdp.Message) {
+					ctx, span := tracing.StartPfcpSpan(context.Background(), trace.SpanKindServer,
+						pfcpMessageTypeName(msg.PfcpMessage.Header.MessageType), msg.RemoteAddr.IP.String(),
+						msg.PfcpMessage.Header.SequenceNumber, msg.PfcpMessage.Header.SEID)
+					defer span.End()
+					dispatch(ctx, msg)
+				}(msg)
 			}
 		}
 	}(Server)
@@ -78,15 +108,186 @@ func Run(dispatch func(*pfcpUdp.Message)) {
 	logger.PfcpLog.Infof("Pfcp running... [%v]", ServerStartTime)
 }
 
+// readFrom reads a PFCP message like pfcpUdp.PfcpServer.ReadFrom, whose receive buffer is limited
+// to pfcpUdp.PFCP_MAX_UDP_LEN, the buffer is reused for the next message
+func readFrom(server *pfcpUdp.PfcpServer, buf []byte) (*pfcpUdp.Message, error) {
+	n, addr, err := server.Conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	pfcpMsg := &pfcp.Message{}
+	msg := pfcpUdp.NewMessage(addr, pfcpMsg)
+	if n == len(buf) {
+		return msg, fmt.Errorf("PFCP message from %s exceeds the maximum size %d", addr, len(buf)-1)
+	}
+	if err = unmarshalMessage(pfcpMsg, append([]byte(nil), buf[:n]...)); err != nil {
+		return msg, err
+	}
+	captureReceived(&pfcpMsg.Header, buf[:n], addr)
+
+	if pfcpMsg.IsRequest() {
+		tx, errFind := server.FindTransaction(pfcpMsg, addr)
+		if errFind != nil {
+			return msg, errFind
+		}
+		if tx != nil {
+			// The request is replied, the response is resent
+			tx.EventChannel <- pfcp.ReceiveEvent{
+				Type:       pfcp.ReceiveEventTypeResendRequest,
+				RemoteAddr: addr,
+				RcvMsg:     pfcpMsg,
+			}
+			return msg, pfcpUdp.ErrReceivedResentRequest
+		}
+	} else if pfcpMsg.IsResponse() {
+		tx, errFind := server.FindTransaction(pfcpMsg, server.Conn.LocalAddr().(*net.UDPAddr))
+		if errFind != nil {
+			return msg, errFind
+		}
+		tx.EventChannel <- pfcp.ReceiveEvent{
+			Type:       pfcp.ReceiveEventTypeValidResponse,
+			RemoteAddr: addr,
+			RcvMsg:     pfcpMsg,
+		}
+	}
+	return msg, nil
+}
+
 func SendPfcpResponse(sndMsg *pfcp.Message, addr *net.UDPAddr) {
+	if activeCapture.Load() != nil {
+		if buf, err := sndMsg.Marshal(); err == nil {
+			captureSent(&sndMsg.Header, buf, addr)
+		}
+	}
 	Server.WriteResponseTo(sndMsg, addr)
 }
 
+// ErrRequestTimeout is returned if no response of the PFCP request is received after N1 retransmissions
+var ErrRequestTimeout = errors.New("PFCP request timed out")
+
 func SendPfcpRequest(sndMsg *pfcp.Message, addr *net.UDPAddr) (rsvMsg *pfcpUdp.Message, err error) {
-	if addr.IP.Equal(net.IPv4zero) {
+	return SendPfcpRequestContext(context.Background(), sndMsg, addr)
+}
+
+// SendPfcpRequestContext sends the PFCP request, the span of the PFCP transaction is a child of the span of ctx
+func SendPfcpRequestContext(
+	ctx context.Context, sndMsg *pfcp.Message, addr *net.UDPAddr,
+) (rsvMsg *pfcpUdp.Message, err error) {
+	return sendPfcpRequest(ctx, sndMsg, addr, nil)
+}
+
+// SendPfcpRequestToUPF sends the PFCP request to the UPF with its retransmission timers,
+// the request is counted in the N4 request statistics of the UPF
+func SendPfcpRequestToUPF(sndMsg *pfcp.Message, upf *smf_context.UPF) (rsvMsg *pfcpUdp.Message, err error) {
+	return SendPfcpRequestToUPFContext(context.Background(), sndMsg, upf)
+}
+
+// SendPfcpRequestToUPFContext is SendPfcpRequestToUPF with the span of the PFCP transaction
+// as a child of the span of ctx
+func SendPfcpRequestToUPFContext(
+	ctx context.Context, sndMsg *pfcp.Message, upf *smf_context.UPF,
+) (rsvMsg *pfcpUdp.Message, err error) {
+	return sendPfcpRequest(ctx, sndMsg, upf.PFCPAddr(), upf)
+}
+
+func sendPfcpRequest(
+	ctx context.Context, sndMsg *pfcp.Message, addr *net.UDPAddr, upf *smf_context.UPF,
+) (rsvMsg *pfcpUdp.Message, err error) {
+	if addr.IP == nil || addr.IP.IsUnspecified() {
 		return nil, errors.New("no destination IP address is specified")
 	}
-	return Server.WriteRequestTo(sndMsg, addr)
+
+	t1, n1 := factory.SmfDefaultPfcpT1, factory.SmfDefaultPfcpN1
+	if upf != nil {
+		t1, n1 = upf.RequestTimers()
+	}
+
+	messageType := pfcpMessageTypeName(sndMsg.Header.MessageType)
+	_, span := tracing.StartPfcpSpan(ctx, trace.SpanKindClient, messageType, addr.IP.String(),
+		sndMsg.Header.SequenceNumber, sndMsg.Header.SEID)
+
+	start := time.Now()
+	rsvMsg, retransmissions, err := writeRequestTo(Server, sndMsg, addr, t1, n1)
+	duration := time.Since(start)
+
+	timedOut := errors.Is(err, ErrRequestTimeout)
+	metrics.ObservePfcpRequest(addr.IP.String(), messageType, duration, retransmissions, timedOut)
+	if upf != nil {
+		upf.CountN4Request(retransmissions, timedOut)
+	}
+
+	span.SetAttributes(tracing.PfcpRetransmissionKey.Int(retransmissions))
+	tracing.EndSpan(span, err)
+	return rsvMsg, err
+}
+
+// writeRequestTo sends the PFCP request like pfcpUdp.PfcpServer.WriteRequestTo, whose timers are fixed,
+// the request is retransmitted after t1 without the response at most n1 times (TS 29.244 6.4)
+func writeRequestTo(
+	server *pfcpUdp.PfcpServer, reqMsg *pfcp.Message, addr *net.UDPAddr, t1 time.Duration, n1 int,
+) (*pfcpUdp.Message, int, error) {
+	if !reqMsg.IsRequest() {
+		return nil, 0, errors.New("not a request message")
+	}
+	buf, err := reqMsg.Marshal()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tx := pfcp.NewTransaction(reqMsg, buf, server.Conn, addr)
+	if err = server.PutTransaction(tx); err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if errRemove := server.RemoveTransaction(tx); errRemove != nil {
+			logger.PfcpLog.Warnf("RemoveTransaction error: %+v", errRemove)
+		}
+	}()
+
+	for retransmissions := 0; ; retransmissions++ {
+		if _, err = server.Conn.WriteToUDP(buf, addr); err != nil {
+			return nil, retransmissions, fmt.Errorf("send PFCP request[%d] to %s failed: %w",
+				tx.SequenceNumber, addr, err)
+		}
+		captureSent(&reqMsg.Header, buf, addr)
+
+		timer := time.NewTimer(t1)
+		select {
+		case event := <-tx.EventChannel:
+			timer.Stop()
+			if event.Type == pfcp.ReceiveEventTypeValidResponse {
+				return pfcpUdp.NewMessage(event.RemoteAddr, event.RcvMsg), retransmissions, nil
+			}
+		case <-timer.C:
+		}
+
+		if retransmissions >= n1 {
+			return nil, retransmissions, fmt.Errorf("%w: no response of request[%d] from %s after %d retransmissions",
+				ErrRequestTimeout, tx.SequenceNumber, addr, retransmissions)
+		}
+	}
+}
+
+var pfcpMessageTypeNames = map[pfcp.MessageType]string{
+	pfcp.PFCP_HEARTBEAT_REQUEST:             "heartbeat",
+	pfcp.PFCP_PFD_MANAGEMENT_REQUEST:        "pfd_management",
+	pfcp.PFCP_ASSOCIATION_SETUP_REQUEST:     "association_setup",
+	pfcp.PFCP_ASSOCIATION_UPDATE_REQUEST:    "association_update",
+	pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST:   "association_release",
+	pfcp.PFCP_NODE_REPORT_REQUEST:           "node_report",
+	pfcp.PFCP_SESSION_SET_DELETION_REQUEST:  "session_set_deletion",
+	pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST: "session_establishment",
+	pfcp.PFCP_SESSION_MODIFICATION_REQUEST:  "session_modification",
+	pfcp.PFCP_SESSION_DELETION_REQUEST:      "session_deletion",
+	pfcp.PFCP_SESSION_REPORT_REQUEST:        "session_report",
+}
+
+func pfcpMessageTypeName(messageType pfcp.MessageType) string {
+	if name, ok := pfcpMessageTypeNames[messageType]; ok {
+		return name
+	}
+	return strconv.Itoa(int(messageType))
 }
 
 func ClosePfcp() error {
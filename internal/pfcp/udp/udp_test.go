@@ -1,18 +1,26 @@
 package udp_test
 
 import (
+	"context"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/free5gc/pfcp"
 	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/pfcp/pfcpUdp"
-	"github.com/free5gc/smf/internal/context"
+	smf_context "github.com/free5gc/smf/internal/context"
 	smf_pfcp "github.com/free5gc/smf/internal/pfcp"
+	"github.com/free5gc/smf/internal/pfcp/pfcptest"
 	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
 const testPfcpClientPort = 12345
@@ -20,12 +28,12 @@ const testPfcpClientPort = 12345
 func TestRun(t *testing.T) {
 	// Set SMF Node ID
 
-	context.GetSelf().CPNodeID = pfcpType.NodeID{
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
 		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
 		IP:         net.ParseIP("127.0.0.1").To4(),
 	}
-	context.GetSelf().ExternalAddr = "127.0.0.1"
-	context.GetSelf().ListenAddr = "127.0.0.1"
+	smf_context.GetSelf().ExternalAddr = "127.0.0.1"
+	smf_context.GetSelf().ListenAddr = "127.0.0.1"
 
 	udp.Run(smf_pfcp.Dispatch)
 
@@ -65,3 +73,232 @@ func TestRun(t *testing.T) {
 
 	time.Sleep(300 * time.Millisecond)
 }
+
+func TestRunIPv6LargeMessage(t *testing.T) {
+	if conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback}); err != nil {
+		t.Skipf("IPv6 is not available: %+v", err)
+	} else {
+		require.NoError(t, conn.Close())
+	}
+
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv6Address,
+		IP:         net.IPv6loopback,
+	}
+	smf_context.GetSelf().ExternalAddr = "::1"
+	smf_context.GetSelf().ListenAddr = "::1"
+
+	received := make(chan *pfcpUdp.Message, 1)
+	udp.Run(func(_ context.Context, msg *pfcpUdp.Message) {
+		received <- msg
+	})
+	defer func() {
+		require.NoError(t, udp.Server.Close())
+	}()
+
+	// The request exceeds the receive buffer of pfcpUdp.PfcpServer.ReadFrom
+	req := pfcp.PFCPSessionEstablishmentRequest{
+		NodeID: &smf_context.GetSelf().CPNodeID,
+		CPFSEID: &pfcpType.FSEID{
+			V6:          true,
+			Seid:        1,
+			Ipv6Address: net.IPv6loopback,
+		},
+	}
+	flowDescription := []byte("permit out ip from 2001:db8::1 to 2001:db8:1::/64 1000-2000")
+	for i := 1; i <= 64; i++ {
+		req.CreatePDR = append(req.CreatePDR, &pfcp.CreatePDR{
+			PDRID:      &pfcpType.PacketDetectionRuleID{RuleId: uint16(i)},
+			Precedence: &pfcpType.Precedence{PrecedenceValue: uint32(i)},
+			PDI: &pfcp.PDI{
+				SourceInterface: &pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCore},
+				SDFFilter: &pfcpType.SDFFilter{
+					Fd:                      true,
+					LengthOfFlowDescription: uint16(len(flowDescription)),
+					FlowDescription:         flowDescription,
+				},
+			},
+			FARID: &pfcpType.FARID{FarIdValue: uint32(i)},
+		})
+	}
+	buf, err := (&pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			S:              pfcp.SEID_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST,
+			SequenceNumber: 1,
+		},
+		Body: req,
+	}).Marshal()
+	require.NoError(t, err)
+	require.Greater(t, len(buf), pfcpUdp.PFCP_MAX_UDP_LEN)
+
+	conn, err := net.DialUDP("udp6", nil, &net.UDPAddr{IP: net.IPv6loopback, Port: pfcpUdp.PFCP_PORT})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, conn.Close())
+	}()
+	_, err = conn.Write(buf)
+	require.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		require.Equal(t, pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST, msg.MessageType())
+		rcvReq := msg.PfcpMessage.Body.(pfcp.PFCPSessionEstablishmentRequest)
+		require.Len(t, rcvReq.CreatePDR, 64)
+		require.True(t, net.IPv6loopback.Equal(rcvReq.CPFSEID.Ipv6Address))
+	case <-time.After(time.Second):
+		t.Fatal("PFCP message is not received")
+	}
+}
+
+func TestSendPfcpRequestToUPF(t *testing.T) {
+	// The UPF responds to the retransmitted request until it's silent
+	var received int
+	var silent atomic.Bool
+	fakeUPF := pfcptest.NewUPF(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: pfcpUdp.PFCP_PORT},
+		func(req *pfcp.Message) *pfcp.Message {
+			received++
+			if received == 1 || silent.Load() {
+				return nil
+			}
+			return &pfcp.Message{
+				Header: pfcp.Header{
+					Version:        pfcp.PfcpVersion,
+					S:              pfcp.SEID_NOT_PRESENT,
+					MessageType:    pfcp.PFCP_HEARTBEAT_RESPONSE,
+					SequenceNumber: req.Header.SequenceNumber,
+				},
+				Body: pfcp.HeartbeatResponse{
+					RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()},
+				},
+			}
+		})
+
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.1").To4(),
+	}
+	smf_context.GetSelf().ExternalAddr = "127.0.0.1"
+	smf_context.GetSelf().ListenAddr = "127.0.0.1"
+	origTimers := smf_context.GetSelf().PfcpRequestTimers
+	smf_context.GetSelf().PfcpRequestTimers = &factory.PfcpRequestTimers{
+		T1: 300 * time.Millisecond,
+		N1: 2,
+	}
+	defer func() {
+		smf_context.GetSelf().PfcpRequestTimers = origTimers
+	}()
+
+	udp.Run(smf_pfcp.Dispatch)
+	defer func() {
+		require.NoError(t, udp.Server.Close())
+	}()
+
+	upf := smf_context.NewUPF(&pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         fakeUPF.Addr().IP.To4(),
+	}, nil)
+	heartbeatRequest := func(seq uint32) *pfcp.Message {
+		return &pfcp.Message{
+			Header: pfcp.Header{
+				Version:        pfcp.PfcpVersion,
+				S:              pfcp.SEID_NOT_PRESENT,
+				MessageType:    pfcp.PFCP_HEARTBEAT_REQUEST,
+				SequenceNumber: seq,
+			},
+			Body: pfcp.HeartbeatRequest{
+				RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()},
+			},
+		}
+	}
+
+	rsp, err := udp.SendPfcpRequestToUPF(heartbeatRequest(1), upf)
+	require.NoError(t, err)
+	require.Equal(t, pfcp.PFCP_HEARTBEAT_RESPONSE, rsp.MessageType())
+	require.Equal(t, smf_context.N4RequestStats{Sent: 1, Retransmitted: 1}, upf.N4RequestStats())
+
+	silent.Store(true)
+	start := time.Now()
+	_, err = udp.SendPfcpRequestToUPF(heartbeatRequest(2), upf)
+	require.ErrorIs(t, err, udp.ErrRequestTimeout)
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.Equal(t, smf_context.N4RequestStats{Sent: 2, Retransmitted: 3, TimedOut: 1}, upf.N4RequestStats())
+}
+
+func TestRunSpanContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer func() {
+		require.NoError(t, provider.Shutdown(context.Background()))
+	}()
+
+	smf_context.GetSelf().CPNodeID = pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("127.0.0.1").To4(),
+	}
+	smf_context.GetSelf().ExternalAddr = "127.0.0.1"
+	smf_context.GetSelf().ListenAddr = "127.0.0.1"
+
+	heartbeat := func(messageType pfcp.MessageType, seq uint32) *pfcp.Message {
+		msg := &pfcp.Message{
+			Header: pfcp.Header{
+				Version:        pfcp.PfcpVersion,
+				S:              pfcp.SEID_NOT_PRESENT,
+				MessageType:    messageType,
+				SequenceNumber: seq,
+			},
+		}
+		recoveryTimeStamp := &pfcpType.RecoveryTimeStamp{RecoveryTimeStamp: time.Now()}
+		if messageType == pfcp.PFCP_HEARTBEAT_REQUEST {
+			msg.Body = pfcp.HeartbeatRequest{RecoveryTimeStamp: recoveryTimeStamp}
+		} else {
+			msg.Body = pfcp.HeartbeatResponse{RecoveryTimeStamp: recoveryTimeStamp}
+		}
+		return msg
+	}
+	fakeUPF := pfcptest.NewUPF(t, &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: pfcpUdp.PFCP_PORT},
+		func(req *pfcp.Message) *pfcp.Message {
+			return heartbeat(pfcp.PFCP_HEARTBEAT_RESPONSE, req.Header.SequenceNumber)
+		})
+	upf := smf_context.NewUPF(&pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         fakeUPF.Addr().IP.To4(),
+	}, nil)
+
+	// The request sent while handling the request of the UPF is traced as a child of the handling
+	handled := make(chan error, 1)
+	udp.Run(func(ctx context.Context, msg *pfcpUdp.Message) {
+		_, errSend := udp.SendPfcpRequestToUPFContext(ctx, heartbeat(pfcp.PFCP_HEARTBEAT_REQUEST, 1), upf)
+		handled <- errSend
+	})
+	defer func() {
+		require.NoError(t, udp.Server.Close())
+	}()
+
+	fakeUPF.Send(t, heartbeat(pfcp.PFCP_HEARTBEAT_REQUEST, 100),
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: pfcpUdp.PFCP_PORT})
+
+	select {
+	case errSend := <-handled:
+		require.NoError(t, errSend)
+	case <-time.After(time.Second):
+		t.Fatal("PFCP request is not handled")
+	}
+
+	var serverSpan, clientSpan sdktrace.ReadOnlySpan
+	require.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			switch span.SpanKind() {
+			case trace.SpanKindServer:
+				serverSpan = span
+			case trace.SpanKindClient:
+				clientSpan = span
+			}
+		}
+		return serverSpan != nil && clientSpan != nil
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, serverSpan.SpanContext().TraceID(), clientSpan.SpanContext().TraceID())
+	require.Equal(t, serverSpan.SpanContext().SpanID(), clientSpan.Parent().SpanID())
+}
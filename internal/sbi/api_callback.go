@@ -25,6 +25,18 @@ func (s *Server) getCallbackRoutes() []Route {
 			Pattern: "/sm-policies/:smContextRef/terminate",
 			APIFunc: s.SmPolicyControlTerminationRequestNotification,
 		},
+		{
+			Name:    "SdmSubscriptionDataChangeNotification",
+			Method:  http.MethodPost,
+			Pattern: "/sdm-subscription-notify/:supi",
+			APIFunc: s.HTTPSdmSubscriptionDataChangeNotification,
+		},
+		{
+			Name:    "N1N2MessageTransferFailureNotification",
+			Method:  http.MethodPost,
+			Pattern: "/n1n2-failure-notify/:smContextRef",
+			APIFunc: s.HTTPN1N2MessageTransferFailureNotification,
+		},
 		{
 			Name:    "ChargingNotification",
 			Method:  http.MethodPost,
@@ -53,7 +65,69 @@ func (s *Server) HTTPSmPolicyUpdateNotification(c *gin.Context) {
 }
 
 func (s *Server) SmPolicyControlTerminationRequestNotification(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	var request models.PcfSmPolicyControlTerminationNotification
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, c.ContentType())
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	smContextRef := c.Params.ByName("smContextRef")
+	s.Processor().HandleSMPolicyTerminationNotify(c, request, smContextRef)
+}
+
+func (s *Server) HTTPSdmSubscriptionDataChangeNotification(c *gin.Context) {
+	var notification models.ModificationNotification
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+
+	err = openapi.Deserialize(&notification, reqBody, c.ContentType())
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	supi := c.Params.ByName("supi")
+	s.Processor().HandleSDMSubscriptionDataChangeNotify(c, notification, supi)
+}
+
+func (s *Server) HTTPN1N2MessageTransferFailureNotification(c *gin.Context) {
+	var notification models.N1N2MsgTxfrFailureNotification
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+
+	err = openapi.Deserialize(&notification, reqBody, c.ContentType())
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	smContextRef := c.Params.ByName("smContextRef")
+	s.Processor().HandleN1N2TransferFailureNotification(c, notification, smContextRef)
 }
 
 func (s *Server) HTTPChargingNotification(c *gin.Context) {
@@ -13,6 +13,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/logger"
 )
 
 func (s *Server) getEventExposureRoutes() []Route {
@@ -54,20 +58,57 @@ func (s *Server) getEventExposureRoutes() []Route {
 
 // SubscriptionsPost -
 func (s *Server) HTTPCreateIndividualSubcription(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	var request models.NsmfEventExposure
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.EeLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, APPLICATION_JSON)
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.EeLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	s.Processor().HandleCreateIndividualSubcription(c, request)
 }
 
 // SubscriptionsSubIdDelete -
 func (s *Server) HTTPDeleteIndividualSubcription(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	subId := c.Params.ByName("subId")
+	s.Processor().HandleDeleteIndividualSubcription(c, subId)
 }
 
 // SubscriptionsSubIdGet -
 func (s *Server) HTTPGetIndividualSubcription(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	subId := c.Params.ByName("subId")
+	s.Processor().HandleGetIndividualSubcription(c, subId)
 }
 
 // SubscriptionsSubIdPut -
 func (s *Server) HTTPReplaceIndividualSubcription(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	var request models.NsmfEventExposure
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.EeLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, APPLICATION_JSON)
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.EeLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	subId := c.Params.ByName("subId")
+	s.Processor().HandleReplaceIndividualSubcription(c, subId, request)
 }
@@ -4,6 +4,10 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/pfcp/udp"
 )
 
 func (s *Server) getOAMRoutes() []Route {
@@ -28,6 +32,36 @@ func (s *Server) getOAMRoutes() []Route {
 			Pattern: "/user-plane-info/",
 			APIFunc: s.HTTPGetSMFUserPlaneInfo,
 		},
+		{
+			Name:    "Get UPF N4 Request Statistics",
+			Method:  http.MethodGet,
+			Pattern: "/upf-n4-stats",
+			APIFunc: s.HTTPGetUPFN4Stats,
+		},
+		{
+			Name:    "Get PFCP Capture Status",
+			Method:  http.MethodGet,
+			Pattern: "/pfcp-capture",
+			APIFunc: s.HTTPGetPfcpCapture,
+		},
+		{
+			Name:    "Start PFCP Capture",
+			Method:  http.MethodPut,
+			Pattern: "/pfcp-capture",
+			APIFunc: s.HTTPStartPfcpCapture,
+		},
+		{
+			Name:    "Stop PFCP Capture",
+			Method:  http.MethodDelete,
+			Pattern: "/pfcp-capture",
+			APIFunc: s.HTTPStopPfcpCapture,
+		},
+		{
+			Name:    "Reload SMF Configuration",
+			Method:  http.MethodPost,
+			Pattern: "/config-reload",
+			APIFunc: s.HTTPReloadConfig,
+		},
 	}
 }
 
@@ -40,3 +74,38 @@ func (s *Server) HTTPGetUEPDUSessionInfo(c *gin.Context) {
 func (s *Server) HTTPGetSMFUserPlaneInfo(c *gin.Context) {
 	s.Processor().HandleGetSMFUserPlaneInfo(c)
 }
+
+func (s *Server) HTTPGetUPFN4Stats(c *gin.Context) {
+	s.Processor().HandleGetUPFN4Stats(c)
+}
+
+func (s *Server) HTTPGetPfcpCapture(c *gin.Context) {
+	s.Processor().HandleGetPfcpCapture(c)
+}
+
+func (s *Server) HTTPStartPfcpCapture(c *gin.Context) {
+	var config udp.CaptureConfig
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.SBILog.Errorln("GetRawData failed")
+	}
+
+	err = openapi.Deserialize(&config, reqBody, "application/json")
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.SBILog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	s.Processor().HandleStartPfcpCapture(c, config)
+}
+
+func (s *Server) HTTPStopPfcpCapture(c *gin.Context) {
+	s.Processor().HandleStopPfcpCapture(c)
+}
+
+func (s *Server) HTTPReloadConfig(c *gin.Context) {
+	s.Processor().HandleReloadConfig(c)
+}
@@ -1,6 +1,7 @@
 package sbi
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -54,7 +55,7 @@ func (s *Server) getPDUSessionRoutes() []Route {
 		},
 		{
 			Name:    "PostPduSessions",
-			Method:  http.MethodPatch,
+			Method:  http.MethodPost,
 			Pattern: "/pdu-sessions",
 			APIFunc: s.HTTPPostPduSessions,
 		},
@@ -137,7 +138,21 @@ func (s *Server) HTTPUpdateSmContext(c *gin.Context) {
 
 // HTTPRetrieveSmContext - Retrieve SM Context
 func (s *Server) HTTPRetrieveSmContext(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	logger.PduSessLog.Info("Receive Retrieve SM Context Request")
+	var request models.SmContextRetrieveData
+
+	// The request body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			problemDetail := "[Request Body] " + err.Error()
+			logger.PduSessLog.Errorln(problemDetail)
+			c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+			return
+		}
+	}
+
+	smContextRef := c.Params.ByName("smContextRef")
+	s.Processor().HandlePDUSessionSMContextRetrieve(c, request, smContextRef)
 }
 
 // HTTPReleaseSmContext - Release SM Context
@@ -169,23 +184,104 @@ func (s *Server) HTTPSendMoData(c *gin.Context) {
 
 // HTTPPostPduSessions - Create
 func (s *Server) HTTPPostPduSessions(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	logger.PduSessLog.Info("Receive Create PDU Session Request")
+	var request models.PostPduSessionsRequest
+
+	contentType := strings.Split(c.GetHeader("Content-Type"), ";")
+	var err error
+	switch contentType[0] {
+	case APPLICATION_JSON:
+		err = c.ShouldBindJSON(&request)
+	case MULTIPART_RELATED:
+		err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
+	}
+	if err == nil && request.JsonData == nil {
+		err = errors.New("jsonData is missing")
+	}
+
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	s.Processor().HandlePDUSessionCreate(c, request)
 }
 
 // HTTPUpdatePduSession - Update (initiated by V-SMF)
 func (s *Server) HTTPUpdatePduSession(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	logger.PduSessLog.Info("Receive Update PDU Session Request")
+	var request models.UpdatePduSessionRequest
+
+	contentType := strings.Split(c.GetHeader("Content-Type"), ";")
+	var err error
+	switch contentType[0] {
+	case APPLICATION_JSON:
+		err = c.ShouldBindJSON(&request)
+	case MULTIPART_RELATED:
+		err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
+	}
+	if err == nil && request.JsonData == nil {
+		err = errors.New("jsonData is missing")
+	}
+
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	pduSessionRef := c.Params.ByName("pduSessionRef")
+	s.Processor().HandlePDUSessionUpdate(c, request, pduSessionRef)
 }
 
 // HTTPReleasePduSession - Release
 func (s *Server) HTTPReleasePduSession(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	logger.PduSessLog.Info("Receive Release PDU Session Request")
+	var request models.ReleasePduSessionRequest
+
+	// The request body is optional
+	if c.Request.ContentLength != 0 {
+		contentType := strings.Split(c.GetHeader("Content-Type"), ";")
+		var err error
+		switch contentType[0] {
+		case APPLICATION_JSON:
+			err = c.ShouldBindJSON(&request)
+		case MULTIPART_RELATED:
+			err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
+		}
+		if err != nil {
+			problemDetail := "[Request Body] " + err.Error()
+			logger.PduSessLog.Errorln(problemDetail)
+			c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+			return
+		}
+	}
+
+	pduSessionRef := c.Params.ByName("pduSessionRef")
+	s.Processor().HandlePDUSessionRelease(c, request, pduSessionRef)
 }
 
+// HTTPRetrievePduSession - Retrieve
 func (s *Server) HTTPRetrievePduSession(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{})
+	logger.PduSessLog.Info("Receive Retrieve PDU Session Request")
+	var request models.RetrieveData
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	pduSessionRef := c.Params.ByName("pduSessionRef")
+	s.Processor().HandlePDUSessionRetrieve(c, request, pduSessionRef)
 }
 
+// HTTPTransferMoData - Transfer MO Data
+// Control Plane CIoT 5GS Optimisation is not supported, so there is no MO data to be transferred
 func (s *Server) HTTPTransferMoData(c *gin.Context) {
 	c.JSON(http.StatusNotImplemented, gin.H{})
 }
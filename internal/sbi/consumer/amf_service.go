@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/amf/Communication"
 	"github.com/free5gc/openapi/models"
 )
@@ -30,6 +31,7 @@ func (s *namfService) getCommunicationClient(uri string) *Communication.APIClien
 
 	configuration := Communication.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_AMF))
 	client = Communication.NewAPIClient(configuration)
 
 	s.CommunicationMu.RUnlock()
@@ -59,3 +61,37 @@ func (s *namfService) N1N2MessageTransfer(
 
 	return &rsp.N1N2MessageTransferRspData, err
 }
+
+// N1N2MessageTransferErrDetail returns the details of the N1N2 message transfer rejected by the AMF,
+// e.g. the DDN throttling (TS 29.518 6.1.5.2.2), nil if the AMF returns no details
+func N1N2MessageTransferErrDetail(err error) *models.N1N2MsgTxfrErrDetail {
+	if apiErr, ok := err.(openapi.GenericOpenAPIError); ok {
+		if errModel, ok := apiErr.Model().(Communication.N1N2MessageTransferError); ok {
+			return errModel.N1N2MessageTransferError.ErrInfo
+		}
+	}
+	return nil
+}
+
+// EBIAssignment requests the AMF to assign the EPS bearer IDs to the QoS flows of the PDU session
+// for the interworking with EPS over N26 (TS 29.518 5.2.2.6)
+func (s *namfService) EBIAssignment(
+	ctx context.Context, supi string, assignEbiData models.AssignEbiData, apiPrefix string,
+) (*models.AssignedEbiData, error) {
+	client := s.getCommunicationClient(apiPrefix)
+	if client == nil {
+		return nil, fmt.Errorf("EBIAssignment client is nil: (%v)", apiPrefix)
+	}
+
+	ebiAssignmentRequest := &Communication.EBIAssignmentRequest{
+		UeContextId:   &supi,
+		AssignEbiData: &assignEbiData,
+	}
+
+	rsp, err := client.IndividualUeContextDocumentApi.EBIAssignment(ctx, ebiAssignmentRequest)
+	if err != nil || rsp == nil {
+		return nil, err
+	}
+
+	return &rsp.AssignedEbiData, nil
+}
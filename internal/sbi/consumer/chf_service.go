@@ -35,6 +35,7 @@ func (s *nchfService) getConvergedChargingClient(uri string) *ConvergedCharging.
 
 	configuration := ConvergedCharging.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_CHF))
 	client = ConvergedCharging.NewAPIClient(configuration)
 
 	s.ConvergedChargingMu.RUnlock()
@@ -118,6 +119,7 @@ func (s *nchfService) SendConvergedChargingRequest(
 	if err != nil {
 		return nil, pd, err
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	if smContext.SelectedCHFProfile.NfServices == nil {
 		errMsg := "no CHF found"
@@ -41,6 +41,7 @@ func (s *nnrfService) getNFManagementClient(uri string) *NFManagement.APIClient
 
 	configuration := NFManagement.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_NRF))
 	client = NFManagement.NewAPIClient(configuration)
 
 	s.NFManagementgMu.RUnlock()
@@ -63,6 +64,7 @@ func (s *nnrfService) getNFDiscoveryClient(uri string) *NFDiscovery.APIClient {
 
 	configuration := NFDiscovery.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_NRF))
 	client = NFDiscovery.NewAPIClient(configuration)
 
 	s.NFDiscoveryMu.RUnlock()
@@ -135,7 +137,7 @@ func (s *nnrfService) RegisterNFInstance(ctx context.Context) error {
 func (s *nnrfService) buildNfProfile(smfContext *smf_context.SMFContext) (
 	profile models.NrfNfManagementNfProfile, err error,
 ) {
-	smfProfile := smfContext.NfProfile
+	smfProfile := smfContext.GetNfProfile()
 
 	sNssais := []models.ExtSnssai{}
 	for _, snssaiSmfInfo := range smfProfile.SMFInfo.SNssaiSmfInfoList {
@@ -153,12 +155,42 @@ func (s *nnrfService) buildNfProfile(smfContext *smf_context.SMFContext) (
 		SNssais:       sNssais,
 		PlmnList:      *smfProfile.PLMNList,
 	}
-	if smfContext.Locality != "" {
-		profile.Locality = smfContext.Locality
-	}
+	profile.Locality = smfContext.GetLocality()
 	return profile, err
 }
 
+// UpdateNFInstance updates the S-NSSAIs, the PLMNs and the locality of the SMF profile in the NRF
+// after they are changed by a configuration reload
+func (s *nnrfService) UpdateNFInstance() error {
+	smfContext := s.consumer.Context()
+	nfProfile, err := s.buildNfProfile(smfContext)
+	if err != nil {
+		return errors.Wrap(err, "UpdateNFInstance buildNfProfile()")
+	}
+
+	ctx, pd, err := smfContext.GetTokenCtx(models.ServiceName_NNRF_NFM, models.NrfNfManagementNfType_NRF)
+	if err != nil {
+		logger.ConsumerLog.Errorf("Get token context failed, problem details: %+v", pd)
+		return err
+	}
+
+	client := s.getNFManagementClient(smfContext.NrfUri)
+	request := &NFManagement.UpdateNFInstanceRequest{
+		NfInstanceID: &smfContext.NfInstanceID,
+		PatchItem: []models.PatchItem{
+			{Op: models.PatchOperation_ADD, Path: "/sNssais", Value: nfProfile.SNssais},
+			{Op: models.PatchOperation_ADD, Path: "/smfInfo", Value: nfProfile.SmfInfo},
+			{Op: models.PatchOperation_ADD, Path: "/plmnList", Value: nfProfile.PlmnList},
+			{Op: models.PatchOperation_ADD, Path: "/locality", Value: nfProfile.Locality},
+		},
+	}
+	if _, err = client.NFInstanceIDDocumentApi.UpdateNFInstance(ctx, request); err != nil {
+		return err
+	}
+	logger.ConsumerLog.Infof("SMF profile updated in NRF")
+	return nil
+}
+
 func (s *nnrfService) SendDeregisterNFInstance() (err error) {
 	logger.ConsumerLog.Infof("Send Deregister NFInstance")
 
@@ -347,6 +379,7 @@ func (s *nnrfService) SendNFDiscoveryServingAMF(smContext *smf_context.SMContext
 	if err != nil {
 		return pd, err
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	// Check data
 	result, localErr := s.NFDiscoveryAMF(smContext, ctx)
@@ -392,6 +425,7 @@ func (s *nnrfService) CHFSelection(smContext *smf_context.SMContext) error {
 	if err != nil {
 		return err
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	client := s.getNFDiscoveryClient(s.consumer.Context().NrfUri)
 	// Check data
@@ -415,6 +449,7 @@ func (s *nnrfService) PCFSelection(smContext *smf_context.SMContext) error {
 	if errToken != nil {
 		return errToken
 	}
+	ctx = smContext.WithTraceContext(ctx)
 	// Send NFDiscovery for find PCF
 	targetNfType := models.NrfNfManagementNfType_PCF
 	requesterNfType := models.NrfNfManagementNfType_SMF
@@ -423,8 +458,8 @@ func (s *nnrfService) PCFSelection(smContext *smf_context.SMContext) error {
 		RequesterNfType: &requesterNfType,
 	}
 
-	if s.consumer.Context().Locality != "" {
-		request.PreferredLocality = &s.consumer.Context().Locality
+	if locality := s.consumer.Context().GetLocality(); locality != "" {
+		request.PreferredLocality = &locality
 	}
 
 	client := s.getNFDiscoveryClient(s.consumer.Context().NrfUri)
@@ -39,6 +39,7 @@ func (s *npcfService) getSMPolicyControlClient(uri string) *SMPolicyControl.APIC
 
 	configuration := SMPolicyControl.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_PCF))
 	client = SMPolicyControl.NewAPIClient(configuration)
 
 	s.SMPolicyControlMu.RUnlock()
@@ -94,6 +95,7 @@ func (s *npcfService) SendSMPolicyAssociationCreate(smContext *smf_context.SMCon
 	if err != nil {
 		return "", nil, err
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	var smPolicyID string
 	var smPolicyDecision *models.SmPolicyDecision
@@ -199,6 +201,7 @@ func (s *npcfService) SendSMPolicyAssociationUpdateByUERequestModification(
 	if err != nil {
 		return nil, err
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	var client *SMPolicyControl.APIClient
 
@@ -223,6 +226,49 @@ func (s *npcfService) SendSMPolicyAssociationUpdateByUERequestModification(
 	return smPolicyDecision, nil
 }
 
+// SendSMPolicyAssociationUpdateBySubscriptionChange reports the changed subscribed session AMBR and
+// default QoS of the PDU session to the PCF (TS 29.512 4.2.4.6)
+func (s *npcfService) SendSMPolicyAssociationUpdateBySubscriptionChange(
+	smContext *smf_context.SMContext,
+) (*models.SmPolicyDecision, error) {
+	updateSMPolicy := models.SmPolicyUpdateContextData{
+		RepPolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{
+			models.PolicyControlRequestTrigger_SE_AMBR_CH,
+			models.PolicyControlRequestTrigger_DEF_QOS_CH,
+		},
+		SubsSessAmbr: smContext.DnnConfiguration.SessionAmbr,
+		SubsDefQos:   smContext.DnnConfiguration.Var5gQosProfile,
+	}
+
+	ctx, _, err := smf_context.GetSelf().
+		GetTokenCtx(models.ServiceName_NPCF_SMPOLICYCONTROL, models.NrfNfManagementNfType_PCF)
+	if err != nil {
+		return nil, err
+	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	var client *SMPolicyControl.APIClient
+	for _, service := range smContext.SelectedPCFProfile.NfServices {
+		if service.ServiceName == models.ServiceName_NPCF_SMPOLICYCONTROL {
+			client = s.getSMPolicyControlClient(service.ApiPrefix)
+		}
+	}
+	if client == nil {
+		return nil, errors.Errorf("smContext not selected PCF")
+	}
+
+	request := &SMPolicyControl.UpdateSMPolicyRequest{
+		SmPolicyId:                &smContext.SMPolicyID,
+		SmPolicyUpdateContextData: &updateSMPolicy,
+	}
+
+	smPolicyDecisionFromPCF, err := client.IndividualSMPolicyDocumentApi.UpdateSMPolicy(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("update sm policy [%s] association failed: %s", smContext.SMPolicyID, err)
+	}
+	return &smPolicyDecisionFromPCF.SmPolicyDecision, nil
+}
+
 func (s *npcfService) nasBitRateToString(value uint16, unit nasType.QoSFlowBitRateUnit) string {
 	var base int
 	var unitStr string
@@ -441,6 +487,7 @@ func (s *npcfService) SendSMPolicyAssociationTermination(smContext *smf_context.
 	if err != nil {
 		return err
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	request := &SMPolicyControl.DeleteSMPolicyRequest{
 		SmPolicyId:         &smContext.SMPolicyID,
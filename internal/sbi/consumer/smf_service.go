@@ -2,12 +2,16 @@ package consumer
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/openapi/smf/PDUSession"
+	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/pkg/factory"
 )
 
 type nsmfService struct {
@@ -31,6 +35,7 @@ func (s *nsmfService) getPDUSessionClient(uri string) *PDUSession.APIClient {
 
 	configuration := PDUSession.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_SMF))
 	client = PDUSession.NewAPIClient(configuration)
 
 	s.PDUSessionMu.RUnlock()
@@ -78,3 +83,119 @@ func (s *nsmfService) SendSMContextStatusNotification(uri string) (*models.Probl
 	}
 	return nil, nil
 }
+
+// SendCreatePduSession creates the PDU session of the SM context in the H-SMF or the anchor SMF
+// (TS 29.502 5.2.2.7.1) and records the URI of the PDU session resource in the peer SMF.
+// The error response is returned if the peer SMF rejected the PDU session establishment.
+func (s *nsmfService) SendCreatePduSession(
+	smContext *smf_context.SMContext, request models.PostPduSessionsRequest,
+) (*models.PostPduSessionsResponse201, *models.PostPduSessionsResponse400, error) {
+	// hSmfUri and smfUri are the URI of the Nsmf_PDUSession service of the peer SMF
+	client := s.getPDUSessionClient(strings.TrimSuffix(smContext.PeerSmfUri(), factory.SmfPdusessionResUriPrefix))
+	if client == nil {
+		return nil, nil, fmt.Errorf("no peer SMF of the PDU session")
+	}
+
+	ctx, _, err := smf_context.GetSelf().
+		GetTokenCtx(models.ServiceName_NSMF_PDUSESSION, models.NrfNfManagementNfType_SMF)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	rsp, err := client.PDUSessionsCollectionApi.PostPduSessions(ctx,
+		&PDUSession.PostPduSessionsRequest{PostPduSessionsRequest: &request})
+	if err != nil {
+		if apiErr, ok := err.(openapi.GenericOpenAPIError); ok {
+			if errModel, ok := apiErr.Model().(PDUSession.PostPduSessionsError); ok &&
+				errModel.PostPduSessionsResponse400.JsonData != nil {
+				return nil, &errModel.PostPduSessionsResponse400, err
+			}
+		}
+		return nil, nil, err
+	}
+	if rsp.PostPduSessionsResponse201.JsonData == nil {
+		return nil, nil, fmt.Errorf("no PDU session created data")
+	}
+
+	smContext.PeerPduSessionUri = rsp.Location
+	return &rsp.PostPduSessionsResponse201, nil, nil
+}
+
+// SendUpdatePduSession updates the PDU session of the SM context in the H-SMF or the anchor SMF
+// (TS 29.502 5.2.2.8.2). The error response is returned if the peer SMF rejected the update.
+func (s *nsmfService) SendUpdatePduSession(
+	smContext *smf_context.SMContext, request models.UpdatePduSessionRequest,
+) (*models.UpdatePduSessionResponse200, *models.UpdatePduSessionResponse400, error) {
+	client, pduSessionRef, err := s.getPeerSmfPDUSessionClient(smContext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, _, err := smf_context.GetSelf().
+		GetTokenCtx(models.ServiceName_NSMF_PDUSESSION, models.NrfNfManagementNfType_SMF)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	rsp, err := client.IndividualPDUSessionHSMFOrSMFApi.UpdatePduSession(ctx,
+		&PDUSession.UpdatePduSessionRequest{
+			PduSessionRef:           &pduSessionRef,
+			UpdatePduSessionRequest: &request,
+		})
+	if err != nil {
+		if apiErr, ok := err.(openapi.GenericOpenAPIError); ok {
+			if errModel, ok := apiErr.Model().(PDUSession.UpdatePduSessionError); ok &&
+				errModel.UpdatePduSessionResponse400.JsonData != nil {
+				return nil, &errModel.UpdatePduSessionResponse400, err
+			}
+		}
+		return nil, nil, err
+	}
+	return &rsp.UpdatePduSessionResponse200, nil, nil
+}
+
+// SendReleasePduSession releases the PDU session of the SM context in the H-SMF or the anchor SMF
+// (TS 29.502 5.2.2.9)
+func (s *nsmfService) SendReleasePduSession(
+	smContext *smf_context.SMContext, releaseData models.ReleaseData,
+) error {
+	client, pduSessionRef, err := s.getPeerSmfPDUSessionClient(smContext)
+	if err != nil {
+		return err
+	}
+
+	ctx, _, err := smf_context.GetSelf().
+		GetTokenCtx(models.ServiceName_NSMF_PDUSESSION, models.NrfNfManagementNfType_SMF)
+	if err != nil {
+		return err
+	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	_, err = client.IndividualPDUSessionHSMFOrSMFApi.ReleasePduSession(ctx,
+		&PDUSession.ReleasePduSessionRequest{
+			PduSessionRef: &pduSessionRef,
+			ReleasePduSessionRequest: &models.ReleasePduSessionRequest{
+				JsonData: &releaseData,
+			},
+		})
+	if err != nil {
+		return fmt.Errorf("release PDU session in peer SMF failed: %v", err)
+	}
+	return nil
+}
+
+// getPeerSmfPDUSessionClient returns the client of the peer SMF and the reference of the PDU session resource
+// in the peer SMF, i.e. {apiRoot}/nsmf-pdusession/v1/pdu-sessions/{pduSessionRef}
+func (s *nsmfService) getPeerSmfPDUSessionClient(
+	smContext *smf_context.SMContext,
+) (*PDUSession.APIClient, string, error) {
+	idx := strings.LastIndex(smContext.PeerPduSessionUri, "/pdu-sessions/")
+	if idx < 0 {
+		return nil, "", fmt.Errorf("invalid PDU session URI[%s] of peer SMF", smContext.PeerPduSessionUri)
+	}
+	apiRoot := strings.TrimSuffix(smContext.PeerPduSessionUri[:idx], factory.SmfPdusessionResUriPrefix)
+	pduSessionRef := smContext.PeerPduSessionUri[idx+len("/pdu-sessions/"):]
+	return s.getPDUSessionClient(apiRoot), pduSessionRef, nil
+}
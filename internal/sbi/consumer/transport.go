@@ -0,0 +1,46 @@
+package consumer
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/smf/internal/metrics"
+	"github.com/free5gc/smf/internal/tracing"
+)
+
+// newHTTPClient makes the HTTP client of the API clients to the target NF type,
+// which traces each request and records its latency
+func newHTTPClient(targetNf models.NrfNfManagementNfType) *http.Client {
+	return &http.Client{
+		Transport: &sbiTransport{targetNf: string(targetNf)},
+	}
+}
+
+type sbiTransport struct {
+	targetNf string
+}
+
+func (t *sbiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, span := tracing.StartSbiClientSpan(req, t.targetNf)
+	start := time.Now()
+	// without the HTTP client of the configuration, the request is sent by the HTTP/2 clients of openapi
+	rsp, err := openapi.CallAPI(defaultConfiguration{}, req)
+	status := "0"
+	if rsp != nil {
+		status = strconv.Itoa(rsp.StatusCode)
+	}
+	metrics.SbiClientRequestDuration.WithLabelValues(t.targetNf, status).Observe(time.Since(start).Seconds())
+	tracing.EndSbiClientSpan(span, rsp, err)
+	return rsp, err
+}
+
+type defaultConfiguration struct{}
+
+func (defaultConfiguration) BasePath() string                 { return "" }
+func (defaultConfiguration) Host() string                     { return "" }
+func (defaultConfiguration) UserAgent() string                { return "" }
+func (defaultConfiguration) DefaultHeader() map[string]string { return nil }
+func (defaultConfiguration) HTTPClient() *http.Client         { return nil }
@@ -39,6 +39,7 @@ func (s *nudmService) getSubscribeDataManagementClient(uri string) *SubscriberDa
 
 	configuration := SubscriberDataManagement.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_UDM))
 	client = SubscriberDataManagement.NewAPIClient(configuration)
 
 	s.SubscriberDataManagementMu.RUnlock()
@@ -61,6 +62,7 @@ func (s *nudmService) getUEContextManagementClient(uri string) *UEContextManagem
 
 	configuration := UEContextManagement.NewConfiguration()
 	configuration.SetBasePath(uri)
+	configuration.SetHTTPClient(newHTTPClient(models.NrfNfManagementNfType_UDM))
 	client = UEContextManagement.NewAPIClient(configuration)
 
 	s.UEContextManagementMu.RUnlock()
@@ -106,6 +108,7 @@ func (s *nudmService) UeCmRegistration(smCtx *smf_context.SMContext) (
 	if err != nil {
 		return pd, err
 	}
+	ctx = smCtx.WithTraceContext(ctx)
 
 	request := &UEContextManagement.RegistrationRequest{
 		UeId:            &smCtx.Supi,
@@ -150,6 +153,7 @@ func (s *nudmService) UeCmDeregistration(smCtx *smf_context.SMContext) (*models.
 	if err != nil {
 		return pd, err
 	}
+	ctx = smCtx.WithTraceContext(ctx)
 
 	request := &UEContextManagement.SmfDeregistrationRequest{
 		UeId:         &smCtx.Supi,
@@ -211,6 +215,7 @@ func (s *nudmService) GetSmData(ctx context.Context, supi string,
 func (s *nudmService) Subscribe(ctx context.Context, smCtx *smf_context.SMContext, smPlmnID *models.PlmnIdNid) (
 	*models.ProblemDetails, error,
 ) {
+	ctx = smCtx.WithTraceContext(ctx)
 	var client *SubscriberDataManagement.APIClient
 	for _, service := range s.consumer.Context().UDMProfile.NfServices {
 		if service.ServiceName == models.ServiceName_NUDM_SDM {
@@ -229,6 +234,14 @@ func (s *nudmService) Subscribe(ctx context.Context, smCtx *smf_context.SMContex
 		UeId: &smCtx.Supi,
 		SdmSubscription: &models.SdmSubscription{
 			NfInstanceId: s.consumer.Context().NfInstanceID,
+			// The subscription is per UE, so the changes of all its PDU sessions are notified
+			CallbackReference: fmt.Sprintf("%s://%s:%d/nsmf-callback/sdm-subscription-notify/%s",
+				s.consumer.Context().URIScheme,
+				s.consumer.Context().RegisterIPv4,
+				s.consumer.Context().SBIPort,
+				smCtx.Supi,
+			),
+			MonitoredResourceUris: []string{smCtx.Supi + "/sm-data"},
 			PlmnId: &models.PlmnId{
 				Mcc: smPlmnID.Mcc,
 				Mnc: smPlmnID.Mnc,
@@ -268,6 +281,7 @@ func (s *nudmService) UnSubscribe(smCtx *smf_context.SMContext) (
 	if err != nil {
 		return nil, err
 	}
+	ctx = smCtx.WithTraceContext(ctx)
 
 	if s.consumer.Context().Ues.IsLastPduSession(smCtx.Supi) {
 		var client *SubscriberDataManagement.APIClient
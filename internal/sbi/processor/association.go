@@ -2,7 +2,9 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/free5gc/nas/nasMessage"
@@ -11,9 +13,12 @@ import (
 	"github.com/free5gc/pfcp/pfcpType"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	"github.com/free5gc/smf/internal/pfcp/message"
 )
 
+var errUPFRestarted = errors.New("UPF restarted")
+
 func (p *Processor) ToBeAssociatedWithUPF(smfPfcpContext context.Context, upf *smf_context.UPF) {
 	var upfStr string
 	if upf.NodeID.NodeIdType == pfcpType.NodeIdTypeFqdn {
@@ -22,6 +27,9 @@ func (p *Processor) ToBeAssociatedWithUPF(smfPfcpContext context.Context, upf *s
 		upfStr = fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
 	}
 
+	// The PFCP sessions of the SM contexts restored after the SMF restart are established on the first
+	// association, the UPF deletes the PFCP sessions of the previous association of the SMF
+	upfRestarted := smf_context.SMContextsRestored()
 	for {
 		// check if SMF PFCP context (parent) was canceled
 		// note: UPF AssociationContexts are children of smfPfcpContext
@@ -30,14 +38,30 @@ func (p *Processor) ToBeAssociatedWithUPF(smfPfcpContext context.Context, upf *s
 			logger.MainLog.Infoln("Canceled SMF PFCP context")
 			return
 		default:
-			ensureSetupPfcpAssociation(smfPfcpContext, upf, upfStr)
+			// The UPF is not associated again before its graceful release period expires
+			if !waitGracefulRelease(smfPfcpContext, upf, upfStr) {
+				return
+			}
+			if ensureSetupPfcpAssociation(smfPfcpContext, upf, upfStr) {
+				p.markPfcpSessionsLost(upf, upfStr)
+				upfRestarted = true
+			}
+			// The PFDs are provisioned before the PFCP sessions using them are restored
+			provisionPfdsToUPF(upf)
+			if upfRestarted {
+				p.restorePfcpSessionsOfUPF(upf, upfStr)
+				upfRestarted = false
+			}
 			if smf_context.GetSelf().PfcpHeartbeatInterval == 0 {
 				return
 			}
-			keepHeartbeatTo(upf, upfStr)
-			// returns when UPF heartbeat loss is detected or association is canceled
-
-			p.releaseAllResourcesOfUPF(upf, upfStr)
+			// returns when UPF heartbeat loss or restart is detected or association is canceled
+			if upfRestarted = keepHeartbeatTo(upf, upfStr); upfRestarted {
+				// The PFCP sessions are restored once the association is set up again
+				p.markPfcpSessionsLost(upf, upfStr)
+			} else {
+				p.releaseAllResourcesOfUPF(upf, upfStr)
+			}
 		}
 	}
 }
@@ -52,17 +76,104 @@ func (p *Processor) ReleaseAllResourcesOfUPF(upf *smf_context.UPF) {
 	p.releaseAllResourcesOfUPF(upf, upfStr)
 }
 
-func ensureSetupPfcpAssociation(parentContext context.Context, upf *smf_context.UPF, upfStr string) {
+// HandleUPFGracefulRelease handles the release of the PFCP association requested by the UPF
+// (TS 29.244 6.2.7.2): the UPF is no longer selected for new PDU sessions. The PDU sessions it serves
+// are released to be re-established by the UEs on another UPF when the graceful release period expires,
+// then the PFCP association is released. A negative period never expires, the UPF then keeps serving
+// its PDU sessions until it releases the PFCP association.
+func (p *Processor) HandleUPFGracefulRelease(upf *smf_context.UPF, period time.Duration) {
+	upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	if !upf.StartGracefulRelease(period) {
+		logger.MainLog.Infof("Graceful release of UPF%s is in progress", upfStr)
+		return
+	}
+	// The default paths are generated again to avoid the UPF for new PDU sessions
+	smf_context.GetUserPlaneInformation().ResetDefaultUserPlanePath()
+
+	if period == 0 {
+		logger.MainLog.Infof("Start graceful release of UPF%s", upfStr)
+		p.releaseUPFGracefully(upf, upfStr)
+		return
+	}
+	if period < 0 {
+		logger.MainLog.Infof("Start graceful release of UPF%s with infinite graceful release period", upfStr)
+		return
+	}
+	logger.MainLog.Infof("Start graceful release of UPF%s for %s", upfStr, period)
+	go func(associationContext context.Context) {
+		timer := time.NewTimer(period)
+		defer timer.Stop()
+		select {
+		case <-associationContext.Done():
+			// The PDU sessions are released with the association
+			logger.MainLog.Infof("Association to UPF%s released in graceful release period", upfStr)
+		case <-timer.C:
+			logger.MainLog.Infof("Graceful release period of UPF%s expired", upfStr)
+			p.releaseUPFGracefully(upf, upfStr)
+		}
+	}(upf.AssociationContext)
+}
+
+// releaseUPFGracefully releases the PDU sessions served by the UPF and the PFCP association
+func (p *Processor) releaseUPFGracefully(upf *smf_context.UPF, upfStr string) {
+	upf.ProcEachSMContextOnPath(func(smContext *smf_context.SMContext) {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		switch smContext.State() {
+		case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+			smContext.Log.Infof("Release PDU session due to graceful release of UPF%s", upfStr)
+			p.releasePDUSessionByNetwork(smContext, nasMessage.Cause5GSMReactivationRequested)
+		}
+	})
+
+	logger.MainLog.Infof("Sending PFCP Association Release Request to UPF%s", upfStr)
+	if rcvMsg, err := message.SendPfcpAssociationReleaseRequest(upf.NodeID); err != nil {
+		logger.MainLog.Warnf("Sending PFCP Association Release Request to UPF%s error: %+v", upfStr, err)
+	} else if rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPAssociationReleaseResponse); rsp.Cause == nil ||
+		rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		logger.MainLog.Warnf("Received PFCP Association Release Not Accepted Response from UPF%s", upfStr)
+	}
+	// The association is set up again once the UPF is available
+	upf.CancelAssociation()
+}
+
+// waitGracefulRelease waits until the graceful release period of the UPF expires, so the UPF
+// is not associated again before. It returns false if the SMF PFCP context is canceled.
+func waitGracefulRelease(parentContext context.Context, upf *smf_context.UPF, upfStr string) bool {
+	remaining, expires := upf.GracefulReleaseRemaining()
+	if expires && remaining <= 0 {
+		return true
+	}
+	var expired <-chan time.Time
+	if expires {
+		logger.MainLog.Infof("Wait %s for the graceful release period of UPF%s to expire", remaining, upfStr)
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		expired = timer.C
+	} else {
+		logger.MainLog.Infof("Graceful release period of UPF%s is infinite, it isn't associated again", upfStr)
+	}
+	select {
+	case <-parentContext.Done():
+		logger.MainLog.Infoln("Canceled SMF PFCP context")
+		return false
+	case <-expired:
+		return true
+	}
+}
+
+// ensureSetupPfcpAssociation returns true if the UPF has restarted since the previous association
+func ensureSetupPfcpAssociation(parentContext context.Context, upf *smf_context.UPF, upfStr string) bool {
 	alertTime := time.Now()
 	alertInterval := smf_context.GetSelf().AssocFailAlertInterval
 	retryInterval := smf_context.GetSelf().AssocFailRetryInterval
 	for {
-		err := setupPfcpAssociation(upf, upfStr)
+		upfRestarted, err := setupPfcpAssociation(upf, upfStr)
 		if err == nil {
 			// success
 			// assign UPF an AssociationContext, with SMF PFCP Context as parent
 			upf.AssociationContext, upf.CancelAssociation = context.WithCancel(parentContext)
-			return
+			return upfRestarted
 		}
 		logger.MainLog.Warnf("Failed to setup an association with UPF[%s], error:%+v", upfStr, err)
 		now := time.Now()
@@ -76,51 +187,71 @@ func ensureSetupPfcpAssociation(parentContext context.Context, upf *smf_context.
 		select { // no default case, either case needs to be true to continue
 		case <-parentContext.Done():
 			logger.MainLog.Infoln("Canceled SMF PFCP context")
-			return
+			return false
 		case <-timer:
 			continue
 		}
 	}
 }
 
-func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) error {
+// setupPfcpAssociation returns true if the UPF has restarted since the previous association
+func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) (bool, error) {
 	logger.MainLog.Infof("Sending PFCP Association Request to UPF%s", upfStr)
 
 	resMsg, err := message.SendPfcpAssociationSetupRequest(upf.NodeID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	rsp := resMsg.PfcpMessage.Body.(pfcp.PFCPAssociationSetupResponse)
 
 	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
-		return fmt.Errorf("received PFCP Association Setup Not Accepted Response from UPF%s", upfStr)
+		return false, fmt.Errorf("received PFCP Association Setup Not Accepted Response from UPF%s", upfStr)
 	}
 
 	nodeID := rsp.NodeID
 	if nodeID == nil {
-		return fmt.Errorf("pfcp association needs NodeID")
+		return false, fmt.Errorf("pfcp association needs NodeID")
 	}
 
 	logger.MainLog.Infof("Received PFCP Association Setup Accepted Response from UPF%s", upfStr)
 	logger.MainLog.Infof("UPF(%s) setup association", upf.NodeID.ResolveNodeIdToIp().String())
 
-	return nil
+	upf.UPFunctionFeatures = rsp.UPFunctionFeatures
+
+	// The UPF reports the user plane path failures again on the new association
+	pathFailuresCleared := upf.ClearPathFailures()
+	if pathFailuresCleared || upf.IsInGracefulRelease() {
+		upf.StopGracefulRelease()
+		smf_context.GetUserPlaneInformation().ResetDefaultUserPlanePath()
+	}
+
+	upfRestarted := false
+	if rsp.RecoveryTimeStamp != nil {
+		recoveryTimeStamp := rsp.RecoveryTimeStamp.RecoveryTimeStamp
+		if upfRestarted = upf.UpdateRecoveryTimeStamp(recoveryTimeStamp); upfRestarted {
+			logger.MainLog.Warnf("UPF%s has restarted at %s (%d restarts detected)",
+				upfStr, recoveryTimeStamp, upf.RestartCount())
+		}
+	}
+
+	return upfRestarted, nil
 }
 
-func keepHeartbeatTo(upf *smf_context.UPF, upfStr string) {
+// keepHeartbeatTo returns true if the UPF restart is detected by the heartbeat
+func keepHeartbeatTo(upf *smf_context.UPF, upfStr string) bool {
 	for {
 		err := doPfcpHeartbeat(upf, upfStr)
 		if err != nil {
 			logger.MainLog.Errorf("PFCP Heartbeat error: %v", err)
-			return
+			return errors.Is(err, errUPFRestarted)
 		}
 
 		timer := time.After(smf_context.GetSelf().PfcpHeartbeatInterval)
 		select {
 		case <-upf.AssociationContext.Done():
 			logger.MainLog.Infof("Canceled association to UPF[%s]", upfStr)
-			return
+			return false
 		case <-timer:
 			continue
 		}
@@ -136,8 +267,8 @@ func doPfcpHeartbeat(upf *smf_context.UPF, upfStr string) error {
 
 	resMsg, err := message.SendPfcpHeartbeatRequest(upf)
 	if err != nil {
+		metrics.PfcpHeartbeatFailures.WithLabelValues(upf.GetUPFIP()).Inc()
 		upf.CancelAssociation()
-		upf.RecoveryTimeStamp = time.Time{}
 		return fmt.Errorf("SendPfcpHeartbeatRequest error: %w", err)
 	}
 
@@ -148,14 +279,12 @@ func doPfcpHeartbeat(upf *smf_context.UPF, upfStr string) error {
 	}
 
 	logger.MainLog.Debugf("Received PFCP Heartbeat Response from UPF%s", upfStr)
-	if upf.RecoveryTimeStamp.IsZero() {
-		// first receive
-		upf.RecoveryTimeStamp = rsp.RecoveryTimeStamp.RecoveryTimeStamp
-	} else if upf.RecoveryTimeStamp.Before(rsp.RecoveryTimeStamp.RecoveryTimeStamp) {
-		// received a newer recovery timestamp
+	recoveryTimeStamp := rsp.RecoveryTimeStamp.RecoveryTimeStamp
+	if upf.UpdateRecoveryTimeStamp(recoveryTimeStamp) {
+		// received a changed recovery timestamp, the UPF has lost the PFCP association and sessions
 		upf.CancelAssociation()
-		upf.RecoveryTimeStamp = time.Time{}
-		return fmt.Errorf("received PFCP Heartbeat Response RecoveryTimeStamp has been updated")
+		return fmt.Errorf("received PFCP Heartbeat Response RecoveryTimeStamp %s (%d restarts detected): %w",
+			recoveryTimeStamp, upf.RestartCount(), errUPFRestarted)
 	}
 	return nil
 }
@@ -163,25 +292,233 @@ func doPfcpHeartbeat(upf *smf_context.UPF, upfStr string) error {
 func (p *Processor) releaseAllResourcesOfUPF(upf *smf_context.UPF, upfStr string) {
 	logger.MainLog.Infof("Release all resources of UPF %s", upfStr)
 
-	upf.ProcEachSMContext(func(smContext *smf_context.SMContext) {
-		smContext.SMLock.Lock()
-		defer smContext.SMLock.Unlock()
-		switch smContext.State() {
-		case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
-			needToSendNotify, removeContext := p.requestAMFToReleasePDUResources(smContext)
-			if needToSendNotify {
-				p.SendReleaseNotification(smContext)
+	csids := smf_context.CSIDsOfUPF(upf)
+	bulkDeleted := deletePfcpSessionSetsOfPeers(upf, csids)
+
+	smf_context.ProcEachSMContextInPDUSessionSets(csids, func(smContext *smf_context.SMContext) {
+		p.releasePDUSessionOfFailedUPF(smContext, upf, bulkDeleted)
+	})
+}
+
+// ReleasePDUSessionSetsOfUPF releases the PDU sessions of the PDU session sets identified by the FQ-CSIDs
+// of the PFCP Session Set Deletion Request of the UPF (TS 29.244 6.2.9), the other PDU sessions
+// served by the UPF are kept
+func (p *Processor) ReleasePDUSessionSetsOfUPF(upf *smf_context.UPF, fqCSIDs []*smf_context.FQCSID) {
+	smContexts := smf_context.SMContextsOfUPFFQCSIDs(upf, fqCSIDs)
+	logger.MainLog.Infof("Release %d PDU sessions of the PDU session sets deleted by UPF[%s]",
+		len(smContexts), upf.GetUPFIP())
+	for _, smContext := range smContexts {
+		p.releasePDUSessionOfFailedUPF(smContext, upf, nil)
+	}
+}
+
+// releasePDUSessionOfFailedUPF releases the PDU session whose PFCP session on the UPF has been lost,
+// the PFCP sessions on the other UPFs are deleted unless they have been deleted by PFCP Session Set Deletion
+func (p *Processor) releasePDUSessionOfFailedUPF(
+	smContext *smf_context.SMContext, upf *smf_context.UPF, bulkDeleted map[string]bool,
+) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	switch smContext.State() {
+	case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+		deletePfcpSessionsOfPeers(smContext, upf, bulkDeleted)
+
+		needToSendNotify, removeContext := p.requestAMFToReleasePDUResources(
+			smContext, nasMessage.Cause5GSMNetworkFailure)
+		if needToSendNotify {
+			p.SendReleaseNotification(smContext)
+		}
+		if removeContext {
+			// Notification has already been sent, if it is needed
+			p.RemoveSMContextFromAllNF(smContext, false)
+		}
+	}
+}
+
+// deletePfcpSessionSetsOfPeers deletes the PFCP sessions on the other UPFs of the PDU session sets
+// with PFCP Session Set Deletion (TS 29.244 6.2.9) if all of the PDU session sets served by that UPF
+// are affected. It returns the node IPs of the UPFs whose PFCP sessions have been deleted.
+func deletePfcpSessionSetsOfPeers(upf *smf_context.UPF, csids []uint16) map[string]bool {
+	affected := make(map[uint16]bool, len(csids))
+	for _, csid := range csids {
+		affected[csid] = true
+	}
+
+	bulkDeleted := make(map[string]bool)
+	for _, nodeIP := range smf_context.UPFNodeIPsOfPDUSessionSets(csids) {
+		if nodeIP == upf.GetUPFIP() {
+			continue
+		}
+		upNode := smf_context.GetUserPlaneInformation().GetUPFNodeByIP(nodeIP)
+		if upNode == nil || upNode.UPF == nil {
+			continue
+		}
+		peer := upNode.UPF
+
+		allAffected := true
+		for _, csid := range smf_context.CSIDsOfUPF(peer) {
+			if !affected[csid] {
+				allAffected = false
+				break
+			}
+		}
+		if !allAffected {
+			continue
+		}
+
+		rcvMsg, err := message.SendPfcpSessionSetDeletionRequest(peer)
+		if err != nil {
+			logger.PfcpLog.Warnf("Sending PFCP Session Set Deletion Request to UPF[%s] error: %+v", nodeIP, err)
+			continue
+		}
+		rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionSetDeletionResponse)
+		if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+			logger.PfcpLog.Warnf("Received PFCP Session Set Deletion Not Accepted Response from UPF[%s]", nodeIP)
+			continue
+		}
+		logger.PfcpLog.Infof("Received PFCP Session Set Deletion Accepted Response from UPF[%s]", nodeIP)
+		bulkDeleted[nodeIP] = true
+	}
+	return bulkDeleted
+}
+
+// deletePfcpSessionsOfPeers deletes the PFCP sessions of the SM context on the UPFs
+// other than the failed one which have not been deleted by PFCP Session Set Deletion
+func deletePfcpSessionsOfPeers(smContext *smf_context.SMContext, upf *smf_context.UPF, bulkDeleted map[string]bool) {
+	peers := make(map[string]*smf_context.UPF)
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			nodeIP := node.GetNodeIP()
+			if node.UPF == upf || bulkDeleted[nodeIP] {
+				continue
 			}
-			if removeContext {
-				// Notification has already been sent, if it is needed
-				p.RemoveSMContextFromAllNF(smContext, false)
+			if _, exist := smContext.PFCPContext[nodeIP]; exist {
+				peers[nodeIP] = node.UPF
 			}
 		}
-	})
+	}
+
+	resChan := make(chan SendPfcpResult)
+	for _, peer := range peers {
+		go deletePfcpSession(peer, smContext, resChan)
+	}
+	for i := 0; i < len(peers); i++ {
+		if res := <-resChan; res.Status != smf_context.SessionReleaseSuccess {
+			smContext.Log.Warnf("Delete PFCP session failed: %+v", res.Err)
+		}
+	}
+	close(resChan)
+}
+
+// markPfcpSessionsLost marks the PFCP sessions on the restarted UPF as lost,
+// so they are established again instead of modified
+func (p *Processor) markPfcpSessionsLost(upf *smf_context.UPF, upfStr string) {
+	logger.MainLog.Infof("PFCP sessions of UPF%s are lost", upfStr)
+
+	smf_context.ProcEachSMContextInPDUSessionSets(smf_context.CSIDsOfUPF(upf),
+		func(smContext *smf_context.SMContext) {
+			smContext.SMLock.Lock()
+			defer smContext.SMLock.Unlock()
+			if pfcpSessionCtx := smContext.PFCPContext[upf.GetUPFIP()]; pfcpSessionCtx != nil {
+				pfcpSessionCtx.RemoteSEID = 0
+			}
+		})
+}
+
+// restorePfcpSessionsOfUPF establishes the lost PFCP sessions again on the restarted UPF
+// from the rules stored in the SM contexts (TS 23.007),
+// the PDU sessions which cannot be restored are released
+func (p *Processor) restorePfcpSessionsOfUPF(upf *smf_context.UPF, upfStr string) {
+	logger.MainLog.Infof("Restore PFCP sessions of UPF%s", upfStr)
+
+	smf_context.ProcEachSMContextInPDUSessionSets(smf_context.CSIDsOfUPF(upf),
+		func(smContext *smf_context.SMContext) {
+			smContext.SMLock.Lock()
+			defer smContext.SMLock.Unlock()
+			switch smContext.State() {
+			case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+			default:
+				return
+			}
+
+			pfcpSessionCtx := smContext.PFCPContext[upf.GetUPFIP()]
+			if pfcpSessionCtx == nil || pfcpSessionCtx.RemoteSEID != 0 {
+				// not lost, or already established again
+				return
+			}
+
+			resChan := make(chan SendPfcpResult)
+			go establishPfcpSession(smContext, pfcpStateOfUPF(smContext, upf), resChan)
+			res := <-resChan
+			close(resChan)
+
+			if res.Status != smf_context.SessionEstablishSuccess {
+				smContext.Log.Warnf("Restore PFCP session on UPF%s failed: %+v", upfStr, res.Err)
+				p.releasePDUSessionByNetwork(smContext, nasMessage.Cause5GSMNetworkFailure)
+				return
+			}
+			smContext.Log.Infof("Restored PFCP session on UPF%s", upfStr)
+		})
+}
+
+// pfcpStateOfUPF collects the rules of the SM context installed on the UPF,
+// which are all to be created again in the UPF
+func pfcpStateOfUPF(smContext *smf_context.SMContext, upf *smf_context.UPF) *PFCPState {
+	pfcpState := &PFCPState{upf: upf}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.UPF != upf {
+				continue
+			}
+			for _, tunnel := range []*smf_context.GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+				if tunnel == nil || tunnel.PDR == nil || tunnel.PDR.State == smf_context.RULE_REMOVE {
+					continue
+				}
+				pdr := tunnel.PDR
+				pdr.State = smf_context.RULE_INITIAL
+				pfcpState.pdrList = append(pfcpState.pdrList, pdr)
+				if pdr.FAR != nil {
+					pdr.FAR.State = smf_context.RULE_INITIAL
+					pfcpState.farList = append(pfcpState.farList, pdr.FAR)
+					// the downlink FARs share the BAR
+					if bar := pdr.FAR.BAR; bar != nil && !slices.Contains(pfcpState.barList, bar) {
+						bar.State = smf_context.RULE_INITIAL
+						pfcpState.barList = append(pfcpState.barList, bar)
+					}
+				}
+				// uplink and downlink share the QERs
+				if tunnel == node.UpLinkTunnel {
+					for _, qer := range pdr.QER {
+						qer.State = smf_context.RULE_INITIAL
+					}
+					pfcpState.qerList = append(pfcpState.qerList, pdr.QER...)
+				}
+				for _, urr := range pdr.URR {
+					urr.State = smf_context.RULE_INITIAL
+				}
+				pfcpState.urrList = append(pfcpState.urrList, pdr.URR...)
+			}
+			// the framed route PDRs share the FAR, QERs and URRs of the downlink PDR
+			for _, pdr := range node.FramedRoutePDRs {
+				pdr.State = smf_context.RULE_INITIAL
+				pfcpState.pdrList = append(pfcpState.pdrList, pdr)
+			}
+		}
+	}
+	// the PDR detecting the downlink data forwarded back by the SMF shares the downlink FAR and QERs
+	if pdr := smContext.DlDataForwardingPDROf(upf); pdr != nil {
+		pdr.State = smf_context.RULE_INITIAL
+		pfcpState.pdrList = append(pfcpState.pdrList, pdr)
+	}
+	return pfcpState
 }
 
 func (p *Processor) requestAMFToReleasePDUResources(
 	smContext *smf_context.SMContext,
+	cause uint8,
 ) (sendNotify bool, releaseContext bool) {
 	n1n2Request := models.N1N2MessageTransferRequest{}
 	// TS 23.502 4.3.4.2 3b. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
@@ -189,11 +526,11 @@ func (p *Processor) requestAMFToReleasePDUResources(
 		PduSessionId: smContext.PDUSessionID,
 		SkipInd:      true,
 	}
-	cause := nasMessage.Cause5GSMNetworkFailure
-	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, false); err != nil {
+	releaseCommand, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, false)
+	if err != nil {
 		logger.MainLog.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
 	} else {
-		n1n2Request.BinaryDataN1Message = buf
+		n1n2Request.BinaryDataN1Message = releaseCommand
 		n1n2Request.JsonData.N1MessageContainer = &models.N1MessageContainer{
 			N1MessageClass:   "SM",
 			N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
@@ -243,6 +580,10 @@ func (p *Processor) requestAMFToReleasePDUResources(
 		} else if rspData.Cause == models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED {
 			// wait for N2 PDU Session Release Response
 			smContext.SetState(smf_context.InActivePending)
+			if releaseCommand != nil {
+				// The PDU Session Release Command is retransmitted until the UE completes the release
+				p.sendGSMPDUSessionReleaseCommand(smContext, releaseCommand)
+			}
 		} else {
 			// other causes are unexpected.
 			// keep SM Context to avoid inconsistency with AMF
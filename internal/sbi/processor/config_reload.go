@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// ReloadConfig reads the SMF and the UE routing configuration files again and applies the changes,
+// nothing is applied if the files are invalid or any change is rejected
+func (p *Processor) ReloadConfig() (*smf_context.ConfigReload, error) {
+	logger.CfgLog.Infof("Reload configuration")
+	cfg, err := factory.ReadConfig(factory.SmfConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	routingCfg, err := factory.ReadUERoutingConfig(factory.UERoutingConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	reload, err := smf_context.ReloadConfig(cfg, routingCfg)
+	if err != nil {
+		if reload != nil {
+			for item, reason := range reload.Rejected {
+				logger.CfgLog.Errorf("Change of %s is rejected: %s", item, reason)
+			}
+		}
+		return reload, err
+	}
+
+	if reload.LoggerChanged {
+		p.SetLogEnable(cfg.GetLogEnable())
+		p.SetLogLevel(cfg.GetLogLevel())
+		p.SetReportCaller(cfg.GetLogReportCaller())
+	}
+	if reload.PfdDataChanged {
+		go p.ProvisionReloadedPfds(reload.RemovedPfdAppIDs)
+	}
+	if reload.NfProfileChanged {
+		// The changes are kept even if the NRF is not updated, the profile is updated again by the next reload
+		if err = p.Consumer().UpdateNFInstance(); err != nil {
+			logger.CfgLog.Errorf("Update SMF profile in NRF failed: %+v", err)
+		}
+	}
+	return reload, nil
+}
+
+func (p *Processor) HandleReloadConfig(c *gin.Context) {
+	reload, err := p.ReloadConfig()
+	switch {
+	case errors.Is(err, smf_context.ErrConfigReloadRejected):
+		c.JSON(http.StatusConflict, reload)
+	case err != nil:
+		problemDetails := openapi.ProblemDetailsSystemFailure(err.Error())
+		c.JSON(int(problemDetails.Status), problemDetails)
+	default:
+		c.JSON(http.StatusOK, reload)
+	}
+}
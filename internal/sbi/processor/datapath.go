@@ -1,6 +1,8 @@
 package processor
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"github.com/free5gc/nas/nasMessage"
@@ -10,6 +12,7 @@ import (
 	"github.com/free5gc/pfcp/pfcpUdp"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	pfcp_message "github.com/free5gc/smf/internal/pfcp/message"
 )
 
@@ -28,12 +31,41 @@ type SendPfcpResult struct {
 	Err    error
 }
 
+// pfcpRejectError is the PFCP request not accepted by the UPF
+type pfcpRejectError struct {
+	cause uint8
+}
+
+func newPfcpRejectError(cause *pfcpType.Cause) error {
+	if cause == nil {
+		return &pfcpRejectError{}
+	}
+	return &pfcpRejectError{cause: cause.CauseValue}
+}
+
+func (e *pfcpRejectError) Error() string {
+	return fmt.Sprintf("cause[%d] if not request accepted", e.cause)
+}
+
+// gsmCauseOfPfcpFailure returns the 5GSM cause of the PDU session procedure failed by the PFCP request
+func gsmCauseOfPfcpFailure(err error) uint8 {
+	var rejectErr *pfcpRejectError
+	if errors.As(err, &rejectErr) {
+		if rejectErr.cause == pfcpType.CauseNoResourcesAvailable {
+			return nasMessage.Cause5GSMInsufficientResources
+		}
+	}
+	// The UPF rejects the request, or isn't reached until the request is timed out
+	return nasMessage.Cause5GSMNetworkFailure
+}
+
 // ActivateUPFSession send all datapaths to UPFs and send result to UE
 // It returns after all PFCP response have been returned or timed out,
 // and before sending N1N2MessageTransfer request if it is needed.
+// The error of the failed PFCP request is passed to notifyUeHander, nil if all requests succeed.
 func ActivateUPFSession(
 	smContext *smf_context.SMContext,
-	notifyUeHander func(*smf_context.SMContext, bool),
+	notifyUeHander func(*smf_context.SMContext, error),
 ) {
 	smContext.Log.Traceln("In ActivateUPFSession")
 
@@ -67,6 +99,8 @@ func ActivateUPFSession(
 				}
 				// skip send QER because uplink and downlink shared one QER
 			}
+			// the framed route PDRs share the FAR, QERs and URRs of the downlink PDR
+			pdrList = append(pdrList, node.FramedRoutePDRs...)
 
 			pfcpState := pfcpPool[node.GetNodeIP()]
 			if pfcpState == nil {
@@ -145,6 +179,22 @@ func establishPfcpSession(smContext *smf_context.SMContext,
 		NodeIDtoIP := rsp.NodeID.ResolveNodeIdToIp().String()
 		pfcpSessionCtx := smContext.PFCPContext[NodeIDtoIP]
 		pfcpSessionCtx.RemoteSEID = rsp.UPFSEID.Seid
+		// The PFCP session is deleted with the PDU session sets of the UPF FQ-CSID, which is decoded
+		// into the first FQ-CSID field of the response
+		for _, fqCSID := range []*pfcpType.FQCSID{rsp.SGWUFQCSID, rsp.PGWUFQCSID} {
+			if fqCSID == nil {
+				continue
+			}
+			if pfcpSessionCtx.UPFFQCSID, err = smf_context.ParseFQCSID(fqCSID.FQCSIDdata); err != nil {
+				logger.PduSessLog.Warnf("Invalid UPF FQ-CSID is ignored: %+v", err)
+			}
+			break
+		}
+	}
+	// NOTE: free5gc/pfcp decodes only one Created PDR IE,
+	// so the UPF is requested to allocate only the N3 F-TEID shared by the UL PDRs
+	if rsp.CreatedPDR != nil && rsp.CreatedPDR.LocalFTEID != nil {
+		smContext.SetChosenN3FTEID(state.upf, rsp.CreatedPDR.LocalFTEID)
 	}
 
 	if rsp.Cause != nil && rsp.Cause.CauseValue == pfcpType.CauseRequestAccepted {
@@ -157,7 +207,7 @@ func establishPfcpSession(smContext *smf_context.SMContext,
 		logger.PduSessLog.Infoln("Received PFCP Session Establishment Not Accepted Response")
 		resCh <- SendPfcpResult{
 			Status: smf_context.SessionEstablishFailed,
-			Err:    fmt.Errorf("cause[%d] if not request accepted", rsp.Cause.CauseValue),
+			Err:    newPfcpRejectError(rsp.Cause),
 		}
 	}
 }
@@ -197,7 +247,7 @@ func modifyExistingPfcpSession(
 	} else {
 		resCh <- SendPfcpResult{
 			Status: smf_context.SessionUpdateFailed,
-			Err:    fmt.Errorf("cause[%d] if not request accepted", rsp.Cause.CauseValue),
+			Err:    newPfcpRejectError(rsp.Cause),
 		}
 	}
 }
@@ -206,49 +256,55 @@ func waitAllPfcpRsp(
 	smContext *smf_context.SMContext,
 	pfcpPoolLen int,
 	resChan <-chan SendPfcpResult,
-	notifyUeHander func(*smf_context.SMContext, bool),
+	notifyUeHander func(*smf_context.SMContext, error),
 ) {
-	success := true
+	var pfcpErr error
 	for i := 0; i < pfcpPoolLen; i++ {
 		res := <-resChan
-		if notifyUeHander == nil {
+		if res.Status != smf_context.SessionEstablishFailed &&
+			res.Status != smf_context.SessionUpdateFailed {
 			continue
 		}
-
-		if res.Status == smf_context.SessionEstablishFailed ||
-			res.Status == smf_context.SessionUpdateFailed {
-			success = false
+		smContext.Log.Warnf("PFCP request failed: %+v", res.Err)
+		// The first failure is the cause of the procedure failure
+		if pfcpErr == nil {
+			pfcpErr = res.Err
+			if pfcpErr == nil {
+				pfcpErr = errors.New("PFCP request failed")
+			}
 		}
 	}
 	if notifyUeHander != nil {
-		notifyUeHander(smContext, success)
+		notifyUeHander(smContext, pfcpErr)
 	}
 }
 
 func (p *Processor) EstHandler(isDone <-chan struct{},
-	smContext *smf_context.SMContext, success bool,
+	smContext *smf_context.SMContext, pfcpErr error,
 ) {
 	// Waiting for Create SMContext Request completed
 	if isDone != nil {
 		<-isDone
 	}
-	if success {
+	if pfcpErr == nil {
+		metrics.CountPduSessionProcedure(metrics.ProcedureEstablishment, true, 0)
 		p.sendPDUSessionEstablishmentAccept(smContext)
 	} else {
-		// TODO: set appropriate 5GSM cause according to PFCP cause value
-		p.sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMNetworkFailure)
+		gsmCause := gsmCauseOfPfcpFailure(pfcpErr)
+		smContext.Log.Warnf("PDU session establishment failed with 5GSM cause[%d]: %+v", gsmCause, pfcpErr)
+		metrics.CountPduSessionProcedure(metrics.ProcedureEstablishment, false, gsmCause)
+		p.sendPDUSessionEstablishmentReject(smContext, gsmCause)
 	}
 }
 
-func ModHandler(smContext *smf_context.SMContext, success bool) {
+func ModHandler(smContext *smf_context.SMContext, pfcpErr error) {
 }
 
 func (p *Processor) sendPDUSessionEstablishmentReject(
 	smContext *smf_context.SMContext,
 	nasErrorCause uint8,
 ) {
-	smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentReject(
-		smContext, nasMessage.Cause5GSMNetworkFailure)
+	smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentReject(smContext, nasErrorCause)
 	if err != nil {
 		logger.PduSessLog.Errorf("Build GSM PDUSessionEstablishmentReject failed: %s", err)
 		return
@@ -272,6 +328,7 @@ func (p *Processor) sendPDUSessionEstablishmentReject(
 		logger.PduSessLog.Warnf("Get NAMF_COMM context failed: %s", errToken)
 		return
 	}
+	ctx = smContext.WithTraceContext(ctx)
 	rspData, err := p.Consumer().
 		N1N2MessageTransfer(ctx, smContext.Supi, n1n2Request, smContext.CommunicationClientApiPrefix)
 	if err != nil || rspData == nil {
@@ -288,8 +345,12 @@ func (p *Processor) sendPDUSessionEstablishmentReject(
 func (p *Processor) sendPDUSessionEstablishmentAccept(
 	smContext *smf_context.SMContext,
 ) {
-	smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext)
-	if err != nil {
+	var smNasBuf []byte
+	var err error
+	if smContext.ServedByPeerSmf() {
+		// the PDU Session Establishment Accept is built by the H-SMF or the anchor SMF
+		smNasBuf = smContext.PeerN1SmMsg
+	} else if smNasBuf, err = smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext); err != nil {
 		logger.PduSessLog.Errorf("Build GSM PDUSessionEstablishmentAccept failed: %s", err)
 		return
 	}
@@ -330,6 +391,12 @@ func (p *Processor) sendPDUSessionEstablishmentAccept(
 		logger.PduSessLog.Warnf("Get NAMF_COMM context failed: %s", err)
 		return
 	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	if !smContext.ServedByPeerSmf() &&
+		smContext.EpsInterworkingInd == models.EpsInterworkingIndication_WITH_N26 {
+		p.assignEpsBearerID(ctx, smContext)
+	}
 
 	rspData, err := p.Consumer().
 		N1N2MessageTransfer(ctx, smContext.Supi, n1n2Request, smContext.CommunicationClientApiPrefix)
@@ -339,10 +406,46 @@ func (p *Processor) sendPDUSessionEstablishmentAccept(
 	}
 
 	smContext.SetState(smf_context.Active)
+	smContext.Checkpoint()
 
 	if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
 		logger.PduSessLog.Warnf("%v", rspData.Cause)
+		return
+	}
+	// The PDU session is established once the PDU Session Establishment Accept is transferred to the UE
+	notifyPduSessionEstablishment(smContext)
+}
+
+// assignEpsBearerID requests the AMF to assign the EPS bearer ID of the default QoS flow for the interworking
+// with EPS over N26 (TS 23.502 4.11.1.4.1). The PDU session is established without the EPS bearer ID
+// if the AMF fails to assign it, the PDU session is then not transferred to EPS.
+func (p *Processor) assignEpsBearerID(ctx context.Context, smContext *smf_context.SMContext) {
+	sessRule := smContext.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthDefQos == nil || sessRule.AuthDefQos.Arp == nil {
+		smContext.Log.Warnln("No authorized default QoS to assign EPS bearer ID")
+		return
+	}
+
+	assignEbiData := models.AssignEbiData{
+		PduSessionId: smContext.PDUSessionID,
+		ArpList:      []models.Arp{*sessRule.AuthDefQos.Arp},
+	}
+	assignedEbiData, err := p.Consumer().
+		EBIAssignment(ctx, smContext.Supi, assignEbiData, smContext.CommunicationClientApiPrefix)
+	if err != nil || assignedEbiData == nil {
+		smContext.Log.Warnf("EBIAssignment failed: %+v", err)
+		return
+	}
+
+	for _, ebiArpMapping := range assignedEbiData.AssignedEbiList {
+		// EBIs 5-15 are used by the EPS bearers (TS 24.007 11.2.3.1.5)
+		if ebiArpMapping.EpsBearerId >= 5 && ebiArpMapping.EpsBearerId <= 15 {
+			smContext.EpsBearerID = uint8(ebiArpMapping.EpsBearerId)
+			smContext.Log.Infof("EPS bearer ID[%d] assigned", smContext.EpsBearerID)
+			return
+		}
 	}
+	smContext.Log.Warnln("No EPS bearer ID assigned by AMF")
 }
 
 func (p *Processor) updateAnUpfPfcpSession(
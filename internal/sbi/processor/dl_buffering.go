@@ -0,0 +1,350 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	pfcp_message "github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/internal/pfcp/n4u"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// downlinkDataBuffering sets the downlink FARs in the AN UPF to buffer the downlink data of the deactivated
+// user plane with the policy of the DNN (TS 23.501 5.8.3), it returns the rules to be updated in the AN UPF
+func downlinkDataBuffering(smContext *smf_context.SMContext) (
+	pdrList []*smf_context.PDR, farList []*smf_context.FAR, barList []*smf_context.BAR,
+) {
+	if smContext.Tunnel == nil || smContext.Tunnel.DataPathPool.GetDefaultPath() == nil {
+		smContext.Log.Warnf("No data path to buffer the downlink data")
+		return nil, nil, nil
+	}
+
+	dlBuffering := smContext.DlBuffering()
+	mode := dlBuffering.GetMode()
+	if mode == factory.DlBufferingModeSmf && !n4u.IsRunning() {
+		smContext.Log.Warnf("N4-u is not running, the downlink data is buffered at the UPF")
+		mode = factory.DlBufferingModeUpf
+	}
+
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		ANUPF := dataPath.FirstDPNode
+		if ANUPF == nil || ANUPF.DownLinkTunnel == nil || ANUPF.DownLinkTunnel.PDR == nil {
+			smContext.Log.Warnf("Access network resource is released")
+			continue
+		}
+		DLPDR := ANUPF.DownLinkTunnel.PDR
+		DLPDR.FAR.State = smf_context.RULE_UPDATE
+
+		if mode == factory.DlBufferingModeSmf {
+			fwdPDR, err := forwardDownlinkDataToSmf(smContext, ANUPF, DLPDR)
+			if err == nil {
+				if fwdPDR.State == smf_context.RULE_INITIAL && !slices.Contains(pdrList, fwdPDR) {
+					pdrList = append(pdrList, fwdPDR)
+				}
+				farList = append(farList, DLPDR.FAR)
+				continue
+			}
+			smContext.Log.Warnf("The downlink data is buffered at the UPF: %+v", err)
+		}
+
+		bar, err := smContext.DownlinkBAR(ANUPF.UPF.NodeID)
+		if err != nil {
+			smContext.Log.Warnf("Buffer the downlink data failed: %+v", err)
+			continue
+		}
+		// The UPF only notifies the arrival of the downlink data if no packet is to be buffered
+		var packetCount *pfcpType.SuggestedBufferingPacketsCount
+		if mode == factory.DlBufferingModeNotifyOnly {
+			packetCount = &pfcpType.SuggestedBufferingPacketsCount{PacketCountValue: 0}
+		} else if dlBuffering != nil && dlBuffering.SuggestedPacketCount != 0 {
+			packetCount = &pfcpType.SuggestedBufferingPacketsCount{PacketCountValue: dlBuffering.SuggestedPacketCount}
+		}
+		if bar.State == smf_context.RULE_CREATE && !reflect.DeepEqual(bar.SuggestedBufferingPacketsCount, packetCount) {
+			bar.State = smf_context.RULE_UPDATE
+		}
+		bar.SuggestedBufferingPacketsCount = packetCount
+		if bar.State != smf_context.RULE_CREATE && !slices.Contains(barList, bar) {
+			barList = append(barList, bar)
+		}
+
+		DLPDR.FAR.ApplyAction = pfcpType.ApplyAction{Buff: true, Nocp: true}
+		DLPDR.FAR.BAR = bar
+		farList = append(farList, DLPDR.FAR)
+	}
+	return pdrList, farList, barList
+}
+
+// forwardDownlinkDataToSmf sets the downlink FAR to forward the downlink data to the SMF over N4-u,
+// it returns the PDR detecting the data forwarded back by the SMF
+func forwardDownlinkDataToSmf(
+	smContext *smf_context.SMContext,
+	ANUPF *smf_context.DataPathNode,
+	DLPDR *smf_context.PDR,
+) (*smf_context.PDR, error) {
+	upf := ANUPF.UPF
+	if !upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesBucp) {
+		return nil, errors.New("UPF doesn't support buffering by the CP function")
+	}
+	iface := upf.GetInterface(smContext.AccessInterfaceType(), smContext.Dnn)
+	if iface == nil {
+		return nil, fmt.Errorf("no %s interface of UPF for DNN[%s]", smContext.AccessInterfaceType(), smContext.Dnn)
+	}
+	upIP, err := iface.IP(smContext.SelectedPDUSessionType)
+	if err != nil {
+		return nil, err
+	}
+	teid, err := smContext.DlDataTEID()
+	if err != nil {
+		return nil, err
+	}
+	fwdPDR, err := smContext.DlDataForwardingPDR(upf, upIP, DLPDR)
+	if err != nil {
+		return nil, err
+	}
+
+	DLPDR.FAR.ApplyAction = pfcpType.ApplyAction{Forw: true}
+	DLPDR.FAR.ForwardingParameters = &smf_context.ForwardingParameters{
+		DestinationInterface: pfcpType.DestinationInterface{
+			InterfaceValue: pfcpType.DestinationInterfaceCpFunction,
+		},
+		OuterHeaderCreation: smf_context.NewOuterHeaderCreation(smf_context.GetSelf().ExternalIP(), teid),
+	}
+	return fwdPDR, nil
+}
+
+// NotifyDownlinkData requests the AMF to page the UE for the downlink data of the deactivated user plane
+// (TS 23.502 4.2.3.3 step 3a), the caller holds SMLock. It returns the update of the buffering in the UPF
+// for the Data Notification Ack, and whether the data buffered in the UPF is to be dropped.
+func (p *Processor) NotifyDownlinkData(
+	smContext *smf_context.SMContext,
+	ddsi *pfcpType.DownlinkDataServiceInformation,
+) (*pfcp.UpdateBARIEInPFCPSessionReportResponse, bool) {
+	var qfi, ppi uint8
+	var hasPpi bool
+	if ddsi != nil {
+		if ddsi.Qfii {
+			qfi = ddsi.Qfi
+		}
+		ppi, hasPpi = ddsi.PagingPolicyIndicationValue, ddsi.Ppi
+	}
+	arp, var5qi := smContext.QoSOfFlow(qfi)
+
+	// TS 23.502 4.2.3.3 3a. The DDN with the lower priority is not sent while the AMF throttles the DDNs
+	if smf_context.IsDDNThrottled(smContext.CommunicationClientApiPrefix, arp) {
+		smContext.Log.Infof("Downlink Data Notification is throttled, drop the buffered downlink data")
+		smContext.TakeBufferedDownlinkData()
+		return nil, true
+	}
+
+	dlBuffering := smContext.DlBuffering()
+	// The UPF buffers the downlink data longer if the UE is in power saving mode
+	extBufSupport := dlBuffering != nil && dlBuffering.GetMode() == factory.DlBufferingModeUpf &&
+		dlBuffering.Duration > 0
+
+	n1n2Request := models.N1N2MessageTransferRequest{}
+
+	// TS 23.502 4.2.3.3 3a. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
+	if n2SmBuf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
+		logger.PduSessLog.Errorln("Build PDUSessionResourceSetupRequestTransfer failed:", err)
+	} else {
+		n1n2Request.BinaryDataN2Information = n2SmBuf
+	}
+
+	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
+		PduSessionId: smContext.PDUSessionID,
+		// TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
+		N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d/nsmf-callback/n1n2-failure-notify/%s",
+			smf_context.GetSelf().URIScheme,
+			smf_context.GetSelf().RegisterIPv4,
+			smf_context.GetSelf().SBIPort,
+			smContext.Ref),
+		N2InfoContainer: &models.N2InfoContainer{
+			N2InformationClass: models.N2InformationClass_SM,
+			SmInfo: &models.N2SmInformation{
+				PduSessionId: smContext.PDUSessionID,
+				N2InfoContent: &models.N2InfoContent{
+					NgapIeType: models.AmfCommunicationNgapIeType_PDU_RES_SETUP_REQ,
+					NgapData: &models.RefToBinaryData{
+						ContentId: "N2SmInformation",
+					},
+				},
+				SNssai: smContext.SNssai,
+			},
+		},
+		Arp:           arp,
+		Var5qi:        var5qi,
+		ExtBufSupport: extBufSupport,
+	}
+	if hasPpi {
+		n1n2Request.JsonData.Ppi = int32(ppi)
+	}
+
+	ctx, _, errToken := smf_context.GetSelf().GetTokenCtx(models.ServiceName_NAMF_COMM, models.NrfNfManagementNfType_AMF)
+	if errToken != nil {
+		logger.PfcpLog.Warnf("Get NAMF_COMM context failed: %s", errToken)
+		return nil, false
+	}
+	rspData, err := p.Consumer().
+		N1N2MessageTransfer(ctx, smContext.Supi, n1n2Request, smContext.CommunicationClientApiPrefix)
+	if err != nil {
+		logger.ConsumerLog.Warnf("Send N1N2Transfer failed: %s", err)
+		return p.handleN1N2TransferErrDetail(smContext, consumer.N1N2MessageTransferErrDetail(err), extBufSupport)
+	}
+
+	if rspData.Cause == models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE {
+		logger.PfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
+		smContext.StartPaging()
+	}
+	if rspData.Cause == models.N1N2MessageTransferCause_UE_NOT_RESPONDING {
+		logger.PfcpLog.Warnf("%v", rspData.Cause)
+		// TS 23.502 4.2.3.3 3c. Failure indication, handled after the SM context is unlocked
+		go p.HandlePagingFailure(smContext)
+	}
+
+	if extBufSupport {
+		return upfBufferingUpdate(smContext, dlBuffering.Duration), false
+	}
+	return nil, false
+}
+
+// handleN1N2TransferErrDetail handles the DDN throttling and the extended buffering indicated by the AMF
+// rejecting the N1N2 message transfer (TS 23.502 4.2.3.3 step 3b)
+func (p *Processor) handleN1N2TransferErrDetail(
+	smContext *smf_context.SMContext,
+	errDetail *models.N1N2MsgTxfrErrDetail,
+	extBufSupport bool,
+) (*pfcp.UpdateBARIEInPFCPSessionReportResponse, bool) {
+	if errDetail == nil {
+		return nil, false
+	}
+
+	if errDetail.RetryAfter > 0 {
+		delay := time.Duration(errDetail.RetryAfter) * time.Second
+		smContext.Log.Infof("AMF throttles the Downlink Data Notifications for %s", delay)
+		smf_context.StartDDNThrottling(smContext.CommunicationClientApiPrefix, delay, errDetail.HighestPrioArp)
+		smContext.TakeBufferedDownlinkData()
+		return nil, true
+	}
+
+	if errDetail.MaxWaitingTime > 0 && extBufSupport {
+		// The UE is in power saving mode and reachable after the waiting time, the downlink data is buffered
+		// in the UPF meanwhile and not notified again
+		waitingTime := time.Duration(errDetail.MaxWaitingTime) * time.Second
+		smContext.Log.Infof("UE is reachable in %s, extend the buffering of the downlink data", waitingTime)
+		smContext.StartDDNBackoff(waitingTime, func() {})
+		return upfBufferingUpdate(smContext, waitingTime), false
+	}
+	return nil, false
+}
+
+// upfBufferingUpdate returns the Update BAR of the Data Notification Ack requesting the AN UPF
+// to buffer the downlink data for the duration (TS 29.244 7.5.9.2)
+func upfBufferingUpdate(
+	smContext *smf_context.SMContext,
+	duration time.Duration,
+) *pfcp.UpdateBARIEInPFCPSessionReportResponse {
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || defaultPath.FirstDPNode == nil {
+		return nil
+	}
+	upf := defaultPath.FirstDPNode.UPF
+	if !upf.IsUPFunctionFeatureSupported(pfcpType.UpFunctionFeaturesDlbd) {
+		return nil
+	}
+	bar, err := smContext.DownlinkBAR(upf.NodeID)
+	if err != nil {
+		return nil
+	}
+
+	updateBAR := &pfcp.UpdateBARIEInPFCPSessionReportResponse{
+		BARID:               &pfcpType.BARID{BarIdValue: bar.BARID},
+		DLBufferingDuration: pfcp_message.NewDLBufferingDuration(duration),
+	}
+	if bar.SuggestedBufferingPacketsCount != nil {
+		updateBAR.DLBufferingSuggestedPacketCount = &pfcpType.DLBufferingSuggestedPacketCount{
+			PacketCountValue: uint16(bar.SuggestedBufferingPacketsCount.PacketCountValue),
+		}
+	}
+	return updateBAR
+}
+
+// HandleN4uDownlinkData buffers the downlink data forwarded by the UPF to the SMF, the AMF is requested to page
+// the UE on the first packet (TS 23.502 4.2.3.3 step 2a)
+func (p *Processor) HandleN4uDownlinkData(teid uint32, packet []byte) {
+	smContext := smf_context.GetSMContextByDlDataTEID(teid)
+	if smContext == nil {
+		logger.PfcpLog.Debugf("Downlink data of unknown TEID[%d] is dropped", teid)
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		smContext.Log.Debugf("Downlink data is dropped in user plane state[%s]", smContext.UpCnxState)
+		return
+	}
+	if !smContext.BufferDownlinkData(packet, smContext.DlBuffering().GetSmfBufferingPacketCount()) {
+		smContext.Log.Debugf("Downlink data buffer is full, the packet is dropped")
+	}
+	if !smContext.ShouldNotifyDownlinkData() {
+		return
+	}
+
+	ddsi := &pfcpType.DownlinkDataServiceInformation{}
+	if dscp, ok := packetDSCP(packet); ok {
+		// The paging policy is differentiated by the DSCP (TS 23.501 5.4.3)
+		ddsi.Ppi, ddsi.PagingPolicyIndicationValue = true, dscp
+	}
+	if _, dropBuffered := p.NotifyDownlinkData(smContext, ddsi); dropBuffered {
+		smContext.TakeBufferedDownlinkData()
+	}
+}
+
+// packetDSCP returns the DSCP of the IP packet
+func packetDSCP(packet []byte) (uint8, bool) {
+	if len(packet) < 2 {
+		return 0, false
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		return packet[1] >> 2, true
+	case 6:
+		// The traffic class spans the first two octets
+		return (packet[0]<<4 | packet[1]>>4) >> 2, true
+	}
+	return 0, false
+}
+
+// forwardBufferedDownlinkData forwards the downlink data buffered at the SMF to the UPF once the user plane
+// is activated (TS 23.502 4.2.3.3 step 12)
+func forwardBufferedDownlinkData(smContext *smf_context.SMContext) {
+	packets := smContext.TakeBufferedDownlinkData()
+	if len(packets) == 0 {
+		return
+	}
+	ip, teid, ok := smContext.DlDataForwardingTunnel()
+	if !ok {
+		smContext.Log.Warnf("No tunnel to forward the %d buffered downlink data packets", len(packets))
+		return
+	}
+
+	addr := &net.UDPAddr{IP: ip, Port: n4u.GtpuPort}
+	for _, packet := range packets {
+		if err := n4u.Send(addr, teid, packet); err != nil {
+			smContext.Log.Warnf("Forward the buffered downlink data failed: %+v", err)
+			return
+		}
+	}
+	smContext.Log.Infof("Forwarded %d buffered downlink data packets to UPF", len(packets))
+}
@@ -0,0 +1,195 @@
+package processor
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+func (p *Processor) HandleCreateIndividualSubcription(
+	c *gin.Context,
+	request models.NsmfEventExposure,
+) {
+	logger.EeLog.Infof("Handle Create Event Exposure Subscription")
+
+	if problemDetails := validateEventExposureSubscription(&request); problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+
+	eeSub := smf_context.AddEventExposureSubscription(&request)
+	logger.EeLog.Infof("Event Exposure Subscription [%s] created", eeSub.SubId)
+
+	response := *eeSub.NsmfEventExposure
+	if request.ImmeRep {
+		response.EventNotifs = buildEventExposureImmeReport(eeSub)
+	}
+
+	c.Header("Location", resourceLocation(c, eeSub.SubId))
+	c.JSON(http.StatusCreated, response)
+}
+
+func (p *Processor) HandleGetIndividualSubcription(c *gin.Context, subId string) {
+	logger.EeLog.Infof("Handle Get Event Exposure Subscription [%s]", subId)
+
+	eeSub := smf_context.GetEventExposureSubscription(subId)
+	if eeSub == nil {
+		problemDetails := openapi.ProblemDetailsDataNotFound(
+			fmt.Sprintf("Event Exposure Subscription [%s] Not Found", subId))
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.JSON(http.StatusOK, eeSub.NsmfEventExposure)
+}
+
+func (p *Processor) HandleReplaceIndividualSubcription(
+	c *gin.Context,
+	subId string,
+	request models.NsmfEventExposure,
+) {
+	logger.EeLog.Infof("Handle Replace Event Exposure Subscription [%s]", subId)
+
+	if problemDetails := validateEventExposureSubscription(&request); problemDetails != nil {
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+
+	eeSub := smf_context.ReplaceEventExposureSubscription(subId, &request)
+	if eeSub == nil {
+		problemDetails := openapi.ProblemDetailsDataNotFound(
+			fmt.Sprintf("Event Exposure Subscription [%s] Not Found", subId))
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+
+	response := *eeSub.NsmfEventExposure
+	if request.ImmeRep {
+		response.EventNotifs = buildEventExposureImmeReport(eeSub)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (p *Processor) HandleDeleteIndividualSubcription(c *gin.Context, subId string) {
+	logger.EeLog.Infof("Handle Delete Event Exposure Subscription [%s]", subId)
+
+	if !smf_context.RemoveEventExposureSubscription(subId) {
+		problemDetails := openapi.ProblemDetailsDataNotFound(
+			fmt.Sprintf("Event Exposure Subscription [%s] Not Found", subId))
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func validateEventExposureSubscription(sub *models.NsmfEventExposure) *models.ProblemDetails {
+	if sub.NotifUri == "" || sub.NotifId == "" {
+		return openapi.ProblemDetailsMalformedReqSyntax("notifUri and notifId are mandatory")
+	}
+	if len(sub.EventSubs) == 0 {
+		return openapi.ProblemDetailsMalformedReqSyntax("eventSubs shall not be empty")
+	}
+	if sub.Supi == "" && !sub.AnyUeInd {
+		if sub.GroupId != "" {
+			// TODO: resolve the members of an internal group
+			return openapi.ProblemDetailsOperationNotSupported()
+		}
+		return openapi.ProblemDetailsMalformedReqSyntax("one of supi or anyUeInd shall be present")
+	}
+	if sub.NotifMethod == models.SmfEventExposureNotificationMethod_PERIODIC {
+		return openapi.ProblemDetailsOperationNotSupported()
+	}
+	if sub.Expiry != nil && !sub.Expiry.After(time.Now()) {
+		return openapi.ProblemDetailsMalformedReqSyntax("expiry is in the past")
+	}
+	return nil
+}
+
+// buildEventExposureImmeReport collects the current status of the subscribed events
+// of every PDU session targeted by the subscription
+func buildEventExposureImmeReport(
+	eeSub *smf_context.EventExposureSubscription,
+) []models.SmfEventExposureEventNotification {
+	var reports []models.SmfEventExposureEventNotification
+	smf_context.ProcEachSMContext(func(smContext *smf_context.SMContext) {
+		reports = append(reports, smContext.BuildEventExposureImmeReport(eeSub)...)
+	})
+	if len(reports) != 0 {
+		// The immediate report counts as one report
+		eeSub.CountReport()
+	}
+	return reports
+}
+
+// sendEventExposureNotification notifies the event to the NF service consumers
+// subscribed to the SM context through Nsmf_EventExposure
+func sendEventExposureNotification(
+	smContext *smf_context.SMContext,
+	event models.SmfEvent,
+	fillFunc func(*models.SmfEventExposureEventNotification),
+) {
+	smContext.SendEventExposureNotification(event, fillFunc, SendUpPathChgEventExposureNotification)
+}
+
+func notifyPduSessionEstablishment(smContext *smf_context.SMContext) {
+	sendEventExposureNotification(smContext, models.SmfEvent_PDU_SES_EST, nil)
+	if smContext.HasPDUAddress() {
+		sendEventExposureNotification(smContext, models.SmfEvent_UE_IP_CH,
+			func(en *models.SmfEventExposureEventNotification) {
+				if ip := smContext.PDUAddress.To4(); ip != nil {
+					en.AdIpv4Addr = ip.String()
+				}
+				en.AdIpv6Prefix = smContext.IPv6PrefixString()
+			})
+	}
+}
+
+func notifyUeIpRelease(smContext *smf_context.SMContext) {
+	if smContext.HasPDUAddress() {
+		sendEventExposureNotification(smContext, models.SmfEvent_UE_IP_CH,
+			func(en *models.SmfEventExposureEventNotification) {
+				if ip := smContext.PDUAddress.To4(); ip != nil {
+					en.ReIpv4Addr = ip.String()
+				}
+				en.ReIpv6Prefix = smContext.IPv6PrefixString()
+			})
+	}
+}
+
+func notifyPduSessionRelease(smContext *smf_context.SMContext) {
+	// The QoS flows are released with the PDU session
+	notifyQosFlowChanges(smContext, smContext.QosFlowEventInfos(), nil)
+	notifyUeIpRelease(smContext)
+	sendEventExposureNotification(smContext, models.SmfEvent_PDU_SES_REL, nil)
+}
+
+// notifyQosFlowChanges notifies the QoS flows allocated, modified or released from origQosFlows to qosFlows.
+// The notification of an allocated or a modified QoS flow carries the traffic mapped to it,
+// the notification of a released QoS flow carries only its QFI.
+func notifyQosFlowChanges(
+	smContext *smf_context.SMContext,
+	origQosFlows, qosFlows map[uint8]*smf_context.QosFlowEventInfo,
+) {
+	changed, released := smf_context.ChangedQosFlows(origQosFlows, qosFlows)
+	for _, qfi := range changed {
+		qosFlow := qosFlows[qfi]
+		sendEventExposureNotification(smContext, models.SmfEvent_QFI_ALLOC,
+			func(en *models.SmfEventExposureEventNotification) {
+				en.Qfi = int32(qfi)
+				en.FlowDescs = qosFlow.FlowDescs
+				en.AppId = qosFlow.AppID
+			})
+	}
+	for _, qfi := range released {
+		sendEventExposureNotification(smContext, models.SmfEvent_QFI_ALLOC,
+			func(en *models.SmfEventExposureEventNotification) {
+				en.Qfi = int32(qfi)
+			})
+	}
+}
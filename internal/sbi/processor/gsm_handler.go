@@ -50,27 +50,28 @@ func HandlePDUSessionEstablishmentRequest(
 			MaxIntegrityProtectedDataRate_MAX_UE_RATE
 	}
 	// Handle PDUSessionType
+	var requestedPDUSessionType uint8
 	if req.PDUSessionType != nil {
-		requestedPDUSessionType := req.PDUSessionType.GetPDUSessionTypeValue()
-		if err := smCtx.IsAllowedPDUSessionType(requestedPDUSessionType); err != nil {
-			logger.CtxLog.Errorf("%s", err)
-			return &GSMError{
-				GSMCause: nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
-			}
-		}
+		requestedPDUSessionType = req.PDUSessionType.GetPDUSessionTypeValue()
 	} else {
 		// Set to default supported PDU Session Type
 		switch smf_context.GetSelf().SupportedPDUSessionType {
 		case "IPv4":
-			smCtx.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+			requestedPDUSessionType = nasMessage.PDUSessionTypeIPv4
 		case "IPv6":
-			smCtx.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv6
+			requestedPDUSessionType = nasMessage.PDUSessionTypeIPv6
 		case "IPv4v6":
-			smCtx.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4IPv6
+			requestedPDUSessionType = nasMessage.PDUSessionTypeIPv4IPv6
 		case "Ethernet":
-			smCtx.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+			requestedPDUSessionType = nasMessage.PDUSessionTypeEthernet
 		default:
-			smCtx.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+			requestedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+		}
+	}
+	if err := smCtx.IsAllowedPDUSessionType(requestedPDUSessionType); err != nil {
+		logger.CtxLog.Errorf("%s", err)
+		return &GSMError{
+			GSMCause: smCtx.PDUSessionTypeRejectCause(requestedPDUSessionType),
 		}
 	}
 
@@ -7,9 +7,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/free5gc/nas/nasMessage"
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
 	"github.com/free5gc/openapi/smf/EventExposure"
+	"github.com/free5gc/openapi/udm/SubscriberDataManagement"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 )
@@ -85,6 +87,7 @@ func (p *Processor) HandleSMPolicyUpdateNotify(
 
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
 
 	smContext.CheckState(smf_context.Active)
 	// Wait till the state becomes Active again
@@ -100,6 +103,8 @@ func (p *Processor) HandleSMPolicyUpdateNotify(
 		return
 	}
 
+	origQosFlows := smContext.QosFlowEventInfos()
+
 	// TODO: Response data type -
 	// [200 OK] UeCampingRep
 	// [200 OK] array(PartialSuccessReport)
@@ -119,7 +124,267 @@ func (p *Processor) HandleSMPolicyUpdateNotify(
 
 	smContext.PostRemoveDataPath()
 
+	// TS 23.502 4.3.3.2 the changed session AMBR is signalled to the UE and the AN
+	if smContext.SessionAMBRModified() {
+		p.requestAMFToModifyPDUSession(smContext, smf_context.BuildPDUSessionResourceModifyRequestTransfer)
+	}
+
+	notifyQosFlowChanges(smContext, origQosFlows, smContext.QosFlowEventInfos())
+
+	c.Status(http.StatusNoContent)
+}
+
+// HandleSMPolicyTerminationNotify releases the PDU session whose SM policy association the PCF requests
+// to terminate (TS 29.512 4.2.5), then the SM policy association is deleted
+func (p *Processor) HandleSMPolicyTerminationNotify(
+	c *gin.Context,
+	request models.PcfSmPolicyControlTerminationNotification,
+	smContextRef string,
+) {
+	logger.PduSessLog.Infoln("In HandleSMPolicyTerminationNotify")
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+
+	if smContext == nil {
+		logger.PduSessLog.Errorf("SMContext[%s] not found", smContextRef)
+		problemDetails := openapi.ProblemDetailsDataNotFound(fmt.Sprintf("SM Context [%s] Not Found", smContextRef))
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+
+	smContext.SetTraceContext(c.Request.Context())
 	c.Status(http.StatusNoContent)
+
+	// The PDU session release involves the other NFs, so it runs after the response is sent
+	go func() {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+
+		smContext.Log.Infof("PCF requests to terminate the SM policy association with cause[%s]", request.Cause)
+		switch smContext.State() {
+		case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+			p.releasePDUSessionByNetwork(smContext, smPolicyReleaseCauseTo5GSMCause(request.Cause))
+		default:
+			smContext.Log.Infof("PDU session in state[%s] is not released", smContext.State())
+		}
+
+		// The SM policy association is already deleted if the SM context is removed
+		if smContext.SMPolicyID != "" {
+			if err := p.Consumer().SendSMPolicyAssociationTermination(smContext); err != nil {
+				smContext.Log.Errorf("SM Policy Termination failed: %s", err)
+			} else {
+				smContext.SMPolicyID = ""
+			}
+		}
+	}()
+}
+
+// smPolicyReleaseCauseTo5GSMCause maps the cause of the SM policy association termination
+// to the 5GSM cause of the PDU Session Release Command
+func smPolicyReleaseCauseTo5GSMCause(cause models.SmPolicyAssociationReleaseCause) uint8 {
+	switch cause {
+	case models.SmPolicyAssociationReleaseCause_INSUFFICIENT_RES:
+		return nasMessage.Cause5GSMInsufficientResources
+	case models.SmPolicyAssociationReleaseCause_REACTIVATION_REQUESTED:
+		return nasMessage.Cause5GSMReactivationRequested
+	default:
+		return nasMessage.Cause5GSMRegularDeactivation
+	}
+}
+
+// HandleSDMSubscriptionDataChangeNotify applies the changed session management subscription data
+// of the UE to its PDU sessions (TS 29.503 5.2.2.3.2)
+func (p *Processor) HandleSDMSubscriptionDataChangeNotify(
+	c *gin.Context,
+	notification models.ModificationNotification,
+	supi string,
+) {
+	logger.PduSessLog.Infoln("In HandleSDMSubscriptionDataChangeNotify")
+
+	var smContexts []*smf_context.SMContext
+	smf_context.ProcEachSMContext(func(smContext *smf_context.SMContext) {
+		if smContext.Supi == supi {
+			smContexts = append(smContexts, smContext)
+		}
+	})
+	if len(smContexts) == 0 {
+		logger.PduSessLog.Errorf("No SMContext of UE[%s]", supi)
+		problemDetails := openapi.ProblemDetailsDataNotFound(fmt.Sprintf("UE [%s] Not Found", supi))
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+
+	for _, item := range notification.NotifyItems {
+		logger.PduSessLog.Infof("Subscription data[%s] of UE[%s] changed", item.ResourceId, supi)
+	}
+	c.Status(http.StatusNoContent)
+
+	// The changed data is retrieved from the UDM for each PDU session, so it runs after the response is sent
+	for _, smContext := range smContexts {
+		go p.updateSubscribedSmData(smContext)
+	}
+}
+
+// updateSubscribedSmData retrieves the session management subscription data of the PDU session,
+// then modifies or releases the PDU session accordingly
+func (p *Processor) updateSubscribedSmData(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.State() != smf_context.Active {
+		smContext.Log.Infof("PDU session in state[%s] is not updated by the subscription change", smContext.State())
+		return
+	}
+
+	dnnConfig, err := p.getSubscribedDnnConfiguration(smContext)
+	if err != nil {
+		smContext.Log.Errorf("Get SessionManagementSubscriptionData error: %+v", err)
+		return
+	}
+
+	switch smContext.UpdateDnnConfiguration(dnnConfig) {
+	case smf_context.SmDataChangeRelease:
+		cause := nasMessage.Cause5GSMReactivationRequested
+		if dnnConfig == nil {
+			cause = nasMessage.Cause5GSMRegularDeactivation
+		}
+		p.releasePDUSessionByNetwork(smContext, cause)
+	case smf_context.SmDataChangeModify:
+		p.modifyPDUSessionBySubscriptionChange(smContext)
+	}
+}
+
+// getSubscribedDnnConfiguration returns nil if the DNN of the PDU session is no longer subscribed
+func (p *Processor) getSubscribedDnnConfiguration(
+	smContext *smf_context.SMContext,
+) (*models.DnnConfiguration, error) {
+	ctx, _, err := smf_context.GetSelf().GetTokenCtx(models.ServiceName_NUDM_SDM, models.NrfNfManagementNfType_UDM)
+	if err != nil {
+		return nil, err
+	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	smDataParams := &SubscriberDataManagement.GetSmDataRequest{
+		Dnn:         &smContext.Dnn,
+		SingleNssai: smContext.SNssai,
+	}
+	if smContext.Guami != nil && smContext.Guami.PlmnId != nil {
+		smDataParams.PlmnId = &models.PlmnId{
+			Mcc: smContext.Guami.PlmnId.Mcc,
+			Mnc: smContext.Guami.PlmnId.Mnc,
+		}
+	}
+
+	sessSubData, err := p.Consumer().GetSmData(ctx, smContext.Supi, smDataParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessSubData) == 0 {
+		return nil, nil
+	}
+	dnnConfig, ok := sessSubData[0].DnnConfigurations[smContext.Dnn]
+	if !ok {
+		return nil, nil
+	}
+	return &dnnConfig, nil
+}
+
+// modifyPDUSessionBySubscriptionChange performs the network requested PDU session modification
+// with the SM policy authorized for the changed subscribed session AMBR and default QoS (TS 23.502 4.3.3.2)
+func (p *Processor) modifyPDUSessionBySubscriptionChange(smContext *smf_context.SMContext) {
+	if smContext.SMPolicyID == "" {
+		smContext.Log.Warnln("No SM policy association to update")
+		return
+	}
+
+	decision, err := p.Consumer().SendSMPolicyAssociationUpdateBySubscriptionChange(smContext)
+	if err != nil {
+		smContext.Log.Errorf("SM policy update failed: %+v", err)
+		return
+	}
+
+	smContext.SetState(smf_context.ModificationPending)
+	defer func() {
+		smContext.SetState(smf_context.Active)
+		smContext.Checkpoint()
+	}()
+
+	if err = smContext.ApplySessionRules(decision); err != nil {
+		smContext.Log.Errorf("apply session rules error: %+v", err)
+		return
+	}
+	if err = smContext.ApplyPccRules(decision); err != nil {
+		smContext.Log.Errorf("apply sm policy decision error: %+v", err)
+	}
+
+	ActivateUPFSession(smContext, nil)
+
+	p.requestAMFToModifyPDUSession(smContext, smf_context.BuildPDUSessionResourceModifyRequestTransferForSessionRule)
+}
+
+// requestAMFToModifyPDUSession sends the PDU Session Modification Command to the UE and, if the UP connection
+// is active, the PDU Session Resource Modify Request built by buildN2SmInfo to the AN
+func (p *Processor) requestAMFToModifyPDUSession(
+	smContext *smf_context.SMContext,
+	buildN2SmInfo func(*smf_context.SMContext) ([]byte, error),
+) {
+	modificationCommand, err := smf_context.BuildGSMPDUSessionModificationCommand(smContext)
+	if err != nil {
+		smContext.Log.Errorf("Build GSM PDUSessionModificationCommand failed: %+v", err)
+		return
+	}
+
+	n1n2Request := models.N1N2MessageTransferRequest{
+		BinaryDataN1Message: modificationCommand,
+		JsonData: &models.N1N2MessageTransferReqData{
+			PduSessionId: smContext.PDUSessionID,
+			N1MessageContainer: &models.N1MessageContainer{
+				N1MessageClass:   "SM",
+				N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+			},
+		},
+	}
+	if smContext.UpCnxState == models.UpCnxState_ACTIVATED {
+		if buf, errBuild := buildN2SmInfo(smContext); errBuild != nil {
+			smContext.Log.Errorf("Build PDUSessionResourceModifyRequestTransfer failed: %+v", errBuild)
+		} else {
+			n1n2Request.BinaryDataN2Information = buf
+			n1n2Request.JsonData.N2InfoContainer = &models.N2InfoContainer{
+				N2InformationClass: models.N2InformationClass_SM,
+				SmInfo: &models.N2SmInformation{
+					PduSessionId: smContext.PDUSessionID,
+					N2InfoContent: &models.N2InfoContent{
+						NgapIeType: models.AmfCommunicationNgapIeType_PDU_RES_MOD_REQ,
+						NgapData: &models.RefToBinaryData{
+							ContentId: "N2SmInformation",
+						},
+					},
+					SNssai: smContext.SNssai,
+				},
+			}
+		}
+	}
+
+	ctx, _, err := smf_context.GetSelf().GetTokenCtx(models.ServiceName_NAMF_COMM, models.NrfNfManagementNfType_AMF)
+	if err != nil {
+		smContext.Log.Warnf("Get namf-comm token failed: %+v", err)
+		return
+	}
+	ctx = smContext.WithTraceContext(ctx)
+
+	rspData, err := p.Consumer().
+		N1N2MessageTransfer(ctx, smContext.Supi, n1n2Request, smContext.CommunicationClientApiPrefix)
+	if err != nil || rspData == nil {
+		logger.ConsumerLog.Warnf("N1N2MessageTransfer for PDUSessionModificationCommand failed: %+v", err)
+		return
+	}
+	if rspData.Cause == models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED {
+		// The PDU Session Modification Command carries the current session AMBR, and is retransmitted
+		// until the UE completes the modification
+		smContext.SessionAMBRSignalledToUE()
+		p.sendGSMPDUSessionModificationCommand(smContext, modificationCommand)
+	} else {
+		smContext.Log.Warnf("N1N2MessageTransfer for PDUSessionModificationCommand: %v", rspData.Cause)
+	}
 }
 
 func SendUpPathChgEventExposureNotification(
@@ -0,0 +1,166 @@
+package processor_test
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/pkg/service"
+)
+
+func TestHandleSMPolicyTerminationNotify(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	consumer, err := consumer.NewConsumer(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create consumer: %+v", err)
+	}
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+	mockSmf.EXPECT().Context().Return(smf_context.GetSelf()).AnyTimes()
+	mockSmf.EXPECT().Consumer().Return(consumer).AnyTimes()
+
+	// The AMF transfers the PDU Session Release Command to the UE and the PCF deletes the SM policy association
+	n1SmMsgCh := make(chan []byte, 1)
+	smPolicyDeleted := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/namf-comm/v1/ue-contexts/imsi-208930000000501/n1-n2-messages",
+		func(w http.ResponseWriter, r *http.Request) {
+			n1SmMsgCh <- n1SmMessage(t, r)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(models.N1N2MessageTransferRspData{
+				Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED,
+			})
+		})
+	mux.HandleFunc("/npcf-smpolicycontrol/v1/sm-policies/imsi-208930000000501-10/delete",
+		func(w http.ResponseWriter, r *http.Request) {
+			smPolicyDeleted <- struct{}{}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	nfServer := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	defer nfServer.Close()
+
+	smContext := smf_context.NewSMContext("imsi-208930000000501", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000501",
+		PduSessionId: 10,
+	}
+	smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+	smContext.CommunicationClientApiPrefix = nfServer.URL
+	smContext.SelectedPCFProfile = models.NrfNfDiscoveryNfProfile{
+		NfServices: []models.NrfNfDiscoveryNfService{
+			{
+				ServiceName: models.ServiceName_NPCF_SMPOLICYCONTROL,
+				ApiPrefix:   nfServer.URL,
+			},
+		},
+	}
+	smContext.SMPolicyID = "imsi-208930000000501-10"
+	smContext.SetState(smf_context.Active)
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	terminate := func(ref string) int {
+		httpRecorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(httpRecorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-callback/v1/sm-policies/"+ref+"/terminate", nil)
+		processor.HandleSMPolicyTerminationNotify(c, models.PcfSmPolicyControlTerminationNotification{
+			Cause: models.SmPolicyAssociationReleaseCause_INSUFFICIENT_RES,
+		}, ref)
+		return c.Writer.Status()
+	}
+
+	// The notification is acknowledged before the PDU session is released
+	require.Equal(t, http.StatusNoContent, terminate(smContext.Ref))
+	require.Equal(t, http.StatusNotFound, terminate("urn:uuid:00000000-0000-0000-0000-000000000000"))
+
+	// The PDU session is released by the network with the 5GSM cause mapped from the termination cause
+	select {
+	case n1SmMsg := <-n1SmMsgCh:
+		m := nas.NewMessage()
+		require.NoError(t, m.GsmMessageDecode(&n1SmMsg))
+		require.Equal(t, nas.MsgTypePDUSessionReleaseCommand, m.GsmHeader.GetMessageType())
+		require.Equal(t, nasMessage.Cause5GSMInsufficientResources,
+			m.PDUSessionReleaseCommand.Cause5GSM.GetCauseValue())
+	case <-time.After(time.Second):
+		t.Fatal("PDU Session Release Command not sent")
+	}
+
+	select {
+	case <-smPolicyDeleted:
+	case <-time.After(time.Second):
+		t.Fatal("SM policy association not deleted")
+	}
+	require.Eventually(t, func() bool {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		return smContext.SMPolicyID == ""
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, smf_context.InActivePending, smContext.State())
+}
+
+// n1SmMessage returns the N1 SM message of the multipart N1N2MessageTransfer request
+func n1SmMessage(t *testing.T, req *http.Request) []byte {
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	mr := multipart.NewReader(req.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		if part.Header.Get("Content-Id") == "GSM_NAS" {
+			buf, err := io.ReadAll(part)
+			require.NoError(t, err)
+			return buf
+		}
+	}
+}
+
+func TestHandleSDMSubscriptionDataChangeNotify(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	smContext := smf_context.NewSMContext("imsi-208930000000502", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000502",
+		PduSessionId: 10,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	notify := func(supi string) int {
+		httpRecorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(httpRecorder)
+		processor.HandleSDMSubscriptionDataChangeNotify(c, models.ModificationNotification{
+			NotifyItems: []models.NotifyItem{
+				{ResourceId: supi + "/sm-data"},
+			},
+		}, supi)
+		return c.Writer.Status()
+	}
+
+	require.Equal(t, http.StatusNoContent, notify("imsi-208930000000502"))
+	require.Equal(t, http.StatusNotFound, notify("imsi-208930000000503"))
+}
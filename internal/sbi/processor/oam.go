@@ -1,13 +1,17 @@
 package processor
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
 	"github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/pfcp/udp"
 	"github.com/free5gc/smf/pkg/factory"
 )
 
@@ -48,6 +52,79 @@ func (p *Processor) HandleOAMGetUEPDUSessionInfo(c *gin.Context, smContextRef st
 	c.JSON(http.StatusOK, pduSessionInfo)
 }
 
+// UPFN4Stats are the counters of the PFCP requests sent to the UPF
+type UPFN4Stats struct {
+	NodeID string `json:"nodeID"`
+	// RestartCount is the number of the UPF restarts detected with the Recovery Time Stamp
+	RestartCount uint64 `json:"restartCount"`
+	context.N4RequestStats
+}
+
+// HandleGetUPFN4Stats returns the N4 request statistics of the UPFs by their names
+func (p *Processor) HandleGetUPFN4Stats(c *gin.Context) {
+	upi := context.GetSelf().UserPlaneInformation
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	stats := make(map[string]UPFN4Stats, len(upi.UPFs))
+	for name, upNode := range upi.UPFs {
+		if upNode.UPF == nil {
+			continue
+		}
+		nodeID := upNode.NodeID.FQDN
+		if upNode.NodeID.NodeIdType != pfcpType.NodeIdTypeFqdn {
+			nodeID = upNode.NodeID.IP.String()
+		}
+		stats[name] = UPFN4Stats{
+			NodeID:         nodeID,
+			RestartCount:   upNode.UPF.RestartCount(),
+			N4RequestStats: upNode.UPF.N4RequestStats(),
+		}
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
 func (p *Processor) HandleGetSMFUserPlaneInfo(c *gin.Context) {
-	c.JSON(http.StatusOK, factory.SmfConfig.Configuration.UserPlaneInformation)
+	c.JSON(http.StatusOK, factory.SmfConfig.GetUserPlaneInformation())
+}
+
+// HandleGetPfcpCapture returns the state of the capture of the PFCP messages
+func (p *Processor) HandleGetPfcpCapture(c *gin.Context) {
+	c.JSON(http.StatusOK, udp.GetCaptureStatus())
+}
+
+// HandleStartPfcpCapture starts to capture the PFCP messages to the pcap file
+func (p *Processor) HandleStartPfcpCapture(c *gin.Context, config udp.CaptureConfig) {
+	err := udp.StartCapture(config)
+	switch {
+	case errors.Is(err, udp.ErrCaptureRunning):
+		problemDetails := &models.ProblemDetails{
+			Title:  "Conflict",
+			Status: http.StatusConflict,
+			Detail: err.Error(),
+		}
+		c.JSON(http.StatusConflict, problemDetails)
+	case errors.Is(err, udp.ErrCaptureDisabled):
+		problemDetails := &models.ProblemDetails{
+			Title:  "Forbidden",
+			Status: http.StatusForbidden,
+			Detail: err.Error(),
+		}
+		c.JSON(http.StatusForbidden, problemDetails)
+	case err != nil:
+		problemDetails := openapi.ProblemDetailsMalformedReqSyntax(err.Error())
+		c.JSON(int(problemDetails.Status), problemDetails)
+	default:
+		c.JSON(http.StatusOK, udp.GetCaptureStatus())
+	}
+}
+
+// HandleStopPfcpCapture stops the capture of the PFCP messages
+func (p *Processor) HandleStopPfcpCapture(c *gin.Context) {
+	if err := udp.StopCapture(); err != nil {
+		problemDetails := openapi.ProblemDetailsSystemFailure(err.Error())
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
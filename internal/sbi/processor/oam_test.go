@@ -0,0 +1,42 @@
+package processor_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/pkg/service"
+)
+
+func TestHandleGetUPFN4Stats(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	require.NoError(t, err)
+
+	upf := smf_context.GetSelf().UserPlaneInformation.UPFs["UPF1"].UPF
+	recoveryTimeStamp := time.Now()
+	require.False(t, upf.UpdateRecoveryTimeStamp(recoveryTimeStamp))
+	require.True(t, upf.UpdateRecoveryTimeStamp(recoveryTimeStamp.Add(time.Minute)))
+
+	httpRecorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(httpRecorder)
+	c.Request = httptest.NewRequest(http.MethodGet, "/upf-n4-stats", nil)
+	processor.HandleGetUPFN4Stats(c)
+	require.Equal(t, http.StatusOK, httpRecorder.Code)
+
+	var stats map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal(httpRecorder.Body.Bytes(), &stats))
+	require.Contains(t, stats, "UPF1")
+	require.EqualValues(t, 1, stats["UPF1"]["restartCount"])
+	require.Contains(t, stats["UPF1"], "sent")
+}
@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	pfcp_message "github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// HandleN1N2TransferFailureNotification handles the notification of the AMF that the UE
+// is not reached by the paging (TS 23.502 4.2.3.3 step 5)
+func (p *Processor) HandleN1N2TransferFailureNotification(
+	c *gin.Context,
+	notification models.N1N2MsgTxfrFailureNotification,
+	smContextRef string,
+) {
+	logger.PduSessLog.Infoln("In HandleN1N2TransferFailureNotification")
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+
+	if smContext == nil {
+		logger.PduSessLog.Errorf("SMContext[%s] not found", smContextRef)
+		problemDetails := openapi.ProblemDetailsDataNotFound(fmt.Sprintf("SM Context [%s] Not Found", smContextRef))
+		c.JSON(int(problemDetails.Status), problemDetails)
+		return
+	}
+
+	smContext.SetTraceContext(c.Request.Context())
+	c.Status(http.StatusNoContent)
+
+	smContext.Log.Warnf("N1N2 message transfer failed with cause[%s]", notification.Cause)
+	go p.HandlePagingFailure(smContext)
+}
+
+// HandlePagingFailure requests the UPF to drop the buffered downlink data of the PDU session whose UE
+// is not reached by the paging, the Downlink Data Reports of the session are not notified to the AMF
+// until the DDN back-off expires (TS 23.502 4.2.3.3 step 3c)
+func (p *Processor) HandlePagingFailure(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		smContext.Log.Infof("Paging failure is ignored in user plane state[%s]", smContext.UpCnxState)
+		smContext.StopPaging()
+		return
+	}
+
+	backoff := factory.SmfConfig.GetDdnBackoffTime()
+	smContext.Log.Infof("Paging failed, drop the buffered downlink data and back off for %s", backoff)
+	smContext.StartDDNBackoff(backoff, func() {
+		resumeDownlinkDataBuffering(smContext)
+	})
+
+	smContext.TakeBufferedDownlinkData()
+	farList := downlinkFARsOfAnUpf(smContext, pfcpType.ApplyAction{Drop: true})
+	if len(farList) == 0 {
+		return
+	}
+	rcvMsg, err := pfcp_message.SendPfcpSessionModificationRequestDropBufferedData(
+		smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.UPF, smContext, farList)
+	checkDownlinkDataModificationResponse(smContext, rcvMsg, err)
+}
+
+// resumeDownlinkDataBuffering buffers and reports the downlink data of the deactivated user plane again
+// when the DDN back-off expires, the caller holds SMLock
+func resumeDownlinkDataBuffering(smContext *smf_context.SMContext) {
+	if smf_context.GetSMContextByRef(smContext.Ref) == nil ||
+		smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		return
+	}
+
+	smContext.Log.Infof("DDN back-off expired, buffer the downlink data again")
+	pdrList, farList, barList := downlinkDataBuffering(smContext)
+	if len(farList) == 0 {
+		return
+	}
+	rcvMsg, err := pfcp_message.SendPfcpSessionModificationRequest(
+		smContext.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.UPF, smContext, pdrList, farList, barList, nil, nil)
+	checkDownlinkDataModificationResponse(smContext, rcvMsg, err)
+}
+
+// downlinkFARsOfAnUpf updates the apply action of the downlink FARs in the AN UPF
+func downlinkFARsOfAnUpf(smContext *smf_context.SMContext, applyAction pfcpType.ApplyAction) []*smf_context.FAR {
+	if smContext.Tunnel == nil || smContext.Tunnel.DataPathPool.GetDefaultPath() == nil {
+		smContext.Log.Warnf("No data path to update the downlink data handling")
+		return nil
+	}
+
+	farList := []*smf_context.FAR{}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		ANUPF := dataPath.FirstDPNode
+		if ANUPF == nil || ANUPF.DownLinkTunnel == nil || ANUPF.DownLinkTunnel.PDR == nil {
+			continue
+		}
+		DLPDR := ANUPF.DownLinkTunnel.PDR
+		DLPDR.FAR.State = smf_context.RULE_UPDATE
+		DLPDR.FAR.ApplyAction = applyAction
+		farList = append(farList, DLPDR.FAR)
+	}
+	return farList
+}
+
+func checkDownlinkDataModificationResponse(smContext *smf_context.SMContext, rcvMsg *pfcpUdp.Message, err error) {
+	if err != nil {
+		smContext.Log.Warnf("Sending PFCP Session Modification Request to AN UPF error: %+v", err)
+		return
+	}
+	rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionModificationResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		smContext.Log.Warn("Received PFCP Session Modification Not Accepted Response from AN UPF")
+	}
+}
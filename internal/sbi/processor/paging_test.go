@@ -0,0 +1,61 @@
+package processor_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/free5gc/openapi/models"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/pkg/service"
+)
+
+func TestHandleN1N2TransferFailureNotification(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	smContext := smf_context.NewSMContext("imsi-208930000000504", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000504",
+		PduSessionId: 10,
+	}
+	smContext.UpCnxState = models.UpCnxState_ACTIVATED
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	notify := func(ref string) int {
+		httpRecorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(httpRecorder)
+		c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-callback/n1n2-failure-notify/"+ref, nil)
+		processor.HandleN1N2TransferFailureNotification(c, models.N1N2MsgTxfrFailureNotification{
+			Cause: models.N1N2MessageTransferCause_UE_NOT_RESPONDING,
+		}, ref)
+		return c.Writer.Status()
+	}
+
+	smContext.SMLock.Lock()
+	smContext.StartPaging()
+	smContext.SMLock.Unlock()
+	require.Equal(t, http.StatusNoContent, notify(smContext.Ref))
+	require.Equal(t, http.StatusNotFound, notify("urn:uuid:00000000-0000-0000-0000-000000000000"))
+
+	// The paging failure of the activated user plane only stops the paging
+	require.Eventually(t, func() bool {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		return smContext.ShouldNotifyDownlinkData()
+	}, time.Second, 10*time.Millisecond)
+	smContext.SMLock.Lock()
+	require.False(t, smContext.InDDNBackoff())
+	smContext.SMLock.Unlock()
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"context"
+	"net"
+
+	"github.com/free5gc/nas/nasMessage"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+)
+
+// HandleUserPlanePathFailure handles the User Plane Path Failure Report of the UPF (TS 29.244 5.9.2).
+// The PDU sessions going through the failed paths are moved onto an alternative data path if possible,
+// otherwise they are released. The requests to reroute or release them are traced as children of ctx.
+func (p *Processor) HandleUserPlanePathFailure(
+	ctx context.Context, upf *smf_context.UPF, remotePeers []net.IP,
+) {
+	upfStr := upf.GetUPFIP()
+	for _, peer := range remotePeers {
+		logger.PfcpLog.Warnf("User plane path failure between UPF[%s] and remote GTP-U peer[%s]", upfStr, peer)
+		upf.SetPathFailure(peer)
+	}
+	// The default paths are generated again to avoid the failed paths for new PDU sessions
+	smf_context.GetUserPlaneInformation().ResetDefaultUserPlanePath()
+
+	upf.ProcEachSMContextOnPath(func(smContext *smf_context.SMContext) {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		smContext.SetTraceContext(ctx)
+
+		switch smContext.State() {
+		case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+		default:
+			return
+		}
+		if !smContext.Tunnel.DataPathPool.IsPathFailed(smContext.Tunnel.ANInformation.IPAddress) {
+			return
+		}
+
+		if err := smContext.RerouteDataPaths(); err != nil {
+			smContext.Log.Warnf("Reroute PDU session failed: %+v", err)
+			p.releasePDUSessionByNetwork(smContext, nasMessage.Cause5GSMNetworkFailure)
+			return
+		}
+
+		smContext.Log.Infof("PDU session rerouted due to user plane path failure of UPF[%s]", upfStr)
+		ActivateUPFSession(smContext, nil)
+		smContext.PostRemoveDataPath()
+	})
+}
+
+// HandleUserPlanePathRecovery handles the User Plane Path Recovery Report of the UPF (TS 29.244 5.9.3),
+// the recovered paths may be selected again for new PDU sessions
+func (p *Processor) HandleUserPlanePathRecovery(upf *smf_context.UPF, remotePeers []net.IP) {
+	upfStr := upf.GetUPFIP()
+	recovered := false
+	for _, peer := range remotePeers {
+		logger.PfcpLog.Infof("User plane path recovery between UPF[%s] and remote GTP-U peer[%s]", upfStr, peer)
+		if upf.ClearPathFailure(peer) {
+			recovered = true
+		}
+	}
+	if recovered {
+		// The default paths are generated again to use the recovered paths for new PDU sessions
+		smf_context.GetUserPlaneInformation().ResetDefaultUserPlanePath()
+	}
+}
+
+// releasePDUSessionByNetwork releases the N4 sessions and requests the AMF to release
+// the PDU session with NAS and N2 signalling (TS 23.502 4.3.4.2)
+func (p *Processor) releasePDUSessionByNetwork(smContext *smf_context.SMContext, cause uint8) {
+	if releaseSession(smContext) != smf_context.SessionReleaseSuccess {
+		smContext.Log.Warnln("Release PFCP sessions failed")
+	}
+	p.ReleaseChargingSession(smContext)
+
+	needToSendNotify, removeContext := p.requestAMFToReleasePDUResources(smContext, cause)
+	if needToSendNotify {
+		p.SendReleaseNotification(smContext)
+	}
+	if removeContext {
+		// Notification has already been sent, if it is needed
+		p.RemoveSMContextFromAllNF(smContext, false)
+	}
+}
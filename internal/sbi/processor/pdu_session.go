@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -19,6 +20,7 @@ import (
 	"github.com/free5gc/pfcp/pfcpType"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	smf_errors "github.com/free5gc/smf/pkg/errors"
 	"github.com/free5gc/smf/pkg/factory"
 )
@@ -34,6 +36,18 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 	response.JsonData = new(models.SmfPduSessionSmContextCreatedData)
 	logger.PduSessLog.Infoln("In HandlePDUSessionSMContextCreate")
 
+	// I-SMF insertion and removal of the existing PDU session without the N1 SM message (TS 23.502 4.23.3)
+	if request.JsonData != nil && len(request.BinaryDataN1SmMessage) == 0 {
+		if request.JsonData.SmfUri != "" {
+			p.handleISmfInsertion(c, request.JsonData)
+			return
+		}
+		if request.JsonData.SmContextRef != "" {
+			p.handleISmfRemoval(c, request.JsonData)
+			return
+		}
+	}
+
 	// Check has PDU Session Establishment Request
 	m := nas.NewMessage()
 	if err := m.GsmMessageDecode(&request.BinaryDataN1SmMessage); err != nil ||
@@ -66,6 +80,7 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 			smContext.SMLock.Unlock()
 		}
 	}()
+	smContext.SetTraceContext(c.Request.Context())
 
 	upi := smf_context.GetUserPlaneInformation()
 	upi.Mu.RLock()
@@ -80,6 +95,18 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 	smContext.Log.Debugf("S-NSSAI[sst: %d, sd: %s] DNN[%s]",
 		smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)
 
+	// The session management of the PDU session is performed by the H-SMF in the home-routed roaming
+	// or by the anchor SMF with the I-SMF inserted
+	if createData.HSmfUri != "" || createData.SmfUri != "" {
+		smContext.SmfRole = smf_context.SmfRoleVSmf
+		if createData.HSmfUri == "" {
+			smContext.SmfRole = smf_context.SmfRoleISmf
+		}
+		needUnlock = false
+		p.handlePeerSMContextCreate(c, smContext, m, request.BinaryDataN1SmMessage, isDone)
+		return
+	}
+
 	// Query UDM
 	if problemDetails, err := p.Consumer().SendNFDiscoveryUDM(); err != nil {
 		smContext.Log.Warnf("Send NF Discovery Serving UDM Error[%v]", err)
@@ -105,6 +132,7 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 		smContext.Log.Errorf("Get Token Context Error[%v]", oauthErr)
 		return
 	}
+	ctx = smContext.WithTraceContext(ctx)
 
 	if sessSubData, err := p.Consumer().
 		GetSmData(ctx, smContext.Supi, smDataParams); err != nil {
@@ -124,35 +152,74 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 	var doSubscribe bool = false
 	defer func() {
 		if doSubscribe {
-			if !p.Context().Ues.UeExists(smContext.Supi) {
-				if problemDetails, err := p.Consumer().
-					Subscribe(ctx, smContext, smPlmnID); problemDetails != nil {
-					smContext.Log.Errorln("SDM Subscription Failed Problem:", problemDetails)
-				} else if err != nil {
-					smContext.Log.Errorln("SDM Subscription Error:", err)
-				}
-			} else {
-				p.Context().Ues.IncrementPduSessionCount(smContext.Supi)
-			}
+			p.subscribeSmDataChange(ctx, smContext, smPlmnID)
 		}
 	}()
 
-	establishmentRequest := m.PDUSessionEstablishmentRequest
-	if err := HandlePDUSessionEstablishmentRequest(smContext, establishmentRequest); err != nil {
-		smContext.Log.Errorf("PDU Session Establishment fail by %s", err)
-		gsmError := &GSMError{}
-		if errors.As(err, &gsmError) {
-			p.makeEstRejectResAndReleaseSMContext(c, smContext,
-				gsmError.GSMCause,
-				&smf_errors.N1SmError)
-			return
-		}
-		p.makeEstRejectResAndReleaseSMContext(c, smContext,
-			nasMessage.Cause5GSMRequestRejectedUnspecified,
-			&smf_errors.N1SmError)
+	p.discoverServingAMF(smContext)
+
+	if estErr := p.establishPDUSession(smContext, m.PDUSessionEstablishmentRequest); estErr != nil {
+		p.makeEstRejectResAndReleaseSMContext(c, smContext, estErr.gsmCause, estErr.sbiError)
 		return
 	}
 
+	// generate goroutine to handle PFCP and
+	// reply PDUSessionSMContextCreate rsp immediately
+	needUnlock = false
+	go func() {
+		defer smContext.SMLock.Unlock()
+
+		smContext.SendUpPathChgNotification("EARLY", SendUpPathChgEventExposureNotification)
+
+		handler := func(smContext *smf_context.SMContext, pfcpErr error) {
+			p.EstHandler(isDone, smContext, pfcpErr)
+		}
+
+		ActivateUPFSession(smContext, handler)
+
+		smContext.SendUpPathChgNotification("LATE", SendUpPathChgEventExposureNotification)
+
+		smContext.PostRemoveDataPath()
+	}()
+
+	doSubscribe = true
+	response.JsonData = smContext.BuildCreatedData()
+
+	c.Header("Location", resourceLocation(c, smContext.Ref))
+	c.JSON(http.StatusCreated, response)
+}
+
+// resourceLocation returns the URI of the resource created by the request
+func resourceLocation(c *gin.Context, ref string) string {
+	// default location value will only be used in test environment
+	// in real environment, location value will be formatted as a full URI
+	location := ref // this is the default location value
+	if c.Request != nil {
+		protocol := "http"
+		if c.Request.TLS != nil {
+			protocol += "s"
+		}
+		// The SM context reference is a URN (urn:uuid:<uuid>), the resource ID is its UUID
+		id := ref
+		if strings.HasPrefix(ref, "urn:") {
+			refParts := strings.Split(ref, ":")
+			if len(refParts) <= 2 {
+				logger.PduSessLog.Errorln("smContext.Ref(uuid) format is incorrect")
+				return location
+			}
+			id = refParts[2]
+		}
+		location = fmt.Sprintf("%s://%s%s/%s",
+			protocol,
+			c.Request.Host,
+			strings.TrimSuffix(c.Request.URL.Path, "/"),
+			id)
+	}
+	return location
+}
+
+// discoverServingAMF discovers the serving AMF of the UE for the Namf_Communication service
+func (p *Processor) discoverServingAMF(smContext *smf_context.SMContext) {
 	// Discover and new Namf_Comm client for use later
 	if problemDetails, err := p.Consumer().SendNFDiscoveryServingAMF(smContext); err != nil {
 		smContext.Log.Warnf("Send NF Discovery Serving AMF Error[%v]", err)
@@ -167,14 +234,52 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 			smContext.CommunicationClientApiPrefix = service.ApiPrefix
 		}
 	}
+}
+
+// subscribeSmDataChange subscribes to the changes of the session management subscription data of the UE
+func (p *Processor) subscribeSmDataChange(
+	ctx context.Context, smContext *smf_context.SMContext, smPlmnID *models.PlmnIdNid,
+) {
+	if !p.Context().Ues.UeExists(smContext.Supi) {
+		if problemDetails, err := p.Consumer().
+			Subscribe(ctx, smContext, smPlmnID); problemDetails != nil {
+			smContext.Log.Errorln("SDM Subscription Failed Problem:", problemDetails)
+		} else if err != nil {
+			smContext.Log.Errorln("SDM Subscription Error:", err)
+		}
+	} else {
+		p.Context().Ues.IncrementPduSessionCount(smContext.Supi)
+	}
+}
+
+// establishmentError is the 5GSM cause and the SBI error to reject the PDU session establishment
+type establishmentError struct {
+	gsmCause uint8
+	sbiError *models.SmfPduSessionExtProblemDetails
+}
+
+// establishPDUSession handles the PDU Session Establishment Request: the UE address, the SM policy,
+// the charging and the user plane of the PDU session are set up
+func (p *Processor) establishPDUSession(
+	smContext *smf_context.SMContext,
+	establishmentRequest *nasMessage.PDUSessionEstablishmentRequest,
+) *establishmentError {
+	if err := HandlePDUSessionEstablishmentRequest(smContext, establishmentRequest); err != nil {
+		smContext.Log.Errorf("PDU Session Establishment fail by %s", err)
+		gsmError := &GSMError{}
+		if errors.As(err, &gsmError) {
+			return &establishmentError{gsmError.GSMCause, &smf_errors.N1SmError}
+		}
+		return &establishmentError{nasMessage.Cause5GSMRequestRejectedUnspecified, &smf_errors.N1SmError}
+	}
 
 	if err := smContext.AllocUeIP(); err != nil {
 		smContext.SetState(smf_context.InActive)
 		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+		return &establishmentError{
 			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&smf_errors.InsufficientResourceSliceDnn)
-		return
+			&smf_errors.InsufficientResourceSliceDnn,
+		}
 	}
 
 	if err := p.Consumer().PCFSelection(smContext); err != nil {
@@ -188,16 +293,13 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 			smContext.Log.Errorln("setup sm policy association failed:", err, problemDetails)
 			smContext.SetState(smf_context.InActive)
 			if problemDetails.Cause == "USER_UNKNOWN" {
-				p.makeEstRejectResAndReleaseSMContext(c, smContext,
+				return &establishmentError{
 					nasMessage.Cause5GSMRequestRejectedUnspecified,
-					&smf_errors.SubscriptionDenied)
-				return
+					&smf_errors.SubscriptionDenied,
+				}
 			}
 		}
-		p.makeEstRejectResAndReleaseSMContext(c, smContext,
-			nasMessage.Cause5GSMNetworkFailure,
-			&smf_errors.NetworkFailure)
-		return
+		return &establishmentError{nasMessage.Cause5GSMNetworkFailure, &smf_errors.NetworkFailure}
 	}
 	smContext.SMPolicyID = smPolicyID
 
@@ -213,10 +315,10 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 	// Update SessionRule from decision
 	if err = smContext.ApplySessionRules(smPolicyDecision); err != nil {
 		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+		return &establishmentError{
 			nasMessage.Cause5GSMRequestRejectedUnspecified,
-			&smf_errors.SubscriptionDenied)
-		return
+			&smf_errors.SubscriptionDenied,
+		}
 	}
 
 	// If PCF prepares default Pcc Rule, SMF do not need to create defaultDataPath.
@@ -228,65 +330,22 @@ func (p *Processor) HandlePDUSessionSMContextCreate(
 	if err = smContext.SelectDefaultDataPath(); err != nil {
 		smContext.SetState(smf_context.InActive)
 		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+		return &establishmentError{
 			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&smf_errors.InsufficientResourceSliceDnn)
-		return
+			&smf_errors.InsufficientResourceSliceDnn,
+		}
 	}
 
 	// SelectULCLDataPaths() will create other paths if ULCL is enabled.
 	if err = smContext.SelectULCLDataPaths(); err != nil {
 		smContext.SetState(smf_context.InActive)
 		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+		return &establishmentError{
 			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&smf_errors.InsufficientResourceSliceDnn)
-		return
-	}
-
-	// generate goroutine to handle PFCP and
-	// reply PDUSessionSMContextCreate rsp immediately
-	needUnlock = false
-	go func() {
-		defer smContext.SMLock.Unlock()
-
-		smContext.SendUpPathChgNotification("EARLY", SendUpPathChgEventExposureNotification)
-
-		handler := func(smContext *smf_context.SMContext, success bool) {
-			p.EstHandler(isDone, smContext, success)
-		}
-
-		ActivateUPFSession(smContext, handler)
-
-		smContext.SendUpPathChgNotification("LATE", SendUpPathChgEventExposureNotification)
-
-		smContext.PostRemoveDataPath()
-	}()
-
-	doSubscribe = true
-	response.JsonData = smContext.BuildCreatedData()
-
-	// default location value will only be used in test environment
-	// in real environment, location value will be formatted as a full URI
-	location := smContext.Ref // this is the default location value
-	if c.Request != nil {
-		protocol := "http"
-		if c.Request.TLS != nil {
-			protocol += "s"
-		}
-		smContextRefParts := strings.Split(smContext.Ref, ":")
-		if len(smContextRefParts) > 2 {
-			location = fmt.Sprintf("%s://%s%s/%s",
-				protocol,
-				c.Request.Host,
-				strings.TrimSuffix(c.Request.URL.Path, "/"),
-				smContextRefParts[2])
-		} else {
-			logger.PduSessLog.Errorln("smContext.Ref(uuid) format is incorrect")
+			&smf_errors.InsufficientResourceSliceDnn,
 		}
 	}
-	c.Header("Location", location)
-	c.JSON(http.StatusCreated, response)
+	return nil
 }
 
 func (p *Processor) HandlePDUSessionSMContextUpdate(
@@ -326,6 +385,7 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
 
 	var sendPFCPModification bool
 	var pfcpResponseStatus smf_context.PFCPSessionResponseStatus
@@ -349,6 +409,12 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 			return
 		}
 
+		// The N1 SM message is handled by the H-SMF or the anchor SMF
+		if smContext.ServedByPeerSmf() {
+			p.relayN1SmMessageToPeerSmf(c, smContext, m, body.BinaryDataN1SmMessage)
+			return
+		}
+
 		switch m.GsmHeader.GetMessageType() {
 		case nas.MsgTypePDUSessionReleaseRequest:
 			smContext.CheckState(smf_context.Active)
@@ -356,10 +422,18 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 			// TODO: implement sleep wait in concurrent architecture
 
 			HandlePDUSessionReleaseRequest(smContext, m.PDUSessionReleaseRequest)
-			if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
-				smContext.Log.Infof("Release IP[%s]", smContext.PDUAddress)
-				upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
-				smContext.PDUAddress = nil
+			if smContext.SelectedUPF != nil && smContext.HasPDUAddress() {
+				notifyUeIpRelease(smContext)
+				if smContext.PDUAddress != nil {
+					smContext.Log.Infof("Release IP[%s]", smContext.PDUAddress)
+					upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
+					smContext.PDUAddress = nil
+				}
+				if smContext.PDUIPv6Prefix != nil {
+					smContext.Log.Infof("Release IPv6 prefix[%s]", smContext.PDUIPv6Prefix)
+					upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUIPv6Prefix, smContext.UseStaticIPv6)
+					smContext.PDUIPv6Prefix = nil
+				}
 				// keep SelectedUPF until PDU Session Release is completed
 			}
 
@@ -431,6 +505,7 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 			smContext.SetState(smf_context.InActive)
 			response.JsonData.UpCnxState = models.UpCnxState_DEACTIVATED
 			smContext.StopT3592()
+			metrics.CountPduSessionProcedure(metrics.ProcedureRelease, true, 0)
 
 			// If CN tunnel resource is released, should
 			if smContext.Tunnel.ANInformation.IPAddress == nil {
@@ -439,6 +514,8 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 		case nas.MsgTypePDUSessionModificationRequest:
 			if rsp, errHandleReq := p.
 				HandlePDUSessionModificationRequest(smContext, m.PDUSessionModificationRequest); errHandleReq != nil {
+				metrics.CountPduSessionProcedure(metrics.ProcedureModification, false,
+					nasMessage.Cause5GSMMessageTypeNonExistentOrNotImplemented)
 				if buf, err = smf_context.BuildGSMPDUSessionModificationReject(smContext); err != nil {
 					smContext.Log.Errorf("build GSM PDUSessionModificationReject failed: %+v", err)
 				} else {
@@ -466,8 +543,11 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 			return
 		case nas.MsgTypePDUSessionModificationComplete:
 			smContext.StopT3591()
+			metrics.CountPduSessionProcedure(metrics.ProcedureModification, true, 0)
 		case nas.MsgTypePDUSessionModificationReject:
 			smContext.StopT3591()
+			metrics.CountPduSessionProcedure(metrics.ProcedureModification, false,
+				m.PDUSessionModificationReject.GetCauseValue())
 		}
 	}
 
@@ -498,6 +578,8 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 			response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDUSessionResourceSetupRequestTransfer"}
 		}
 		smContext.UpCnxState = models.UpCnxState_ACTIVATING
+		// The UE responds to the paging, or it requests the service itself
+		smContext.StopPaging()
 	case models.UpCnxState_DEACTIVATED:
 		smContext.CheckState(smf_context.Active)
 		// Wait till the state becomes Active again
@@ -536,21 +618,10 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 
 		// Set FAR and An, N3 Release Info
 		// TODO: Deactivate all datapath in ANUPF
-		farList = []*smf_context.FAR{}
-		for _, dataPath := range smContext.Tunnel.DataPathPool {
-			ANUPF := dataPath.FirstDPNode
-			DLPDR := ANUPF.DownLinkTunnel.PDR
-			if DLPDR == nil {
-				smContext.Log.Warnf("Access network resource is released")
-			} else {
-				DLPDR.FAR.State = smf_context.RULE_UPDATE
-				DLPDR.FAR.ApplyAction.Forw = false
-				DLPDR.FAR.ApplyAction.Buff = true
-				DLPDR.FAR.ApplyAction.Nocp = true
-				farList = append(farList, DLPDR.FAR)
-				sendPFCPModification = true
-				smContext.SetState(smf_context.PFCPModification)
-			}
+		pdrList, farList, barList = downlinkDataBuffering(smContext)
+		if len(farList) != 0 {
+			sendPFCPModification = true
+			smContext.SetState(smf_context.PFCPModification)
 		}
 	}
 
@@ -582,7 +653,7 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 					},
 					NetworkInstance: &pfcpType.NetworkInstance{
 						NetworkInstance: smContext.Dnn,
-						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
+						FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
 					},
 				}
 
@@ -834,6 +905,10 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 		case smf_context.SessionUpdateSuccess:
 			smContext.Log.Traceln("In case SessionUpdateSuccess")
 			smContext.SetState(smf_context.Active)
+			smContext.Checkpoint()
+			if smContextUpdateData.N2SmInfoType == models.N2SmInfoType_PDU_RES_SETUP_RSP {
+				forwardBufferedDownlinkData(smContext)
+			}
 			c.Render(http.StatusOK, openapi.MultipartRelatedRender{Data: response})
 		case smf_context.SessionUpdateFailed:
 			smContext.Log.Traceln("In case SessionUpdateFailed")
@@ -857,6 +932,8 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 			// Update SmContext Request(N1 PDU Session Release Request)
 			// Send PDU Session Release Reject
 			smContext.Log.Traceln("In case SessionReleaseFailed")
+			metrics.CountPduSessionProcedure(metrics.ProcedureRelease, false,
+				nasMessage.Cause5GSMRequestRejectedUnspecified)
 			problemDetail := models.SmfPduSessionExtProblemDetails{
 				Status: http.StatusInternalServerError,
 				Cause:  "SYSTEM_FAILURE",
@@ -881,6 +958,7 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 	case smf_context.ModificationPending:
 		smContext.Log.Traceln("In case ModificationPending")
 		smContext.SetState(smf_context.Active)
+		smContext.Checkpoint()
 		c.Render(http.StatusOK, openapi.MultipartRelatedRender{Data: response})
 	case smf_context.InActive, smf_context.InActivePending:
 		smContext.Log.Traceln("In case InActive, InActivePending")
@@ -897,6 +975,78 @@ func (p *Processor) HandlePDUSessionSMContextUpdate(
 	}
 }
 
+// HandlePDUSessionSMContextRetrieve returns the SM context requested by the AMF (TS 29.502 5.2.2.5)
+func (p *Processor) HandlePDUSessionSMContextRetrieve(
+	c *gin.Context,
+	body models.SmContextRetrieveData,
+	smContextRef string,
+) {
+	logger.PduSessLog.Infoln("In HandlePDUSessionSMContextRetrieve")
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+
+	if smContext == nil {
+		logger.PduSessLog.Warnf("SMContext[%s] is not found", smContextRef)
+
+		problemDetails := &models.ProblemDetails{
+			Title:  "SMContext Ref is not found",
+			Status: http.StatusNotFound,
+			Cause:  CONTEXT_NOT_FOUND,
+		}
+		c.JSON(http.StatusNotFound, problemDetails)
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	response := models.SmContextRetrievedData{}
+	switch body.SmContextType {
+	case models.SmContextType_SM_CONTEXT:
+		smContextModel, err := smContext.BuildSmContext()
+		if err != nil {
+			smContext.Log.Errorf("Build SM context failed: %+v", err)
+			problemDetails := &models.ProblemDetails{
+				Title:  "Build SM context failed",
+				Status: http.StatusInternalServerError,
+				Detail: err.Error(),
+				Cause:  "SYSTEM_FAILURE",
+			}
+			c.JSON(http.StatusInternalServerError, problemDetails)
+			return
+		}
+		response.SmContext = smContextModel
+	case models.SmContextType_AF_COORDINATION_INFO:
+		response.AfCoordinationInfo = smContext.BuildAfCoordinationInfo()
+	default:
+		// The EPS PDN connection is requested if the SM context type is absent
+		ueEpsPdnConnection, err := smContext.BuildUeEpsPdnConnection()
+		if errors.Is(err, smf_context.ErrNoEpsBearerID) {
+			smContext.Log.Warnln("No EPS PDN connection for the PDU session")
+			problemDetails := &models.ProblemDetails{
+				Title:  "No EPS PDN connection",
+				Status: http.StatusForbidden,
+				Detail: "No EPS bearer ID is assigned to the PDU session",
+				Cause:  "NO_EPS_5GS_CONTINUITY",
+			}
+			c.JSON(http.StatusForbidden, problemDetails)
+			return
+		} else if err != nil {
+			smContext.Log.Errorf("Build EPS PDN connection failed: %+v", err)
+			problemDetails := &models.ProblemDetails{
+				Title:  "Build EPS PDN connection failed",
+				Status: http.StatusInternalServerError,
+				Detail: err.Error(),
+				Cause:  "SYSTEM_FAILURE",
+			}
+			c.JSON(http.StatusInternalServerError, problemDetails)
+			return
+		}
+		response.UeEpsPdnConnection = ueEpsPdnConnection
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 func (p *Processor) HandlePDUSessionSMContextRelease(
 	c *gin.Context,
 	body models.ReleaseSmContextRequest,
@@ -924,6 +1074,7 @@ func (p *Processor) HandlePDUSessionSMContextRelease(
 
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
 
 	smContext.StopT3591()
 	smContext.StopT3592()
@@ -959,6 +1110,8 @@ func (p *Processor) HandlePDUSessionSMContextRelease(
 		}
 	}
 
+	// the release procedure of a PDU session released by the UE has already been counted
+	releasedByUE := smContext.State() == smf_context.InActive
 	if !smContext.CheckState(smf_context.InActive) {
 		smContext.SetState(smf_context.PFCPModification)
 	}
@@ -970,12 +1123,17 @@ func (p *Processor) HandlePDUSessionSMContextRelease(
 
 		smContext.Log.Traceln("In case SessionReleaseSuccess")
 		smContext.SetState(smf_context.InActive)
+		if !releasedByUE {
+			metrics.CountPduSessionProcedure(metrics.ProcedureRelease, true, 0)
+		}
 		c.Status(http.StatusNoContent)
 
 	case smf_context.SessionReleaseFailed:
 		// Update SmContext Request(N1 PDU Session Release Request)
 		// Send PDU Session Release Reject
 		smContext.Log.Traceln("In case SessionReleaseFailed")
+		metrics.CountPduSessionProcedure(metrics.ProcedureRelease, false,
+			nasMessage.Cause5GSMRequestRejectedUnspecified)
 		problemDetail := models.SmfPduSessionExtProblemDetails{
 			Status: http.StatusInternalServerError,
 			Cause:  "SYSTEM_FAILURE",
@@ -999,6 +1157,8 @@ func (p *Processor) HandlePDUSessionSMContextRelease(
 		smContext.Log.Warnf("The state shouldn't be [%s]\n", pfcpResponseStatus)
 
 		smContext.Log.Traceln("In case Unknown")
+		metrics.CountPduSessionProcedure(metrics.ProcedureRelease, false,
+			nasMessage.Cause5GSMRequestRejectedUnspecified)
 		problemDetail := models.SmfPduSessionExtProblemDetails{
 			Status: http.StatusInternalServerError,
 			Cause:  "SYSTEM_FAILURE",
@@ -1127,6 +1287,7 @@ func (p *Processor) makeEstRejectResAndReleaseSMContext(
 	} else {
 		postSmContextsError.BinaryDataN1SmMessage = buf
 	}
+	metrics.CountPduSessionProcedure(metrics.ProcedureEstablishment, false, nasErrorCause)
 	p.nasErrorResponse(c, int(sbiError.Status), postSmContextsError)
 	p.RemoveSMContextFromAllNF(smContext, false)
 }
@@ -1147,16 +1308,18 @@ func (p *Processor) sendGSMPDUSessionReleaseCommand(smContext *smf_context.SMCon
 	}
 
 	// Start T3592
-	t3592 := factory.SmfConfig.Configuration.T3592
+	t3592 := factory.SmfConfig.GetT3592()
 	if t3592.Enable {
 		ctx, _, err := smf_context.GetSelf().GetTokenCtx(models.ServiceName_NAMF_COMM, models.NrfNfManagementNfType_AMF)
 		if err != nil {
 			smContext.Log.Warnf("Get namf-comm token failed: %+v", err)
 			return
 		}
+		ctx = smContext.WithTraceContext(ctx)
 
 		smContext.T3592 = smf_context.NewTimer(t3592.ExpireTime, t3592.MaxRetryTimes, func(expireTimes int32) {
 			smContext.SMLock.Lock()
+			defer smContext.SMLock.Unlock()
 			rspData, errMsgTransfer := p.Consumer().
 				N1N2MessageTransfer(ctx, smContext.Supi, n1n2Request, smContext.CommunicationClientApiPrefix)
 			if errMsgTransfer != nil {
@@ -1167,8 +1330,6 @@ func (p *Processor) sendGSMPDUSessionReleaseCommand(smContext *smf_context.SMCon
 			if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
 				smContext.Log.Warnf("%v", rspData.Cause)
 			}
-
-			smContext.SMLock.Unlock()
 		}, func() {
 			smContext.Log.Warn("T3592 Expires 3 times, abort notification procedure")
 			smContext.SMLock.Lock()
@@ -1196,13 +1357,14 @@ func (p *Processor) sendGSMPDUSessionModificationCommand(smContext *smf_context.
 	}
 
 	// Start T3591
-	t3591 := factory.SmfConfig.Configuration.T3591
+	t3591 := factory.SmfConfig.GetT3591()
 	if t3591.Enable {
 		ctx, _, err := smf_context.GetSelf().GetTokenCtx(models.ServiceName_NAMF_COMM, models.NrfNfManagementNfType_AMF)
 		if err != nil {
 			smContext.Log.Warnf("Get namf-comm token failed: %+v", err)
 			return
 		}
+		ctx = smContext.WithTraceContext(ctx)
 
 		smContext.T3591 = smf_context.NewTimer(t3591.ExpireTime, t3591.MaxRetryTimes, func(expireTimes int32) {
 			smContext.SMLock.Lock()
@@ -2,8 +2,10 @@ package processor_test
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -93,6 +95,8 @@ var testConfig = factory.Config{
 		},
 		NrfUri:               "http://127.0.0.10:8000",
 		UserPlaneInformation: userPlaneConfig,
+		T3591:                &factory.TimerValue{},
+		T3592:                &factory.TimerValue{},
 		ServiceNameList: []string{
 			"nsmf-pdusession",
 			"nsmf-event-exposure",
@@ -603,6 +607,7 @@ func TestHandlePDUSessionSMContextCreate(t *testing.T) {
 		t.Run(tc.paramStr, func(t *testing.T) {
 			httpRecorder := httptest.NewRecorder()
 			c, _ := gin.CreateTestContext(httpRecorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/sm-contexts", nil)
 
 			processor.HandlePDUSessionSMContextCreate(c, tc.request, nil)
 
@@ -651,3 +656,114 @@ func TestHandlePDUSessionSMContextCreate(t *testing.T) {
 	err = udp.ClosePfcp()
 	require.NoError(t, err)
 }
+
+func TestHandlePDUSessionSMContextRetrieve(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	smContext := smf_context.NewSMContext("imsi-208930000000401", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000401",
+		PduSessionId: 10,
+		Dnn:          "internet",
+		SNssai: &models.Snssai{
+			Sst: 1,
+			Sd:  "112232",
+		},
+	}
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.PDUAddress = net.ParseIP("10.60.0.1").To4()
+	smContext.SessionRules["SessRule1"] = &smf_context.SessionRule{
+		SessionRule: &models.SessionRule{
+			AuthSessAmbr: &models.Ambr{
+				Uplink:   "1000 Mbps",
+				Downlink: "1000 Mbps",
+			},
+			AuthDefQos: &models.AuthorizedDefaultQos{
+				Var5qi: 9,
+				Arp: &models.Arp{
+					PriorityLevel: 8,
+				},
+			},
+		},
+		DefQosQFI: 1,
+	}
+	smContext.SelectedSessionRuleID = "SessRule1"
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	retrieve := func(ref string, smContextType models.SmContextType) (int, []byte) {
+		httpRecorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(httpRecorder)
+		processor.HandlePDUSessionSMContextRetrieve(c,
+			models.SmContextRetrieveData{SmContextType: smContextType}, ref)
+		return httpRecorder.Code, httpRecorder.Body.Bytes()
+	}
+
+	t.Run("SM context", func(t *testing.T) {
+		status, body := retrieve(smContext.Ref, models.SmContextType_SM_CONTEXT)
+		require.Equal(t, http.StatusOK, status)
+
+		var rsp models.SmContextRetrievedData
+		require.NoError(t, json.Unmarshal(body, &rsp))
+		require.NotNil(t, rsp.SmContext)
+		require.Equal(t, int32(10), rsp.SmContext.PduSessionId)
+		require.Equal(t, "internet", rsp.SmContext.Dnn)
+		require.Equal(t, models.PduSessionType_IPV4, rsp.SmContext.PduSessionType)
+		require.Equal(t, "10.60.0.1", rsp.SmContext.UeIpv4Address)
+		require.Equal(t, "1000 Mbps", rsp.SmContext.SessionAmbr.Uplink)
+		require.Len(t, rsp.SmContext.QosFlowsList, 1)
+		require.Equal(t, int32(1), rsp.SmContext.QosFlowsList[0].Qfi)
+		require.True(t, rsp.SmContext.QosFlowsList[0].DefaultQosRuleInd)
+		require.NotEmpty(t, rsp.SmContext.QosFlowsList[0].QosRules)
+	})
+
+	t.Run("No EPS bearer ID", func(t *testing.T) {
+		status, body := retrieve(smContext.Ref, "")
+		require.Equal(t, http.StatusForbidden, status)
+
+		var problemDetails models.ProblemDetails
+		require.NoError(t, json.Unmarshal(body, &problemDetails))
+		require.Equal(t, "NO_EPS_5GS_CONTINUITY", problemDetails.Cause)
+	})
+
+	t.Run("EPS PDN connection", func(t *testing.T) {
+		smContext.EpsBearerID = 5
+		defer func() {
+			smContext.EpsBearerID = 0
+		}()
+		smfIP := smf_context.GetSelf().CPNodeID.ResolveNodeIdToIp().To4()
+		require.NotNil(t, smfIP)
+
+		status, body := retrieve(smContext.Ref, models.SmContextType_EPS_PDN_CONNECTION)
+		require.Equal(t, http.StatusOK, status)
+
+		var rsp models.SmContextRetrievedData
+		require.NoError(t, json.Unmarshal(body, &rsp))
+		require.Nil(t, rsp.SmContext)
+		pdnConnection, err := base64.StdEncoding.DecodeString(rsp.UeEpsPdnConnection)
+		require.NoError(t, err)
+
+		expected := []byte{
+			109, 0, 86, 0, // PDN Connection
+			71, 0, 9, 0, 8, 'i', 'n', 't', 'e', 'r', 'n', 'e', 't', // APN
+			74, 0, 4, 0, 10, 60, 0, 1, // IPv4 address
+			73, 0, 1, 0, 5, // linked EBI
+			87, 0, 9, 0, 0x87, 0, 0, 0, 0, smfIP[0], smfIP[1], smfIP[2], smfIP[3], // PGW S5/S8 F-TEID
+			93, 0, 31, 0, // bearer context
+			73, 0, 1, 0, 5, // EBI
+			80, 0, 22, 0, 8 << 2, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // bearer QoS
+			72, 0, 8, 0, 0, 0x0f, 0x42, 0x40, 0, 0x0f, 0x42, 0x40, // APN-AMBR 1000000 kbps
+		}
+		require.Equal(t, expected, pdnConnection)
+	})
+
+	t.Run("SM context not found", func(t *testing.T) {
+		status, _ := retrieve("urn:uuid:00000000-0000-0000-0000-000000000000", models.SmContextType_SM_CONTEXT)
+		require.Equal(t, http.StatusNotFound, status)
+	})
+}
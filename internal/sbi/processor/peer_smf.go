@@ -0,0 +1,950 @@
+package processor
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/nas"
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
+	smf_errors "github.com/free5gc/smf/pkg/errors"
+)
+
+// handlePeerSMContextCreate creates the PDU session of the SM context in the H-SMF or the anchor SMF
+// (TS 23.502 4.3.2.2.2, 4.23.5.1). The V-SMF or the I-SMF sets up the V-UPF or the I-UPF toward
+// the H-UPF or the PSA and relays the PDU Session Establishment Accept of the peer SMF to the UE.
+func (p *Processor) handlePeerSMContextCreate(
+	c *gin.Context,
+	smContext *smf_context.SMContext,
+	m *nas.Message,
+	n1SmMsg []byte,
+	isDone <-chan struct{},
+) {
+	needUnlock := true
+	defer func() {
+		if needUnlock {
+			smContext.SMLock.Unlock()
+		}
+	}()
+
+	establishmentRequest := m.PDUSessionEstablishmentRequest
+	smContext.Pti = establishmentRequest.GetPTI()
+
+	p.discoverServingAMF(smContext)
+
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	if establishmentRequest.PDUSessionType != nil {
+		smContext.SelectedPDUSessionType = establishmentRequest.PDUSessionType.GetPDUSessionTypeValue()
+	}
+
+	// Select the V-UPF or the I-UPF of the PDU session
+	smContext.SelectionParam = &smf_context.UPFSelectionParams{
+		Dnn: smContext.Dnn,
+		SNssai: &smf_context.SNssai{
+			Sst: smContext.SNssai.Sst,
+			Sd:  smContext.SNssai.Sd,
+		},
+		PDUSessionType: smContext.SelectedPDUSessionType,
+	}
+	smContext.SelectedUPF = smf_context.GetUserPlaneInformation().SelectUPF(smContext.SelectionParam)
+	if smContext.SelectedUPF == nil {
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: no UPF for %s", smContext.SelectionParam)
+		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&smf_errors.InsufficientResourceSliceDnn)
+		return
+	}
+
+	dlTunnelInfo, err := smContext.BuildDLN9TunnelInfo()
+	if err != nil {
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
+		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&smf_errors.InsufficientResourceSliceDnn)
+		return
+	}
+
+	request := models.PostPduSessionsRequest{
+		JsonData:                 smContext.BuildPduSessionCreateData(dlTunnelInfo),
+		BinaryDataN1SmInfoFromUe: n1SmMsg,
+	}
+	rsp, errRsp, err := p.Consumer().SendCreatePduSession(smContext, request)
+	if err != nil {
+		smContext.Log.Errorf("Create PDU session in peer SMF failed: %v", err)
+		if errRsp != nil && errRsp.BinaryDataN1SmInfoToUe != nil {
+			// Relay the PDU Session Establishment Reject of the peer SMF to the UE
+			postSmContextsError := models.PostSmContextsError{
+				JsonData: &models.SmContextCreateError{
+					Error:   &smf_errors.N1SmError,
+					N1SmMsg: &models.RefToBinaryData{ContentId: "n1SmMsg"},
+				},
+				BinaryDataN1SmMessage: errRsp.BinaryDataN1SmInfoToUe,
+			}
+			p.nasErrorResponse(c, int(smf_errors.N1SmError.Status), postSmContextsError)
+			p.RemoveSMContextFromAllNF(smContext, false)
+			return
+		}
+		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+			nasMessage.Cause5GSMNetworkFailure, &smf_errors.NetworkFailure)
+		return
+	}
+	smContext.PeerN1SmMsg = rsp.BinaryDataN1SmInfoToUe
+
+	if err = smContext.ApplyPduSessionCreatedData(rsp.JsonData); err != nil {
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
+		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+			nasMessage.Cause5GSMNetworkFailure, &smf_errors.NetworkFailure)
+		return
+	}
+
+	if err = smContext.SelectDefaultDataPath(); err != nil {
+		smContext.SetState(smf_context.InActive)
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
+		p.makeEstRejectResAndReleaseSMContext(c, smContext,
+			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&smf_errors.InsufficientResourceSliceDnn)
+		return
+	}
+
+	// generate goroutine to handle PFCP and
+	// reply PDUSessionSMContextCreate rsp immediately
+	needUnlock = false
+	go func() {
+		defer smContext.SMLock.Unlock()
+
+		handler := func(smContext *smf_context.SMContext, pfcpErr error) {
+			p.EstHandler(isDone, smContext, pfcpErr)
+		}
+
+		ActivateUPFSession(smContext, handler)
+	}()
+
+	response := models.PostSmContextsResponse201{
+		JsonData: smContext.BuildCreatedData(),
+	}
+	c.Header("Location", resourceLocation(c, smContext.Ref))
+	c.JSON(http.StatusCreated, response)
+}
+
+// relayN1SmMessageToPeerSmf relays the N1 SM message of the UE to the H-SMF or the anchor SMF and the N1 SM
+// message of the peer SMF to the UE (TS 23.502 4.3.3.3, 4.3.4.3, 4.23.6, 4.23.7). The V-SMF or the I-SMF
+// provides the N2 SM information and updates the V-UPF or the I-UPF.
+func (p *Processor) relayN1SmMessageToPeerSmf(
+	c *gin.Context,
+	smContext *smf_context.SMContext,
+	m *nas.Message,
+	n1SmMsg []byte,
+) {
+	var requestIndication models.RequestIndication
+	switch m.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionReleaseRequest:
+		requestIndication = models.RequestIndication_UE_REQ_PDU_SES_REL
+	case nas.MsgTypePDUSessionModificationRequest:
+		requestIndication = models.RequestIndication_UE_REQ_PDU_SES_MOD
+	case nas.MsgTypePDUSessionReleaseComplete:
+		requestIndication = models.RequestIndication_NW_REQ_PDU_SES_REL
+	case nas.MsgTypePDUSessionModificationComplete, nas.MsgTypePDUSessionModificationReject:
+		requestIndication = models.RequestIndication_NW_REQ_PDU_SES_MOD
+	default:
+		smContext.Log.Warnf("N1 Message[%d] can't be relayed to peer SMF", m.GsmHeader.GetMessageType())
+		updateSmContextError := models.UpdateSmContextResponse400{
+			JsonData: &models.SmContextUpdateError{
+				Error: &smf_errors.N1SmError,
+			},
+		}
+		c.JSON(http.StatusForbidden, updateSmContextError)
+		return
+	}
+
+	request := models.UpdatePduSessionRequest{
+		JsonData: &models.HsmfUpdateData{
+			RequestIndication: requestIndication,
+			N1SmInfoFromUe:    &models.RefToBinaryData{ContentId: "n1SmInfoFromUe"},
+			AnType:            smContext.AnType,
+			RatType:           smContext.RatType,
+			UeLocation:        smContext.UeLocation,
+			UeTimeZone:        smContext.UeTimeZone,
+		},
+		BinaryDataN1SmInfoFromUe: n1SmMsg,
+	}
+	rsp, errRsp, err := p.Consumer().SendUpdatePduSession(smContext, request)
+	if err != nil {
+		smContext.Log.Errorf("Update PDU session in peer SMF failed: %v", err)
+		updateSmContextError := models.UpdateSmContextResponse400{
+			JsonData: &models.SmContextUpdateError{
+				Error: &smf_errors.N1SmError,
+			},
+		}
+		if errRsp != nil && errRsp.BinaryDataN1SmInfoToUe != nil {
+			// Relay the reject of the peer SMF to the UE
+			updateSmContextError.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "N1SmMsg"}
+			updateSmContextError.BinaryDataN1SmMessage = errRsp.BinaryDataN1SmInfoToUe
+			c.Render(http.StatusForbidden, openapi.MultipartRelatedRender{Data: updateSmContextError})
+			return
+		}
+		c.JSON(http.StatusForbidden, updateSmContextError)
+		return
+	}
+
+	var response models.UpdateSmContextResponse200
+	response.JsonData = new(models.SmContextUpdatedData)
+	if rsp.BinaryDataN1SmInfoToUe != nil {
+		response.BinaryDataN1SmMessage = rsp.BinaryDataN1SmInfoToUe
+		response.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "N1SmMsg"}
+	}
+
+	switch requestIndication {
+	case models.RequestIndication_UE_REQ_PDU_SES_REL:
+		// Only send N2 PDU Session Resource Release when UP connection is active
+		if smContext.UpCnxState == models.UpCnxState_ACTIVATED {
+			if buf, errBuild := smf_context.
+				BuildPDUSessionResourceReleaseCommandTransfer(smContext); errBuild != nil {
+				smContext.Log.Errorf("Build PDUSessionResourceReleaseCommandTransfer failed: %+v", errBuild)
+			} else {
+				response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_REL_CMD
+				response.BinaryDataN2SmInformation = buf
+				response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDUResourceReleaseCommand"}
+			}
+		}
+
+		if pfcpResponseStatus := releaseSession(smContext); pfcpResponseStatus != smf_context.SessionReleaseSuccess {
+			smContext.Log.Warnf("Release PFCP sessions of AN UPF failed: %s", pfcpResponseStatus)
+		}
+		smContext.SetState(smf_context.InActivePending)
+	case models.RequestIndication_UE_REQ_PDU_SES_MOD:
+		// The session AMBR and the QoS flows are provided if the peer SMF accepted the modification
+		if rsp.JsonData != nil && (rsp.JsonData.SessionAmbr != nil || len(rsp.JsonData.QosFlowsSetupList) > 0) {
+			smContext.ApplyHsmfUpdatedData(rsp.JsonData)
+			if buf, errBuild := smf_context.BuildPDUSessionResourceModifyRequestTransfer(smContext); errBuild != nil {
+				smContext.Log.Errorf("build N2 BuildPDUSessionResourceModifyRequestTransfer failed: %v", errBuild)
+			} else {
+				response.BinaryDataN2SmInformation = buf
+				response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDU_RES_MOD"}
+				response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_MOD_REQ
+			}
+		}
+	case models.RequestIndication_NW_REQ_PDU_SES_REL:
+		// The PDU session resource has been released by the peer SMF
+		smContext.PeerPduSessionUri = ""
+		smContext.SetState(smf_context.InActive)
+		smContext.UpCnxState = models.UpCnxState_DEACTIVATED
+		response.JsonData.UpCnxState = models.UpCnxState_DEACTIVATED
+
+		// If CN tunnel resource is released, should
+		if smContext.Tunnel.ANInformation.IPAddress == nil {
+			p.RemoveSMContextFromAllNF(smContext, true)
+		}
+	}
+
+	c.Render(http.StatusOK, openapi.MultipartRelatedRender{Data: response})
+}
+
+// HandlePDUSessionCreate creates the PDU session requested by the V-SMF or the I-SMF (TS 29.502 5.2.2.7.1).
+// The H-SMF or the anchor SMF performs the session management of the PDU session and sets up the H-UPF
+// or the PSA toward the V-UPF or the I-UPF.
+func (p *Processor) HandlePDUSessionCreate(
+	c *gin.Context,
+	request models.PostPduSessionsRequest,
+) {
+	logger.PduSessLog.Infoln("In HandlePDUSessionCreate")
+
+	createData := request.JsonData
+	smfRole := smf_context.SmfRoleHSmf
+	peerPduSessionUri, peerTunnelInfo := createData.VsmfPduSessionUri, createData.VcnTunnelInfo
+	if createData.IsmfPduSessionUri != "" {
+		smfRole = smf_context.SmfRoleAnchorSmf
+		peerPduSessionUri, peerTunnelInfo = createData.IsmfPduSessionUri, createData.IcnTunnelInfo
+	}
+
+	// I-SMF insertion of the existing PDU session without the N1 SM message
+	if smfRole == smf_context.SmfRoleAnchorSmf && len(request.BinaryDataN1SmInfoFromUe) == 0 {
+		p.insertISmf(c, createData)
+		return
+	}
+
+	// Check has PDU Session Establishment Request
+	m := nas.NewMessage()
+	if err := m.GsmMessageDecode(&request.BinaryDataN1SmInfoFromUe); err != nil ||
+		m.GsmHeader.GetMessageType() != nas.MsgTypePDUSessionEstablishmentRequest {
+		logger.PduSessLog.Warnln("GsmMessageDecode Error: ", err)
+		postPduSessionsError := models.PostPduSessionsResponse400{
+			JsonData: &models.PduSessionCreateError{
+				Error: toProblemDetails(&smf_errors.N1SmError),
+			},
+		}
+		c.JSON(http.StatusForbidden, postPduSessionsError)
+		return
+	}
+
+	peerIP, peerTEID, err := smf_context.ParseTunnelInfo(peerTunnelInfo)
+	if err != nil {
+		problemDetail := "[Request Body] cnTunnelInfo: " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	smCreateData := smf_context.NewPeerSMContextCreateData(createData)
+	// Check duplicate SM Context
+	if dupSmContext := smf_context.GetSMContextById(createData.Supi, createData.PduSessionId); dupSmContext != nil {
+		p.HandlePDUSessionSMContextLocalRelease(dupSmContext, smCreateData)
+	}
+
+	smContext := smf_context.NewSMContext(createData.Supi, createData.PduSessionId)
+	smContext.SetState(smf_context.ActivePending)
+	smContext.SmfPduSessionSmContextCreateData = smCreateData
+	smContext.SmfRole = smfRole
+	smContext.PeerPduSessionUri = peerPduSessionUri
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	// DNN Information from config
+	smContext.DNNInfo = smf_context.RetrieveDnnInformation(smContext.SNssai, smContext.Dnn)
+	if smContext.DNNInfo == nil {
+		logger.PduSessLog.Errorf("S-NSSAI[sst: %d, sd: %s] DNN[%s] not matched DNN Config",
+			smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)
+	}
+	smContext.Log.Debugf("S-NSSAI[sst: %d, sd: %s] DNN[%s]",
+		smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)
+
+	// Query UDM
+	if problemDetails, errDiscovery := p.Consumer().SendNFDiscoveryUDM(); errDiscovery != nil {
+		smContext.Log.Warnf("Send NF Discovery Serving UDM Error[%v]", errDiscovery)
+	} else if problemDetails != nil {
+		smContext.Log.Warnf("Send NF Discovery Serving UDM Problem[%+v]", problemDetails)
+	} else {
+		smContext.Log.Infoln("Send NF Discovery Serving UDM Successfully")
+	}
+
+	if dnnConfig, errSmData := p.getSubscribedDnnConfiguration(smContext); errSmData != nil {
+		smContext.Log.Errorln("Get SessionManagementSubscriptionData error:", errSmData)
+	} else if dnnConfig == nil {
+		smContext.Log.Errorln("SessionManagementSubscriptionData from UDM is nil")
+	} else {
+		smContext.DnnConfiguration = *dnnConfig
+		// UP Security info present in session management subscription data
+		if dnnConfig.UpSecurity != nil {
+			smContext.UpSecurity = dnnConfig.UpSecurity
+		}
+	}
+
+	// The H-UPF or the PSA sends the DL traffic of the PDU session to the V-UPF or the I-UPF
+	smContext.Tunnel.UpdateANInformation(peerIP, peerTEID)
+
+	if estErr := p.establishPDUSession(smContext, m.PDUSessionEstablishmentRequest); estErr != nil {
+		p.makeHSmfEstRejectResAndReleaseSMContext(c, smContext, estErr.gsmCause, estErr.sbiError)
+		return
+	}
+
+	var upSetupErr error
+	ActivateUPFSession(smContext, func(_ *smf_context.SMContext, pfcpErr error) {
+		upSetupErr = pfcpErr
+	})
+
+	createdData, err := smContext.BuildPduSessionCreatedData()
+	if err == nil && upSetupErr != nil {
+		err = fmt.Errorf("setup PFCP sessions of anchor UPF failed: %w", upSetupErr)
+	}
+	var n1SmMsg []byte
+	if err == nil {
+		n1SmMsg, err = smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext)
+	}
+	if err != nil {
+		smContext.Log.Errorf("PDUSessionCreate err: %v", err)
+		releaseSession(smContext)
+		p.makeHSmfEstRejectResAndReleaseSMContext(c, smContext,
+			nasMessage.Cause5GSMNetworkFailure, &smf_errors.NetworkFailure)
+		return
+	}
+
+	smContext.SetState(smf_context.Active)
+	smContext.Checkpoint()
+	metrics.CountPduSessionProcedure(metrics.ProcedureEstablishment, true, 0)
+
+	smPlmnID := createData.ServingNetwork
+	if createData.Guami != nil && createData.Guami.PlmnId != nil {
+		smPlmnID = createData.Guami.PlmnId
+	}
+	if ctx, _, errToken := smf_context.GetSelf().
+		GetTokenCtx(models.ServiceName_NUDM_SDM, models.NrfNfManagementNfType_UDM); errToken != nil {
+		smContext.Log.Errorf("Get Token Context Error[%v]", errToken)
+	} else if smPlmnID != nil {
+		p.subscribeSmDataChange(ctx, smContext, smPlmnID)
+	}
+
+	response := models.PostPduSessionsResponse201{
+		JsonData:               createdData,
+		BinaryDataN1SmInfoToUe: n1SmMsg,
+	}
+	c.Header("Location", resourceLocation(c, smContext.Ref))
+	c.JSON(http.StatusCreated, response)
+
+	// The V-SMF or the I-SMF transfers the PDU Session Establishment Accept to the UE
+	notifyPduSessionEstablishment(smContext)
+}
+
+// HandlePDUSessionUpdate updates the PDU session with the N1 SM message of the UE relayed by the V-SMF
+// or the I-SMF, or with the DL tunnel endpoint of the V-UPF or the I-UPF (TS 29.502 5.2.2.8.2)
+func (p *Processor) HandlePDUSessionUpdate(
+	c *gin.Context,
+	request models.UpdatePduSessionRequest,
+	pduSessionRef string,
+) {
+	logger.PduSessLog.Infoln("In HandlePDUSessionUpdate")
+	smContext := smf_context.GetSMContextByPduSessionRef(pduSessionRef)
+	if smContext == nil || !smContext.ServesPeerSmf() {
+		logger.PduSessLog.Warnf("PDU session[%s] is not found", pduSessionRef)
+		c.JSON(http.StatusNotFound, pduSessionNotFound())
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
+
+	if updateData := request.JsonData; updateData != nil &&
+		(updateData.VcnTunnelInfo != nil || updateData.IcnTunnelInfo != nil) {
+		peerTunnelInfo := updateData.VcnTunnelInfo
+		if smContext.IsAnchorSmf() {
+			peerTunnelInfo = updateData.IcnTunnelInfo
+		}
+		peerIP, peerTEID, err := smf_context.ParseTunnelInfo(peerTunnelInfo)
+		if err != nil {
+			problemDetail := "[Request Body] cnTunnelInfo: " + err.Error()
+			smContext.Log.Errorln(problemDetail)
+			c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+			return
+		}
+		if err = p.updateAccessTunnel(smContext, peerIP, peerTEID); err != nil {
+			smContext.Log.Errorf("Update DL tunnel of the PDU session failed: %v", err)
+			c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+			return
+		}
+	}
+	if len(request.BinaryDataN1SmInfoFromUe) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	m := nas.NewMessage()
+	if err := m.GsmMessageDecode(&request.BinaryDataN1SmInfoFromUe); err != nil {
+		smContext.Log.Errorf("N1 Message parse failed: %v", err)
+		updatePduSessionError := models.UpdatePduSessionResponse400{
+			JsonData: &models.HsmfUpdateError{
+				Error: toProblemDetails(&smf_errors.N1SmError),
+			},
+		}
+		c.JSON(http.StatusForbidden, updatePduSessionError)
+		return
+	}
+
+	var response models.UpdatePduSessionResponse200
+	switch m.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionReleaseRequest:
+		HandlePDUSessionReleaseRequest(smContext, m.PDUSessionReleaseRequest)
+
+		cause := nasMessage.Cause5GSMRegularDeactivation
+		if m.PDUSessionReleaseRequest.Cause5GSM != nil {
+			cause = m.PDUSessionReleaseRequest.Cause5GSM.GetCauseValue()
+		}
+
+		if releaseSession(smContext) != smf_context.SessionReleaseSuccess {
+			smContext.SetState(smf_context.Active)
+			metrics.CountPduSessionProcedure(metrics.ProcedureRelease, false,
+				nasMessage.Cause5GSMRequestRejectedUnspecified)
+			updatePduSessionError := models.UpdatePduSessionResponse400{
+				JsonData: &models.HsmfUpdateError{
+					Error: &models.ProblemDetails{
+						Status: http.StatusInternalServerError,
+						Cause:  "SYSTEM_FAILURE",
+					},
+					Pti: int32(smContext.Pti),
+				},
+			}
+			if buf, err := smf_context.BuildGSMPDUSessionReleaseReject(smContext); err != nil {
+				smContext.Log.Errorf("build GSM PDUSessionReleaseReject failed: %+v", err)
+			} else {
+				updatePduSessionError.BinaryDataN1SmInfoToUe = buf
+				updatePduSessionError.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+			}
+			c.JSON(http.StatusInternalServerError, updatePduSessionError)
+			return
+		}
+		p.ReleaseChargingSession(smContext)
+		smContext.SetState(smf_context.InActivePending)
+
+		if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, true); err != nil {
+			smContext.Log.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
+		} else {
+			response.BinaryDataN1SmInfoToUe = buf
+		}
+	case nas.MsgTypePDUSessionReleaseComplete:
+		smContext.StopT3592()
+		metrics.CountPduSessionProcedure(metrics.ProcedureRelease, true, 0)
+		p.RemoveSMContextFromAllNF(smContext, false)
+		c.Status(http.StatusNoContent)
+		return
+	case nas.MsgTypePDUSessionModificationRequest:
+		rsp, errHandleReq := p.HandlePDUSessionModificationRequest(smContext, m.PDUSessionModificationRequest)
+		if errHandleReq != nil {
+			smContext.Log.Errorf("PDU Session Modification fail by %s", errHandleReq)
+			metrics.CountPduSessionProcedure(metrics.ProcedureModification, false,
+				nasMessage.Cause5GSMMessageTypeNonExistentOrNotImplemented)
+			updatePduSessionError := models.UpdatePduSessionResponse400{
+				JsonData: &models.HsmfUpdateError{
+					Error: toProblemDetails(&smf_errors.N1SmError),
+					Pti:   int32(smContext.Pti),
+				},
+			}
+			if buf, err := smf_context.BuildGSMPDUSessionModificationReject(smContext); err != nil {
+				smContext.Log.Errorf("build GSM PDUSessionModificationReject failed: %+v", err)
+			} else {
+				updatePduSessionError.BinaryDataN1SmInfoToUe = buf
+				updatePduSessionError.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+			}
+			c.JSON(http.StatusForbidden, updatePduSessionError)
+			return
+		}
+
+		buf, err := rsp.PlainNasEncode()
+		if err != nil {
+			smContext.Log.Errorf("build GSM PDUSessionModificationCommand failed: %+v", err)
+			c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+			return
+		}
+		response.JsonData = smContext.BuildHsmfUpdatedData()
+		response.BinaryDataN1SmInfoToUe = buf
+	case nas.MsgTypePDUSessionModificationComplete:
+		smContext.StopT3591()
+		metrics.CountPduSessionProcedure(metrics.ProcedureModification, true, 0)
+		c.Status(http.StatusNoContent)
+		return
+	case nas.MsgTypePDUSessionModificationReject:
+		smContext.StopT3591()
+		metrics.CountPduSessionProcedure(metrics.ProcedureModification, false,
+			m.PDUSessionModificationReject.GetCauseValue())
+		c.Status(http.StatusNoContent)
+		return
+	default:
+		smContext.Log.Warnf("N1 Message[%d] is not supported in the PDU session update",
+			m.GsmHeader.GetMessageType())
+		updatePduSessionError := models.UpdatePduSessionResponse400{
+			JsonData: &models.HsmfUpdateError{
+				Error: toProblemDetails(&smf_errors.N1SmError),
+			},
+		}
+		c.JSON(http.StatusForbidden, updatePduSessionError)
+		return
+	}
+
+	if response.BinaryDataN1SmInfoToUe == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if response.JsonData == nil {
+		response.JsonData = &models.HsmfUpdatedData{
+			Pti: int32(smContext.Pti),
+		}
+	}
+	response.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+	c.JSON(http.StatusOK, response)
+}
+
+// HandlePDUSessionRelease releases the PDU session requested by the V-SMF or the I-SMF (TS 29.502 5.2.2.9)
+func (p *Processor) HandlePDUSessionRelease(
+	c *gin.Context,
+	request models.ReleasePduSessionRequest,
+	pduSessionRef string,
+) {
+	logger.PduSessLog.Infoln("In HandlePDUSessionRelease")
+	smContext := smf_context.GetSMContextByPduSessionRef(pduSessionRef)
+	if smContext == nil || !smContext.ServesPeerSmf() {
+		logger.PduSessLog.Warnf("PDU session[%s] is not found", pduSessionRef)
+		c.JSON(http.StatusNotFound, pduSessionNotFound())
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
+
+	if request.JsonData != nil && request.JsonData.Cause != "" {
+		smContext.Log.Infof("Release PDU session due to %s", request.JsonData.Cause)
+	}
+
+	smContext.StopT3591()
+	smContext.StopT3592()
+
+	if pfcpResponseStatus := releaseSession(smContext); pfcpResponseStatus == smf_context.SessionReleaseSuccess {
+		p.ReleaseChargingSession(smContext)
+	} else {
+		smContext.Log.Warnf("Release PFCP sessions of anchor UPF failed: %s", pfcpResponseStatus)
+	}
+
+	// The peer SMF has released the PDU session and is not notified
+	smContext.PeerPduSessionUri = ""
+	p.RemoveSMContextFromAllNF(smContext, false)
+	c.Status(http.StatusNoContent)
+}
+
+// HandlePDUSessionRetrieve returns the information of the PDU session requested by the V-SMF or the I-SMF
+// (TS 29.502 5.2.2.10)
+func (p *Processor) HandlePDUSessionRetrieve(
+	c *gin.Context,
+	request models.RetrieveData,
+	pduSessionRef string,
+) {
+	logger.PduSessLog.Infoln("In HandlePDUSessionRetrieve")
+	smContext := smf_context.GetSMContextByPduSessionRef(pduSessionRef)
+	if smContext == nil || !smContext.ServesPeerSmf() {
+		logger.PduSessLog.Warnf("PDU session[%s] is not found", pduSessionRef)
+		c.JSON(http.StatusNotFound, pduSessionNotFound())
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	// NOTE: The small data rate control isn't supported, so there is no small data rate status to retrieve.
+	response := models.RetrievedData{}
+	if request.PduSessionContextType == models.PduSessionContextType_AF_COORDINATION_INFO {
+		response.AfCoordinationInfo = smContext.BuildAfCoordinationInfo()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleISmfInsertion inserts the I-SMF into the existing PDU session of the anchor SMF when the UE moves
+// outside the service area of the anchor SMF (TS 23.502 4.23.3, 4.23.4). The I-SMF selects the I-UPF with the
+// user plane information and sets up the N9 tunnel toward the PSA of the anchor SMF.
+func (p *Processor) handleISmfInsertion(
+	c *gin.Context,
+	createData *models.SmfPduSessionSmContextCreateData,
+) {
+	// Check duplicate SM Context
+	if dupSmContext := smf_context.GetSMContextById(createData.Supi, createData.PduSessionId); dupSmContext != nil {
+		p.HandlePDUSessionSMContextLocalRelease(dupSmContext, createData)
+	}
+
+	smContext := smf_context.NewSMContext(createData.Supi, createData.PduSessionId)
+	smContext.SetState(smf_context.ActivePending)
+	smContext.SmfPduSessionSmContextCreateData = createData
+	smContext.SmStatusNotifyUri = createData.SmContextStatusUri
+	smContext.SmfRole = smf_context.SmfRoleISmf
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	smContext.DNNInfo = smf_context.RetrieveDnnInformation(smContext.SNssai, smContext.Dnn)
+	p.discoverServingAMF(smContext)
+
+	// The PDU session type is provided by the anchor SMF, the I-UPF is selected for IPv4 in the meantime
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	smContext.SelectionParam = &smf_context.UPFSelectionParams{
+		Dnn: smContext.Dnn,
+		SNssai: &smf_context.SNssai{
+			Sst: smContext.SNssai.Sst,
+			Sd:  smContext.SNssai.Sd,
+		},
+		PDUSessionType: smContext.SelectedPDUSessionType,
+	}
+	smContext.SelectedUPF = upi.SelectUPF(smContext.SelectionParam)
+	if smContext.SelectedUPF == nil {
+		smContext.Log.Errorf("I-SMF insertion err: no I-UPF for %s", smContext.SelectionParam)
+		p.makeISmfChangeErrResAndReleaseSMContext(c, smContext, &smf_errors.InsufficientResourceSliceDnn)
+		return
+	}
+
+	dlTunnelInfo, err := smContext.BuildDLN9TunnelInfo()
+	if err != nil {
+		smContext.Log.Errorf("I-SMF insertion err: %v", err)
+		p.makeISmfChangeErrResAndReleaseSMContext(c, smContext, &smf_errors.InsufficientResourceSliceDnn)
+		return
+	}
+
+	request := models.PostPduSessionsRequest{
+		JsonData: smContext.BuildPduSessionCreateData(dlTunnelInfo),
+	}
+	request.JsonData.N1SmInfoFromUe = nil
+	request.JsonData.UpCnxState = createData.UpCnxState
+	rsp, _, err := p.Consumer().SendCreatePduSession(smContext, request)
+	if err != nil {
+		smContext.Log.Errorf("Insert I-SMF into the PDU session of anchor SMF failed: %v", err)
+		p.makeISmfChangeErrResAndReleaseSMContext(c, smContext, &smf_errors.NetworkFailure)
+		return
+	}
+
+	if err = smContext.ApplyPduSessionCreatedData(rsp.JsonData); err != nil {
+		smContext.Log.Errorf("I-SMF insertion err: %v", err)
+		p.makeISmfChangeErrResAndReleaseSMContext(c, smContext, &smf_errors.NetworkFailure)
+		return
+	}
+
+	if err = smContext.SelectDefaultDataPath(); err != nil {
+		smContext.Log.Errorf("I-SMF insertion err: %v", err)
+		p.makeISmfChangeErrResAndReleaseSMContext(c, smContext, &smf_errors.InsufficientResourceSliceDnn)
+		return
+	}
+
+	var upSetupErr error
+	ActivateUPFSession(smContext, func(_ *smf_context.SMContext, pfcpErr error) {
+		upSetupErr = pfcpErr
+	})
+	if upSetupErr != nil {
+		smContext.Log.Errorf("I-SMF insertion err: setup PFCP sessions of I-UPF failed: %+v", upSetupErr)
+		releaseSession(smContext)
+		p.makeISmfChangeErrResAndReleaseSMContext(c, smContext, &smf_errors.NetworkFailure)
+		return
+	}
+	smContext.SetState(smf_context.Active)
+	smContext.Checkpoint()
+
+	p.replyTakenOverSMContext(c, smContext, createData.UpCnxState)
+}
+
+// insertISmf inserts the I-SMF into the existing PDU session of the anchor SMF (TS 23.502 4.23.3, 4.23.4).
+// The PSA sends the DL traffic to the I-UPF and receives the UL traffic from the I-UPF over N9.
+func (p *Processor) insertISmf(
+	c *gin.Context,
+	createData *models.PduSessionCreateData,
+) {
+	smContext := smf_context.GetSMContextById(createData.Supi, createData.PduSessionId)
+	if smContext == nil || smContext.SmfRole != smf_context.SmfRoleNone {
+		logger.PduSessLog.Warnf("PDU session[%s:%d] is not found", createData.Supi, createData.PduSessionId)
+		c.JSON(http.StatusNotFound, pduSessionNotFound())
+		return
+	}
+
+	iIP, iTEID, err := smf_context.ParseTunnelInfo(createData.IcnTunnelInfo)
+	if err != nil {
+		problemDetail := "[Request Body] icnTunnelInfo: " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, openapi.ProblemDetailsMalformedReqSyntax(problemDetail))
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	smContext.SmfRole = smf_context.SmfRoleAnchorSmf
+	smContext.PeerPduSessionUri = createData.IsmfPduSessionUri
+
+	// The session management is performed for the I-SMF, whose PDU session is released with the
+	// Nsmf_PDUSession_Release if the I-UPF can't be set up
+	if err = p.updateAccessTunnel(smContext, iIP, iTEID); err != nil {
+		smContext.Log.Errorf("I-SMF insertion err: %v", err)
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+
+	createdData, err := smContext.BuildPduSessionCreatedData()
+	if err != nil {
+		smContext.Log.Errorf("I-SMF insertion err: %v", err)
+		c.JSON(http.StatusInternalServerError, openapi.ProblemDetailsSystemFailure(err.Error()))
+		return
+	}
+	createdData.N1SmInfoToUe = nil
+
+	c.Header("Location", resourceLocation(c, smContext.Ref))
+	c.JSON(http.StatusCreated, models.PostPduSessionsResponse201{JsonData: createdData})
+}
+
+// handleISmfRemoval removes the I-SMF from the PDU session when the AMF selects the anchor SMF to serve the UE
+// again (TS 23.502 4.23.3, 4.23.4). The anchor SMF takes over the SM context of the I-SMF, and the PSA is
+// reached from the AN over N3. The PDU session of the I-SMF is released by the AMF.
+func (p *Processor) handleISmfRemoval(
+	c *gin.Context,
+	createData *models.SmfPduSessionSmContextCreateData,
+) {
+	smContext := smf_context.GetSMContextByPduSessionRef(path.Base(createData.SmContextRef))
+	if smContext == nil || !smContext.IsAnchorSmf() {
+		logger.PduSessLog.Warnf("SM context[%s] is not found", createData.SmContextRef)
+		c.JSON(http.StatusNotFound, models.PostSmContextsError{
+			JsonData: &models.SmContextCreateError{
+				Error: &models.SmfPduSessionExtProblemDetails{
+					Title:  "SM Context Ref is not found",
+					Status: http.StatusNotFound,
+					Cause:  CONTEXT_NOT_FOUND,
+				},
+			},
+		})
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	smContext.SetTraceContext(c.Request.Context())
+
+	smContext.SmfRole = smf_context.SmfRoleNone
+	smContext.PeerPduSessionUri = ""
+	smContext.ServingNfId = createData.ServingNfId
+	smContext.Guami = createData.Guami
+	smContext.ServingNetwork = createData.ServingNetwork
+	smContext.AnType = createData.AnType
+	smContext.RatType = createData.RatType
+	smContext.UeLocation = createData.UeLocation
+	smContext.UeTimeZone = createData.UeTimeZone
+	smContext.SmStatusNotifyUri = createData.SmContextStatusUri
+	p.discoverServingAMF(smContext)
+
+	// The DL traffic is buffered until the AN tunnel endpoint is provided in the N2 PDU session resource setup
+	if err := p.updateAccessTunnel(smContext, nil, 0); err != nil {
+		smContext.Log.Errorf("I-SMF removal err: %v", err)
+		c.JSON(int(smf_errors.NetworkFailure.Status), models.PostSmContextsError{
+			JsonData: &models.SmContextCreateError{
+				Error: &smf_errors.NetworkFailure,
+			},
+		})
+		return
+	}
+
+	p.replyTakenOverSMContext(c, smContext, createData.UpCnxState)
+}
+
+// updateAccessTunnel updates the AN UPF for the I-SMF insertion or removal. The UL tunnel endpoint is moved
+// to the access interface of the SMF role, and the DL traffic is sent to the tunnel endpoint of the I-UPF
+// or buffered if the tunnel endpoint of the AN is not known yet.
+func (p *Processor) updateAccessTunnel(smContext *smf_context.SMContext, anIP net.IP, anTEID uint32) error {
+	pdrList, err := smContext.SwitchAccessTunnel()
+	if err != nil {
+		return err
+	}
+	if anIP != nil {
+		smContext.Tunnel.UpdateANInformation(anIP, anTEID)
+	}
+
+	farList := []*smf_context.FAR{}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated || dataPath.FirstDPNode.DownLinkTunnel == nil {
+			continue
+		}
+		DLPDR := dataPath.FirstDPNode.DownLinkTunnel.PDR
+		if DLPDR == nil {
+			continue
+		}
+		if anIP != nil {
+			DLPDR.FAR.ApplyAction = pfcpType.ApplyAction{Forw: true}
+		} else {
+			DLPDR.FAR.ApplyAction = pfcpType.ApplyAction{Buff: true, Nocp: true}
+		}
+		DLPDR.FAR.State = smf_context.RULE_UPDATE
+		farList = append(farList, DLPDR.FAR)
+	}
+
+	if p.updateAnUpfPfcpSession(smContext, pdrList, farList, nil, nil, nil) != smf_context.SessionUpdateSuccess {
+		return fmt.Errorf("update PFCP session of AN UPF failed")
+	}
+	return nil
+}
+
+// replyTakenOverSMContext replies the SM context of the existing PDU session to the AMF, with the N2 PDU
+// session resource setup request if the UP connection of the PDU session is to be activated
+func (p *Processor) replyTakenOverSMContext(
+	c *gin.Context,
+	smContext *smf_context.SMContext,
+	upCnxState models.UpCnxState,
+) {
+	response := models.PostSmContextsResponse201{
+		JsonData: smContext.BuildCreatedData(),
+	}
+	c.Header("Location", resourceLocation(c, smContext.Ref))
+	if upCnxState != models.UpCnxState_ACTIVATING {
+		c.JSON(http.StatusCreated, response)
+		return
+	}
+
+	n2Buf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext)
+	if err != nil {
+		smContext.Log.Errorf("Build PDUSession Resource Setup Request Transfer Error(%s)", err.Error())
+		c.JSON(http.StatusCreated, response)
+		return
+	}
+	smContext.UpCnxState = models.UpCnxState_ACTIVATING
+	response.JsonData.UpCnxState = models.UpCnxState_ACTIVATING
+	response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_SETUP_REQ
+	response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDUSessionResourceSetupRequestTransfer"}
+	response.BinaryDataN2SmInformation = n2Buf
+	c.Render(http.StatusCreated, openapi.MultipartRelatedRender{Data: response})
+}
+
+func (p *Processor) makeISmfChangeErrResAndReleaseSMContext(
+	c *gin.Context,
+	smContext *smf_context.SMContext,
+	sbiError *models.SmfPduSessionExtProblemDetails,
+) {
+	c.JSON(int(sbiError.Status), models.PostSmContextsError{
+		JsonData: &models.SmContextCreateError{
+			Error: sbiError,
+		},
+	})
+	p.RemoveSMContextFromAllNF(smContext, false)
+}
+
+func (p *Processor) makeHSmfEstRejectResAndReleaseSMContext(
+	c *gin.Context,
+	smContext *smf_context.SMContext,
+	nasErrorCause uint8,
+	sbiError *models.SmfPduSessionExtProblemDetails,
+) {
+	postPduSessionsError := models.PostPduSessionsResponse400{
+		JsonData: &models.PduSessionCreateError{
+			Error:        toProblemDetails(sbiError),
+			N1smCause:    fmt.Sprintf("%02X", nasErrorCause),
+			N1SmInfoToUe: &models.RefToBinaryData{ContentId: "n1SmInfoToUe"},
+		},
+	}
+	if buf, err := smf_context.
+		BuildGSMPDUSessionEstablishmentReject(
+			smContext,
+			nasErrorCause); err != nil {
+		logger.PduSessLog.Errorf("Build GSM PDUSessionEstablishmentReject failed: %+v", err)
+		postPduSessionsError.JsonData.N1SmInfoToUe = nil
+	} else {
+		postPduSessionsError.BinaryDataN1SmInfoToUe = buf
+	}
+	metrics.CountPduSessionProcedure(metrics.ProcedureEstablishment, false, nasErrorCause)
+	c.JSON(int(sbiError.Status), postPduSessionsError)
+
+	// The peer SMF releases its own resources of the rejected PDU session
+	smContext.PeerPduSessionUri = ""
+	p.RemoveSMContextFromAllNF(smContext, false)
+}
+
+func pduSessionNotFound() *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Title:  "PDU Session Ref is not found",
+		Status: http.StatusNotFound,
+		Cause:  CONTEXT_NOT_FOUND,
+	}
+}
+
+func toProblemDetails(sbiError *models.SmfPduSessionExtProblemDetails) *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Type:          sbiError.Type,
+		Title:         sbiError.Title,
+		Status:        sbiError.Status,
+		Detail:        sbiError.Detail,
+		Instance:      sbiError.Instance,
+		Cause:         sbiError.Cause,
+		InvalidParams: sbiError.InvalidParams,
+	}
+}
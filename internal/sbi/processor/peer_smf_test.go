@@ -0,0 +1,370 @@
+package processor_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/free5gc/nas/nasMessage"
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	"github.com/free5gc/pfcp/pfcpUdp"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/pfcp/pfcptest"
+	"github.com/free5gc/smf/internal/pfcp/udp"
+	"github.com/free5gc/smf/internal/sbi/consumer"
+	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/pkg/factory"
+	"github.com/free5gc/smf/pkg/service"
+)
+
+func TestHandlePDUSessionRetrieve(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	smContext := smf_context.NewSMContext("imsi-208930000000503", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000503",
+		PduSessionId: 10,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	retrieve := func(ref string) int {
+		httpRecorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(httpRecorder)
+		processor.HandlePDUSessionRetrieve(c, models.RetrieveData{
+			PduSessionContextType: models.PduSessionContextType_AF_COORDINATION_INFO,
+		}, ref)
+		return c.Writer.Status()
+	}
+
+	// The PDU session resource is only provided by the H-SMF
+	require.Equal(t, http.StatusNotFound, retrieve(smContext.PduSessionRef()))
+	smContext.SmfRole = smf_context.SmfRoleHSmf
+	require.Equal(t, http.StatusOK, retrieve(smContext.PduSessionRef()))
+	require.Equal(t, http.StatusNotFound, retrieve("00000000-0000-0000-0000-000000000000"))
+}
+
+func TestHandlePDUSessionUpdateAndRelease_NotFound(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	pduSessionRef := "00000000-0000-0000-0000-000000000000"
+
+	httpRecorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(httpRecorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/pdu-sessions/"+pduSessionRef+"/modify", nil)
+	processor.HandlePDUSessionUpdate(c, models.UpdatePduSessionRequest{
+		JsonData: &models.HsmfUpdateData{
+			RequestIndication: models.RequestIndication_UE_REQ_PDU_SES_MOD,
+		},
+	}, pduSessionRef)
+	require.Equal(t, http.StatusNotFound, c.Writer.Status())
+
+	httpRecorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(httpRecorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/pdu-sessions/"+pduSessionRef+"/release", nil)
+	processor.HandlePDUSessionRelease(c, models.ReleasePduSessionRequest{}, pduSessionRef)
+	require.Equal(t, http.StatusNotFound, c.Writer.Status())
+}
+
+func TestHandlePDUSessionCreate_InvalidN1SmMessage(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	httpRecorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(httpRecorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/pdu-sessions", nil)
+	processor.HandlePDUSessionCreate(c, models.PostPduSessionsRequest{
+		JsonData: &models.PduSessionCreateData{
+			Supi:         "imsi-208930000000504",
+			PduSessionId: 10,
+		},
+	})
+	require.Equal(t, http.StatusForbidden, c.Writer.Status())
+	require.Nil(t, smf_context.GetSMContextById("imsi-208930000000504", 10))
+}
+
+func TestHandleISmfInsertionAndRemoval_NotFound(t *testing.T) {
+	initConfig()
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+
+	// The I-SMF is inserted into the PDU session which doesn't exist in the anchor SMF
+	httpRecorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(httpRecorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/pdu-sessions", nil)
+	processor.HandlePDUSessionCreate(c, models.PostPduSessionsRequest{
+		JsonData: &models.PduSessionCreateData{
+			Supi:              "imsi-208930000000504",
+			PduSessionId:      10,
+			IsmfPduSessionUri: "https://i-smf.example.com/nsmf-pdusession/v1/pdu-sessions/1",
+		},
+	})
+	require.Equal(t, http.StatusNotFound, c.Writer.Status())
+
+	// The I-SMF is removed from the PDU session which has no I-SMF inserted
+	smContext := smf_context.NewSMContext("imsi-208930000000504", 10)
+	smContext.SmfPduSessionSmContextCreateData = &models.SmfPduSessionSmContextCreateData{
+		Supi:         "imsi-208930000000504",
+		PduSessionId: 10,
+	}
+	defer smf_context.RemoveSMContext(smContext.Ref)
+
+	httpRecorder = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(httpRecorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/sm-contexts", nil)
+	processor.HandlePDUSessionSMContextCreate(c, models.PostSmContextsRequest{
+		JsonData: &models.SmfPduSessionSmContextCreateData{
+			Supi:         "imsi-208930000000504",
+			PduSessionId: 10,
+			SmContextRef: smContext.Ref,
+		},
+	}, nil)
+	require.Equal(t, http.StatusNotFound, c.Writer.Status())
+}
+
+func TestHandlePDUSessionCreateUpdateRelease(t *testing.T) {
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+
+	// The H-UPF or the PSA is the fake UPF, the V-UPF or the I-UPF is the AN node of the user plane
+	const hUpfIP = "127.0.0.9"
+	upNodes := make(map[string]*factory.UPNode)
+	for name, node := range userPlaneConfig.UPNodes {
+		n := *node
+		if n.Type == "UPF" {
+			n.NodeID = hUpfIP
+			n.InterfaceUpfInfoList = []*factory.InterfaceUpfInfoItem{
+				{
+					InterfaceType:    "N9",
+					Endpoints:        []string{"10.200.200.1"},
+					NetworkInstances: []string{"internet"},
+				},
+			}
+		}
+		upNodes[name] = &n
+	}
+	configuration := *testConfig.Configuration
+	configuration.UserPlaneInformation = factory.UserPlaneInformation{
+		UPNodes: upNodes,
+		Links:   userPlaneConfig.Links,
+	}
+	config := &factory.Config{
+		Info:          testConfig.Info,
+		Configuration: &configuration,
+	}
+	smf_context.InitSmfContext(config)
+	factory.SmfConfig = config
+	// The UPFs of the PFCP sessions to be released are identified by the UPF IDs
+	smf_context.AllocateUPFID()
+	defer initConfig()
+
+	hUpf := pfcptest.NewUPF(t, &net.UDPAddr{IP: net.ParseIP(hUpfIP), Port: pfcpUdp.PFCP_PORT},
+		pfcptest.AcceptSessions(net.ParseIP(hUpfIP).To4()))
+	initStubPFCP()
+	defer func() {
+		require.NoError(t, udp.ClosePfcp())
+	}()
+	for _, upfNode := range smf_context.GetSelf().UserPlaneInformation.UPFs {
+		upfNode.UPF.AssociationContext = context.Background()
+	}
+
+	mockSmf := service.NewMockSmfAppInterface(gomock.NewController(t))
+	consumer, err := consumer.NewConsumer(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create consumer: %+v", err)
+	}
+	processor, err := processor.NewProcessor(mockSmf)
+	if err != nil {
+		t.Fatalf("Failed to create processor: %+v", err)
+	}
+	service.SMF = mockSmf
+	mockSmf.EXPECT().Context().Return(smf_context.GetSelf()).AnyTimes()
+	mockSmf.EXPECT().Consumer().Return(consumer).AnyTimes()
+
+	testCases := []struct {
+		name string
+		// setPeer sets the PDU session resource and the DL tunnel of the V-SMF or the I-SMF
+		setPeer func(createData *models.PduSessionCreateData, tunnelInfo *models.TunnelInfo)
+		// setPeerTunnel sets the DL tunnel of the V-UPF or the I-UPF to be updated
+		setPeerTunnel func(updateData *models.HsmfUpdateData, tunnelInfo *models.TunnelInfo)
+		// ulTunnelInfo returns the UL tunnel of the H-UPF or the PSA
+		ulTunnelInfo func(createdData *models.PduSessionCreatedData) *models.TunnelInfo
+	}{
+		{
+			name: "H-SMF",
+			setPeer: func(createData *models.PduSessionCreateData, tunnelInfo *models.TunnelInfo) {
+				createData.VsmfPduSessionUri = "http://127.0.0.20:8000/nsmf-pdusession/v1/pdu-sessions/1"
+				createData.VcnTunnelInfo = tunnelInfo
+			},
+			setPeerTunnel: func(updateData *models.HsmfUpdateData, tunnelInfo *models.TunnelInfo) {
+				updateData.VcnTunnelInfo = tunnelInfo
+			},
+			ulTunnelInfo: func(createdData *models.PduSessionCreatedData) *models.TunnelInfo {
+				return createdData.HcnTunnelInfo
+			},
+		},
+		{
+			name: "anchor SMF",
+			setPeer: func(createData *models.PduSessionCreateData, tunnelInfo *models.TunnelInfo) {
+				createData.IsmfPduSessionUri = "http://127.0.0.20:8000/nsmf-pdusession/v1/pdu-sessions/1"
+				createData.IcnTunnelInfo = tunnelInfo
+			},
+			setPeerTunnel: func(updateData *models.HsmfUpdateData, tunnelInfo *models.TunnelInfo) {
+				updateData.IcnTunnelInfo = tunnelInfo
+			},
+			ulTunnelInfo: func(createdData *models.PduSessionCreatedData) *models.TunnelInfo {
+				return createdData.CnTunnelInfo
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			initDiscUDMStubNRF()
+			initDiscPCFStubNRF()
+			initGetSMDataStubUDM()
+			initSMPoliciesPostStubPCF()
+
+			// The peer SMF creates the PDU session with the DL tunnel of the V-UPF or the I-UPF
+			createData := &models.PduSessionCreateData{
+				Supi:           "imsi-208930000007487",
+				PduSessionId:   10,
+				Dnn:            "internet",
+				SNssai:         &models.Snssai{Sst: 1, Sd: "112232"},
+				ServingNetwork: &models.PlmnIdNid{Mcc: "208", Mnc: "93"},
+			}
+			tc.setPeer(createData, &models.TunnelInfo{
+				Ipv4Addr: "10.200.200.101",
+				GtpTeid:  "00000011",
+			})
+			httpRecorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(httpRecorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/pdu-sessions", nil)
+			processor.HandlePDUSessionCreate(c, models.PostPduSessionsRequest{
+				JsonData:                 createData,
+				BinaryDataN1SmInfoFromUe: buildPDUSessionEstablishmentRequest(10, 3, nasMessage.PDUSessionTypeIPv4),
+			})
+			require.Equal(t, http.StatusCreated, c.Writer.Status())
+
+			var created models.PostPduSessionsResponse201
+			require.NoError(t, json.Unmarshal(httpRecorder.Body.Bytes(), &created))
+			require.NotNil(t, created.JsonData)
+			ulTunnelInfo := tc.ulTunnelInfo(created.JsonData)
+			require.NotNil(t, ulTunnelInfo)
+			require.Equal(t, "10.200.200.1", ulTunnelInfo.Ipv4Addr)
+			ulTEID, err := strconv.ParseUint(ulTunnelInfo.GtpTeid, 16, 32)
+			require.NoError(t, err)
+
+			smContext := smf_context.GetSMContextById("imsi-208930000007487", 10)
+			require.NotNil(t, smContext)
+			require.Equal(t, smf_context.Active, smContext.State())
+			pduSessionRef := smContext.PduSessionRef()
+
+			// The UPF receives the UL traffic on the N9 tunnel of the created data and sends the DL traffic
+			// on the N9 tunnel of the peer SMF
+			req := hUpf.Next(t, pfcp.PFCP_SESSION_ESTABLISHMENT_REQUEST)
+			estReq := req.Body.(pfcp.PFCPSessionEstablishmentRequest)
+			var ulPDR *pfcp.CreatePDR
+			for _, pdr := range estReq.CreatePDR {
+				if pdr.PDI.SourceInterface.InterfaceValue == pfcpType.SourceInterfaceAccess {
+					ulPDR = pdr
+				}
+			}
+			require.NotNil(t, ulPDR)
+			require.NotNil(t, ulPDR.PDI.LocalFTEID)
+			require.Equal(t, uint32(ulTEID), ulPDR.PDI.LocalFTEID.Teid)
+			require.True(t, ulPDR.PDI.LocalFTEID.Ipv4Address.Equal(net.ParseIP("10.200.200.1")))
+
+			var dlFAR *pfcp.CreateFAR
+			for _, far := range estReq.CreateFAR {
+				if far.ForwardingParameters != nil &&
+					far.ForwardingParameters.DestinationInterface.InterfaceValue == pfcpType.DestinationInterfaceAccess {
+					dlFAR = far
+				}
+			}
+			require.NotNil(t, dlFAR)
+			require.True(t, dlFAR.ApplyAction.Forw)
+			outerHeaderCreation := dlFAR.ForwardingParameters.OuterHeaderCreation
+			require.NotNil(t, outerHeaderCreation)
+			require.Equal(t, uint32(0x11), outerHeaderCreation.Teid)
+			require.True(t, outerHeaderCreation.Ipv4Address.Equal(net.ParseIP("10.200.200.101")))
+
+			// The peer SMF updates the DL tunnel of the V-UPF or the I-UPF
+			updateData := &models.HsmfUpdateData{
+				RequestIndication: models.RequestIndication_NW_REQ_PDU_SES_MOD,
+			}
+			tc.setPeerTunnel(updateData, &models.TunnelInfo{
+				Ipv4Addr: "10.200.200.102",
+				GtpTeid:  "00000012",
+			})
+			httpRecorder = httptest.NewRecorder()
+			c, _ = gin.CreateTestContext(httpRecorder)
+			c.Request = httptest.NewRequest(http.MethodPost,
+				"/nsmf-pdusession/v1/pdu-sessions/"+pduSessionRef+"/modify", nil)
+			processor.HandlePDUSessionUpdate(c, models.UpdatePduSessionRequest{
+				JsonData: updateData,
+			}, pduSessionRef)
+			require.Equal(t, http.StatusNoContent, c.Writer.Status())
+
+			req = hUpf.Next(t, pfcp.PFCP_SESSION_MODIFICATION_REQUEST)
+			modReq := req.Body.(pfcp.PFCPSessionModificationRequest)
+			require.Len(t, modReq.UpdateFAR, 1)
+			require.Equal(t, dlFAR.FARID.FarIdValue, modReq.UpdateFAR[0].FARID.FarIdValue)
+			require.True(t, modReq.UpdateFAR[0].ApplyAction.Forw)
+			require.NotNil(t, modReq.UpdateFAR[0].UpdateForwardingParameters)
+			outerHeaderCreation = modReq.UpdateFAR[0].UpdateForwardingParameters.OuterHeaderCreation
+			require.NotNil(t, outerHeaderCreation)
+			require.Equal(t, uint32(0x12), outerHeaderCreation.Teid)
+			require.True(t, outerHeaderCreation.Ipv4Address.Equal(net.ParseIP("10.200.200.102")))
+
+			// The peer SMF releases the PDU session
+			httpRecorder = httptest.NewRecorder()
+			c, _ = gin.CreateTestContext(httpRecorder)
+			c.Request = httptest.NewRequest(http.MethodPost,
+				"/nsmf-pdusession/v1/pdu-sessions/"+pduSessionRef+"/release", nil)
+			processor.HandlePDUSessionRelease(c, models.ReleasePduSessionRequest{
+				JsonData: &models.ReleaseData{Cause: models.SmfPduSessionCause_REL_DUE_TO_SUBSCRIPTION_CHANGE},
+			}, pduSessionRef)
+			require.Equal(t, http.StatusNoContent, c.Writer.Status())
+
+			req = hUpf.Next(t, pfcp.PFCP_SESSION_DELETION_REQUEST)
+			require.Equal(t, smContext.PFCPContext[hUpfIP].RemoteSEID, req.Header.SEID)
+			// The SM context is removed asynchronously
+			require.Eventually(t, func() bool {
+				return smf_context.GetSMContextByPduSessionRef(pduSessionRef) == nil
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
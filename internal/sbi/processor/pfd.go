@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/free5gc/pfcp"
+	"github.com/free5gc/pfcp/pfcpType"
+	smf_context "github.com/free5gc/smf/internal/context"
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/pfcp/message"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+// RemoveExpiredPfds removes the PFDs of the applications from the UPFs when their caching time expires,
+// until the SMF PFCP context is canceled
+func (p *Processor) RemoveExpiredPfds(smfPfcpContext context.Context) {
+	for {
+		// The caching times are checked again if the PFDs are reloaded
+		expiry, appIDs := smf_context.NextPfdExpiry(time.Now())
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if len(appIDs) != 0 {
+			timer = time.NewTimer(time.Until(expiry))
+			expired = timer.C
+		}
+
+		select {
+		case <-smfPfcpContext.Done():
+			stopTimer(timer)
+			return
+		case <-smf_context.PfdDataReloaded():
+			stopTimer(timer)
+			continue
+		case <-expired:
+		}
+
+		logger.PfcpLog.Infof("Caching time of the PFDs of AppID %v expired", appIDs)
+		sendPfdManagementRequestToUPFs(nil, appIDs)
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// ProvisionReloadedPfds provisions the reloaded PFDs to the associated UPFs and removes the PFDs
+// of the applications which are not in the reloaded configuration
+func (p *Processor) ProvisionReloadedPfds(removedAppIDs []string) {
+	pfdDatas := smf_context.ValidPfdDatas(time.Now())
+	if len(pfdDatas) == 0 && len(removedAppIDs) == 0 {
+		return
+	}
+	sendPfdManagementRequestToUPFs(pfdDatas, removedAppIDs)
+}
+
+func sendPfdManagementRequestToUPFs(pfdDatas []*factory.PfdDataForApp, removedAppIDs []string) {
+	for _, upNode := range smf_context.GetUserPlaneInformation().UPFs {
+		if upNode.UPF.IsAssociated() != nil {
+			continue
+		}
+		sendPfdManagementRequest(upNode.UPF, pfdDatas, removedAppIDs)
+	}
+}
+
+// provisionPfdsToUPF provisions the PFDs of the applications to the newly associated UPF
+func provisionPfdsToUPF(upf *smf_context.UPF) {
+	// The UPF may have lost the PFDs of the previous association
+	upf.ResetProvisionedPfds()
+	pfdDatas := smf_context.ValidPfdDatas(time.Now())
+	if len(pfdDatas) == 0 {
+		return
+	}
+	sendPfdManagementRequest(upf, pfdDatas, nil)
+}
+
+func sendPfdManagementRequest(upf *smf_context.UPF, pfdDatas []*factory.PfdDataForApp, removedAppIDs []string) {
+	upfIP := upf.GetUPFIP()
+	if !upf.SupportUPFunctionFeature(pfcpType.UpFunctionFeaturesPfdm) {
+		logger.PfcpLog.Debugf("UPF[%s] doesn't support PFD management", upfIP)
+		return
+	}
+
+	// The removed PFDs are no longer used even if the UPF doesn't accept their removal
+	upf.UpdateProvisionedPfds(nil, removedAppIDs)
+	rcvMsg, err := message.SendPfcpPfdManagementRequest(upf, pfdDatas, removedAppIDs)
+	if err != nil {
+		logger.PfcpLog.Warnf("Sending PFCP PFD Management Request to UPF[%s] error: %+v", upfIP, err)
+		return
+	}
+	rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPPFDManagementResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		logger.PfcpLog.Warnf("Received PFCP PFD Management Not Accepted Response from UPF[%s]", upfIP)
+		return
+	}
+	logger.PfcpLog.Infof("Received PFCP PFD Management Accepted Response from UPF[%s]", upfIP)
+	appIDs := make([]string, 0, len(pfdDatas))
+	for _, pfdData := range pfdDatas {
+		appIDs = append(appIDs, pfdData.AppID)
+	}
+	upf.UpdateProvisionedPfds(appIDs, nil)
+}
@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"github.com/free5gc/openapi/models"
 	smf_context "github.com/free5gc/smf/internal/context"
 )
 
@@ -24,6 +25,15 @@ func (p *Processor) RemoveSMContextFromAllNF(smContext *smf_context.SMContext, s
 		}
 	}
 
+	// release the PDU session resource in the H-SMF or the anchor SMF
+	if smContext.ServedByPeerSmf() && smContext.PeerPduSessionUri != "" {
+		if err := p.Consumer().SendReleasePduSession(smContext, models.ReleaseData{}); err != nil {
+			smContext.Log.Errorf("Release PDU session in peer SMF failed: %s", err)
+		} else {
+			smContext.PeerPduSessionUri = ""
+		}
+	}
+
 	// Because the amfUE who called this SMF API is being locked until the API Handler returns,
 	// sending SMContext Status Notification should run asynchronously
 	// so that this function returns immediately.
@@ -39,6 +49,7 @@ func (p *Processor) sendSMContextStatusNotificationAndRemoveSMContext(
 	if sendNotification && len(smContext.SmStatusNotifyUri) != 0 {
 		p.SendReleaseNotification(smContext)
 	}
+	notifyPduSessionRelease(smContext)
 
 	smf_context.RemoveSMContext(smContext.Ref)
 }
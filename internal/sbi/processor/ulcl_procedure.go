@@ -342,16 +342,13 @@ func EstablishRANTunnelInfo(smContext *context.SMContext) {
 		},
 		NetworkInstance: &pfcpType.NetworkInstance{
 			NetworkInstance: smContext.Dnn,
-			FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
+			FQDNEncoding:    factory.SmfConfig.GetNwInstFqdnEncoding(),
 		},
 	}
 
 	activatingANUPFDLFAR.State = context.RULE_INITIAL
-	activatingANUPFDLFAR.ForwardingParameters.OuterHeaderCreation = new(pfcpType.OuterHeaderCreation)
-	anOuterHeaderCreation := activatingANUPFDLFAR.ForwardingParameters.OuterHeaderCreation
-	anOuterHeaderCreation.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
-	anOuterHeaderCreation.Teid = defaultANUPFDLFAR.ForwardingParameters.OuterHeaderCreation.Teid
-	anOuterHeaderCreation.Ipv4Address = defaultANUPFDLFAR.ForwardingParameters.OuterHeaderCreation.Ipv4Address
+	anOuterHeaderCreation := *defaultANUPFDLFAR.ForwardingParameters.OuterHeaderCreation
+	activatingANUPFDLFAR.ForwardingParameters.OuterHeaderCreation = &anOuterHeaderCreation
 }
 
 func UpdateRANAndIUPFUpLink(smContext *context.SMContext) {
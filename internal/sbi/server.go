@@ -9,12 +9,15 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/free5gc/openapi/models"
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/internal/metrics"
 	"github.com/free5gc/smf/internal/sbi/consumer"
 	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/internal/tracing"
 	util_oauth "github.com/free5gc/smf/internal/util/oauth"
 	"github.com/free5gc/smf/pkg/app"
 	"github.com/free5gc/smf/pkg/factory"
@@ -51,6 +54,8 @@ func NewServer(smf ServerSmf, tlsKeyLogPath string) (*Server, error) {
 	// allocate id for each upf
 	smf_context.AllocateUPFID()
 	smf_context.InitSMFUERouting(factory.UERoutingConfig)
+	// restore the PDU sessions before the SBI and PFCP services are started
+	smf_context.RestoreSMContexts()
 
 	s.router = newRouter(s)
 
@@ -66,6 +71,7 @@ func NewServer(smf ServerSmf, tlsKeyLogPath string) (*Server, error) {
 
 func newRouter(s *Server) *gin.Engine {
 	router := logger_util.NewGinWithLogrus(logger.GinLog)
+	router.Use(tracing.Middleware(factory.MetricsUriPath))
 
 	smfCallbackGroup := router.Group(factory.SmfCallbackUriPrefix)
 	smfCallbackRoutes := s.getCallbackRoutes()
@@ -75,7 +81,9 @@ func newRouter(s *Server) *gin.Engine {
 	upiRoutes := s.getUPIRoutes()
 	applyRoutes(upiGroup, upiRoutes)
 
-	for _, serviceName := range factory.SmfConfig.Configuration.ServiceNameList {
+	router.GET(factory.MetricsUriPath, gin.WrapH(metrics.Handler()))
+
+	for _, serviceName := range factory.SmfConfig.GetServiceNameList() {
 		switch models.ServiceName(serviceName) {
 		case models.ServiceName_NSMF_PDUSESSION:
 			smfPDUSessionGroup := router.Group(factory.SmfPdusessionResUriPrefix)
@@ -108,7 +116,9 @@ func newRouter(s *Server) *gin.Engine {
 }
 
 func (s *Server) Run(traceCtx context.Context, wg *sync.WaitGroup) error {
-	err := s.Consumer().RegisterNFInstance(s.CancelContext())
+	// The NF registration is traced as a part of the start of the SMF
+	ctx := trace.ContextWithSpanContext(s.CancelContext(), trace.SpanContextFromContext(traceCtx))
+	err := s.Consumer().RegisterNFInstance(ctx)
 	if err != nil {
 		return err
 	}
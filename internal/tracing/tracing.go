@@ -0,0 +1,204 @@
+// Package tracing traces the SBI requests and the PFCP transactions of the SMF with OpenTelemetry spans,
+// the trace context is propagated over the HTTP headers of the SBI requests
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/free5gc/smf/internal/logger"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const (
+	tracerName  = "github.com/free5gc/smf"
+	serviceName = "SMF"
+	// URL path of the traces in the OTLP/HTTP collector
+	otlpTracesPath = "/v1/traces"
+)
+
+// Attributes of the PFCP spans
+const (
+	PfcpMessageTypeKey    = attribute.Key("pfcp.message_type")
+	PfcpSequenceNumberKey = attribute.Key("pfcp.sequence_number")
+	PfcpSEIDKey           = attribute.Key("pfcp.seid")
+	PfcpRetransmissionKey = attribute.Key("pfcp.retransmissions")
+	SbiTargetNfKey        = attribute.Key("sbi.target_nf")
+)
+
+var (
+	tracerProvider *sdktrace.TracerProvider
+	exportFile     *os.File
+)
+
+func init() {
+	// The trace context of the inbound requests is propagated to the outbound requests
+	// even if the spans are not exported
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Init sets up the export of the spans, nothing is exported if cfg is nil
+func Init(cfg *factory.Tracing, nfInstanceID string) error {
+	if cfg == nil {
+		return nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "otlp":
+		// The spans are sent in the protobuf encoding of OTLP, over HTTP unless the endpoint is https
+		var err error
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+otlpTracesPath))
+		if err != nil {
+			return fmt.Errorf("new otlp exporter of tracing: %w", err)
+		}
+	case "file":
+		f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("open file of tracing exporter: %w", err)
+		}
+		if exporter, err = stdouttrace.New(stdouttrace.WithWriter(f)); err != nil {
+			return errors.Join(fmt.Errorf("new file exporter of tracing: %w", err), f.Close())
+		}
+		exportFile = f
+	default:
+		return fmt.Errorf("unsupported tracing exporter: %s", cfg.Exporter)
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceInstanceID(nfInstanceID))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	logger.InitLog.Infof("Export spans by the %s exporter", cfg.Exporter)
+	return nil
+}
+
+// Shutdown exports the ended spans and stops the export
+func Shutdown(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	err := tracerProvider.Shutdown(ctx)
+	if exportFile != nil {
+		err = errors.Join(err, exportFile.Close())
+	}
+	return err
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Middleware starts the server span of each inbound SBI request with the trace context of its headers,
+// the span is kept in the context of the request
+func Middleware(skippedPaths ...string) gin.HandlerFunc {
+	skipped := make(map[string]bool, len(skippedPaths))
+	for _, path := range skippedPaths {
+		skipped[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skipped[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, span := Tracer().Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// StartSbiClientSpan starts the client span of an outbound SBI request to the target NF type
+// and returns the request carrying the trace context in its headers
+func StartSbiClientSpan(req *http.Request, targetNf string) (*http.Request, trace.Span) {
+	ctx, span := Tracer().Start(req.Context(), req.Method+" "+targetNf,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			SbiTargetNfKey.String(targetNf),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLPath(req.URL.Path),
+		))
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
+
+// EndSbiClientSpan ends the client span of an outbound SBI request with its result
+func EndSbiClientSpan(span trace.Span, rsp *http.Response, err error) {
+	defer span.End()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(rsp.StatusCode))
+	if rsp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(rsp.StatusCode))
+	}
+}
+
+// StartPfcpSpan starts the span of a PFCP transaction with the UPF
+func StartPfcpSpan(ctx context.Context, kind trace.SpanKind, messageType, upf string,
+	sequenceNumber uint32, seid uint64,
+) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "PFCP "+messageType,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			PfcpMessageTypeKey.String(messageType),
+			PfcpSequenceNumberKey.Int64(int64(sequenceNumber)),
+			PfcpSEIDKey.String(fmt.Sprintf("%#x", seid)),
+			semconv.ServerAddress(upf),
+			semconv.NetworkTransportUDP,
+		))
+}
+
+// EndSpan ends the span, the span fails with the error if it is not nil
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,115 @@
+package tracing_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/free5gc/smf/internal/tracing"
+	"github.com/free5gc/smf/pkg/factory"
+)
+
+const (
+	parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanID  = "00f067aa0ba902b7"
+)
+
+func newRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		require.NoError(t, provider.Shutdown(context.Background()))
+	})
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := newRecorder(t)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tracing.Middleware("/metrics"))
+	router.POST("/nsmf-pdusession/v1/sm-contexts/:smContextRef/modify", func(c *gin.Context) {
+		require.True(t, trace.SpanContextFromContext(c.Request.Context()).IsValid())
+		c.Status(http.StatusInternalServerError)
+	})
+	router.GET("/metrics", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/nsmf-pdusession/v1/sm-contexts/urn:uuid:1/modify", nil)
+	req.Header.Set("traceparent", "00-"+parentTraceID+"-"+parentSpanID+"-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	require.Equal(t, "POST /nsmf-pdusession/v1/sm-contexts/:smContextRef/modify", span.Name())
+	require.Equal(t, trace.SpanKindServer, span.SpanKind())
+	require.Equal(t, parentTraceID, span.SpanContext().TraceID().String())
+	require.Equal(t, parentSpanID, span.Parent().SpanID().String())
+	require.True(t, span.Parent().IsRemote())
+	require.Equal(t, "Error", span.Status().Code.String())
+}
+
+func TestStartSbiClientSpan(t *testing.T) {
+	recorder := newRecorder(t)
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://127.0.0.9:8000/nudm-sdm/v2/imsi-208930000000001/sm-data", nil)
+	require.NoError(t, err)
+
+	tracedReq, span := tracing.StartSbiClientSpan(req, "UDM")
+	tracing.EndSbiClientSpan(span, &http.Response{StatusCode: http.StatusOK}, nil)
+	parent.End()
+
+	require.Empty(t, req.Header.Get("traceparent"))
+	spanContext := span.SpanContext()
+	require.Equal(t, "00-"+spanContext.TraceID().String()+"-"+spanContext.SpanID().String()+"-01",
+		tracedReq.Header.Get("traceparent"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, "GET UDM", spans[0].Name())
+	require.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	require.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+}
+
+func TestInitOtlpExporter(t *testing.T) {
+	exported := make(chan *http.Request, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NotEmpty(t, b)
+		exported <- r
+	}))
+	defer collector.Close()
+
+	require.NoError(t, tracing.Init(&factory.Tracing{Exporter: "otlp", Endpoint: collector.URL + "/"}, "nf-1"))
+	_, span := tracing.StartPfcpSpan(context.Background(), trace.SpanKindClient,
+		"session_establishment", "10.4.0.11", 7, 1)
+	tracing.EndSpan(span, io.ErrUnexpectedEOF)
+	require.NoError(t, tracing.Shutdown(context.Background()))
+
+	// The ended spans are sent to the collector in the protobuf encoding on shutdown
+	select {
+	case r := <-exported:
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+	default:
+		require.FailNow(t, "no span is exported")
+	}
+}
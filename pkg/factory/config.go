@@ -7,6 +7,7 @@ package factory
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"sync"
 	"time"
@@ -36,6 +37,21 @@ const (
 	UdmSdmUriPrefix              = "/nudm-sdm/v1"
 	PcfSmpolicycontrolUriPrefix  = "/npcf-smpolicycontrol/v1"
 	UpiUriPrefix                 = "/upi/v1"
+	MetricsUriPath               = "/metrics"
+	SmfDefaultDdnBackoffTime     = 30 * time.Second
+	// The number of the downlink data packets buffered at the SMF if no suggested packet count is configured
+	SmfDefaultDlBufferingPacketCount = 10
+	// The maximum UDP payload sizes: the IP packet is at most 65535 octets including the IPv4 header of 20 octets,
+	// the IPv6 payload is at most 65535 octets excluding the IPv6 header, and the UDP header takes 8 octets
+	UdpMaxPayloadSizeIPv4 = 0xffff - 20 - 8
+	UdpMaxPayloadSizeIPv6 = 0xffff - 8
+	// The maximum PFCP message size. A PFCP message is carried in one UDP datagram (TS 29.244 7.2.1),
+	// so it's bounded by the UDP payload rather than by the Message Length of the PFCP header,
+	// which excludes the first 4 octets of the header and allows 0xffff + 4 octets (TS 29.244 7.2.2)
+	PfcpMaxMessageSize = UdpMaxPayloadSizeIPv6
+	// The PFCP request is retransmitted after T1 without the response, at most N1 times
+	SmfDefaultPfcpT1 = 3 * time.Second
+	SmfDefaultPfcpN1 = 2
 )
 
 type Config struct {
@@ -92,6 +108,10 @@ type Configuration struct {
 	T3592                *TimerValue          `yaml:"t3592" valid:"required"`
 	NwInstFqdnEncoding   bool                 `yaml:"nwInstFqdnEncoding" valid:"type(bool),optional"`
 	RequestedUnit        int32                `yaml:"requestedUnit,omitempty" valid:"optional"`
+	SessionStore         *SessionStore        `yaml:"sessionStore,omitempty" valid:"optional"`
+	Tracing              *Tracing             `yaml:"tracing,omitempty" valid:"optional"`
+	// DdnBackoffTime is how long the Downlink Data Reports are not notified after the paging fails
+	DdnBackoffTime time.Duration `yaml:"ddnBackoffTime,omitempty" valid:"type(time.Duration),optional"`
 }
 
 type Logger struct {
@@ -113,6 +133,18 @@ func (c *Configuration) validate() (bool, error) {
 		}
 	}
 
+	if sessionStore := c.SessionStore; sessionStore != nil {
+		if result, err := sessionStore.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if tracing := c.Tracing; tracing != nil {
+		if result, err := tracing.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	if userPlaneInformation := &c.UserPlaneInformation; userPlaneInformation != nil {
 		if result, err := userPlaneInformation.validate(); err != nil {
 			return result, err
@@ -192,9 +224,10 @@ func (s *SnssaiInfoItem) Validate() (bool, error) {
 }
 
 type SnssaiDnnInfoItem struct {
-	Dnn   string `yaml:"dnn" valid:"type(string),minstringlength(1),required"`
-	DNS   *DNS   `yaml:"dns" valid:"required"`
-	PCSCF *PCSCF `yaml:"pcscf,omitempty" valid:"optional"`
+	Dnn         string       `yaml:"dnn" valid:"type(string),minstringlength(1),required"`
+	DNS         *DNS         `yaml:"dns" valid:"required"`
+	PCSCF       *PCSCF       `yaml:"pcscf,omitempty" valid:"optional"`
+	DlBuffering *DlBuffering `yaml:"dlBuffering,omitempty" valid:"optional"`
 }
 
 func (s *SnssaiDnnInfoItem) validate() (bool, error) {
@@ -210,6 +243,12 @@ func (s *SnssaiDnnInfoItem) validate() (bool, error) {
 		}
 	}
 
+	if dlBuffering := s.DlBuffering; dlBuffering != nil {
+		if result, err := dlBuffering.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(s)
 	return result, appendInvalid(err)
 }
@@ -256,13 +295,106 @@ type PFCP struct {
 	AssocFailAlertInterval time.Duration `yaml:"assocFailAlertInterval,omitempty" valid:"type(time.Duration),optional"`
 	AssocFailRetryInterval time.Duration `yaml:"assocFailRetryInterval,omitempty" valid:"type(time.Duration),optional"`
 	HeartbeatInterval      time.Duration `yaml:"heartbeatInterval,omitempty" valid:"type(time.Duration),optional"`
+	// MaxMessageSize bounds the size of the received PFCP messages, i.e. the UDP payload including the PFCP header,
+	// the larger messages are discarded. PfcpMaxMessageSize if 0
+	MaxMessageSize int `yaml:"maxMessageSize,omitempty" valid:"optional"`
+	// RequestTimers are the retransmission timers of the PFCP requests to the UPFs without their own timers
+	RequestTimers *PfcpRequestTimers `yaml:"requestTimers,omitempty" valid:"optional"`
+	// CaptureDir is the directory of the capture files of the PFCP messages, the capture is disabled if empty
+	CaptureDir string `yaml:"captureDir,omitempty" valid:"type(string),optional"`
 }
 
 func (p *PFCP) validate() (bool, error) {
-	result, err := govalidator.ValidateStruct(p)
+	if result, err := govalidator.ValidateStruct(p); err != nil {
+		return result, appendInvalid(err)
+	}
+	if requestTimers := p.RequestTimers; requestTimers != nil {
+		if result, err := requestTimers.validate(); err != nil {
+			return result, err
+		}
+	}
+	if p.MaxMessageSize < 0 || p.MaxMessageSize > PfcpMaxMessageSize {
+		return false, fmt.Errorf("Invalid maxMessageSize: %d, should be within 0 and %d",
+			p.MaxMessageSize, PfcpMaxMessageSize)
+	}
+	return true, nil
+}
+
+// PfcpRequestTimers are the timers of the reliable delivery of the PFCP requests (TS 29.244 6.4),
+// the default is used if a timer is 0
+type PfcpRequestTimers struct {
+	// T1 is the time waiting for the response before the request is retransmitted
+	T1 time.Duration `json:"t1,omitempty" yaml:"t1,omitempty" valid:"type(time.Duration),optional"`
+	// N1 is the maximum number of the retransmissions of the request
+	N1 int `json:"n1,omitempty" yaml:"n1,omitempty" valid:"optional"`
+}
+
+func (t *PfcpRequestTimers) validate() (bool, error) {
+	if t.T1 < 0 || t.N1 < 0 {
+		return false, fmt.Errorf("Invalid requestTimers: t1(%s) and n1(%d) should not be negative", t.T1, t.N1)
+	}
+	return true, nil
+}
+
+// GetT1 returns the time waiting for the response of the PFCP request
+func (t *PfcpRequestTimers) GetT1() time.Duration {
+	if t == nil || t.T1 == 0 {
+		return SmfDefaultPfcpT1
+	}
+	return t.T1
+}
+
+// GetN1 returns the maximum number of the retransmissions of the PFCP request
+func (t *PfcpRequestTimers) GetN1() int {
+	if t == nil || t.N1 == 0 {
+		return SmfDefaultPfcpN1
+	}
+	return t.N1
+}
+
+// GetMaxMessageSize returns the maximum size of the received PFCP messages
+func (p *PFCP) GetMaxMessageSize() int {
+	if p == nil || p.MaxMessageSize == 0 {
+		return PfcpMaxMessageSize
+	}
+	return p.MaxMessageSize
+}
+
+// SessionStore is the persistent store of the SM contexts, the PDU sessions are restored from the store
+// when the SMF restarts
+type SessionStore struct {
+	// "file": the SM contexts are kept in the files of the directory of Path
+	Type string `yaml:"type" valid:"required,in(file)"`
+	Path string `yaml:"path" valid:"type(string),required"`
+}
+
+func (s *SessionStore) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(s)
 	return result, appendInvalid(err)
 }
 
+// Tracing is the export of the spans of the SBI requests and the PFCP transactions of the SMF
+type Tracing struct {
+	// "otlp": the spans are sent to the OTLP/HTTP collector of Endpoint, e.g. http://127.0.0.1:4318
+	// "file": the spans are appended to the file of Path in JSON
+	Exporter string `yaml:"exporter" valid:"required,in(otlp|file)"`
+	Endpoint string `yaml:"endpoint,omitempty" valid:"url,optional"`
+	Path     string `yaml:"path,omitempty" valid:"type(string),optional"`
+}
+
+func (t *Tracing) validate() (bool, error) {
+	if result, err := govalidator.ValidateStruct(t); err != nil {
+		return result, appendInvalid(err)
+	}
+	switch {
+	case t.Exporter == "otlp" && t.Endpoint == "":
+		return false, errors.New("Invalid tracing: endpoint is required by the otlp exporter")
+	case t.Exporter == "file" && t.Path == "":
+		return false, errors.New("Invalid tracing: path is required by the file exporter")
+	}
+	return true, nil
+}
+
 type DNS struct {
 	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,required"`
 	IPv6Addr string `yaml:"ipv6,omitempty" valid:"ipv6,optional"`
@@ -273,6 +405,47 @@ func (d *DNS) validate() (bool, error) {
 	return result, appendInvalid(err)
 }
 
+const (
+	// The downlink data is buffered at the UPF, which notifies the SMF of the first packet
+	DlBufferingModeUpf = "upf"
+	// The downlink data is buffered at the SMF, the UPF forwards it to the SMF over N4-u
+	DlBufferingModeSmf = "smf"
+	// The downlink data is not buffered, the UPF only notifies the SMF of its arrival
+	DlBufferingModeNotifyOnly = "notifyOnly"
+)
+
+// DlBuffering is the handling of the downlink data while the user plane of the PDU session
+// is deactivated (TS 23.501 5.8.3)
+type DlBuffering struct {
+	Mode string `yaml:"mode,omitempty" valid:"in(upf|smf|notifyOnly),optional"`
+	// SuggestedPacketCount is the number of the packets to be buffered, decided by the buffering node if 0
+	SuggestedPacketCount uint8 `yaml:"suggestedPacketCount,omitempty" valid:"optional"`
+	// Duration is how long the UPF buffers the downlink data after it is notified,
+	// until the user plane is activated if 0
+	Duration time.Duration `yaml:"duration,omitempty" valid:"type(time.Duration),optional"`
+}
+
+func (d *DlBuffering) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(d)
+	return result, appendInvalid(err)
+}
+
+// GetSmfBufferingPacketCount returns the number of the downlink data packets buffered at the SMF
+func (d *DlBuffering) GetSmfBufferingPacketCount() int {
+	if d == nil || d.SuggestedPacketCount == 0 {
+		return SmfDefaultDlBufferingPacketCount
+	}
+	return int(d.SuggestedPacketCount)
+}
+
+// GetMode returns the buffering mode, the data is buffered at the UPF by default
+func (d *DlBuffering) GetMode() string {
+	if d == nil || d.Mode == "" {
+		return DlBufferingModeUpf
+	}
+	return d.Mode
+}
+
 type PCSCF struct {
 	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,required"`
 }
@@ -483,6 +656,10 @@ type UPNode struct {
 	Dnn                  string                  `json:"dnn" yaml:"dnn" valid:"type(string),minstringlength(1),optional"`
 	SNssaiInfos          []*SnssaiUpfInfoItem    `json:"sNssaiUpfInfos" yaml:"sNssaiUpfInfos,omitempty" valid:"optional"`
 	InterfaceUpfInfoList []*InterfaceUpfInfoItem `json:"interfaces" yaml:"interfaces,omitempty" valid:"optional"`
+	// UP function features announced by the UPF, only reported by the UPI
+	UPFunctionFeatures []string `json:"upFunctionFeatures,omitempty" yaml:"-" valid:"-"`
+	// retransmission timers of the PFCP requests to the UPF, the timers of the PFCP configuration if nil
+	RequestTimers *PfcpRequestTimers `json:"requestTimers,omitempty" yaml:"requestTimers,omitempty" valid:"optional"`
 }
 
 func (u *UPNode) validate() (bool, error) {
@@ -516,6 +693,11 @@ func (u *UPNode) validate() (bool, error) {
 				n3IfsNum, n9IfsNum)
 		}
 	}
+	if requestTimers := u.RequestTimers; requestTimers != nil {
+		if result, err := requestTimers.validate(); err != nil {
+			return result, err
+		}
+	}
 	result, err := govalidator.ValidateStruct(u)
 	return result, appendInvalid(err)
 }
@@ -635,7 +817,17 @@ func (u *UEIPPool) validate() (bool, error) {
 	})
 
 	result, err := govalidator.ValidateStruct(u)
-	return result, appendInvalid(err)
+	if err != nil {
+		return result, appendInvalid(err)
+	}
+
+	// IPv6 pools are allocated per /64 prefix (TS 23.501 5.8.2.2.3)
+	if _, ipNet, errParse := net.ParseCIDR(u.Cidr); errParse == nil && ipNet.IP.To4() == nil {
+		if ones, _ := ipNet.Mask.Size(); ones > 64 {
+			return false, fmt.Errorf("invalid IPv6 pool %s: prefix length shall not be longer than 64", u.Cidr)
+		}
+	}
+	return result, nil
 }
 
 type SpecificPath struct {
@@ -795,6 +987,99 @@ func (c *Config) GetSbiScheme() string {
 	return SmfSbiDefaultScheme
 }
 
+func (c *Config) GetDdnBackoffTime() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration != nil && c.Configuration.DdnBackoffTime > 0 {
+		return c.Configuration.DdnBackoffTime
+	}
+	return SmfDefaultDdnBackoffTime
+}
+
+// The getters below read the items which may be changed by a configuration reload,
+// the running configuration is replaced as a whole by the reload
+
+func (c *Config) GetServiceNameList() []string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return nil
+	}
+	return c.Configuration.ServiceNameList
+}
+
+func (c *Config) GetPLMNList() []PlmnID {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return nil
+	}
+	return c.Configuration.PLMNList
+}
+
+func (c *Config) GetUserPlaneInformation() UserPlaneInformation {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return UserPlaneInformation{}
+	}
+	return c.Configuration.UserPlaneInformation
+}
+
+func (c *Config) GetUrrPeriod() uint16 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return 0
+	}
+	return c.Configuration.UrrPeriod
+}
+
+func (c *Config) GetUrrThreshold() uint64 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return 0
+	}
+	return c.Configuration.UrrThreshold
+}
+
+func (c *Config) GetRequestedUnit() int32 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return 0
+	}
+	return c.Configuration.RequestedUnit
+}
+
+func (c *Config) GetT3591() *TimerValue {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return nil
+	}
+	return c.Configuration.T3591
+}
+
+func (c *Config) GetT3592() *TimerValue {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return nil
+	}
+	return c.Configuration.T3592
+}
+
+func (c *Config) GetNwInstFqdnEncoding() bool {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration == nil {
+		return false
+	}
+	return c.Configuration.NwInstFqdnEncoding
+}
+
 func (c *Config) GetCertPemPath() string {
 	c.RLock()
 	defer c.RUnlock()
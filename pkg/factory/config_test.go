@@ -44,6 +44,25 @@ func TestSnssaiInfoItem(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "Downlink data buffering",
+			Snssai: &models.Snssai{
+				Sst: int32(1),
+				Sd:  "112233",
+			},
+			DnnInfos: []*factory.SnssaiDnnInfoItem{
+				{
+					Dnn: "iot",
+					DNS: &factory.DNS{
+						IPv4Addr: "8.8.8.8",
+					},
+					DlBuffering: &factory.DlBuffering{
+						Mode:                 factory.DlBufferingModeSmf,
+						SuggestedPacketCount: 20,
+					},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testcase {
@@ -17,6 +17,10 @@ import (
 var (
 	SmfConfig       *Config
 	UERoutingConfig *RoutingConfig
+
+	// The configuration files are read again when the configuration is reloaded
+	SmfConfigPath       string
+	UERoutingConfigPath string
 )
 
 // TODO: Support configuration update from REST api
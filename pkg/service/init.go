@@ -6,6 +6,7 @@ import (
 	"os"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/free5gc/smf/internal/sbi"
 	"github.com/free5gc/smf/internal/sbi/consumer"
 	"github.com/free5gc/smf/internal/sbi/processor"
+	"github.com/free5gc/smf/internal/tracing"
 	"github.com/free5gc/smf/pkg/app"
 	"github.com/free5gc/smf/pkg/factory"
 )
@@ -29,6 +31,8 @@ type SmfAppInterface interface {
 
 var SMF SmfAppInterface
 
+const tracingShutdownTimeout = 5 * time.Second
+
 type SmfApp struct {
 	SmfAppInterface
 
@@ -66,6 +70,10 @@ func NewApp(
 	smf.SetLogLevel(cfg.GetLogLevel())
 	smf.SetReportCaller(cfg.GetLogReportCaller())
 
+	if err := tracing.Init(cfg.Configuration.Tracing, smf.smfCtx.NfInstanceID); err != nil {
+		return nil, err
+	}
+
 	// Initialize consumer
 	consumer, err := consumer.NewConsumer(smf)
 	if err != nil {
@@ -163,7 +171,9 @@ func (a *SmfApp) SetReportCaller(reportCaller bool) {
 func (a *SmfApp) Start() {
 	logger.InitLog.Infoln("Server started")
 
-	err := a.sbiServer.Run(context.Background(), &a.wg)
+	traceCtx, span := tracing.Tracer().Start(context.Background(), "SMF start")
+	err := a.sbiServer.Run(traceCtx, &a.wg)
+	tracing.EndSpan(span, err)
 	if err != nil {
 		logger.MainLog.Errorf("sbi server run error %+v", err)
 	}
@@ -177,6 +187,13 @@ func (a *SmfApp) Start() {
 	a.WaitRoutineStopped()
 }
 
+// ReloadConfig reloads the configuration files, e.g. on SIGHUP
+func (a *SmfApp) ReloadConfig() {
+	if _, err := a.processor.ReloadConfig(); err != nil {
+		logger.MainLog.Errorf("Reload configuration failed: %+v", err)
+	}
+}
+
 func (a *SmfApp) listenShutDownEvent() {
 	defer func() {
 		if p := recover(); p != nil {
@@ -218,6 +235,16 @@ func (a *SmfApp) terminateProcedure() {
 
 	a.sbiServer.Stop()
 	logger.MainLog.Infof("SMF SBI Server terminated")
+
+	// The SM contexts are restored from the checkpoints when the SMF restarts
+	smf_context.FlushCheckpoints()
+
+	// Export the spans which are not exported yet
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := tracing.Shutdown(shutdownCtx); err != nil {
+		logger.MainLog.Errorf("Shutdown tracing failed: %+v", err)
+	}
 }
 
 func (a *SmfApp) WaitRoutineStopped() {
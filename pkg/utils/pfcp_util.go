@@ -7,6 +7,7 @@ import (
 	smf_context "github.com/free5gc/smf/internal/context"
 	"github.com/free5gc/smf/internal/logger"
 	"github.com/free5gc/smf/internal/pfcp"
+	"github.com/free5gc/smf/internal/pfcp/n4u"
 	"github.com/free5gc/smf/internal/pfcp/udp"
 	"github.com/free5gc/smf/pkg/service"
 )
@@ -20,12 +21,21 @@ func InitPFCPFunc(pCtx context.Context) (func(a *service.SmfApp), func()) {
 
 		udp.Run(pfcp.Dispatch)
 
+		// The downlink data buffered at the SMF is forwarded by the UPFs over N4-u
+		if smf_context.DlBufferingAtSmf() {
+			err := n4u.Run(smfContext.PfcpContext, smfContext.ListenIP(), a.Processor().HandleN4uDownlinkData)
+			if err != nil {
+				logger.Log.Errorf("N4-u start failed, the downlink data is buffered at the UPF: %+v", err)
+			}
+		}
+
 		// Wait for PFCP start
 		time.Sleep(1000 * time.Millisecond)
 
 		for _, upNode := range smf_context.GetSelf().UserPlaneInformation.UPFs {
 			go a.Processor().ToBeAssociatedWithUPF(smfContext.PfcpContext, upNode.UPF)
 		}
+		go a.Processor().RemoveExpiredPfds(smfContext.PfcpContext)
 	}
 
 	pfcpStop := func() {